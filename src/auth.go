@@ -1,11 +1,15 @@
 package main
 
 import (
-	"crypto/rand"
 	"encoding/json"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	auth "imuslab.com/arozos/mod/auth"
+	"imuslab.com/arozos/mod/auth/accesscontrol/geofilter"
+	"imuslab.com/arozos/mod/network"
 	prout "imuslab.com/arozos/mod/prouter"
 	"imuslab.com/arozos/mod/utils"
 )
@@ -13,23 +17,32 @@ import (
 func AuthInit() {
 	//Generate session key for authentication module if empty
 	sysdb.NewTable("auth")
+	sessionKeyFixed := *session_key != ""
 	if *session_key == "" {
 		//Check if the key was generated already. If not, generate a new one
-		if !sysdb.KeyExists("auth", "sessionkey") {
-			key := make([]byte, 32)
-			rand.Read(key)
-			newSessionKey := string(key)
-			sysdb.Write("auth", "sessionkey", newSessionKey)
+		key := auth.LoadSessionKey(sysdb)
+		if key == nil {
+			var err error
+			key, err = auth.GenerateSessionKey(sysdb)
+			if err != nil {
+				systemWideLogger.PrintAndLog("Auth", "Unable to generate authentication session key", err)
+				os.Exit(1)
+			}
 			systemWideLogger.PrintAndLog("Auth", "New authentication session key generated", nil)
 		} else {
 			systemWideLogger.PrintAndLog("Auth", "Authentication session key loaded from database", nil)
 
 		}
-		skeyString := ""
-		sysdb.Read("auth", "sessionkey", &skeyString)
+		skeyString := string(key)
 		session_key = &skeyString
 	}
 
+	//Resolve the client IP from proxy headers only if the request is sent by a trusted proxy
+	err := network.SetTrustedProxies(strings.Split(*trusted_proxies, ","), *client_ip_header)
+	if err != nil {
+		systemWideLogger.PrintAndLog("Auth", "Invalid trusted proxies given. Proxy headers are only trusted from localhost", err)
+	}
+
 	//Create an Authentication Agent
 	authAgent = auth.NewAuthenticationAgent("ao_auth", []byte(*session_key), sysdb, *allow_public_registry, func(w http.ResponseWriter, r *http.Request) {
 		//Login Redirection Handler, redirect it login.system
@@ -37,6 +50,18 @@ func AuthInit() {
 		http.Redirect(w, r, utils.ConstructRelativePathFromRequestURL(r.RequestURI, "login.system")+"?redirect="+r.URL.Path, http.StatusTemporaryRedirect)
 	})
 
+	//Session key given by flag cannot be replaced by rotation
+	authAgent.SessionKeyFixed = sessionKeyFixed
+
+	//Keep accepting the sessions signed with the key replaced by the last rotation
+	authAgent.LoadSessionKeyRotation()
+
+	//Sessions that must change their password are sent to the account page
+	authAgent.PasswordChangeRedirectionHandler = redirectToPasswordChange
+
+	//Sessions of non-admin users are paused during maintenance
+	authAgent.MaintenanceRedirectionHandler = showMaintenancePage
+
 	if *allow_autologin {
 		authAgent.AllowAutoLogin = true
 	} else {
@@ -44,12 +69,125 @@ func AuthInit() {
 		authAgent.AllowAutoLogin = false
 	}
 
+	//Set the default lifetime of new autologin tokens
+	if *autologin_token_ttl > 0 {
+		authAgent.AutoLoginTokenTTL = int64(*autologin_token_ttl)
+	}
+
+	//Bind the passkeys to the external URL of this host
+	if *external_url != "" {
+		err := authAgent.SetWebAuthnRelyingParty(*external_url)
+		if err != nil {
+			systemWideLogger.PrintAndLog("Auth", "Unable to set WebAuthn relying party. Passkey login disabled", err)
+		}
+	}
+
+	//Set the accepted 2FA time step window
+	if *totp_window >= 0 {
+		authAgent.TOTPWindow = *totp_window
+	}
+
+	//Set the quiet period before the login retry delay reset
+	if *login_retry_window >= 0 {
+		authAgent.ExpDelayHandler.RetryWindow = int64(*login_retry_window)
+	}
+
+	//Set the time a trusted device can skip 2FA
+	if *trusted_device_ttl > 0 {
+		authAgent.TrustedDeviceTTL = int64(*trusted_device_ttl)
+	}
+
+	//Set the session lookup cache TTL
+	if *session_cache_ttl >= 0 {
+		authAgent.SessionCache.TTL = time.Duration(*session_cache_ttl) * time.Second
+	}
+
+	//Set the session cookie attributes, e.g. Secure with SameSite=None behind a TLS terminating proxy
+	sameSite, err := auth.ParseSameSite(*cookie_samesite)
+	if err != nil {
+		systemWideLogger.PrintAndLog("Auth", "Unknown cookie SameSite mode. Using Lax instead", err)
+		sameSite = http.SameSiteLaxMode
+	}
+	err = authAgent.SetCookieOptions(auth.CookieOptions{
+		Domain:   *cookie_domain,
+		Path:     *cookie_path,
+		Secure:   *cookie_secure,
+		SameSite: sameSite,
+	})
+	if err != nil {
+		systemWideLogger.PrintAndLog("Auth", "Invalid cookie attributes. Using default settings", err)
+	}
+
+	//Set the password hashing cost
+	authAgent.PasswordHashCost = *password_hash_cost
+
+	//Set the password reset token expire time
+	if *password_reset_ttl > 0 {
+		authAgent.PasswordResetTTL = int64(*password_reset_ttl)
+	}
+
+	//Load the GeoIP database for geo-IP login filtering
+	if *geoip_db != "" {
+		geoResolver, err := geofilter.NewMaxMindResolver(*geoip_db)
+		if err != nil {
+			systemWideLogger.PrintAndLog("Auth", "Unable to load GeoIP database. Geo-IP filtering disabled", err)
+		} else {
+			authAgent.GeoFilterManager.Resolver = geoResolver
+		}
+	}
+
+	//Authenticate machine clients by TLS client certificate on the given paths
+	if *use_tls && *tls_client_ca != "" {
+		clientCertAuth, err := auth.NewClientCertAuth(*tls_client_ca, *tls_client_map, strings.Split(*tls_client_paths, ","))
+		if err != nil {
+			systemWideLogger.PrintAndLog("Auth", "Unable to setup client certificate authentication. Client certificate authentication disabled", err)
+		} else {
+			authAgent.ClientCertAuth = clientCertAuth
+		}
+	}
+
+	//Enrich the login records with reverse DNS hostname and geo location
+	authAgent.Logger.EnableEnrichment(*auth_log_rdns, authAgent.GeoFilterManager.Resolver)
+
+	//Set the brute-force protection thresholds
+	authAgent.AutoBanThreshold = *autoban_threshold
+	authAgent.AutoBanWindow = int64(*autoban_window)
+	authAgent.AutoBanDuration = int64(*autoban_duration)
+
+	//Require CAPTCHA on login after repeated failures if a verifier is configured
+	if *captcha_verify_url != "" && *captcha_secret != "" {
+		authAgent.CaptchaVerifier = auth.NewSiteVerifyCaptchaVerifier(*captcha_verify_url, *captcha_secret)
+		authAgent.CaptchaSiteKey = *captcha_sitekey
+		if *captcha_threshold >= 0 {
+			authAgent.CaptchaThreshold = *captcha_threshold
+		}
+	}
+
+	//Breached password check, only used if enabled in the password policy
+	if *breached_password_api != "" {
+		authAgent.BreachedPasswordChecker = auth.NewRangeAPIBreachedPasswordChecker(*breached_password_api)
+	}
+
+	//Limit the request body size of the login, register and CSV import requests
+	authAgent.MaxAuthBodySize = int64(*auth_max_body) << 10
+	authAgent.MaxCSVImportBodySize = int64(*csv_import_max_body) << 20
+
+	//Set the account lockout threshold
+	authAgent.LockoutThreshold = *lockout_threshold
+	authAgent.LockoutNightlyClear = *lockout_nightly_clear
+
 	//Register the API endpoints for the authentication UI
 	http.HandleFunc("/system/auth/login", authAgent.HandleLogin)
 	http.HandleFunc("/system/auth/logout", authAgent.HandleLogout)
 	http.HandleFunc("/system/auth/register", authAgent.HandleRegister)
 	http.HandleFunc("/system/auth/checkLogin", authAgent.CheckLogin)
+	http.HandleFunc("/system/auth/captcha/status", authAgent.HandleCaptchaStatus)
 	http.HandleFunc("/api/auth/login", authAgent.HandleAutologinTokenLogin)
+	http.HandleFunc("/system/auth/register/verify", authAgent.HandlePendingAccountVerify)
+	http.HandleFunc("/system/auth/resetRequest", authAgent.HandlePasswordResetRequest)
+	http.HandleFunc("/system/auth/resetConfirm", authAgent.HandlePasswordResetConfirm)
+	http.HandleFunc("/system/auth/webauthn/login/begin", authAgent.HandleWebAuthnLoginBegin)
+	http.HandleFunc("/system/auth/webauthn/login/finish", authAgent.HandleWebAuthnLoginFinish)
 
 	authAgent.LoadAutologinTokenFromDB()
 }
@@ -67,8 +205,77 @@ func AuthSettingsInit() {
 
 	//Handle additional batch operations
 	adminRouter.HandleFunc("/system/auth/csvimport", authAgent.HandleCreateUserAccountsFromCSV)
+	adminRouter.HandleFunc("/system/auth/csvexport", authAgent.HandleExportUserAccountsToCSV)
 	adminRouter.HandleFunc("/system/auth/groupdel", authAgent.HandleUserDeleteByGroup)
 
+	//Concurrent session limits
+	adminRouter.HandleFunc("/system/auth/session/limit", authAgent.HandleSessionLimitSettings)
+
+	//Session lifetime and idle timeout
+	adminRouter.HandleFunc("/system/auth/session/expiry", authAgent.HandleSessionExpirySettings)
+
+	//Log out all users, optionally rotate the session key
+	adminRouter.HandleFunc("/system/auth/session/revokeall", authAgent.HandleRevokeAllSessions)
+
+	//Rotate the session key without logging out the users
+	adminRouter.HandleFunc("/system/auth/session/rotatekey", authAgent.HandleSessionKeyRotate)
+
+	//Effective auth configuration for debugging, secrets redacted
+	adminRouter.HandleFunc("/system/auth/diagnostic/config", authAgent.HandleConfigDiagnostic)
+
+	//Group session policies
+	adminRouter.HandleFunc("/system/auth/grouppolicy", authAgent.HandleGroupPolicySettings)
+
+	//Password policy
+	adminRouter.HandleFunc("/system/auth/password/policy", authAgent.HandlePasswordPolicySettings)
+
+	//Username rules of the new accounts
+	adminRouter.HandleFunc("/system/auth/username/policy", authAgent.HandleUsernamePolicySettings)
+
+	//Password expiry and forced password change
+	adminRouter.HandleFunc("/system/auth/password/maxage", authAgent.HandlePasswordExpirySettings)
+	adminRouter.HandleFunc("/system/auth/password/status", authAgent.HandlePasswordStatus)
+	adminRouter.HandleFunc("/system/auth/password/forcechange", authAgent.HandleForcePasswordChange)
+
+	//Account lockout
+	adminRouter.HandleFunc("/system/auth/lockout/list", authAgent.HandleListLockedAccounts)
+	adminRouter.HandleFunc("/system/auth/lockout/unlock", authAgent.HandleUnlockAccount)
+
+	//Account disable without deletion
+	adminRouter.HandleFunc("/system/auth/disable/list", authAgent.HandleListDisabledAccounts)
+	adminRouter.HandleFunc("/system/auth/disable", authAgent.HandleDisableAccount)
+	adminRouter.HandleFunc("/system/auth/enable", authAgent.HandleEnableAccount)
+	adminRouter.HandleFunc("/system/auth/groupdisable", authAgent.HandleUserDisableByGroup)
+
+	//Registration rate limit
+	adminRouter.HandleFunc("/system/auth/register/limit", authAgent.HandleRegistrationLimitSettings)
+
+	//Allow-list of the redirect targets after login
+	adminRouter.HandleFunc("/system/auth/redirect/allowlist", authAgent.HandleRedirectAllowListSettings)
+
+	//Show or hide the specific login rejection reasons
+	adminRouter.HandleFunc("/system/auth/rejectionreason", authAgent.HandleLoginRejectionReasonSettings)
+
+	//Maintenance mode, only administrators can login when it is on
+	adminRouter.HandleFunc("/system/auth/maintenance", authAgent.HandleMaintenanceMode)
+
+	//Step-up window of sensitive operations, set to 0 to always require password
+	adminRouter.HandleFunc("/system/auth/stepup", authAgent.HandleStepUpSettings)
+
+	//Impersonate a user for troubleshooting
+	adminRouter.HandleFunc("/system/auth/impersonate/start", authAgent.SwitchableAccountManager.HandleImpersonateStart)
+
+	//Audit and clear the account switching pools of all users
+	adminRouter.HandleFunc("/system/auth/u/admin/list", authAgent.SwitchableAccountManager.HandleListAllPools)
+	adminRouter.HandleFunc("/system/auth/u/admin/clear", authAgent.SwitchableAccountManager.HandleClearPools)
+
+	//Reset a user 2FA settings
+	adminRouter.HandleFunc("/system/auth/2fa/reset", authAgent.HandleTOTPAdminReset)
+
+	//Manage API keys of all users
+	adminRouter.HandleFunc("/system/auth/apikey/admin/list", authAgent.HandleAdminListAPIKeys)
+	adminRouter.HandleFunc("/system/auth/apikey/admin/revoke", authAgent.HandleAdminRevokeAPIKey)
+
 	//System for logging and displaying login user information
 	registerSetting(settingModule{
 		Name:         "Connection Log",
@@ -81,6 +288,15 @@ func AuthSettingsInit() {
 
 	adminRouter.HandleFunc("/system/auth/logger/index", authAgent.Logger.HandleIndexListing)
 	adminRouter.HandleFunc("/system/auth/logger/list", authAgent.Logger.HandleTableListing)
+	adminRouter.HandleFunc("/system/auth/logger/export", authAgent.Logger.HandleExport)
+	adminRouter.HandleFunc("/system/auth/logger/ipmode", authAgent.Logger.HandleIPModeSettings)
+
+	//Audit log of authentication events
+	adminRouter.HandleFunc("/system/auth/audit/tail", authAgent.AuditLogger.HandleTail)
+	adminRouter.HandleFunc("/system/auth/audit/verify", authAgent.AuditLogger.HandleVerify)
+
+	//Authentication metrics for monitoring
+	adminRouter.HandleFunc("/system/auth/metrics", authAgent.HandleMetrics)
 
 	//Blacklist Management
 	registerSetting(settingModule{
@@ -98,18 +314,47 @@ func AuthSettingsInit() {
 	adminRouter.HandleFunc("/system/auth/whitelist/set", authAgent.WhitelistManager.HandleAddWhitelistedIP)
 	adminRouter.HandleFunc("/system/auth/whitelist/unset", authAgent.WhitelistManager.HandleRemoveWhitelistedIP)
 
+	//Geo-IP filter API
+	adminRouter.HandleFunc("/system/auth/geofilter/settings", authAgent.GeoFilterManager.HandleGeoFilterSettings)
+
+	//Login time windows API
+	adminRouter.HandleFunc("/system/auth/timewindow/settings", authAgent.LoginTimeWindows.HandleTimeWindowSettings)
+
 	//Blacklist API
 	adminRouter.HandleFunc("/system/auth/blacklist/enable", authAgent.BlacklistManager.HandleSetBlacklistEnable)
 	adminRouter.HandleFunc("/system/auth/blacklist/list", authAgent.BlacklistManager.HandleListBannedIPs)
 	adminRouter.HandleFunc("/system/auth/blacklist/ban", authAgent.BlacklistManager.HandleAddBannedIP)
 	adminRouter.HandleFunc("/system/auth/blacklist/unban", authAgent.BlacklistManager.HandleRemoveBannedIP)
+	adminRouter.HandleFunc("/system/auth/blacklist/import", authAgent.BlacklistManager.HandleImportBanList)
+	adminRouter.HandleFunc("/system/auth/blacklist/export", authAgent.BlacklistManager.HandleExportBanList)
+	adminRouter.HandleFunc("/system/auth/blacklist/bulkunban", authAgent.BlacklistManager.HandleBulkUnban)
+
+	//Register nightly task for clearup all user retry counter (and account lockouts if enabled)
+	nightlyManager.RegisterNightlyTask(authAgent.ResetAllUserRetryCounter)
 
-	//Register nightly task for clearup all user retry counter
-	nightlyManager.RegisterNightlyTask(authAgent.ExpDelayHandler.ResetAllUserRetryCounter)
+	//Register nightly task for pruning expired automatic IP bans
+	nightlyManager.RegisterNightlyTask(authAgent.RunBruteForceProtectionCleanup)
 
 	//Register nightly task for clearup all expired switchable account pools
 	nightlyManager.RegisterNightlyTask(authAgent.SwitchableAccountManager.RunNightlyCleanup)
 
+	//Register nightly task for removing expired password reset tokens
+	nightlyManager.RegisterNightlyTask(authAgent.RemoveExpiredPasswordResetTokens)
+
+	//Register nightly task for removing expired trusted devices
+	nightlyManager.RegisterNightlyTask(authAgent.RemoveExpiredTrustedDevices)
+
+	//Register nightly task for removing expired autologin tokens
+	nightlyManager.RegisterNightlyTask(authAgent.RemoveExpiredAutologinTokens)
+
+	//Register nightly task for pruning known devices that are not used for a long time
+	nightlyManager.RegisterNightlyTask(authAgent.RemoveExpiredKnownDevices)
+
+	//Register nightly task for removing public registered accounts that are not verified in time
+	nightlyManager.RegisterNightlyTask(func() {
+		authAgent.RemoveExpiredPendingAccounts(int64(*public_registry_pending_ttl))
+	})
+
 	/*
 		Account switching functions
 	*/
@@ -127,6 +372,42 @@ func AuthSettingsInit() {
 	userRouter.HandleFunc("/system/auth/u/switch", authAgent.SwitchableAccountManager.HandleAccountSwitch)
 	userRouter.HandleFunc("/system/auth/u/logoutAll", authAgent.SwitchableAccountManager.HandleLogoutAllAccounts)
 
+	//Return to the admin account after impersonation, the impersonated user might not be an admin
+	userRouter.HandleFunc("/system/auth/impersonate/stop", authAgent.SwitchableAccountManager.HandleImpersonateStop)
+
+	//Two-factor authentication
+	userRouter.HandleFunc("/system/auth/2fa/enroll", authAgent.HandleTOTPEnroll)
+	userRouter.HandleFunc("/system/auth/2fa/verify", authAgent.HandleTOTPVerify)
+	userRouter.HandleFunc("/system/auth/2fa/disable", authAgent.HandleTOTPDisable)
+
+	//Active sessions of the current user
+	userRouter.HandleFunc("/system/auth/sessions/list", authAgent.HandleListUserSessions)
+	userRouter.HandleFunc("/system/auth/sessions/revoke", authAgent.HandleRevokeUserSession)
+
+	//API keys of the current user for headless clients
+	userRouter.HandleFunc("/system/auth/apikey/create", authAgent.HandleCreateAPIKey)
+	userRouter.HandleFunc("/system/auth/apikey/list", authAgent.HandleListAPIKeys)
+	userRouter.HandleFunc("/system/auth/apikey/revoke", authAgent.HandleRevokeAPIKey)
+
+	//Trusted devices that skip 2FA
+	userRouter.HandleFunc("/system/auth/trusteddevice/list", authAgent.HandleListTrustedDevices)
+	userRouter.HandleFunc("/system/auth/trusteddevice/revoke", authAgent.HandleRevokeTrustedDevice)
+
+	//Autologin tokens of the current user
+	userRouter.HandleFunc("/system/auth/autologin/list", authAgent.HandleListAutologinTokens)
+	userRouter.HandleFunc("/system/auth/autologin/revoke", authAgent.HandleRevokeAutologinToken)
+
+	//Devices used to login and the new device login notification
+	userRouter.HandleFunc("/system/auth/knowndevice/list", authAgent.HandleListKnownDevices)
+	userRouter.HandleFunc("/system/auth/knowndevice/notify", authAgent.HandleSetNewDeviceNotification)
+	userRouter.HandleFunc("/system/auth/knowndevice/forget", authAgent.HandleForgetKnownDevice)
+
+	//WebAuthn / Passkey authenticators of the current user
+	userRouter.HandleFunc("/system/auth/webauthn/register/begin", authAgent.HandleWebAuthnRegisterBegin)
+	userRouter.HandleFunc("/system/auth/webauthn/register/finish", authAgent.HandleWebAuthnRegisterFinish)
+	userRouter.HandleFunc("/system/auth/webauthn/list", authAgent.HandleWebAuthnList)
+	userRouter.HandleFunc("/system/auth/webauthn/remove", authAgent.HandleWebAuthnRemove)
+
 	//API for not logged in pool check
 	http.HandleFunc("/system/auth/u/p/list", func(w http.ResponseWriter, r *http.Request) {
 		type ResumableSessionAccount struct {
@@ -149,7 +430,7 @@ func AuthSettingsInit() {
 }
 
 // Validate secure request that use authreq.html
-// Require POST: password and admin permission
+// Require POST: password and admin permission, or a step-up token issued to this session (see mod/auth/stepup.go)
 // return true if authentication passed
 func AuthValidateSecureRequest(w http.ResponseWriter, r *http.Request, requireAdmin bool) bool {
 	userinfo, err := userHandler.GetUserInfoFromRequest(w, r)
@@ -167,6 +448,11 @@ func AuthValidateSecureRequest(w http.ResponseWriter, r *http.Request, requireAd
 		}
 	}
 
+	//Password already confirmed within the step-up window
+	if authAgent.ValidateStepUpToken(r, userinfo.Username) {
+		return true
+	}
+
 	//Double check password for this user
 	password, err := utils.PostPara(r, "password")
 	if err != nil {
@@ -182,5 +468,30 @@ func AuthValidateSecureRequest(w http.ResponseWriter, r *http.Request, requireAd
 		return false
 	}
 
+	//Allow the following sensitive operations without password for a short while
+	authAgent.IssueStepUpToken(w, r, userinfo.Username)
 	return true
 }
+
+// Redirect the session to the account page for changing the expired password
+func redirectToPasswordChange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache, no-store, no-transform, must-revalidate, private, max-age=0")
+	http.Redirect(w, r, utils.ConstructRelativePathFromRequestURL(r.RequestURI, "SystemAO/users/account.html")+"?mustchangepw=true", http.StatusTemporaryRedirect)
+}
+
+// Show the maintenance page to the sessions paused by maintenance mode, API requests are replied with the maintenance message
+func showMaintenancePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache, no-store, no-transform, must-revalidate, private, max-age=0")
+	if !isPageNavigation(r) {
+		utils.SendErrorResponse(w, authAgent.GetMaintenanceMessage())
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	maintenancePage, err := os.ReadFile("./system/errors/maintenance.html")
+	if err != nil {
+		w.Write([]byte("503 - " + authAgent.GetMaintenanceMessage()))
+		return
+	}
+	w.Write(maintenancePage)
+}
@@ -135,7 +135,10 @@ func consoleCommandHandler(input string) string {
 		if matchSubfix(chunk, []string{"scan", "all"}, 2, "") {
 			//scan all nearby arozos units
 			fmt.Println("Scanning (Should take around 10s)")
-			hosts := MDNS.Scan(10, "")
+			hosts, err := MDNS.Scan(10, "")
+			if err != nil {
+				return err.Error()
+			}
 			for _, host := range hosts {
 				fmt.Println(host)
 			}
@@ -143,7 +146,10 @@ func consoleCommandHandler(input string) string {
 		} else if matchSubfix(chunk, []string{"scan", "aroz"}, 2, "") || matchSubfix(chunk, []string{"scan", "arozos"}, 2, "") {
 			//scan all nearby arozos units
 			fmt.Println("Scanning nearybe ArozOS Hosts (Should take around 10s)")
-			hosts := MDNS.Scan(10, "arozos.com")
+			hosts, err := MDNS.Scan(10, "arozos.com")
+			if err != nil {
+				return err.Error()
+			}
 			for _, host := range hosts {
 				fmt.Println(host)
 			}
@@ -572,7 +572,7 @@ func system_fs_handleLowMemoryUpload(w http.ResponseWriter, r *http.Request) {
 					os.RemoveAll(uploadFolder)
 				}
 				return
-			} else if !userinfo.StorageQuota.HaveSpace(totalFileSize) {
+			} else if err := authAgent.CheckStorageQuota(userinfo.Username, totalFileSize); err != nil {
 				//Quota exceeded
 				c.WriteMessage(1, []byte(`{\"error\":\"User Storage Quota Exceeded\"}`))
 
@@ -674,7 +674,7 @@ func system_fs_handleLowMemoryUpload(w http.ResponseWriter, r *http.Request) {
 		c.WriteMessage(1, []byte(`{\"error\":\"Failed to validate uploaded file\"}`))
 		return
 	}
-	if !userinfo.StorageQuota.HaveSpace(fi.Size()) {
+	if err := authAgent.CheckStorageQuota(userinfo.Username, fi.Size()); err != nil {
 		c.WriteMessage(1, []byte(`{\"error\":\"User Storage Quota Exceeded\"}`))
 		if fsh.RequireBuffer {
 			os.RemoveAll(mergeFileLocation)
@@ -830,8 +830,8 @@ func system_fs_handleUpload(w http.ResponseWriter, r *http.Request) {
 
 	//Check for storage quota
 	uploadFileSize := handler.Size
-	if !userinfo.StorageQuota.HaveSpace(uploadFileSize) {
-		utils.SendErrorResponse(w, "User Storage Quota Exceeded")
+	if err := authAgent.CheckStorageQuota(userinfo.Username, uploadFileSize); err != nil {
+		utils.SendErrorResponse(w, err.Error())
 		return
 	}
 
@@ -2035,8 +2035,8 @@ func system_fs_handleOpr(w http.ResponseWriter, r *http.Request) {
 				existsOpr, _ := utils.PostPara(r, "existsresp")
 
 				//Check if the user have space for the extra file
-				if !userinfo.StorageQuota.HaveSpace(filesystem.GetFileSize(rdestFile)) {
-					utils.SendErrorResponse(w, "Storage Quota Full")
+				if err := authAgent.CheckStorageQuota(userinfo.Username, filesystem.GetFileSize(rdestFile)); err != nil {
+					utils.SendErrorResponse(w, err.Error())
 					return
 				}
 
@@ -61,7 +61,11 @@ var disable_subservices = flag.Bool("disable_subservice", false, "Disable subser
 var allow_upnp = flag.Bool("allow_upnp", false, "Enable uPNP service, recommended for host under NAT router")
 var allow_ssdp = flag.Bool("allow_ssdp", true, "Enable SSDP service, disable this if you do not want your device to be scanned by Windows's Network Neighborhood Page")
 var allow_mdns = flag.Bool("allow_mdns", true, "Enable MDNS service. Allow device to be scanned by nearby ArOZ Hosts")
-var force_mac = flag.String("force_mac", "", "Force MAC address to be used for discovery services. If not set, it will use the first NIC")
+var mdns_seeds = flag.String("mdns_seeds", "", "Comma separated list of hosts (e.g. 10.0.1.5) or DNS-SD servers (e.g. dns://10.0.0.1/example.com) to query by unicast for discovering hosts in other subnets")
+var mdns_status_interval = flag.Int("mdns_status_interval", 60, "Interval in seconds of updating the load of this host (CPU, free storage, user count) advertised in MDNS TXT records. Set to 0 to disable")
+var mdns_watch_iface = flag.Bool("mdns_watch_iface", true, "Re-register MDNS service when the network interface addresses changed")
+var mdns_advertise_iface = flag.String("mdns_advertise_iface", "", "MAC address or interface name (e.g. eth0) to advertise the MDNS service on, comma seperated for multiple NICs. If not set, MDNS advertise on all multicast capable NICs including VPN and public interfaces")
+var force_mac = flag.String("force_mac", "", "Force MAC address or interface name (e.g. eth0) to be used for discovery services, comma seperated for multiple NICs. If not set, MDNS scan on all multicast capable NICs")
 var disable_ip_resolve_services = flag.Bool("disable_ip_resolver", false, "Disable IP resolving if the system is running under reverse proxy environment")
 var enable_gzip = flag.Bool("gzip", true, "Enable gzip compress on file server")
 
@@ -70,6 +74,9 @@ var use_tls = flag.Bool("tls", false, "Enable TLS on HTTP serving (HTTPS Mode)")
 var disable_http = flag.Bool("disable_http", false, "Disable HTTP server, require tls=true")
 var tls_cert = flag.String("cert", "localhost.crt", "TLS certificate file (.crt)")
 var tls_key = flag.String("key", "localhost.key", "TLS key file (.key)")
+var tls_client_ca = flag.String("tls_client_ca", "", "CA certificate file (.crt) for verifying TLS client certificates. Set to enable client certificate authentication, require tls=true")
+var tls_client_map = flag.String("tls_client_map", "./system/auth/clientcert.json", "File location of the client certificate to user mapping")
+var tls_client_paths = flag.String("tls_client_paths", "", "Comma separated path prefixes accepting client certificate authentication, e.g. /api/,/system/file_system/")
 var session_key = flag.String("session_key", "", "Session key, must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256). Leave empty for auto generated.")
 
 // Flags related to hardware or interfaces
@@ -98,15 +105,59 @@ var enable_buffering = flag.Bool("enable_buffpool", true, "Enable buffer pool fo
 var enable_beta_scanning_support = flag.Bool("beta_scan", false, "Allow compatibility to ArOZ Online Beta Clusters")
 var enable_console = flag.Bool("console", false, "Enable the debugging console.")
 var enable_logging = flag.Bool("logging", true, "Enable logging to file for debug purpose")
+var log_level = flag.String("log_level", "info", "Minimum level of system log to be written, accept debug, info, warn or error")
+var log_format = flag.String("log_format", "text", "Format of the system log file, accept text or json")
+var log_rotation = flag.String("log_rotation", "monthly", "Rotation period of the system log files, accept monthly, weekly or daily")
+var log_compress = flag.Bool("log_compress", false, "Gzip compress the system log files after rotation")
+var log_retention = flag.Int("log_retention", 0, "Number of months of system log files to keep, older files are removed nightly. Set to 0 to keep forever")
+var log_sync = flag.Bool("log_sync", false, "Write the system log synchronously, slower but no log lost on crash")
+var log_queue_size = flag.Int("log_queue_size", 1024, "Number of system log entries buffered for the background log writer")
+var log_drop_when_full = flag.Bool("log_drop_when_full", false, "Drop the system log entries instead of waiting when the log writer buffer is full")
+var log_to_file = flag.Bool("log_to_file", true, "Write the system log to files under system/logs/system/. Disable if the log is forwarded with log_syslog")
+var log_syslog = flag.String("log_syslog", "", "Forward the system log to syslog. Set to local for the local syslog daemon / journald, or udp://host:port or tcp://host:port for a remote server. Leave empty to disable")
+var log_error_file = flag.Bool("log_error_file", false, "Also write error entries of the system log to a dedicated system_error_{year}-{month}.log file")
+var log_sampling = flag.String("log_sampling", "", "Limit the system log entries per title during log storms, given as comma separated {title prefix}={count}/{window}, e.g. WebDAV=100/1s. Excess entries are replaced by a suppressed count summary. Leave empty to disable")
 
 // Flags related to running on Cloud Environment or public domain
+var external_url = flag.String("external_url", "", "External URL of this host as seen by the users, e.g. https://cloud.example.com. Required by passkey (WebAuthn) login and the links in verification emails")
 var allow_public_registry = flag.Bool("public_reg", false, "Enable public register interface for account creation")
+var bootstrap_setup_token = flag.Bool("setup_token", true, "Print a one-time setup token for creating the administrator account remotely on fresh install. If disabled, the administrator account can only be created from localhost")
+var public_registry_verify = flag.Bool("public_reg_verify", false, "Require email verification before public registered accounts can login")
+var password_reset_ttl = flag.Int("password_reset_ttl", 3600, "Time before a self-service password reset token expires in seconds")
+var password_hash_cost = flag.Int("password_hash_cost", 10, "bcrypt cost of password hashes (4 - 31). Existing hashes are upgraded on the next login after changing this value")
+var public_registry_pending_ttl = flag.Int("public_reg_pending_ttl", 172800, "Time before unverified public registered accounts are removed in seconds. Default 172800 seconds = 48 hours")
 var allow_autologin = flag.Bool("allow_autologin", true, "Allow RESTFUL login redirection that allow machines like billboards to login to the system on boot")
+var autologin_token_ttl = flag.Int("autologin_token_ttl", 0, "Default lifetime of new autologin tokens in seconds, 0 for never expire")
 var allow_package_autoInstall = flag.Bool("allow_pkg_install", true, "Allow the system to install package using Advanced Package Tool (aka apt or apt-get)")
 var allow_homepage = flag.Bool("homepage", true, "Enable user homepage. Accessible via /www/{username}/")
+var geoip_db = flag.String("geoip_db", "", "Path to a MaxMind GeoIP2 / GeoLite2 Country database (mmdb) for geo-IP login filtering. Leave empty to disable")
+var auth_log_rdns = flag.Bool("auth_log_rdns", false, "Resolve the reverse DNS hostname of the IP in login records")
+var autoban_threshold = flag.Int("autoban_threshold", 0, "Number of failed logins from an IP within the autoban window before it is banned automatically. Set to 0 to disable")
+var autoban_window = flag.Int("autoban_window", 600, "Time window for counting failed logins for automatic ban in seconds")
+var autoban_duration = flag.Int("autoban_duration", 3600, "Duration of automatic IP ban in seconds")
+var lockout_threshold = flag.Int("lockout_threshold", 0, "Number of consecutive failed logins before the account is locked and require admin unlock. Set to 0 to disable")
+var lockout_nightly_clear = flag.Bool("lockout_nightly_clear", false, "Unlock all locked accounts in the nightly login retry counter reset")
+var breached_password_api = flag.String("breached_password_api", auth.DefaultBreachedPasswordAPI, "HaveIBeenPwned compatible range API for rejecting breached passwords when enabled in the password policy. Only the first 5 characters of the password SHA-1 hash are sent. Leave empty to disable")
+var captcha_verify_url = flag.String("captcha_verify_url", "", "Siteverify API of the CAPTCHA provider for login challenges, e.g. https://hcaptcha.com/siteverify. Leave empty to disable")
+var captcha_secret = flag.String("captcha_secret", "", "Secret key of the CAPTCHA provider")
+var captcha_sitekey = flag.String("captcha_sitekey", "", "Public site key of the CAPTCHA provider for rendering the widget on the login page")
+var auth_max_body = flag.Int("auth_max_body", 64, "Max request body size of the login and register requests in KB")
+var csv_import_max_body = flag.Int("csv_import_max_body", 10, "Max request body size of the CSV account import in MB")
+var captcha_threshold = flag.Int("captcha_threshold", 3, "Number of failed logins from an IP before CAPTCHA is required, 0 to always require")
+var session_cache_ttl = flag.Int("session_cache_ttl", 5, "Time to cache the user info of a login session in seconds. Set to 0 to disable the cache for debugging")
+var totp_window = flag.Int("totp_window", 1, "Number of 30 seconds time steps before and after the current one that a 2FA code is accepted")
+var trusted_proxies = flag.String("trusted_proxies", "127.0.0.0/8,::1/128", "Comma separated IPs or CIDRs of the reverse proxies allowed to set the client IP header, e.g. the Cloudflare IP ranges")
+var client_ip_header = flag.String("client_ip_header", "", "Header carrying the client IP set by the trusted proxies, e.g. X-Forwarded-For, X-Real-IP or CF-Connecting-IP. Leave empty for X-Real-IP then X-Forwarded-For")
+var login_retry_window = flag.Int("login_retry_window", 900, "Reset the login retry delay of a user after this number of seconds without failed attempts. Set to 0 to only reset nightly")
+var trusted_device_ttl = flag.Int("trusted_device_ttl", 2592000, "Time before a trusted device require 2FA again in seconds. Default 30 days")
+var cookie_secure = flag.Bool("cookie_secure", false, "Always set the Secure attribute on session cookies. Enable this if TLS is terminated by a reverse proxy")
+var cookie_samesite = flag.String("cookie_samesite", "lax", "SameSite policy of session cookies {lax / strict / none}. None requires cookie_secure")
+var cookie_domain = flag.String("cookie_domain", "", "Domain attribute of session cookies. Leave empty for host only cookies")
+var cookie_path = flag.String("cookie_path", "/", "Path attribute of session cookies")
 
 // Scheduling and System Service Related
 var nightlyTaskRunTime = flag.Int("ntt", 3, "Nightly tasks execution time. Default 3 = 3 am in the morning")
+var nightlyTaskSpread = flag.Int("ntt_spread", 0, "Spread the nightly tasks across this number of minutes after the execution time, so they do not all run at once. Set to 0 to run them together")
 var maxTempFileKeepTime = flag.Int("tmp_time", 86400, "Time before tmp file will be deleted in seconds. Default 86400 seconds = 24 hours")
 
 // Flags related to ArozOS Cluster services
@@ -37,6 +37,9 @@ func SetupCloseHandler() {
 }
 
 func executeShutdownSequence() {
+	//Write logs inline so the shutdown messages are kept in order
+	systemWideLogger.Synchronous = true
+
 	//Shutdown authAgent
 	systemWideLogger.PrintAndLog("System", "<!> Shutting down auth gateway", nil)
 	authAgent.Close()
@@ -46,6 +49,7 @@ func executeShutdownSequence() {
 	closeAllStoragePools()
 
 	//Shutdown Logger
+	systemWideLogger.Flush()
 	systemWideLogger.Close()
 
 	//Shutdown database
@@ -123,7 +127,13 @@ func main() {
 			}
 			address := fmt.Sprintf("%s:%d", *listen_host, *tls_listen_port)
 			log.Println("Secure (HTTPS) Web server listening at", address)
-			http.ListenAndServeTLS(address, *tls_cert, *tls_key, nil)
+			if authAgent.ClientCertAuth != nil {
+				//Request client certificates for client certificate authentication
+				server := &http.Server{Addr: address, TLSConfig: authAgent.ClientCertAuth.TLSConfig()}
+				server.ListenAndServeTLS(*tls_cert, *tls_key)
+			} else {
+				http.ListenAndServeTLS(address, *tls_cert, *tls_key, nil)
+			}
 		} else {
 			address := fmt.Sprintf("%s:%d", *listen_host, *listen_port)
 			log.Println("Web server listening at", address)
@@ -8,7 +8,9 @@ package main
 */
 
 import (
+	"html"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -31,6 +33,7 @@ func mrouter(h http.Handler) http.Handler {
 			//Login page. Require special treatment for template.
 			//Get the redirection address from the request URL
 			red, _ := utils.GetPara(r, "redirect")
+			red = authAgent.SanitizeRedirectTarget(r, red)
 
 			//Append the redirection addr into the template
 			imgsrc := filepath.Join(vendorResRoot, "auth_icon.png")
@@ -38,11 +41,16 @@ func mrouter(h http.Handler) http.Handler {
 				imgsrc = "./web/img/public/auth_icon.png"
 			}
 			imageBase64, _ := utils.LoadImageAsBase64(imgsrc)
+
+			//Single sign-on button, see oidc.go
 			parsedPage, err := utils.Templateload("web/login.system", map[string]string{
 				"redirection_addr": red,
 				"usercount":        strconv.Itoa(authAgent.GetUserCounts()),
 				"service_logo":     imageBase64,
 				"login_addr":       "system/auth/login",
+				"sso_enabled":      strconv.FormatBool(oidcHandler.GetConfig().Enabled),
+				"sso_label":        html.EscapeString(oidcHandler.GetButtonLabel()),
+				"sso_login_addr":   "system/auth/oidc/login?redirect=" + url.QueryEscape(red),
 			})
 			if err != nil {
 				panic("Error. Unable to parse login page. Is web directory data exists?")
@@ -95,6 +103,16 @@ func mrouter(h http.Handler) http.Handler {
 		} else if r.URL.Path == "/" && authAgent.CheckAuth(r) {
 			//Use logged in and request the index. Serve the user's interface module
 			w.Header().Set("Cache-Control", "no-cache, no-store, no-transform, must-revalidate, private, max-age=0")
+			if authAgent.PasswordChangePending(w, r) {
+				//Password expired. Change it before entering the interface module
+				redirectToPasswordChange(w, r)
+				return
+			}
+			if authAgent.MaintenancePending(w, r) {
+				//Non-admin users cannot enter the interface module during maintenance
+				showMaintenancePage(w, r)
+				return
+			}
 			userinfo, err := userHandler.GetUserInfoFromRequest(w, r)
 			if err != nil {
 				//ERROR!! Server default
@@ -129,6 +147,16 @@ func mrouter(h http.Handler) http.Handler {
 		} else if authAgent.CheckAuth(r) {
 			//User logged in. Continue to serve the file the client want
 			authAgent.UpdateSessionExpireTime(w, r)
+			if isPageNavigation(r) && !strings.HasPrefix(r.URL.Path, "/SystemAO/users/") && authAgent.PasswordChangePending(w, r) {
+				//Password expired. Only the account page is accessible until it is changed
+				redirectToPasswordChange(w, r)
+				return
+			}
+			if isPageNavigation(r) && authAgent.MaintenancePending(w, r) {
+				//Pages are not accessible by non-admin users during maintenance
+				showMaintenancePage(w, r)
+				return
+			}
 			if build_version == "development" {
 				//Do something if development build
 				//w.Header().Add("Cross-Origin-Opener-Policy", "same-origin")
@@ -169,6 +197,9 @@ func mrouter(h http.Handler) http.Handler {
 				return
 			}
 			routerStaticContentServer(h, w, r)
+		} else if authAgent.HandleAPIKeyScopeDenied(w, r) {
+			//Valid API key used outside of its scope. Rejected with 403
+			return
 		} else {
 			//User not logged in. Check if the path end with public/. If yes, allow public access
 			if !fs.FileExists(filepath.Join("./web", r.URL.Path)) {
@@ -199,3 +230,9 @@ func routerStaticContentServer(h http.Handler, w http.ResponseWriter, r *http.Re
 		h.ServeHTTP(w, r)
 	}
 }
+
+// Check if the request is loading a page instead of its resources
+func isPageNavigation(r *http.Request) bool {
+	ext := strings.ToLower(filepath.Ext(r.URL.Path))
+	return strings.HasSuffix(r.URL.Path, "/") || ext == ".html" || ext == ".htm" || ext == ".system"
+}
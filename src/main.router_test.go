@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"imuslab.com/arozos/mod/auth"
+	"imuslab.com/arozos/mod/auth/accesscontrol/blacklist"
+	"imuslab.com/arozos/mod/auth/accesscontrol/whitelist"
+	"imuslab.com/arozos/mod/database"
+)
+
+func TestRouterAPIKeyStaticContent(t *testing.T) {
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "router.db"), false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer sysdb.Close()
+	sysdb.NewTable("auth")
+	sysdb.NewTable("auth_apikey")
+
+	//Swap in a minimal auth agent, subservices are not initialized in tests
+	previousAgent, previousSubservices := authAgent, *disable_subservices
+	defer func() {
+		authAgent, *disable_subservices = previousAgent, previousSubservices
+	}()
+	*disable_subservices = true
+	authAgent = &auth.AuthAgent{
+		SessionName:      "ao_auth",
+		SessionStore:     sessions.NewCookieStore([]byte("0123456789abcdef")),
+		Database:         sysdb,
+		SessionCache:     auth.NewSessionCache(16, time.Minute),
+		WhitelistManager: whitelist.NewWhitelistManager(sysdb),
+		BlacklistManager: blacklist.NewBlacklistManager(sysdb),
+	}
+	authAgent.CreateUserAccount("alice", "password", []string{"user"})
+	key, _, err := authAgent.CreateAPIKey("alice", "static", []string{"/NotepadA/"}, false)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	//Static content requested with an API key has no session to extend
+	served := false
+	router := mrouter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+	r := httptest.NewRequest("GET", "/NotepadA/ace/package.json", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if !served {
+		t.Errorf("Expected static content served to API key, got status %d", w.Code)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Expected no session cookie set for API key request")
+	}
+}
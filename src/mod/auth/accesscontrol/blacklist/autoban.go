@@ -0,0 +1,80 @@
+package blacklist
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"imuslab.com/arozos/mod/auth/accesscontrol"
+)
+
+/*
+	Automatic Ban
+
+	IPs that are banned automatically (e.g. by brute-force protection)
+	are written to the blacklist like manual bans, with an extra record
+	storing its expire time in the ipblacklist_auto table
+
+	ipblacklist_auto/{ip} => AutoBanRecord
+*/
+
+type AutoBanRecord struct {
+	IpAddr     string //The banned IP address
+	Reason     string //Reason of the ban
+	BannedTime int64  //Time when the ban is issued
+	ExpireTime int64  //Time when the ban expire
+}
+
+// Ban an IP for the given duration (in seconds). Manually banned IPs are not affected
+func (bl *BlackList) AutoBan(ip string, duration int64, reason string) error {
+	ip = accesscontrol.NormalizeIp(ip)
+	if bl.database.KeyExists("ipblacklist", ip) && !bl.database.KeyExists("ipblacklist_auto", ip) {
+		//Already banned manually
+		return nil
+	}
+
+	err := bl.Ban(ip)
+	if err != nil {
+		return err
+	}
+
+	log.Println("[Auth/Blacklist] " + ip + " banned automatically: " + reason)
+	return bl.database.Write("ipblacklist_auto", ip, AutoBanRecord{
+		IpAddr:     ip,
+		Reason:     reason,
+		BannedTime: time.Now().Unix(),
+		ExpireTime: time.Now().Unix() + duration,
+	})
+}
+
+// Get the auto ban record of the ip, return nil if the ip is not banned automatically
+func (bl *BlackList) GetAutoBanRecord(ip string) *AutoBanRecord {
+	record := AutoBanRecord{}
+	err := bl.database.Read("ipblacklist_auto", ip, &record)
+	if err != nil || record.IpAddr == "" {
+		return nil
+	}
+	return &record
+}
+
+// Remove all the expired auto bans
+func (bl *BlackList) PruneExpiredAutoBans() {
+	entries, err := bl.database.ListTable("ipblacklist_auto")
+	if err != nil {
+		return
+	}
+	for _, keypairs := range entries {
+		record := AutoBanRecord{}
+		err = json.Unmarshal(keypairs[1], &record)
+		if err != nil || record.ExpireTime < time.Now().Unix() {
+			bl.UnBan(string(keypairs[0]))
+			bl.database.Delete("ipblacklist_auto", string(keypairs[0]))
+		}
+	}
+}
+
+// Check if the ip is auto banned and the ban has expired
+func (bl *BlackList) autoBanExpired(ip string) bool {
+	record := bl.GetAutoBanRecord(ip)
+	return record != nil && record.ExpireTime < time.Now().Unix()
+}
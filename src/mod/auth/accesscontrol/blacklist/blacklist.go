@@ -3,6 +3,7 @@ package blacklist
 import (
 	"errors"
 	"log"
+	"net/http"
 	"strings"
 
 	"imuslab.com/arozos/mod/auth/accesscontrol"
@@ -20,12 +21,15 @@ import (
 */
 
 type BlackList struct {
-	Enabled  bool
-	database *db.Database
+	Enabled      bool
+	EventHandler func(r *http.Request, action string, target string, succeed bool, detail string) //Called when an IP is banned or unbanned by request, can be nil
+	database     *db.Database
 }
 
 func NewBlacklistManager(sysdb *db.Database) *BlackList {
 	sysdb.NewTable("ipblacklist")
+	sysdb.NewTable("ipblacklist_auto")
+	sysdb.NewTable("ipblacklist_reason")
 
 	blacklistEnabled := false
 	if sysdb.KeyExists("ipblacklist", "enable") {
@@ -46,8 +50,13 @@ func (bl *BlackList) IsBanned(ip string) bool {
 	if bl.Enabled == false {
 		return false
 	}
+	ip = accesscontrol.NormalizeIp(ip)
 	if bl.database.KeyExists("ipblacklist", ip) {
-		return true
+		if !bl.autoBanExpired(ip) {
+			return true
+		}
+		//Automatic ban expired. Lift the ban
+		bl.UnBan(ip)
 	}
 
 	//The ip might be inside as a range. Do a range search.
@@ -105,5 +114,7 @@ func (bl *BlackList) UnBan(ipRange string) error {
 	}
 
 	//Ip range exists, remove it from database
+	bl.database.Delete("ipblacklist_auto", ipRange)
+	bl.database.Delete("ipblacklist_reason", ipRange)
 	return bl.database.Delete("ipblacklist", ipRange)
 }
@@ -140,6 +140,39 @@ func TestBlackList_Ban_UnBan(t *testing.T) {
 	}
 }
 
+func TestBlackList_BanCIDR(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+
+	// Create a new database
+	var err error
+	sysDb, err = database.NewDatabase(dbFilePath+dbFileName, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new database: %v", err)
+	}
+
+	bl := NewBlacklistManager(sysDb)
+	bl.SetBlacklistEnabled(true)
+
+	// Test case 1: Ban a /24 subnet
+	err = bl.Ban("192.168.3.0/24")
+	if err != nil {
+		t.Fatalf("Unexpected error when banning subnet: %v", err)
+	}
+	if !bl.IsBanned("192.168.3.255") || !bl.IsBanned("::ffff:192.168.3.1") {
+		t.Error("Expected IP inside subnet to be banned")
+	}
+	if bl.IsBanned("192.168.4.0") {
+		t.Error("Expected IP just outside subnet to not be banned")
+	}
+
+	// Test case 2: Unban the subnet
+	bl.UnBan("192.168.3.0/24")
+	if bl.IsBanned("192.168.3.1") {
+		t.Error("Expected subnet to be unbanned")
+	}
+}
+
 func TestBlackList_InvalidIpRange(t *testing.T) {
 	teardownSuite := setupSuite(t)
 	defer teardownSuite(t)
@@ -166,3 +199,51 @@ func TestBlackList_InvalidIpRange(t *testing.T) {
 		t.Error("Expected error for invalid IP range")
 	}
 }
+
+func TestBlackList_AutoBan(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+
+	var err error
+	sysDb, err = database.NewDatabase(dbFilePath+dbFileName, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new database: %v", err)
+	}
+
+	bl := NewBlacklistManager(sysDb)
+	bl.SetBlacklistEnabled(true)
+
+	// Active auto ban
+	bl.AutoBan("192.168.1.10", 3600, "test")
+	if !bl.IsBanned("192.168.1.10") {
+		t.Error("Expected IP to be auto banned")
+	}
+	if record := bl.GetAutoBanRecord("192.168.1.10"); record == nil || record.Reason != "test" {
+		t.Error("Expected auto ban record to exist")
+	}
+
+	// Expired auto ban should be lifted
+	bl.AutoBan("192.168.1.11", -1, "test")
+	if bl.IsBanned("192.168.1.11") {
+		t.Error("Expected expired auto ban to be lifted")
+	}
+
+	// Manual ban should not be turned into an auto ban
+	bl.Ban("192.168.1.12")
+	bl.AutoBan("192.168.1.12", -1, "test")
+	if bl.GetAutoBanRecord("192.168.1.12") != nil || !bl.IsBanned("192.168.1.12") {
+		t.Error("Expected manual ban to be kept")
+	}
+
+	// Prune removes expired auto bans only
+	bl.AutoBan("192.168.1.13", -1, "test")
+	bl.PruneExpiredAutoBans()
+	for _, ipRange := range bl.ListBannedIpRanges() {
+		if ipRange == "192.168.1.13" {
+			t.Error("Expected expired auto ban to be pruned")
+		}
+	}
+	if !bl.IsBanned("192.168.1.10") {
+		t.Error("Expected active auto ban to survive pruning")
+	}
+}
@@ -0,0 +1,255 @@
+package blacklist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/auth/accesscontrol"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Bulk Ban List Management
+
+	Import, export and bulk unban of the ban list, e.g. for loading threat
+	intelligence lists or syncing the ban list across nodes. Accepted formats
+
+	1. Plain text, one IP or IP range per line. Text after # or ; is ignored
+	2. CSV, ip_range,reason,banned_time,expire_time (same as the CSV export)
+	3. JSON array of BanListEntry (same as the JSON export)
+
+	Reason of the bans without expire time are stored as
+	ipblacklist_reason/{ip range} => reason
+*/
+
+type BanListEntry struct {
+	IpRange    string //The banned IP or IP range
+	Reason     string `json:",omitempty"` //Reason of the ban, can be empty
+	BannedTime int64  `json:",omitempty"` //Time when the ban is issued, 0 if unknown
+	ExpireTime int64  `json:",omitempty"` //Time when the ban expire, 0 for permanent
+}
+
+type BulkResult struct {
+	Added   int      //Number of IP ranges added to or removed from the ban list
+	Skipped int      //Number of duplicated, not banned or expired entries
+	Invalid []string //Entries with invalid IP range
+}
+
+// Header of the CSV export
+var csvHeader = []string{"ip_range", "reason", "banned_time", "expire_time"}
+
+// Export all entries in the ban list with their metadata
+func (bl *BlackList) ExportBanList() []*BanListEntry {
+	results := []*BanListEntry{}
+	for _, ipRange := range bl.ListBannedIpRanges() {
+		thisEntry := BanListEntry{IpRange: ipRange}
+		if autoBanRecord := bl.GetAutoBanRecord(ipRange); autoBanRecord != nil {
+			thisEntry.Reason = autoBanRecord.Reason
+			thisEntry.BannedTime = autoBanRecord.BannedTime
+			thisEntry.ExpireTime = autoBanRecord.ExpireTime
+		} else {
+			thisEntry.Reason = bl.getBanReason(ipRange)
+		}
+		results = append(results, &thisEntry)
+	}
+	return results
+}
+
+// Import the entries into the ban list. Already banned and expired entries are skipped
+func (bl *BlackList) ImportBanList(entries []*BanListEntry) *BulkResult {
+	result := BulkResult{Invalid: []string{}}
+	now := time.Now().Unix()
+	for _, entry := range entries {
+		ipRange := normalizeIpRange(entry.IpRange)
+		if accesscontrol.ValidateIpRange(ipRange) != nil {
+			result.Invalid = append(result.Invalid, entry.IpRange)
+			continue
+		}
+		if bl.database.KeyExists("ipblacklist", ipRange) || (entry.ExpireTime > 0 && entry.ExpireTime < now) {
+			result.Skipped++
+			continue
+		}
+
+		if bl.Ban(ipRange) != nil {
+			result.Invalid = append(result.Invalid, entry.IpRange)
+			continue
+		}
+		if entry.ExpireTime > 0 {
+			//Expiring ban, lifted like an automatic ban
+			bannedTime := entry.BannedTime
+			if bannedTime <= 0 {
+				bannedTime = now
+			}
+			bl.database.Write("ipblacklist_auto", ipRange, AutoBanRecord{
+				IpAddr:     ipRange,
+				Reason:     entry.Reason,
+				BannedTime: bannedTime,
+				ExpireTime: entry.ExpireTime,
+			})
+		} else if entry.Reason != "" {
+			bl.database.Write("ipblacklist_reason", ipRange, entry.Reason)
+		}
+		result.Added++
+	}
+	return &result
+}
+
+// Unban all the given IP ranges. IP ranges that are not banned are skipped
+func (bl *BlackList) BulkUnBan(ipRanges []string) *BulkResult {
+	result := BulkResult{Invalid: []string{}}
+	for _, ipRange := range ipRanges {
+		normalizedRange := normalizeIpRange(ipRange)
+		if accesscontrol.ValidateIpRange(normalizedRange) != nil {
+			result.Invalid = append(result.Invalid, ipRange)
+			continue
+		}
+		if bl.UnBan(normalizedRange) != nil {
+			result.Skipped++
+			continue
+		}
+		result.Added++
+	}
+	return &result
+}
+
+// Parse the ban list in plain text, CSV or JSON format
+func ParseBanList(data string) ([]*BanListEntry, error) {
+	data = strings.TrimSpace(data)
+	entries := []*BanListEntry{}
+	if strings.HasPrefix(data, "[") {
+		err := json.Unmarshal([]byte(data), &entries)
+		if err != nil {
+			return nil, errors.New("invalid JSON ban list")
+		}
+		return entries, nil
+	}
+
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.New("invalid ban list: " + err.Error())
+		}
+
+		//Remove trailing comments of the IP list, e.g. 1.2.3.0/24 ; SBL123
+		ipRange := strings.TrimSpace(strings.SplitN(strings.SplitN(fields[0], "#", 2)[0], ";", 2)[0])
+		if ipRange == "" || strings.EqualFold(ipRange, csvHeader[0]) {
+			continue
+		}
+
+		thisEntry := BanListEntry{IpRange: ipRange}
+		if len(fields) > 1 {
+			thisEntry.Reason = strings.TrimSpace(fields[1])
+		}
+		if len(fields) > 2 {
+			thisEntry.BannedTime, _ = strconv.ParseInt(strings.TrimSpace(fields[2]), 10, 64)
+		}
+		if len(fields) > 3 {
+			thisEntry.ExpireTime, _ = strconv.ParseInt(strings.TrimSpace(fields[3]), 10, 64)
+		}
+		entries = append(entries, &thisEntry)
+	}
+	return entries, nil
+}
+
+// Get the reason of a ban without expire time
+func (bl *BlackList) getBanReason(ipRange string) string {
+	reason := ""
+	if bl.database.TableExists("ipblacklist_reason") && bl.database.KeyExists("ipblacklist_reason", ipRange) {
+		bl.database.Read("ipblacklist_reason", ipRange, &reason)
+	}
+	return reason
+}
+
+func normalizeIpRange(ipRange string) string {
+	return strings.ReplaceAll(strings.TrimSpace(ipRange), " ", "")
+}
+
+// Handle import of the ban list. Require POST list in plain text, CSV or JSON format
+func (bl *BlackList) HandleImportBanList(w http.ResponseWriter, r *http.Request) {
+	list, err := utils.PostPara(r, "list")
+	if err != nil {
+		utils.SendErrorResponse(w, "Empty ban list given")
+		return
+	}
+
+	entries, err := ParseBanList(list)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	result := bl.ImportBanList(entries)
+	bl.emitBulkEvent(r, "ban", result)
+	js, _ := json.Marshal(result)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Handle export of the ban list. Accept GET format (json / csv), default json
+func (bl *BlackList) HandleExportBanList(w http.ResponseWriter, r *http.Request) {
+	format, _ := utils.GetPara(r, "format")
+	entries := bl.ExportBanList()
+	if format != "csv" {
+		js, _ := json.Marshal(entries)
+		utils.SendJSONResponse(w, string(js))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"banlist.csv\"")
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+	for _, entry := range entries {
+		writer.Write([]string{
+			entry.IpRange,
+			entry.Reason,
+			strconv.FormatInt(entry.BannedTime, 10),
+			strconv.FormatInt(entry.ExpireTime, 10),
+		})
+	}
+	writer.Flush()
+}
+
+// Handle bulk unban. Require POST list of IP ranges, one per line
+func (bl *BlackList) HandleBulkUnban(w http.ResponseWriter, r *http.Request) {
+	list, err := utils.PostPara(r, "list")
+	if err != nil {
+		utils.SendErrorResponse(w, "Empty ip range list given")
+		return
+	}
+
+	entries, err := ParseBanList(list)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	ipRanges := []string{}
+	for _, entry := range entries {
+		ipRanges = append(ipRanges, entry.IpRange)
+	}
+
+	result := bl.BulkUnBan(ipRanges)
+	bl.emitBulkEvent(r, "unban", result)
+	js, _ := json.Marshal(result)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Notify the event handler of a bulk ban list change
+func (bl *BlackList) emitBulkEvent(r *http.Request, action string, result *BulkResult) {
+	if bl.EventHandler == nil {
+		return
+	}
+	detail := strconv.Itoa(result.Added) + " changed, " + strconv.Itoa(result.Skipped) + " skipped, " + strconv.Itoa(len(result.Invalid)) + " invalid"
+	bl.EventHandler(r, action, "bulk", result.Added > 0, detail)
+}
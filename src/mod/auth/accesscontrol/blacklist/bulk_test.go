@@ -0,0 +1,75 @@
+package blacklist
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"imuslab.com/arozos/mod/database"
+)
+
+func TestBulkImportExport(t *testing.T) {
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "bulk.db"), false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer sysdb.Close()
+	bl := NewBlacklistManager(sysdb)
+	bl.SetBlacklistEnabled(true)
+	bl.Ban("10.0.0.1")
+
+	expire := time.Now().Unix() + 3600
+	list := "# threat intel feed\n" +
+		"10.0.0.1\n" +
+		"192.168.5.0/24 ; SBL123\n" +
+		"172.16.0.1,scanner\n" +
+		"172.16.0.2,brute force,0," + strconv.FormatInt(expire, 10) + "\n" +
+		"172.16.0.3,old,0,1000\n" +
+		"not-an-ip\n"
+	entries, err := ParseBanList(list)
+	if err != nil {
+		t.Fatalf("Failed to parse ban list: %v", err)
+	}
+
+	result := bl.ImportBanList(entries)
+	if result.Added != 3 || result.Skipped != 2 || len(result.Invalid) != 1 {
+		t.Fatalf("Unexpected import result: %+v", result)
+	}
+	if !bl.IsBanned("192.168.5.20") || !bl.IsBanned("172.16.0.2") || bl.IsBanned("172.16.0.3") {
+		t.Error("Unexpected ban state after import")
+	}
+
+	//Importing again is idempotent
+	if result := bl.ImportBanList(entries); result.Added != 0 || result.Skipped != 5 {
+		t.Errorf("Expected re-import to skip all entries, got %+v", result)
+	}
+
+	//Metadata is preserved in the export and can be imported to another node
+	exported := bl.ExportBanList()
+	reasons := map[string]*BanListEntry{}
+	for _, entry := range exported {
+		reasons[entry.IpRange] = entry
+	}
+	if len(exported) != 4 || reasons["172.16.0.1"].Reason != "scanner" || reasons["172.16.0.2"].ExpireTime != expire {
+		t.Errorf("Unexpected export: %+v", reasons)
+	}
+
+	otherdb, _ := database.NewDatabase(filepath.Join(t.TempDir(), "other.db"), false)
+	defer otherdb.Close()
+	other := NewBlacklistManager(otherdb)
+	if result := other.ImportBanList(exported); result.Added != 4 {
+		t.Errorf("Expected all exported entries to be imported, got %+v", result)
+	}
+	if other.getBanReason("172.16.0.1") != "scanner" || other.GetAutoBanRecord("172.16.0.2") == nil {
+		t.Error("Expected ban metadata to be imported")
+	}
+
+	result = bl.BulkUnBan([]string{"10.0.0.1", "192.168.5.0/24", "8.8.8.8", "bad"})
+	if result.Added != 2 || result.Skipped != 1 || len(result.Invalid) != 1 {
+		t.Errorf("Unexpected bulk unban result: %+v", result)
+	}
+	if bl.getBanReason("192.168.5.0/24") != "" {
+		t.Error("Expected ban reason to be removed on unban")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"imuslab.com/arozos/mod/auth/accesscontrol"
 	"imuslab.com/arozos/mod/network"
 	"imuslab.com/arozos/mod/utils"
 )
@@ -22,6 +23,7 @@ func (bl *BlackList) HandleAddBannedIP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = bl.Ban(ipRange)
+	bl.emitEvent(r, "ban", ipRange, err)
 	if err != nil {
 		utils.SendErrorResponse(w, err.Error())
 		return
@@ -38,6 +40,7 @@ func (bl *BlackList) HandleRemoveBannedIP(w http.ResponseWriter, r *http.Request
 	}
 
 	err = bl.UnBan(ipRange)
+	bl.emitEvent(r, "unban", ipRange, err)
 	if err != nil {
 		utils.SendErrorResponse(w, err.Error())
 		return
@@ -75,8 +78,28 @@ func (bl *BlackList) SetBlacklistEnabled(enabled bool) {
 	}
 }
 
+type BannedIpRangeEntry struct {
+	*accesscontrol.IpRangeEntry
+	Automatic  bool   //If this ban is issued automatically or imported with expire time
+	Reason     string //Reason of the ban
+	ExpireTime int64  //Expire time of automatic ban
+}
+
 func (bl *BlackList) HandleListBannedIPs(w http.ResponseWriter, r *http.Request) {
-	bannedIpRanges := bl.ListBannedIpRanges()
+	bannedIpRanges := []*BannedIpRangeEntry{}
+	for _, ipRangeEntry := range accesscontrol.GetIpRangeEntries(bl.ListBannedIpRanges()) {
+		thisEntry := BannedIpRangeEntry{
+			IpRangeEntry: ipRangeEntry,
+		}
+		if autoBanRecord := bl.GetAutoBanRecord(ipRangeEntry.IpRange); autoBanRecord != nil {
+			thisEntry.Automatic = true
+			thisEntry.Reason = autoBanRecord.Reason
+			thisEntry.ExpireTime = autoBanRecord.ExpireTime
+		} else {
+			thisEntry.Reason = bl.getBanReason(ipRangeEntry.IpRange)
+		}
+		bannedIpRanges = append(bannedIpRanges, &thisEntry)
+	}
 	js, _ := json.Marshal(bannedIpRanges)
 	utils.SendJSONResponse(w, string(js))
 }
@@ -94,3 +117,15 @@ func (bl *BlackList) CheckIsBannedByRequest(r *http.Request) bool {
 
 	return bl.IsBanned(requestIP)
 }
+
+// Notify the event handler of a ban list change made by request
+func (bl *BlackList) emitEvent(r *http.Request, action string, ipRange string, err error) {
+	if bl.EventHandler == nil {
+		return
+	}
+	if err != nil {
+		bl.EventHandler(r, action, ipRange, false, err.Error())
+		return
+	}
+	bl.EventHandler(r, action, ipRange, true, "")
+}
@@ -0,0 +1,138 @@
+package geofilter
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+
+	"imuslab.com/arozos/mod/auth/accesscontrol"
+	"imuslab.com/arozos/mod/database"
+)
+
+/*
+	Geo-IP Filter
+
+	This module allow or block login requests by the country of the
+	request origin. The country is resolved with a pluggable Resolver
+	(e.g. a MaxMind GeoLite2 / GeoIP2 Country database, see maxmind.go).
+
+	If no Resolver is set, the filter is a no-op. Loopback and private
+	network addresses are never filtered.
+
+	Settings are stored in the ipgeofilter table as
+	ipgeofilter/enable => bool
+	ipgeofilter/mode => "allow" or "block"
+	ipgeofilter/countries => []string (ISO 3166-1 alpha-2 codes)
+*/
+
+const (
+	ModeAllow = "allow" //Only the listed countries can login
+	ModeBlock = "block" //The listed countries cannot login
+)
+
+// Interface for resolving the country of an IP address
+type Resolver interface {
+	//Return the ISO 3166-1 alpha-2 country code of the IP, e.g. "HK"
+	LookupCountry(ip net.IP) (string, error)
+}
+
+type GeoFilter struct {
+	Enabled   bool
+	Mode      string
+	Countries []string
+	Resolver  Resolver //Country resolver, filter disabled if nil
+	database  *database.Database
+}
+
+func NewGeoFilterManager(sysdb *database.Database) *GeoFilter {
+	sysdb.NewTable("ipgeofilter")
+
+	thisFilter := GeoFilter{
+		Enabled:   false,
+		Mode:      ModeBlock,
+		Countries: []string{},
+		database:  sysdb,
+	}
+
+	if sysdb.KeyExists("ipgeofilter", "enable") {
+		err := sysdb.Read("ipgeofilter", "enable", &thisFilter.Enabled)
+		if err != nil {
+			log.Println("[Auth/GeoFilter] Unable to load previous enable state from database. Using default.")
+		}
+	}
+	sysdb.Read("ipgeofilter", "mode", &thisFilter.Mode)
+	sysdb.Read("ipgeofilter", "countries", &thisFilter.Countries)
+	return &thisFilter
+}
+
+// Check if the filter is active, i.e. enabled with a country resolver available
+func (g *GeoFilter) Active() bool {
+	return g.Enabled && g.Resolver != nil
+}
+
+// Check if the given ip is allowed to login, return the resolved country code
+func (g *GeoFilter) IsAllowed(ip string) (bool, string) {
+	if !g.Active() {
+		return true, ""
+	}
+
+	parsedIP := net.ParseIP(accesscontrol.NormalizeIp(ip))
+	if parsedIP == nil {
+		return g.Mode != ModeAllow, ""
+	}
+
+	if parsedIP.IsLoopback() || parsedIP.IsPrivate() || parsedIP.IsLinkLocalUnicast() {
+		//LAN access is never filtered
+		return true, ""
+	}
+
+	country, err := g.Resolver.LookupCountry(parsedIP)
+	if err != nil || country == "" {
+		//Unknown origin. Only allow it if in block list mode
+		return g.Mode != ModeAllow, ""
+	}
+
+	country = strings.ToUpper(country)
+	listed := false
+	for _, thisCountry := range g.Countries {
+		if thisCountry == country {
+			listed = true
+			break
+		}
+	}
+
+	if g.Mode == ModeAllow {
+		return listed, country
+	}
+	return !listed, country
+}
+
+func (g *GeoFilter) SetGeoFilterEnabled(enabled bool) {
+	g.Enabled = enabled
+	g.database.Write("ipgeofilter", "enable", enabled)
+}
+
+// Set the filter mode and the list of country codes
+func (g *GeoFilter) SetCountries(mode string, countries []string) error {
+	if mode != ModeAllow && mode != ModeBlock {
+		return errors.New("invalid mode given")
+	}
+
+	normalizedCountries := []string{}
+	for _, country := range countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if country == "" {
+			continue
+		}
+		if len(country) != 2 {
+			return errors.New("invalid country code: " + country)
+		}
+		normalizedCountries = append(normalizedCountries, country)
+	}
+
+	g.Mode = mode
+	g.Countries = normalizedCountries
+	g.database.Write("ipgeofilter", "mode", mode)
+	return g.database.Write("ipgeofilter", "countries", normalizedCountries)
+}
@@ -0,0 +1,69 @@
+package geofilter
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"imuslab.com/arozos/mod/database"
+)
+
+type mockResolver map[string]string
+
+func (m mockResolver) LookupCountry(ip net.IP) (string, error) {
+	country, ok := m[ip.String()]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return country, nil
+}
+
+func TestGeoFilter_IsAllowed(t *testing.T) {
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "geo.db"), false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer sysdb.Close()
+
+	g := NewGeoFilterManager(sysdb)
+	g.SetGeoFilterEnabled(true)
+	g.SetCountries(ModeBlock, []string{"xx", " YY"})
+
+	//No-op without resolver
+	if ok, _ := g.IsAllowed("203.0.113.1"); !ok {
+		t.Error("Expected filter without resolver to allow all requests")
+	}
+
+	g.Resolver = mockResolver{"203.0.113.1": "XX", "203.0.113.2": "HK"}
+	tests := []struct {
+		mode    string
+		ip      string
+		allowed bool
+	}{
+		{ModeBlock, "203.0.113.1", false},
+		{ModeBlock, "203.0.113.2", true},
+		{ModeBlock, "203.0.113.3", true}, //Unknown country
+		{ModeBlock, "192.168.0.10", true},
+		{ModeAllow, "203.0.113.1", true},
+		{ModeAllow, "203.0.113.2", false},
+		{ModeAllow, "203.0.113.3", false}, //Unknown country
+		{ModeAllow, "127.0.0.1", true},
+	}
+	for _, test := range tests {
+		g.SetCountries(test.mode, g.Countries)
+		if ok, _ := g.IsAllowed(test.ip); ok != test.allowed {
+			t.Errorf("Mode %s, IP %s: expected allowed=%v, got %v", test.mode, test.ip, test.allowed, ok)
+		}
+	}
+
+	//Settings are persisted
+	g = NewGeoFilterManager(sysdb)
+	if !g.Enabled || g.Mode != ModeAllow || len(g.Countries) != 2 || g.Countries[1] != "YY" {
+		t.Errorf("Unexpected settings loaded: %+v", g)
+	}
+
+	if err := g.SetCountries("invalid", nil); err == nil {
+		t.Error("Expected invalid mode to be rejected")
+	}
+}
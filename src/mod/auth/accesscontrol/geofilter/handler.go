@@ -0,0 +1,66 @@
+package geofilter
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Handler for geo-IP filter module
+
+*/
+
+// Handle the geo filter settings. Accept POST enable, mode and countries (comma seperated). Leave all empty for reading the current settings
+func (g *GeoFilter) HandleGeoFilterSettings(w http.ResponseWriter, r *http.Request) {
+	enable, _ := utils.PostPara(r, "enable")
+	mode, _ := utils.PostPara(r, "mode")
+	countries, countriesErr := utils.PostPara(r, "countries")
+	if enable == "" && mode == "" && countriesErr != nil {
+		type GeoFilterSettings struct {
+			Enabled   bool
+			Available bool //If a GeoIP database is loaded
+			Mode      string
+			Countries []string
+		}
+
+		js, _ := json.Marshal(GeoFilterSettings{
+			Enabled:   g.Enabled,
+			Available: g.Resolver != nil,
+			Mode:      g.Mode,
+			Countries: g.Countries,
+		})
+		utils.SendJSONResponse(w, string(js))
+		return
+	}
+
+	if mode != "" || countriesErr == nil {
+		if mode == "" {
+			mode = g.Mode
+		}
+		countryList := g.Countries
+		if countriesErr == nil {
+			countryList = strings.Split(countries, ",")
+		}
+		err := g.SetCountries(mode, countryList)
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+	}
+
+	switch strings.ToLower(enable) {
+	case "":
+	case "true":
+		g.SetGeoFilterEnabled(true)
+	case "false":
+		g.SetGeoFilterEnabled(false)
+	default:
+		utils.SendErrorResponse(w, "Invalid enable state given")
+		return
+	}
+
+	utils.SendOK(w)
+}
@@ -0,0 +1,64 @@
+package geofilter
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+/*
+	MaxMind Resolver
+
+	Resolve the country with a MaxMind GeoIP2 / GeoLite2 Country
+	or City database in mmdb format
+*/
+
+type MaxMindResolver struct {
+	reader *maxminddb.Reader
+}
+
+type maxmindCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type maxmindCityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// Open a MaxMind database file as country resolver
+func NewMaxMindResolver(dbPath string) (*MaxMindResolver, error) {
+	reader, err := maxminddb.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return &MaxMindResolver{
+		reader: reader,
+	}, nil
+}
+
+func (m *MaxMindResolver) LookupCountry(ip net.IP) (string, error) {
+	record := maxmindCountryRecord{}
+	err := m.reader.Lookup(ip, &record)
+	if err != nil {
+		return "", err
+	}
+	return record.Country.ISOCode, nil
+}
+
+// Lookup the English city name of the IP, only available with a City database
+func (m *MaxMindResolver) LookupCity(ip net.IP) (string, error) {
+	record := maxmindCityRecord{}
+	err := m.reader.Lookup(ip, &record)
+	if err != nil {
+		return "", err
+	}
+	return record.City.Names["en"], nil
+}
+
+func (m *MaxMindResolver) Close() error {
+	return m.reader.Close()
+}
@@ -0,0 +1,60 @@
+package timewindow
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Handler for login time window module
+
+*/
+
+// Handle the login time window settings. Accept POST group and schedule (JSON), or remove=true to always allow the group.
+// Leave group empty for listing all schedules
+func (m *TimeWindowManager) HandleTimeWindowSettings(w http.ResponseWriter, r *http.Request) {
+	group, err := utils.PostPara(r, "group")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(m.ListSchedules())
+		utils.SendJSONResponse(w, string(js))
+		return
+	}
+
+	remove, _ := utils.PostPara(r, "remove")
+	if remove == "true" {
+		err = m.SetSchedule(group, nil)
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+		log.Println("[Auth/TimeWindow] Login time window of " + group + " removed")
+		utils.SendOK(w)
+		return
+	}
+
+	scheduleJSON, err := utils.PostPara(r, "schedule")
+	if err != nil {
+		utils.SendErrorResponse(w, "Invalid schedule given")
+		return
+	}
+
+	newSchedule := Schedule{}
+	err = json.Unmarshal([]byte(scheduleJSON), &newSchedule)
+	if err != nil {
+		utils.SendErrorResponse(w, "Invalid schedule given")
+		return
+	}
+
+	err = m.SetSchedule(group, &newSchedule)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[Auth/TimeWindow] Login time window of " + group + " updated")
+	utils.SendOK(w)
+}
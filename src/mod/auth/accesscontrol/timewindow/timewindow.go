@@ -0,0 +1,263 @@
+package timewindow
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"imuslab.com/arozos/mod/database"
+)
+
+/*
+	Login Time Windows
+
+	This module restrict the login of a permission group to the given
+	time windows, e.g. Mon-Fri 08:00-18:00. Groups without a schedule
+	are always allowed to login.
+
+	When a user belongs to multiple groups, the login is allowed if any
+	of the groups allows it, so a user in a group without schedule is
+	never restricted.
+
+	Each schedule has its own timezone (IANA name, e.g. Asia/Hong_Kong).
+	An empty timezone means the local time of the server. A window with
+	End earlier than Start crosses midnight, e.g. 22:00-02:00.
+
+	Schedules are stored in the logintimewindow table as
+	logintimewindow/{groupname} => Schedule
+*/
+
+type Window struct {
+	Days  []string //Days of week the window starts on, e.g. ["mon","tue"]. Empty for every day
+	Start string   //Start time in HH:MM
+	End   string   //End time in HH:MM, 24:00 for end of day
+}
+
+type Schedule struct {
+	Timezone string   //IANA timezone name of the windows, empty for server local time
+	Windows  []Window //Login is allowed if the time falls in any of the windows
+}
+
+type TimeWindowManager struct {
+	schedules map[string]*Schedule
+	mutex     sync.RWMutex
+	database  *database.Database
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func NewTimeWindowManager(sysdb *database.Database) *TimeWindowManager {
+	sysdb.NewTable("logintimewindow")
+
+	thisManager := TimeWindowManager{
+		schedules: map[string]*Schedule{},
+		database:  sysdb,
+	}
+
+	entries, err := sysdb.ListTable("logintimewindow")
+	if err != nil {
+		log.Println("[Auth/TimeWindow] Unable to load login time windows: " + err.Error())
+		return &thisManager
+	}
+	for _, keypairs := range entries {
+		thisSchedule := Schedule{}
+		if json.Unmarshal(keypairs[1], &thisSchedule) == nil {
+			thisManager.schedules[string(keypairs[0])] = &thisSchedule
+		}
+	}
+	return &thisManager
+}
+
+// Get the schedule of a group, return nil if the group is always allowed
+func (m *TimeWindowManager) GetSchedule(group string) *Schedule {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.schedules[group]
+}
+
+// List the schedules of all groups
+func (m *TimeWindowManager) ListSchedules() map[string]*Schedule {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	results := map[string]*Schedule{}
+	for group, schedule := range m.schedules {
+		results[group] = schedule
+	}
+	return results
+}
+
+// Set the schedule of a group, set schedule to nil to always allow the group
+func (m *TimeWindowManager) SetSchedule(group string, schedule *Schedule) error {
+	if group == "" {
+		return errors.New("invalid group name given")
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if schedule == nil {
+		delete(m.schedules, group)
+		return m.database.Delete("logintimewindow", group)
+	}
+
+	err := schedule.Validate()
+	if err != nil {
+		return err
+	}
+	m.schedules[group] = schedule
+	return m.database.Write("logintimewindow", group, schedule)
+}
+
+// Check if the user with the given groups can login at the given time.
+// Return the reason if the login is not allowed
+func (m *TimeWindowManager) IsAllowed(groups []string, t time.Time) (bool, string) {
+	reasons := []string{}
+	for _, group := range groups {
+		thisSchedule := m.GetSchedule(group)
+		if thisSchedule == nil || thisSchedule.AllowedAt(t) {
+			return true, ""
+		}
+		reasons = append(reasons, group+": "+thisSchedule.String())
+	}
+
+	if len(reasons) == 0 {
+		//User without any group
+		return true, ""
+	}
+	return false, "Login is only allowed during " + strings.Join(reasons, "; ")
+}
+
+// Check if the schedule is valid
+func (s *Schedule) Validate() error {
+	if _, err := s.location(); err != nil {
+		return errors.New("invalid timezone given: " + s.Timezone)
+	}
+	for _, thisWindow := range s.Windows {
+		start, err := parseClock(thisWindow.Start)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(thisWindow.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return errors.New("window start and end cannot be the same")
+		}
+		for _, day := range thisWindow.Days {
+			if parseWeekday(day) < 0 {
+				return errors.New("invalid day given: " + day)
+			}
+		}
+	}
+	return nil
+}
+
+// Check if the given time falls in any of the windows
+func (s *Schedule) AllowedAt(t time.Time) bool {
+	loc, err := s.location()
+	if err != nil {
+		return false
+	}
+	t = t.In(loc)
+	minuteOfDay := t.Hour()*60 + t.Minute()
+	for _, thisWindow := range s.Windows {
+		start, err := parseClock(thisWindow.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseClock(thisWindow.End)
+		if err != nil {
+			continue
+		}
+
+		if start < end {
+			if thisWindow.onDay(t.Weekday()) && minuteOfDay >= start && minuteOfDay < end {
+				return true
+			}
+		} else {
+			//Window crossing midnight, the part after midnight belongs to the previous day
+			if thisWindow.onDay(t.Weekday()) && minuteOfDay >= start {
+				return true
+			}
+			if thisWindow.onDay((t.Weekday()+6)%7) && minuteOfDay < end {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the human readable description of the schedule, e.g. mon,tue 08:00-18:00 (Asia/Hong_Kong)
+func (s *Schedule) String() string {
+	windows := []string{}
+	for _, thisWindow := range s.Windows {
+		days := "every day"
+		if len(thisWindow.Days) > 0 {
+			days = strings.Join(thisWindow.Days, ",")
+		}
+		windows = append(windows, days+" "+thisWindow.Start+"-"+thisWindow.End)
+	}
+	if len(windows) == 0 {
+		windows = append(windows, "no time")
+	}
+
+	timezone := s.Timezone
+	if timezone == "" {
+		timezone = "server time"
+	}
+	return strings.Join(windows, ", ") + " (" + timezone + ")"
+}
+
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+func (w *Window) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, thisDay := range w.Days {
+		if parseWeekday(thisDay) == int(day) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the weekday name (e.g. mon or Monday), return -1 if invalid
+func parseWeekday(day string) int {
+	day = strings.ToLower(strings.TrimSpace(day))
+	if len(day) < 3 {
+		return -1
+	}
+	for i, name := range weekdayNames {
+		if strings.HasPrefix(day, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parse the HH:MM time into minutes of the day
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) != 2 {
+		return 0, errors.New("invalid time given: " + clock)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errors.New("invalid time given: " + clock)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, errors.New("invalid time given: " + clock)
+	}
+	return hour*60 + minute, nil
+}
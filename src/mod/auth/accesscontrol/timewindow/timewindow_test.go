@@ -0,0 +1,88 @@
+package timewindow
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"imuslab.com/arozos/mod/database"
+)
+
+func TestTimeWindow(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "timewindow.db")
+	sysdb, err := database.NewDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	m := NewTimeWindowManager(sysdb)
+
+	schoolHours := &Schedule{
+		Timezone: "Asia/Hong_Kong",
+		Windows: []Window{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00"},
+			{Days: []string{"sat"}, Start: "22:00", End: "02:00"},
+		},
+	}
+	if err := m.SetSchedule("student", schoolHours); err != nil {
+		t.Fatalf("Failed to set schedule: %v", err)
+	}
+
+	hkt := time.FixedZone("HKT", 8*3600)
+	tests := []struct {
+		time     time.Time
+		expected bool
+	}{
+		{time.Date(2024, 1, 8, 9, 0, 0, 0, hkt), true},        //Monday morning
+		{time.Date(2024, 1, 8, 7, 59, 0, 0, hkt), false},      //Before school
+		{time.Date(2024, 1, 8, 18, 0, 0, 0, hkt), false},      //End is exclusive
+		{time.Date(2024, 1, 8, 1, 0, 0, 0, time.UTC), true},   //09:00 in Hong Kong
+		{time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC), false}, //18:00 in Hong Kong
+		{time.Date(2024, 1, 7, 10, 0, 0, 0, hkt), false},      //Sunday
+		{time.Date(2024, 1, 13, 23, 0, 0, 0, hkt), true},      //Saturday night
+		{time.Date(2024, 1, 14, 1, 30, 0, 0, hkt), true},      //Saturday window crossing midnight
+		{time.Date(2024, 1, 14, 2, 0, 0, 0, hkt), false},      //After the Saturday window
+		{time.Date(2024, 1, 15, 1, 30, 0, 0, hkt), false},     //Sunday night is not allowed
+	}
+	for i, test := range tests {
+		if allowed, _ := m.IsAllowed([]string{"student"}, test.time); allowed != test.expected {
+			t.Errorf("Test %d: expected %v at %v", i, test.expected, test.time)
+		}
+	}
+
+	//Groups without schedule are always allowed
+	sunday := time.Date(2024, 1, 7, 10, 0, 0, 0, hkt)
+	if allowed, _ := m.IsAllowed([]string{"student", "teacher"}, sunday); !allowed {
+		t.Error("Expected user in a group without schedule to be allowed")
+	}
+	if allowed, reason := m.IsAllowed([]string{"student"}, sunday); allowed || reason == "" {
+		t.Error("Expected a descriptive reason for rejected login")
+	}
+
+	//Invalid schedules are rejected
+	invalidSchedules := []*Schedule{
+		{Timezone: "Mars/Olympus_Mons"},
+		{Windows: []Window{{Start: "08:00", End: "08:00"}}},
+		{Windows: []Window{{Start: "25:00", End: "08:00"}}},
+		{Windows: []Window{{Days: []string{"someday"}, Start: "08:00", End: "09:00"}}},
+	}
+	for i, schedule := range invalidSchedules {
+		if m.SetSchedule("student", schedule) == nil {
+			t.Errorf("Expected invalid schedule %d to be rejected", i)
+		}
+	}
+
+	//Schedules persist across restart
+	sysdb.Close()
+	sysdb, err = database.NewDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer sysdb.Close()
+	m = NewTimeWindowManager(sysdb)
+	if m.GetSchedule("student") == nil || m.GetSchedule("student").Timezone != "Asia/Hong_Kong" {
+		t.Fatal("Expected schedule to be loaded from database")
+	}
+	if err := m.SetSchedule("student", nil); err != nil || m.GetSchedule("student") != nil {
+		t.Error("Expected schedule to be removed")
+	}
+}
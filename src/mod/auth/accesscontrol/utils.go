@@ -8,15 +8,66 @@ import (
 	"strings"
 )
 
-//Break an ip range text into independent ip strings
+const (
+	IpRangeTypeSingle = "single" //A single ip address
+	IpRangeTypeRange  = "range"  //An ip range in the format of start-end
+	IpRangeTypeCIDR   = "cidr"   //A subnet in CIDR notation
+)
+
+//Ip range entry for listing, indicate if the entry is a single ip or a range
+type IpRangeEntry struct {
+	IpRange string //The ip, ip range or CIDR subnet
+	Type    string //Type of the entry, see IpRangeType constants
+}
+
+//Convert the given ip range texts into entries for listing
+func GetIpRangeEntries(ipRanges []string) []*IpRangeEntry {
+	results := []*IpRangeEntry{}
+	for _, ipRange := range ipRanges {
+		results = append(results, &IpRangeEntry{
+			IpRange: ipRange,
+			Type:    GetIpRangeType(ipRange),
+		})
+	}
+	return results
+}
+
+//Get the type of the given ip range text, return empty string if it is invalid
+func GetIpRangeType(ipRange string) string {
+	ipRange = strings.ReplaceAll(ipRange, " ", "")
+	if ValidateIpRange(ipRange) != nil {
+		return ""
+	}
+	if strings.Contains(ipRange, "/") {
+		return IpRangeTypeCIDR
+	} else if strings.Contains(ipRange, "-") {
+		return IpRangeTypeRange
+	}
+	return IpRangeTypeSingle
+}
+
+//Normalize the given ip string, IPv4-mapped IPv6 addresses (e.g. ::ffff:192.168.1.1) are converted to IPv4
+func NormalizeIp(ip string) string {
+	ip = strings.TrimSpace(ip)
+	parsedIp := net.ParseIP(ip)
+	if parsedIp == nil {
+		return ip
+	}
+	if v4 := parsedIp.To4(); v4 != nil {
+		return v4.String()
+	}
+	return parsedIp.String()
+}
+
+//Break an ip range text into independent ip strings, CIDR ranges are returned as is
 func BreakdownIpRange(ipRange string) []string {
 	ipRange = strings.ReplaceAll(ipRange, " ", "")
 	err := ValidateIpRange(ipRange)
 	if err != nil {
 		return []string{}
 	}
-	if !strings.Contains(ipRange, "-") {
-		//This is not an ip range but a single ip
+	if strings.Contains(ipRange, "/") || !strings.Contains(ipRange, "-") {
+		//This is not an ip range but a single ip or a subnet
 		return []string{ipRange}
 	}
 
@@ -48,17 +99,33 @@ func BreakdownIpRange(ipRange string) []string {
 
 //Check if an given ip in the given range
 func IpInRange(ip string, ipRange string) bool {
-	ip = strings.TrimSpace(ip)
+	ip = NormalizeIp(ip)
 	ipRange = strings.ReplaceAll(ipRange, " ", "")
-	if ip == ipRange {
+	if ip == ipRange || ip == NormalizeIp(ipRange) {
 		//For fields that the ipRange is the ip itself
 		return true
 	}
 
+	//Try matching subnet
+	if strings.Contains(ipRange, "/") {
+		_, subnet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return false
+		}
+		trial := net.ParseIP(ip)
+		if trial == nil {
+			return false
+		}
+		return subnet.Contains(trial)
+	}
+
 	//Try matching range
 	if strings.Contains(ipRange, "-") {
 		//Parse the source IP
 		trial := net.ParseIP(ip)
+		if trial == nil {
+			return false
+		}
 
 		//Parse the IP range
 		ips := strings.Split(ipRange, "-")
@@ -86,7 +153,13 @@ func IpInRange(ip string, ipRange string) bool {
 func ValidateIpRange(ipRange string) error {
 	ipRange = strings.TrimSpace(ipRange)
 	ipRange = strings.ReplaceAll(ipRange, " ", "")
-	if strings.Contains(ipRange, "-") {
+	if strings.Contains(ipRange, "/") {
+		//This is a subnet in CIDR notation, e.g. 192.168.1.0/24 or 2001:db8::/32
+		_, _, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return errors.New("Invalid CIDR subnet given")
+		}
+	} else if strings.Contains(ipRange, "-") {
 		//This is a range
 		if strings.Count(ipRange, "-") != 1 {
 			//Invalid range defination
@@ -94,3 +94,48 @@ func isEqual(slice1, slice2 []string) bool {
 	}
 	return true
 }
+
+func TestIpInRangeCIDR(t *testing.T) {
+	// Test case 1: IPv4 inside and just outside a /24 boundary
+	if !IpInRange("192.168.1.0", "192.168.1.0/24") || !IpInRange("192.168.1.255", "192.168.1.0/24") {
+		t.Error("Expected true for IP inside /24 subnet")
+	}
+	if IpInRange("192.168.2.0", "192.168.1.0/24") || IpInRange("192.168.0.255", "192.168.1.0/24") {
+		t.Error("Expected false for IP just outside /24 subnet")
+	}
+
+	// Test case 2: IPv4-mapped IPv6 address from reverse proxies
+	if !IpInRange("::ffff:192.168.1.20", "192.168.1.0/24") {
+		t.Error("Expected true for IPv4-mapped IPv6 address inside subnet")
+	}
+	if !IpInRange("::ffff:192.168.1.20", "192.168.1.20") {
+		t.Error("Expected true for IPv4-mapped IPv6 address matching single IP")
+	}
+
+	// Test case 3: IPv6 subnet
+	if !IpInRange("2001:db8:ffff::1", "2001:db8::/32") {
+		t.Error("Expected true for IPv6 address inside subnet")
+	}
+	if IpInRange("2001:db9::1", "2001:db8::/32") {
+		t.Error("Expected false for IPv6 address outside subnet")
+	}
+}
+
+func TestValidateIpRangeCIDR(t *testing.T) {
+	// Test case 1: Valid IPv4 and IPv6 subnets
+	if ValidateIpRange("192.168.1.0/24") != nil || ValidateIpRange("2001:db8::/32") != nil {
+		t.Error("Expected no error for valid CIDR subnet")
+	}
+
+	// Test case 2: Invalid subnets
+	if ValidateIpRange("192.168.1.0/33") == nil || ValidateIpRange("invalid/24") == nil {
+		t.Error("Expected error for invalid CIDR subnet")
+	}
+
+	// Test case 3: Range type detection
+	if GetIpRangeType("192.168.1.1") != IpRangeTypeSingle ||
+		GetIpRangeType("192.168.1.1-192.168.1.5") != IpRangeTypeRange ||
+		GetIpRangeType("192.168.1.0/24") != IpRangeTypeCIDR {
+		t.Error("Unexpected ip range type")
+	}
+}
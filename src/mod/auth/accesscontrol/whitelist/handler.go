@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"imuslab.com/arozos/mod/auth/accesscontrol"
 	"imuslab.com/arozos/mod/network"
 	"imuslab.com/arozos/mod/utils"
 )
@@ -62,8 +63,8 @@ func (wl *WhiteList) HandleSetWhitelistEnable(w http.ResponseWriter, r *http.Req
 }
 
 func (wl *WhiteList) HandleListWhitelistedIPs(w http.ResponseWriter, r *http.Request) {
-	bannedIpRanges := wl.ListWhitelistedIpRanges()
-	js, _ := json.Marshal(bannedIpRanges)
+	whitelistedIpRanges := accesscontrol.GetIpRangeEntries(wl.ListWhitelistedIpRanges())
+	js, _ := json.Marshal(whitelistedIpRanges)
 	utils.SendJSONResponse(w, string(js))
 }
 
@@ -53,6 +53,7 @@ func (wl *WhiteList) IsWhitelisted(ip string) bool {
 	}
 
 	//Check if this is reserved IP address
+	ip = accesscontrol.NormalizeIp(ip)
 	if ip == "127.0.0.1" || ip == "localhost" {
 		return true
 	}
@@ -3,14 +3,16 @@ package auth
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/sessions"
 	uuid "github.com/satori/go.uuid"
 	"imuslab.com/arozos/mod/database"
+	"imuslab.com/arozos/mod/network"
 	"imuslab.com/arozos/mod/utils"
 )
 
@@ -42,11 +44,12 @@ type SwitchableAccountsPool struct {
 }
 
 type SwitchableAccountPoolManager struct {
-	SessionStore *sessions.CookieStore
-	SessionName  string
-	Database     *database.Database
-	ExpireTime   int64 //Expire time of the switchable account
-	authAgent    *AuthAgent
+	SessionStore      *sessions.CookieStore
+	SessionName       string
+	Database          *database.Database
+	ExpireTime        int64 //Expire time of the switchable account
+	authAgent         *AuthAgent
+	sessionStoreMutex sync.RWMutex //Guard the session store replaced by session key rotation
 }
 
 // Create a new switchable account pool manager
@@ -63,15 +66,57 @@ func NewSwitchableAccountPoolManager(sysdb *database.Database, parent *AuthAgent
 		authAgent:    parent,
 	}
 
-	//Do an initialization cleanup
-	go func() {
-		thisManager.RunNightlyCleanup()
-	}()
+	//Reload the pools from previous runs and remove the expired ones
+	err := thisManager.LoadPoolsFromDB()
+	if err != nil {
+		log.Println("[auth] Unable to load account switching pools: " + err.Error())
+	}
 
 	//Return the manager
 	return &thisManager
 }
 
+// Get the session store, which is replaced when the session key rotates
+func (m *SwitchableAccountPoolManager) getSessionStore() *sessions.CookieStore {
+	m.sessionStoreMutex.RLock()
+	defer m.sessionStoreMutex.RUnlock()
+	return m.SessionStore
+}
+
+// Load the switchable account pools persisted in the database, so the pools survive server restart.
+// Pools that cannot be decoded or have all their accounts expired are removed
+func (m *SwitchableAccountPoolManager) LoadPoolsFromDB() error {
+	if !m.Database.TableExists("auth_acswitch") {
+		return nil
+	}
+	entries, err := m.Database.ListTable("auth_acswitch")
+	if err != nil {
+		return err
+	}
+
+	loadedPools := 0
+	for _, keypairs := range entries {
+		poolid := string(keypairs[0])
+		thisPool := SwitchableAccountsPool{}
+		err = json.Unmarshal(keypairs[1], &thisPool)
+		if err != nil || thisPool.UUID != poolid {
+			//Corrupted pool record, remove it so no one can switch with it
+			m.Database.Delete("auth_acswitch", poolid)
+			continue
+		}
+
+		thisPool.parent = m
+		if !thisPool.DeletePoolIfAllUserSessionExpired() {
+			loadedPools++
+		}
+	}
+
+	if loadedPools > 0 {
+		log.Println("[auth] " + strconv.Itoa(loadedPools) + " account switching pools loaded")
+	}
+	return nil
+}
+
 // When called, this will clear the account switching pool in which all users session has expired
 func (m *SwitchableAccountPoolManager) RunNightlyCleanup() {
 	pools, err := m.GetAllPools()
@@ -94,7 +139,7 @@ func (m *SwitchableAccountPoolManager) HandleSwitchableAccountListing(w http.Res
 		return
 	}
 
-	session, _ := m.SessionStore.Get(r, m.SessionName)
+	session, _ := m.getSessionStore().Get(r, m.SessionName)
 	poolid, ok := session.Values["poolid"].(string)
 	if !ok {
 		utils.SendErrorResponse(w, "invalid pool id given")
@@ -141,7 +186,7 @@ func (m *SwitchableAccountPoolManager) HandleSwitchableAccountListing(w http.Res
 // Handle unauth account listing by cookie. You can use this without authRouter.
 func (m *SwitchableAccountPoolManager) GetUnauthedSwitchableAccountCreatorList(w http.ResponseWriter, r *http.Request) string {
 	resumeSessionOwnerName := ""
-	session, _ := m.SessionStore.Get(r, m.SessionName)
+	session, _ := m.getSessionStore().Get(r, m.SessionName)
 	poolid, ok := session.Values["poolid"].(string)
 	if !ok {
 		//poolid not found. Return empty string
@@ -167,7 +212,7 @@ func (m *SwitchableAccountPoolManager) HandleLogoutforUser(w http.ResponseWriter
 		return "", err
 	}
 
-	session, _ := m.SessionStore.Get(r, m.SessionName)
+	session, _ := m.getSessionStore().Get(r, m.SessionName)
 	poolid, ok := session.Values["poolid"].(string)
 	if !ok {
 		return "", errors.New("user not in a any switchable account pool")
@@ -204,7 +249,7 @@ func (m *SwitchableAccountPoolManager) HandleLogoutAllAccounts(w http.ResponseWr
 		return
 	}
 
-	session, _ := m.SessionStore.Get(r, m.SessionName)
+	session, _ := m.getSessionStore().Get(r, m.SessionName)
 	poolid, ok := session.Values["poolid"].(string)
 	if !ok {
 		utils.SendErrorResponse(w, "invalid pool id given")
@@ -225,6 +270,7 @@ func (m *SwitchableAccountPoolManager) HandleLogoutAllAccounts(w http.ResponseWr
 
 	//Remove the pool
 	targetpool.Delete()
+	m.authAgent.LogAuditEvent(r, AuditActionPoolLogout, currentUsername, targetpool.UUID, true, "")
 
 	//Unset the session
 	unsetPoolidFromSession(session, w, r)
@@ -232,7 +278,8 @@ func (m *SwitchableAccountPoolManager) HandleLogoutAllAccounts(w http.ResponseWr
 	utils.SendOK(w)
 }
 
-// Handle account switching
+// Handle account switching. Accounts not switched to before require POST password,
+// and POST totp if the account has 2FA enabled
 func (m *SwitchableAccountPoolManager) HandleAccountSwitch(w http.ResponseWriter, r *http.Request) {
 	previousUserName, err := m.authAgent.GetUserName(w, r)
 	if err != nil {
@@ -240,7 +287,12 @@ func (m *SwitchableAccountPoolManager) HandleAccountSwitch(w http.ResponseWriter
 		return
 	}
 
-	session, _ := m.SessionStore.Get(r, m.SessionName)
+	if m.authAgent.GetImpersonator(r) != "" {
+		utils.SendErrorResponse(w, "account switching is not allowed during impersonation")
+		return
+	}
+
+	session, _ := m.getSessionStore().Get(r, m.SessionName)
 	poolid, ok := session.Values["poolid"].(string)
 	if !ok {
 		//No pool is given. Generate a pool for this request
@@ -260,10 +312,7 @@ func (m *SwitchableAccountPoolManager) HandleAccountSwitch(w http.ResponseWriter
 		newPool.Save()
 
 		session.Values["poolid"] = poolid
-		session.Options = &sessions.Options{
-			MaxAge: 3600 * 24 * 30, //One month
-			Path:   "/",
-		}
+		session.Options = m.authAgent.sessionCookieOptions(r, 3600*24*30) //One month
 		session.Save(r, w)
 	}
 
@@ -299,24 +348,56 @@ func (m *SwitchableAccountPoolManager) HandleAccountSwitch(w http.ResponseWriter
 		lastSwitchTime := targetPool.GetLastSwitchTimeFromUsername(username)
 		if time.Now().Unix() > lastSwitchTime+m.ExpireTime {
 			//Already expired
+			m.authAgent.LogAuditEvent(r, AuditActionAccountSwitch, previousUserName, username, false, "target account session has expired")
 			utils.SendErrorResponse(w, "target account session has expired")
 			return
 		}
 
-		//Not expired. Switch over directly
-		m.authAgent.LoginUserByRequest(w, r, username, true)
+		//Not expired, the account can be switched to directly
 	} else {
-		//Password given. Use Add User Account routine
-		ok, reason := m.authAgent.ValidateUsernameAndPasswordWithReason(username, password)
+		//Password given. Use Add User Account routine, the account can be given by its email
+		username = m.authAgent.ResolveLoginUsername(username)
+		ok, code, reason := m.authAgent.validateUsernameAndPasswordByRequest(r, username, password)
 		if !ok {
-			utils.SendErrorResponse(w, reason)
+			m.authAgent.LogAuditEvent(r, AuditActionAccountSwitch, previousUserName, username, false, reason)
+			sendAuthErrorResponse(w, code, reason)
 			return
 		}
 
-		m.authAgent.LoginUserByRequest(w, r, username, true)
+		//Accounts with 2FA enabled require the code as in login, see HandleLogin
+		if ok, code, reason, failure := m.authAgent.validateLoginSecondFactor(w, r, username); !ok {
+			if failure != "" {
+				m.authAgent.LogAuditEvent(r, AuditActionAccountSwitch, previousUserName, username, false, reason)
+			}
+			sendAuthErrorResponse(w, code, reason)
+			return
+		}
+	}
 
+	//Only administrators can login during maintenance, see HandleLogin
+	if m.authAgent.MaintenanceBlocksUser(username) {
+		m.authAgent.LogAuditEvent(r, AuditActionAccountSwitch, previousUserName, username, false, "System under maintenance")
+		sendAuthErrorResponse(w, AuthErrMaintenance, m.authAgent.GetMaintenanceMessage())
+		return
+	}
+
+	err = m.authAgent.LoginUserByRequest(w, r, username, true)
+	if err != nil {
+		m.authAgent.LogAuditEvent(r, AuditActionAccountSwitch, previousUserName, username, false, err.Error())
+		sendAuthErrorResponse(w, getAuthErrorCode(err), err.Error())
+		return
 	}
 
+	m.authAgent.LogAuditEvent(r, AuditActionAccountSwitch, previousUserName, username, true, "")
+	clientIP, _ := network.GetIpFromRequest(r)
+	m.authAgent.Events.Publish(AuthEvent{
+		Type:             EventAccountSwitch,
+		Username:         username,
+		PreviousUsername: previousUserName,
+		IpAddr:           clientIP,
+		Request:          r,
+	})
+
 	//Update the pool account info
 	targetPool.UpdateUserPoolAccountInfo(username)
 	targetPool.Save()
@@ -354,7 +435,7 @@ func (m *SwitchableAccountPoolManager) GetAllPools() ([]*SwitchableAccountsPool,
 // if the user is logging in as a sub-account (i.e. not the creator of the switchable account pool),
 // the account pool id will be reset to prevent hacking from sub-account to master account
 func (m *SwitchableAccountPoolManager) MatchPoolCreatorOrResetPoolID(username string, w http.ResponseWriter, r *http.Request) {
-	session, _ := m.SessionStore.Get(r, m.SessionName)
+	session, _ := m.getSessionStore().Get(r, m.SessionName)
 	poolid, ok := session.Values["poolid"].(string)
 	if !ok {
 		//No pool. Continue
@@ -381,8 +462,11 @@ func (m *SwitchableAccountPoolManager) MatchPoolCreatorOrResetPoolID(username st
 
 // Get a switchable account pool by its id
 func (m *SwitchableAccountPoolManager) GetPoolByID(uuid string) (*SwitchableAccountsPool, error) {
+	if !m.Database.KeyExists("auth_acswitch", uuid) {
+		return nil, errors.New("pool with given uuid not found")
+	}
 	targetPool := SwitchableAccountsPool{}
-	err := m.authAgent.Database.Read("auth_acswitch", uuid, &targetPool)
+	err := m.Database.Read("auth_acswitch", uuid, &targetPool)
 	if err != nil {
 		return nil, errors.New("pool with given uuid not found")
 	}
@@ -411,7 +495,6 @@ func (p *SwitchableAccountPoolManager) ExpireUserFromAllSwitchableAccountPool(us
 		return err
 	}
 	for _, accountPool := range allAccountPool {
-		fmt.Println(allAccountPool)
 		if accountPool.IsAccessibleBy(username) {
 			//aka this user is in the pool
 			accountPool.ExpireUser(username)
@@ -506,8 +589,8 @@ func (p *SwitchableAccountsPool) RemoveUser(username string) {
 	p.Save()
 }
 
-// Save changes of this pool to database
-func (p *SwitchableAccountsPool) DeletePoolIfAllUserSessionExpired() {
+// Delete this pool if all accounts are expired, return true if the pool is deleted
+func (p *SwitchableAccountsPool) DeletePoolIfAllUserSessionExpired() bool {
 	allExpred := true
 	for _, acc := range p.Accounts {
 		if !p.IsAccountExpired(acc) {
@@ -519,6 +602,7 @@ func (p *SwitchableAccountsPool) DeletePoolIfAllUserSessionExpired() {
 		//All account expired. Remove this pool
 		p.Delete()
 	}
+	return allExpred
 }
 
 // Save changes of this pool to database
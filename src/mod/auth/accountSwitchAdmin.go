@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Account Switch Administration
+
+	Let the administrators audit the switchable account pools and clear
+	them on demand, e.g. when an account is offboarded or compromised.
+
+	A user logged in with any account in a pool can switch to the other
+	accounts in the same pool without password until they expire, so
+	each pool is a privilege escalation path between its accounts
+*/
+
+type SwitchableAccountStatus struct {
+	Username   string //Username of the account
+	LastSwitch int64  //Last time this account is accessed
+	Expired    bool   //Switching into this account require password again
+}
+
+type SwitchableAccountPoolInfo struct {
+	UUID     string                     //UUID of this pool
+	Creator  string                     //The user who created the pool
+	Accounts []*SwitchableAccountStatus //Accounts in this pool
+}
+
+// List all switchable account pools with the expiry status of their accounts, sorted by creator
+func (m *SwitchableAccountPoolManager) ListPoolInfo() ([]*SwitchableAccountPoolInfo, error) {
+	pools, err := m.GetAllPools()
+	if err != nil {
+		return []*SwitchableAccountPoolInfo{}, err
+	}
+
+	results := []*SwitchableAccountPoolInfo{}
+	for _, thisPool := range pools {
+		thisPoolInfo := SwitchableAccountPoolInfo{
+			UUID:     thisPool.UUID,
+			Creator:  thisPool.Creator,
+			Accounts: []*SwitchableAccountStatus{},
+		}
+		for _, acc := range thisPool.Accounts {
+			thisPoolInfo.Accounts = append(thisPoolInfo.Accounts, &SwitchableAccountStatus{
+				Username:   acc.Username,
+				LastSwitch: acc.LastSwitch,
+				Expired:    thisPool.IsAccountExpired(acc),
+			})
+		}
+		results = append(results, &thisPoolInfo)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Creator != results[j].Creator {
+			return results[i].Creator < results[j].Creator
+		}
+		return results[i].UUID < results[j].UUID
+	})
+	return results, nil
+}
+
+// Get the accounts each user can switch into without password, usernames as key
+func (m *SwitchableAccountPoolManager) ListSwitchPaths() (map[string][]string, error) {
+	pools, err := m.ListPoolInfo()
+	if err != nil {
+		return map[string][]string{}, err
+	}
+
+	results := map[string][]string{}
+	for _, thisPool := range pools {
+		for _, from := range thisPool.Accounts {
+			for _, to := range thisPool.Accounts {
+				if to.Username == from.Username || to.Expired || inSlice(results[from.Username], to.Username) {
+					continue
+				}
+				results[from.Username] = append(results[from.Username], to.Username)
+			}
+		}
+	}
+
+	for username := range results {
+		sort.Strings(results[username])
+	}
+	return results, nil
+}
+
+// Delete all the pools containing the user, so the user cannot switch into other
+// accounts and other accounts cannot switch into this user. Return the number of pools deleted
+func (m *SwitchableAccountPoolManager) ClearUserPools(username string) (int, error) {
+	pools, err := m.GetAllPools()
+	if err != nil {
+		return 0, err
+	}
+
+	deletedCount := 0
+	for _, thisPool := range pools {
+		if thisPool.Creator != username && !thisPool.IsAccessibleBy(username) {
+			continue
+		}
+		if thisPool.Delete() == nil {
+			deletedCount++
+		}
+	}
+	return deletedCount, nil
+}
+
+// Handle listing of all switchable account pools and the switch paths between accounts
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (m *SwitchableAccountPoolManager) HandleListAllPools(w http.ResponseWriter, r *http.Request) {
+	pools, err := m.ListPoolInfo()
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	switchPaths, _ := m.ListSwitchPaths()
+
+	js, _ := json.Marshal(struct {
+		Pools       []*SwitchableAccountPoolInfo
+		SwitchPaths map[string][]string
+		ExpireTime  int64
+		CurrentTime int64
+	}{
+		Pools:       pools,
+		SwitchPaths: switchPaths,
+		ExpireTime:  m.ExpireTime,
+		CurrentTime: time.Now().Unix(),
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Handle clearing of the switchable account pools. Require POST username to clear all pools containing the user,
+// or POST poolid to clear a single pool
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (m *SwitchableAccountPoolManager) HandleClearPools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, "invalid request method")
+		return
+	}
+
+	username, _ := utils.PostPara(r, "username")
+	poolid, _ := utils.PostPara(r, "poolid")
+	if username == "" && poolid == "" {
+		utils.SendErrorResponse(w, "username or poolid is required")
+		return
+	}
+
+	if poolid != "" {
+		thisPool, err := m.GetPoolByID(poolid)
+		if err == nil {
+			err = thisPool.Delete()
+		}
+		if err != nil {
+			m.authAgent.LogAuditEventByRequest(r, AuditActionPoolClear, poolid, false, err.Error())
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
+		m.authAgent.LogAuditEventByRequest(r, AuditActionPoolClear, poolid, true, "")
+		utils.SendOK(w)
+		return
+	}
+
+	deletedCount, err := m.ClearUserPools(username)
+	if err != nil {
+		m.authAgent.LogAuditEventByRequest(r, AuditActionPoolClear, username, false, err.Error())
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[auth] " + strconv.Itoa(deletedCount) + " account switching pools of " + username + " cleared")
+	m.authAgent.LogAuditEventByRequest(r, AuditActionPoolClear, username, true, strconv.Itoa(deletedCount)+" pools cleared")
+	js, _ := json.Marshal(deletedCount)
+	utils.SendJSONResponse(w, string(js))
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"imuslab.com/arozos/mod/auth/explogin"
+	"imuslab.com/arozos/mod/database"
+)
+
+func TestSwitchableAccountPoolSurviveRestart(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "acswitch.db")
+	sysdb, err := database.NewDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	a := &AuthAgent{Database: sysdb}
+	m := NewSwitchableAccountPoolManager(sysdb, a, []byte("0123456789abcdef"))
+
+	now := time.Now().Unix()
+	activePool := SwitchableAccountsPool{
+		UUID:    "active-pool",
+		Creator: "alice",
+		Accounts: []*SwitchableAccount{
+			{Username: "alice", LastSwitch: now},
+			{Username: "bob", LastSwitch: 0}, //Expired, require password again
+		},
+		parent: m,
+	}
+	activePool.Save()
+
+	expiredPool := SwitchableAccountsPool{
+		UUID:     "expired-pool",
+		Creator:  "carol",
+		Accounts: []*SwitchableAccount{{Username: "carol", LastSwitch: now - m.ExpireTime - 1}},
+		parent:   m,
+	}
+	expiredPool.Save()
+
+	//Simulate a restart by reopening the database
+	sysdb.Close()
+	sysdb, err = database.NewDatabase(dbPath, false)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer sysdb.Close()
+	a = &AuthAgent{Database: sysdb}
+	m = NewSwitchableAccountPoolManager(sysdb, a, []byte("0123456789abcdef"))
+
+	pool, err := m.GetPoolByID("active-pool")
+	if err != nil {
+		t.Fatalf("Expected pool to survive restart: %v", err)
+	}
+	if pool.Creator != "alice" || !pool.IsAccessibleBy("alice") || !pool.IsAccessibleBy("bob") {
+		t.Errorf("Pool accounts not restored: %+v", pool)
+	}
+	if pool.GetLastSwitchTimeFromUsername("alice") != now {
+		t.Error("Expected last switch time to be restored")
+	}
+	if !pool.IsAccountExpired(pool.Accounts[1]) {
+		t.Error("Expected expired account to still require re-authentication after restart")
+	}
+
+	if _, err := m.GetPoolByID("expired-pool"); err == nil {
+		t.Error("Expected fully expired pool to be pruned on reload")
+	}
+}
+
+func TestSwitchableAccountPoolAdmin(t *testing.T) {
+	a := newTestAuthAgent(t)
+	m := NewSwitchableAccountPoolManager(a.Database, a, []byte("0123456789abcdef"))
+
+	now := time.Now().Unix()
+	pools := []SwitchableAccountsPool{
+		{
+			UUID:    "pool-alice",
+			Creator: "alice",
+			Accounts: []*SwitchableAccount{
+				{Username: "alice", LastSwitch: now},
+				{Username: "admin", LastSwitch: now},
+				{Username: "bob", LastSwitch: 0}, //Expired, require password again
+			},
+		},
+		{
+			UUID:     "pool-carol",
+			Creator:  "carol",
+			Accounts: []*SwitchableAccount{{Username: "carol", LastSwitch: now}, {Username: "dave", LastSwitch: now}},
+		},
+	}
+	for i := range pools {
+		pools[i].parent = m
+		pools[i].Save()
+	}
+
+	paths, err := m.ListSwitchPaths()
+	if err != nil {
+		t.Fatalf("ListSwitchPaths failed: %v", err)
+	}
+	if len(paths["bob"]) != 2 || paths["bob"][0] != "admin" || paths["bob"][1] != "alice" {
+		t.Errorf("Unexpected switch paths of bob: %v", paths["bob"])
+	}
+	if len(paths["alice"]) != 1 || paths["alice"][0] != "admin" {
+		t.Errorf("Expired account should not be switchable into, got %v", paths["alice"])
+	}
+
+	//Clearing a member removes the pools containing it, not only the ones it created
+	deletedCount, err := m.ClearUserPools("admin")
+	if err != nil || deletedCount != 1 {
+		t.Fatalf("Expected 1 pool cleared, got %d (%v)", deletedCount, err)
+	}
+	infos, _ := m.ListPoolInfo()
+	if len(infos) != 1 || infos[0].UUID != "pool-carol" {
+		t.Errorf("Unexpected pools after clearing: %v", infos)
+	}
+}
+
+func TestAccountSwitchRequire2FA(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_sessions", "auth_policy")
+	a.ExpDelayHandler = explogin.NewExponentialLoginHandler(2, 10800)
+	a.SwitchableAccountManager = NewSwitchableAccountPoolManager(a.Database, a, []byte("0123456789abcdef"))
+	a.CreateUserAccount("alice", "password", []string{"user"})
+	a.CreateUserAccount("bob", "password", []string{"user"})
+	secret, _ := generateTOTPSecret()
+	a.Database.Write("auth", "totp/bob", TOTPConfig{Secret: secret, Enabled: true})
+
+	w := httptest.NewRecorder()
+	a.LoginUserByRequest(w, httptest.NewRequest("POST", "/system/auth/login", nil), "alice", false)
+	aliceCookies := w.Result().Cookies()
+	switchTo := func(form url.Values) string {
+		r := httptest.NewRequest("POST", "/system/auth/u/switch", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range aliceCookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		a.SwitchableAccountManager.HandleAccountSwitch(w, r)
+		return w.Body.String()
+	}
+
+	//Password alone cannot switch into an account with 2FA enabled
+	if resp := switchTo(url.Values{"username": {"bob"}, "password": {"password"}}); !strings.Contains(resp, string(AuthErr2FARequired)) {
+		t.Errorf("Expected 2FA code required, got %s", resp)
+	}
+	if resp := switchTo(url.Values{"username": {"bob"}, "password": {"password"}, "totp": {"000000"}}); !strings.Contains(resp, string(AuthErrInvalid2FA)) {
+		t.Errorf("Expected invalid 2FA code rejected, got %s", resp)
+	}
+	code, _ := generateTOTP(secret, time.Now())
+	if resp := switchTo(url.Values{"username": {"bob"}, "password": {"password"}, "totp": {code}}); strings.Contains(resp, "error") {
+		t.Errorf("Expected switch with valid 2FA code, got %s", resp)
+	}
+}
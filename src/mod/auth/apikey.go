@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	API Key Authentication
+
+	This script allow headless clients (e.g. backup scripts) to access
+	the APIs with the Authorization: Bearer {key} header instead of a login
+	session. The request is authenticated as the key owner, so the same
+	group permissions apply.
+
+	A key can be limited to a list of path prefixes (scopes), e.g.
+	/system/file_system/, matched by whole path segments so /system/file
+	does not cover /system/file_system. Keys without scopes can access all APIs
+	the owner can access. Read only keys can only make GET, HEAD, OPTIONS
+	and PROPFIND requests. Requests outside of the key scope are rejected
+	with 403 and logged. Only the hash of the key is stored as
+
+	auth_apikey/{hashed key} => APIKey
+*/
+
+const apiKeyPrefix = "ak_"
+
+type APIKey struct {
+	ID           string   //ID of this key for listing and revoking
+	Owner        string   //Username of the key owner
+	Name         string   //Name of the key given by the user
+	KeyHash      string   //Hash of the key
+	Scopes       []string //Path prefixes this key can access, empty for all
+	ReadOnly     bool     //Only allow the request methods that do not modify data
+	CreationTime int64    //Creation time of this key
+	LastUsed     int64    //Last time this key is used
+}
+
+// Returned by ValidateAPIKeyRequest if the key is valid but not permitted for the request path or method
+var ErrAPIKeyOutOfScope = errors.New("API key not permitted to access this path")
+
+// Request methods allowed for read only keys
+var apiKeyReadOnlyMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND"}
+
+// Create a new API key for the user, return the key in plain text. The key cannot be retrieved afterward
+func (a *AuthAgent) CreateAPIKey(username string, name string, scopes []string, readOnly bool) (string, *APIKey, error) {
+	if !a.UserExists(username) {
+		return "", nil, errors.New("user not exists")
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+
+	cleanScopes := []string{}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !strings.HasPrefix(scope, "/") {
+			return "", nil, errors.New("invalid scope: " + scope)
+		}
+		cleanScopes = append(cleanScopes, scope)
+	}
+
+	thisKey := APIKey{
+		ID:           uuid.NewV4().String(),
+		Owner:        username,
+		Name:         name,
+		KeyHash:      Hash(key),
+		Scopes:       cleanScopes,
+		ReadOnly:     readOnly,
+		CreationTime: time.Now().Unix(),
+	}
+
+	err = a.Database.Write("auth_apikey", thisKey.KeyHash, thisKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, &thisKey, nil
+}
+
+// List the API keys, set username to empty string to list keys of all users
+func (a *AuthAgent) ListAPIKeys(username string) []*APIKey {
+	results := []*APIKey{}
+	entries, err := a.Database.ListTable("auth_apikey")
+	if err != nil {
+		return results
+	}
+	for _, keypairs := range entries {
+		thisKey := APIKey{}
+		err = json.Unmarshal(keypairs[1], &thisKey)
+		if err != nil {
+			continue
+		}
+		if username == "" || thisKey.Owner == username {
+			results = append(results, &thisKey)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreationTime < results[j].CreationTime
+	})
+	return results
+}
+
+// Revoke an API key by its ID. Set username to empty string to revoke regardless of the owner
+func (a *AuthAgent) RevokeAPIKey(username string, keyID string) (*APIKey, error) {
+	for _, thisKey := range a.ListAPIKeys(username) {
+		if thisKey.ID == keyID {
+			return thisKey, a.Database.Delete("auth_apikey", thisKey.KeyHash)
+		}
+	}
+	return nil, errors.New("API key not found")
+}
+
+// Remove all API keys of the user
+func (a *AuthAgent) RevokeAllUserAPIKeys(username string) {
+	for _, thisKey := range a.ListAPIKeys(username) {
+		a.Database.Delete("auth_apikey", thisKey.KeyHash)
+	}
+}
+
+// Check if the request carry an API key in the Authorization header
+func requestHasAPIKey(r *http.Request) bool {
+	_, ok := getRequestAPIKey(r)
+	return ok
+}
+
+func getRequestAPIKey(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Bearer ") {
+		return "", false
+	}
+	key := strings.TrimSpace(authHeader[7:])
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	return key, true
+}
+
+// Validate the API key of the request and return the key record
+func (a *AuthAgent) ValidateAPIKeyRequest(r *http.Request) (*APIKey, error) {
+	key, ok := getRequestAPIKey(r)
+	if !ok {
+		return nil, errors.New("API key not found")
+	}
+
+	thisKey := APIKey{}
+	err := a.Database.Read("auth_apikey", Hash(key), &thisKey)
+	if err != nil || thisKey.Owner == "" {
+		return nil, errors.New("invalid API key")
+	}
+
+	if !thisKey.InScope(r.URL.Path) || !thisKey.AllowMethod(r.Method) {
+		return &thisKey, ErrAPIKeyOutOfScope
+	}
+
+	if !a.UserExists(thisKey.Owner) {
+		return nil, errors.New("API key owner not exists")
+	}
+	if a.UserIsDisabled(thisKey.Owner) {
+		return nil, errors.New("API key owner account disabled")
+	}
+
+	//The same IP access control as login applies
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if ok, reason := a.ValidateLoginIpAccess(clientIP); !ok {
+		return nil, reason
+	}
+
+	//Only write back to database once every minute to reduce IO
+	now := time.Now().Unix()
+	if now-thisKey.LastUsed > 60 {
+		thisKey.LastUsed = now
+		a.Database.Write("auth_apikey", thisKey.KeyHash, thisKey)
+	}
+	return &thisKey, nil
+}
+
+// Check if the key is allowed to access the given path
+func (k *APIKey) InScope(path string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range k.Scopes {
+		if matchPathPrefix(path, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the key is allowed to make request with the given method
+func (k *APIKey) AllowMethod(method string) bool {
+	return !k.ReadOnly || inSlice(apiKeyReadOnlyMethods, strings.ToUpper(method))
+}
+
+// Reject the request with 403 if it carry a valid API key that is not permitted for the request path or method.
+// Return true if the request is rejected. Call this before redirecting an unauthenticated request to login
+func (a *AuthAgent) HandleAPIKeyScopeDenied(w http.ResponseWriter, r *http.Request) bool {
+	if !requestHasAPIKey(r) {
+		return false
+	}
+	thisKey, err := a.ValidateAPIKeyRequest(r)
+	if err != ErrAPIKeyOutOfScope {
+		return false
+	}
+
+	log.Println("[System Auth] API key " + thisKey.ID + " of " + thisKey.Owner + " rejected for " + r.Method + " " + r.URL.Path + ": out of scope")
+	a.LogAuditEvent(r, AuditActionAPIKeyDenied, thisKey.Owner, thisKey.Owner, false, thisKey.ID+" "+r.Method+" "+r.URL.Path)
+	http.Error(w, "403 - Forbidden", http.StatusForbidden)
+	return true
+}
+
+/*
+	API Key Handlers
+*/
+
+// Handle creation of API key for the current user. Require POST name, optional scopes (comma seperated) and readonly
+func (a *AuthAgent) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	username, err := a.getAPIKeyManagerUsername(w, r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	name, err := utils.PostPara(r, "name")
+	if err != nil || strings.TrimSpace(name) == "" {
+		sendErrorResponse(w, "Invalid key name given")
+		return
+	}
+
+	scopes, _ := utils.PostPara(r, "scopes")
+	readOnly, _ := utils.PostBool(r, "readonly")
+	key, keyRecord, err := a.CreateAPIKey(username, strings.TrimSpace(name), strings.Split(scopes, ","), readOnly)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] " + username + " created API key " + keyRecord.Name)
+	a.LogAuditEvent(r, AuditActionAPIKeyCreate, username, username, true, keyRecord.ID)
+
+	type CreateAPIKeyResponse struct {
+		ID  string
+		Key string
+	}
+	js, _ := json.Marshal(CreateAPIKeyResponse{
+		ID:  keyRecord.ID,
+		Key: key,
+	})
+	sendJSONResponse(w, string(js))
+}
+
+// Handle listing of the current user's API keys
+func (a *AuthAgent) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	username, err := a.getAPIKeyManagerUsername(w, r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(apiKeyInfoList(a.ListAPIKeys(username)))
+	sendJSONResponse(w, string(js))
+}
+
+// Handle revoking one of the current user's API keys. Require POST id
+func (a *AuthAgent) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	username, err := a.getAPIKeyManagerUsername(w, r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	keyID, err := utils.PostPara(r, "id")
+	if err != nil {
+		sendErrorResponse(w, "Invalid key id given")
+		return
+	}
+
+	_, err = a.RevokeAPIKey(username, keyID)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] " + username + " revoked API key " + keyID)
+	a.LogAuditEvent(r, AuditActionAPIKeyRevoke, username, username, true, keyID)
+	sendOK(w)
+}
+
+// Handle listing of all API keys. Accept optional GET username
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleAdminListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	username, _ := utils.GetPara(r, "username")
+	js, _ := json.Marshal(apiKeyInfoList(a.ListAPIKeys(username)))
+	sendJSONResponse(w, string(js))
+}
+
+// Handle revoking of any user's API key. Require POST id
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleAdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := utils.PostPara(r, "id")
+	if err != nil {
+		sendErrorResponse(w, "Invalid key id given")
+		return
+	}
+
+	thisKey, err := a.RevokeAPIKey("", keyID)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] API key " + keyID + " of " + thisKey.Owner + " revoked by admin")
+	a.LogAuditEventByRequest(r, AuditActionAPIKeyRevoke, thisKey.Owner, true, keyID)
+	sendOK(w)
+}
+
+// Get the username for managing API keys. API keys cannot be used to manage API keys
+func (a *AuthAgent) getAPIKeyManagerUsername(w http.ResponseWriter, r *http.Request) (string, error) {
+	if requestHasAPIKey(r) {
+		return "", errors.New("API keys cannot be managed with an API key")
+	}
+	return a.GetUserName(w, r)
+}
+
+// The API key records without the key hash
+type apiKeyInfo struct {
+	ID           string
+	Owner        string
+	Name         string
+	Scopes       []string
+	ReadOnly     bool
+	CreationTime int64
+	LastUsed     int64
+}
+
+func apiKeyInfoList(keys []*APIKey) []*apiKeyInfo {
+	results := []*apiKeyInfo{}
+	for _, thisKey := range keys {
+		results = append(results, &apiKeyInfo{
+			ID:           thisKey.ID,
+			Owner:        thisKey.Owner,
+			Name:         thisKey.Name,
+			Scopes:       thisKey.Scopes,
+			ReadOnly:     thisKey.ReadOnly,
+			CreationTime: thisKey.CreationTime,
+			LastUsed:     thisKey.LastUsed,
+		})
+	}
+	return results
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"imuslab.com/arozos/mod/auth/accesscontrol/blacklist"
+	"imuslab.com/arozos/mod/auth/accesscontrol/whitelist"
+)
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_apikey")
+	a.WhitelistManager = whitelist.NewWhitelistManager(a.Database)
+	a.BlacklistManager = blacklist.NewBlacklistManager(a.Database)
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	key, keyRecord, err := a.CreateAPIKey("alice", "backup", []string{"/system/file_system/"}, false)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/system/file_system/listDir", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	if !a.CheckAuth(r) {
+		t.Fatal("Expected request with valid API key to be authenticated")
+	}
+	if username, err := a.GetUserName(nil, r); err != nil || username != "alice" {
+		t.Errorf("Expected API key owner alice, got %q: %v", username, err)
+	}
+
+	//Out of scope path
+	r = httptest.NewRequest("GET", "/system/users/list", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	if a.CheckAuth(r) {
+		t.Error("Expected request outside of key scope to be rejected")
+	}
+	partialScope := &APIKey{Scopes: []string{"/system/file"}}
+	if partialScope.InScope("/system/file_system/listDir") || !partialScope.InScope("/system/file/share") {
+		t.Error("Expected key scope to match whole path segments")
+	}
+
+	//Invalid key
+	r = httptest.NewRequest("GET", "/system/file_system/listDir", nil)
+	r.Header.Set("Authorization", "Bearer "+apiKeyPrefix+"invalid")
+	if a.CheckAuth(r) {
+		t.Error("Expected invalid API key to be rejected")
+	}
+
+	//Revoked key
+	if _, err := a.RevokeAPIKey("bob", keyRecord.ID); err == nil {
+		t.Error("Expected other users unable to revoke alice's key")
+	}
+	if _, err := a.RevokeAPIKey("alice", keyRecord.ID); err != nil {
+		t.Fatalf("Failed to revoke API key: %v", err)
+	}
+	r = httptest.NewRequest("GET", "/system/file_system/listDir", nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	if a.CheckAuth(r) {
+		t.Error("Expected revoked API key to be rejected")
+	}
+}
+
+func TestAPIKeyScopeDenied(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_apikey")
+	a.WhitelistManager = whitelist.NewWhitelistManager(a.Database)
+	a.BlacklistManager = blacklist.NewBlacklistManager(a.Database)
+	a.LoginRedirectionHandler = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	key, _, err := a.CreateAPIKey("alice", "backup", []string{"/system/file_system/"}, true)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	if keys := a.ListAPIKeys("alice"); len(keys) != 1 || !keys[0].ReadOnly {
+		t.Fatalf("Expected read only key listed, got %v", keys)
+	}
+
+	handled := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handled = true
+	}
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/system/file_system/listDir", http.StatusOK},
+		{"POST", "/system/file_system/fileOpr", http.StatusForbidden}, //Write with read only key
+		{"GET", "/system/users/list", http.StatusForbidden},           //Out of scope path
+	}
+	for _, test := range tests {
+		handled = false
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		a.HandleCheckAuth(w, r, handler)
+		if w.Code != test.status || handled != (test.status == http.StatusOK) {
+			t.Errorf("%s %s: expected status %d, got %d (handled=%v)", test.method, test.path, test.status, w.Code, handled)
+		}
+	}
+
+	//Invalid keys are not authenticated rather than forbidden
+	r := httptest.NewRequest("GET", "/system/users/list", nil)
+	r.Header.Set("Authorization", "Bearer "+apiKeyPrefix+"invalid")
+	w := httptest.NewRecorder()
+	a.HandleCheckAuth(w, r, handler)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected invalid key to be redirected to login, got %d", w.Code)
+	}
+}
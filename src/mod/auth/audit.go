@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"imuslab.com/arozos/mod/auth/auditlog"
+	"imuslab.com/arozos/mod/network"
+)
+
+/*
+	Audit Trail
+
+	Helper functions for recording authentication events
+	to the audit log. See mod/auth/auditlog for the log format
+*/
+
+// Audit log actions
+const (
+	AuditActionLogin         = "login"
+	AuditActionLogout        = "logout"
+	AuditActionRegister      = "register"
+	AuditActionUnregister    = "unregister"
+	AuditActionAccountSwitch = "account-switch"
+	AuditActionPoolLogout    = "account-pool-logout"
+	AuditActionPoolClear     = "account-pool-clear"
+	AuditActionBan           = "ban"
+	AuditActionUnban         = "unban"
+	AuditActionAutoBan       = "autoban"
+	AuditActionCSVImport     = "csv-import"
+	AuditActionGroupDelete   = "group-delete"
+	AuditActionResetRequest  = "password-reset-request"
+	AuditActionResetConfirm  = "password-reset"
+	AuditActionAPIKeyCreate  = "apikey-create"
+	AuditActionAPIKeyRevoke  = "apikey-revoke"
+	AuditActionAPIKeyDenied  = "apikey-denied"
+	AuditActionLock          = "account-lock"
+	AuditActionUnlock        = "account-unlock"
+	AuditActionDisable       = "account-disable"
+	AuditActionEnable        = "account-enable"
+	AuditActionImpersonStart = "impersonate-start"
+	AuditActionImpersonStop  = "impersonate-stop"
+	AuditActionForcePwChange = "force-password-change"
+	AuditActionRevokeAll     = "revoke-all-sessions"
+	AuditActionKeyRotate     = "session-key-rotate"
+	AuditActionBootstrap     = "admin-bootstrap"
+	AuditActionMaintenance   = "maintenance-mode"
+	AuditActionTOTPReset     = "2fa-reset"
+)
+
+// Record an authentication event to the audit log. Actor is the user performing the action
+func (a *AuthAgent) LogAuditEvent(r *http.Request, action string, actor string, target string, succeed bool, detail string) {
+	if a.AuditLogger == nil {
+		return
+	}
+
+	clientIP := ""
+	if r != nil {
+		clientIP, _ = network.GetIpFromRequest(r)
+	}
+
+	err := a.AuditLogger.Log(auditlog.AuditEvent{
+		Action:  action,
+		Actor:   actor,
+		Target:  target,
+		IpAddr:  clientIP,
+		Succeed: succeed,
+		Detail:  detail,
+	})
+	if err != nil {
+		log.Println("[System Auth] Unable to write audit log: " + err.Error())
+	}
+}
+
+// Record an event performed by the user logged in with this request
+func (a *AuthAgent) LogAuditEventByRequest(r *http.Request, action string, target string, succeed bool, detail string) {
+	actor, _ := a.GetUserName(nil, r)
+	a.LogAuditEvent(r, action, actor, target, succeed, detail)
+}
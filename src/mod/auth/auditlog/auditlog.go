@@ -0,0 +1,178 @@
+package auditlog
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	AuditLog
+
+	This module keep an append-only audit trail of the authentication
+	events (logins, logouts, account switches, bans etc) for compliance.
+
+	Each event is written as a JSON line containing the hash of the
+	previous event, forming a hash chain. Modifying or removing any line
+	in the middle of the file breaks the chain and can be detected
+	with Verify().
+*/
+
+type AuditEvent struct {
+	Timestamp int64  //Unix timestamp of the event
+	Action    string //Action performed, e.g. login, logout, ban
+	Actor     string //Username who performed the action, empty if not logged in
+	Target    string //Target of the action, e.g. the username or IP address
+	IpAddr    string //IP address of the request
+	Succeed   bool   //If the action succeed
+	Detail    string //Additional information, e.g. the reason of a failure
+	PrevHash  string //Hash of the previous event in the log
+	Hash      string //Hash of this event, including the previous hash
+}
+
+type AuditLogger struct {
+	Filepath string
+	file     *os.File
+	lastHash string
+	mutex    sync.Mutex
+}
+
+// Create a new audit logger that append to the given file
+func NewAuditLogger(logFilepath string) (*AuditLogger, error) {
+	err := os.MkdirAll(filepath.Dir(logFilepath), 0775)
+	if err != nil {
+		return nil, err
+	}
+
+	//Resume the hash chain from the last event in the file
+	lastHash := ""
+	events, err := readEvents(logFilepath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(events) > 0 {
+		lastHash = events[len(events)-1].Hash
+	}
+
+	f, err := os.OpenFile(logFilepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditLogger{
+		Filepath: logFilepath,
+		file:     f,
+		lastHash: lastHash,
+	}, nil
+}
+
+// Append an event to the audit log. Timestamp and hashes are filled in automatically
+func (l *AuditLogger) Log(event AuditEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return errors.New("audit log closed")
+	}
+
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	event.PrevHash = l.lastHash
+	event.Hash = hashEvent(&event)
+
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.file.Write(append(js, '\n'))
+	if err != nil {
+		return err
+	}
+
+	l.lastHash = event.Hash
+	return l.file.Sync()
+}
+
+// Get the latest n events, return all events if n <= 0
+func (l *AuditLogger) Tail(n int) ([]*AuditEvent, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	events, err := readEvents(l.Filepath)
+	if err != nil {
+		return []*AuditEvent{}, err
+	}
+	if n > 0 && len(events) > n {
+		events = events[len(events)-n:]
+	}
+	return events, nil
+}
+
+// Verify the hash chain of the audit log. Return the line number (starting from 1) of the first broken event if any
+func (l *AuditLogger) Verify() (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	events, err := readEvents(l.Filepath)
+	if err != nil {
+		return 0, err
+	}
+
+	prevHash := ""
+	for i, event := range events {
+		if event.PrevHash != prevHash || event.Hash != hashEvent(event) {
+			return i + 1, errors.New("audit log hash chain broken at line " + strconv.Itoa(i+1))
+		}
+		prevHash = event.Hash
+	}
+	return 0, nil
+}
+
+func (l *AuditLogger) Close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// Calculate the hash of an event, the Hash field itself is excluded
+func hashEvent(event *AuditEvent) string {
+	thisEvent := *event
+	thisEvent.Hash = ""
+	js, _ := json.Marshal(thisEvent)
+	sum := sha256.Sum256(js)
+	return hex.EncodeToString(sum[:])
+}
+
+func readEvents(logFilepath string) ([]*AuditEvent, error) {
+	f, err := os.Open(logFilepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []*AuditEvent{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		thisEvent := AuditEvent{}
+		err = json.Unmarshal(scanner.Bytes(), &thisEvent)
+		if err != nil {
+			//Keep the malformed line so Verify can report it
+			thisEvent = AuditEvent{Detail: "malformed audit log line"}
+		}
+		events = append(events, &thisEvent)
+	}
+	return events, scanner.Err()
+}
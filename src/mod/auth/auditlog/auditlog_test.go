@@ -0,0 +1,50 @@
+package auditlog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogHashChain(t *testing.T) {
+	logFilepath := filepath.Join(t.TempDir(), "audit.log")
+	l, err := NewAuditLogger(logFilepath)
+	if err != nil {
+		t.Fatalf("Failed to create audit logger: %v", err)
+	}
+
+	l.Log(AuditEvent{Action: "login", Actor: "alice", Target: "alice", IpAddr: "127.0.0.1", Succeed: true})
+	l.Log(AuditEvent{Action: "ban", Actor: "alice", Target: "192.168.0.10", IpAddr: "127.0.0.1", Succeed: true})
+	l.Close()
+
+	//Reopen and continue the chain
+	l, err = NewAuditLogger(logFilepath)
+	if err != nil {
+		t.Fatalf("Failed to reopen audit logger: %v", err)
+	}
+	defer l.Close()
+	l.Log(AuditEvent{Action: "logout", Actor: "alice", Target: "alice", IpAddr: "127.0.0.1", Succeed: true})
+
+	events, err := l.Tail(2)
+	if err != nil {
+		t.Fatalf("Tail failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != "ban" || events[1].Action != "logout" {
+		t.Fatalf("Unexpected tail result: %+v", events)
+	}
+	if events[1].PrevHash != events[0].Hash {
+		t.Error("Expected hash chain to continue after reopen")
+	}
+
+	if line, err := l.Verify(); err != nil || line != 0 {
+		t.Fatalf("Expected intact audit log, got line %d: %v", line, err)
+	}
+
+	//Tamper the second event
+	content, _ := os.ReadFile(logFilepath)
+	os.WriteFile(logFilepath, []byte(strings.Replace(string(content), "192.168.0.10", "192.168.0.11", 1)), 0600)
+	if line, err := l.Verify(); err == nil || line != 2 {
+		t.Fatalf("Expected tampering at line 2 to be detected, got line %d", line)
+	}
+}
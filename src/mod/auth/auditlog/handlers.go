@@ -0,0 +1,53 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+// Handle tailing of the audit log. Accept GET n for the number of latest events, default 100
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (l *AuditLogger) HandleTail(w http.ResponseWriter, r *http.Request) {
+	n := 100
+	nstr, err := utils.GetPara(r, "n")
+	if err == nil && nstr != "" {
+		n, err = strconv.Atoi(nstr)
+		if err != nil {
+			utils.SendErrorResponse(w, "invalid n given")
+			return
+		}
+	}
+
+	events, err := l.Tail(n)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(events)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Handle verification of the audit log hash chain
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (l *AuditLogger) HandleVerify(w http.ResponseWriter, r *http.Request) {
+	type VerifyResult struct {
+		Intact     bool
+		BrokenLine int
+	}
+
+	brokenLine, err := l.Verify()
+	if err != nil && brokenLine == 0 {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(VerifyResult{
+		Intact:     brokenLine == 0,
+		BrokenLine: brokenLine,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
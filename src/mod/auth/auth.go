@@ -32,9 +32,13 @@ import (
 	"time"
 
 	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
 
 	"imuslab.com/arozos/mod/auth/accesscontrol/blacklist"
+	"imuslab.com/arozos/mod/auth/accesscontrol/geofilter"
+	"imuslab.com/arozos/mod/auth/accesscontrol/timewindow"
 	"imuslab.com/arozos/mod/auth/accesscontrol/whitelist"
+	"imuslab.com/arozos/mod/auth/auditlog"
 	"imuslab.com/arozos/mod/auth/authlogger"
 	"imuslab.com/arozos/mod/auth/explogin"
 	db "imuslab.com/arozos/mod/database"
@@ -46,6 +50,8 @@ type AuthAgent struct {
 	//Session related
 	SessionName             string
 	SessionStore            *sessions.CookieStore
+	sessionStoreMutex       sync.RWMutex  //Guard the session store replaced by session key rotation, see sessionkey.go
+	CookieOptions           CookieOptions //Attributes of the cookies set by the agent, change with SetCookieOptions
 	Database                *db.Database
 	LoginRedirectionHandler func(http.ResponseWriter, *http.Request)
 
@@ -65,12 +71,140 @@ type AuthAgent struct {
 	//IPLists manager
 	WhitelistManager *whitelist.WhiteList
 	BlacklistManager *blacklist.BlackList
+	GeoFilterManager *geofilter.GeoFilter
+	LoginTimeWindows *timewindow.TimeWindowManager //Per group login time windows, see ValidateLoginTimeWindow
+
+	//Brute-force protection
+	AutoBanThreshold  int   //Number of failed logins from an IP before it is banned, 0 = disabled
+	AutoBanWindow     int64 //Time window for counting failed logins in seconds
+	AutoBanDuration   int64 //Duration of the automatic ban in seconds
+	loginFailures     map[string][]int64
+	loginFailureMutex sync.Mutex
+
+	//Registration rate limit
+	registrationLimit   RegistrationLimit
+	registrations       map[string][]int64 //Registration time of each IP within the window
+	globalRegistrations []int64            //Registration time of all registrations within the window
+	registrationMutex   sync.Mutex
+
+	//Group session policies, see grouppolicy.go
+	groupPolicies    map[string]*GroupPolicy
+	groupPolicyMutex sync.RWMutex
+
+	//Account lockout, see lockout.go
+	LockoutThreshold     int                                     //Number of consecutive failed logins before the account is locked, 0 to disable
+	LockoutNightlyClear  bool                                    //Clear all account lockouts in the nightly retry counter reset
+	AccountLockedHandler func(username string, lock AccountLock) //Called when an account is locked, can be nil
+	accountFailures      map[string]int
+	accountFailureMutex  sync.Mutex
+
+	//CAPTCHA challenge, see captcha.go
+	CaptchaVerifier     CaptchaVerifier //Verifier of the CAPTCHA tokens, CAPTCHA is disabled if nil
+	CaptchaSiteKey      string          //Public site key for rendering the CAPTCHA widget
+	CaptchaThreshold    int             //Number of failed logins from an IP before CAPTCHA is required
+	captchaFailures     map[string]int
+	captchaFailureMutex sync.Mutex
+
+	//Breached password check of the password policy, disabled if nil. See breached.go
+	BreachedPasswordChecker BreachedPasswordChecker
+
+	//Check if a permission group exists, used to validate the group of imported accounts. Can be nil
+	GroupExists func(group string) bool
+
+	//Storage quota of the users, quota is not enforced if nil. See quota.go
+	StorageQuota StorageQuotaProvider
+
+	//Client certificate authentication, disabled if nil. See clientcert.go
+	ClientCertAuth *ClientCertAuth
+
+	//Request body size limits, see bodylimit.go
+	MaxAuthBodySize      int64 //Max request body size of login and register in bytes, 0 for default
+	MaxCSVImportBodySize int64 //Max request body size of the CSV account import in bytes, 0 for default
 
 	//Account Switcher
 	SwitchableAccountManager *SwitchableAccountPoolManager
 
+	//Session tracking
+	MaxConcurrentSessions int           //Max number of concurrent sessions per user, 0 = unlimited
+	sessionRecords        sync.Map      //Session records, session id as key
+	SessionCache          *SessionCache //Short lived cache of the request session lookups
+	SessionMaxAge         int64         //Absolute lifetime of a session in seconds, 0 = never expire
+	SessionIdleTimeout    int64         //Max idle time of a session in seconds, 0 = never expire
+
+	//Global session revocation, see sessionrevoke.go
+	SessionKeyFixed     bool //Session key is given by startup flag and cannot be rotated
+	trackedSessionsOnly bool //Reject the sessions created before session tracking
+
+	//Two-factor authentication
+	TOTPWindow       int   //Number of time steps before and after the current one that is accepted
+	TrustedDeviceTTL int64 //Time before a trusted device require 2FA again in seconds, see trusteddevice.go
+	totpMutex        sync.Mutex
+
+	//Password policy
+	passwordPolicy   PasswordPolicy
+	PasswordHashCost int //bcrypt cost of the password hashes, see passwordhash.go
+
+	//Rules of the new usernames, see usernamepolicy.go
+	usernamePolicy UsernamePolicy
+
+	//Password expiry, see passwordexpiry.go
+	PasswordMaxAge                   int64                                    //Max age of a password in seconds before it must be changed, 0 = never expire
+	PasswordChangeRedirectionHandler func(http.ResponseWriter, *http.Request) //Redirect sessions that must change their password, reply with error if nil
+
+	//Self-service password reset
+	PasswordResetTTL      int64                              //Time before a reset token expires in seconds
+	SendPasswordReset     PasswordResetSender                //Deliver the reset token to the user, password reset is disabled if nil
+	LookupUsernameByEmail func(email string) (string, error) //Resolve the username from email for reset requests and login, can be nil
+
+	//Email of the accounts created outside public registration, provided by the register module. Can be nil
+	CheckUserEmail func(username string, email string) error //Check if the email is valid and not used by other accounts
+	StoreUserEmail func(username string, email string) error //Store the email of the user, reject emails used by other accounts
+
+	//New device login notification, see newdevice.go
+	SendNewDeviceNotice NewDeviceNotifier //Notify the user about login from a new device, disabled if nil
+	KnownDeviceTTL      int64             //Forget the devices not seen for this number of seconds, 0 to keep forever
+	MaxKnownDevices     int               //Max number of known devices kept per user, 0 for unlimited
+
+	//Additional redirect prefixes allowed after login, see redirect.go
+	redirectAllowList []string
+
+	//Show the specific login rejection reasons to clients, see rejection.go
+	verboseLoginReason bool
+
+	//Step-up tokens of sensitive operations, see stepup.go
+	stepUpWindow int64    //Time a step-up token can be used in seconds, 0 = disabled
+	stepUpTokens sync.Map //Step-up tokens, session id as key
+
+	//Admin bootstrap of fresh install, see bootstrap.go
+	IsAdminGroup   func(group string) bool //Check if a permission group is administrator. Can be nil
+	setupTokenHash string                  //Hash of the one-time setup token, empty if not issued or used
+	bootstrapMutex sync.Mutex
+
+	//Autologin token lifetime, see autologin.go
+	AutoLoginTokenTTL int64 //Default lifetime of new autologin tokens in seconds, 0 = never expire
+	autoLoginMutex    sync.Mutex
+
+	//WebAuthn relying party and pending ceremonies, see webauthn.go
+	WebAuthnRPID       string   //Relying party ID (domain) of passkeys, WebAuthn is disabled if empty
+	WebAuthnOrigins    []string //Origins allowed to run the ceremonies, https://{WebAuthnRPID} if empty
+	webauthnCeremonies sync.Map //Pending ceremony session data, ceremony id as key
+	webauthnDummyKey   []byte   //Key for generating the fake credentials of users without passkeys
+	webauthnDummyOnce  sync.Once
+
+	//Maintenance mode that block non-admin users, see maintenance.go
+	MaintenanceRedirectionHandler func(http.ResponseWriter, *http.Request) //Handle the sessions paused by maintenance mode, reply with error if nil
+	maintenance                   MaintenanceMode
+	maintenanceMutex              sync.RWMutex
+
+	//Counters of the authentication events, see metrics.go
+	metrics *AuthMetrics
+
+	//Authentication events for the subscribers, see events.go
+	Events *AuthEventBus
+
 	//Logger
-	Logger *authlogger.Logger
+	Logger      *authlogger.Logger
+	AuditLogger *auditlog.AuditLogger //Append-only audit trail of authentication events, see audit.go
 }
 
 type AuthEndpoints struct {
@@ -90,6 +224,28 @@ func NewAuthenticationAgent(sessionName string, key []byte, sysdb *db.Database,
 		panic(err)
 	}
 
+	//Create the tables for session tracking
+	sysdb.NewTable("auth_sessions")
+	sysdb.NewTable("auth_sessionconf")
+
+	//Create the table for password policy
+	sysdb.NewTable("auth_policy")
+
+	//Create the table for WebAuthn credentials
+	sysdb.NewTable("auth_webauthn")
+
+	//Create the table for API keys
+	sysdb.NewTable("auth_apikey")
+
+	//Create the table for trusted devices
+	sysdb.NewTable("auth_trusteddevice")
+
+	//Create the table for devices used to login
+	sysdb.NewTable("auth_knowndevice")
+
+	//Create the table for group session policies
+	sysdb.NewTable("auth_grouppolicy")
+
 	//Creat a ticker to clean out outdated token every 5 minutes
 	ticker := time.NewTicker(300 * time.Second)
 	done := make(chan bool)
@@ -103,11 +259,27 @@ func NewAuthenticationAgent(sessionName string, key []byte, sysdb *db.Database,
 	//Create a new blacklist manager
 	thisBlacklistManager := blacklist.NewBlacklistManager(sysdb)
 
+	//Create a new geo-IP filter, no-op until a GeoIP database is loaded
+	thisGeoFilterManager := geofilter.NewGeoFilterManager(sysdb)
+
+	//Create a new login time window manager, all groups are always allowed by default
+	thisTimeWindowManager := timewindow.NewTimeWindowManager(sysdb)
+
 	//Create a new logger for logging all login request
 	newLogger, err := authlogger.NewLogger()
 	if err != nil {
 		panic(err)
 	}
+	err = newLogger.LoadIPModeSettings(sysdb)
+	if err != nil {
+		log.Println("[System Auth] Unable to load connection log IP mode: " + err.Error())
+	}
+
+	//Create the audit logger for authentication events
+	newAuditLogger, err := auditlog.NewAuditLogger("./system/auth/audit.log")
+	if err != nil {
+		panic(err)
+	}
 
 	//Create a new AuthAgent object
 	newAuthAgent := AuthAgent{
@@ -127,15 +299,111 @@ func NewAuthenticationAgent(sessionName string, key []byte, sysdb *db.Database,
 		//Blacklist management
 		WhitelistManager: thisWhitelistManager,
 		BlacklistManager: thisBlacklistManager,
+		GeoFilterManager: thisGeoFilterManager,
+		LoginTimeWindows: thisTimeWindowManager,
 		ExpDelayHandler:  expLoginHandler,
 
+		//2FA, allow ±1 time step for clock drift
+		TOTPWindow: 1,
+
+		//Trusted devices skip 2FA for 30 days
+		TrustedDeviceTTL: 86400 * 30,
+
+		//Brute-force protection
+		AutoBanThreshold: 0,
+		AutoBanWindow:    600,
+		AutoBanDuration:  3600,
+		loginFailures:    map[string][]int64{},
+		registrations:    map[string][]int64{},
+		accountFailures:  map[string]int{},
+
+		//Require CAPTCHA after 3 failed logins if a verifier is set
+		CaptchaThreshold: 3,
+		captchaFailures:  map[string]int{},
+
+		//Authentication event bus and counters
+		Events:  NewAuthEventBus(),
+		metrics: NewAuthMetrics(),
+
+		//Session lookup cache
+		SessionCache: NewSessionCache(1024, 5*time.Second),
+
+		//bcrypt default cost for password hashing
+		PasswordHashCost: bcrypt.DefaultCost,
+
+		//Password reset token expire in 1 hour
+		PasswordResetTTL: 3600,
+
+		//Keep the 20 latest devices used in the last 180 days
+		KnownDeviceTTL:  86400 * 180,
+		MaxKnownDevices: 20,
+
+		//Session expiry, default never expire
+		SessionMaxAge:      0,
+		SessionIdleTimeout: 0,
+
 		//Switchable Account Pool Manager
-		Logger: newLogger,
+		Logger:      newLogger,
+		AuditLogger: newAuditLogger,
 	}
 
+	//Record the manual ban and unban to the audit log
+	thisBlacklistManager.EventHandler = func(r *http.Request, action string, target string, succeed bool, detail string) {
+		newAuthAgent.LogAuditEventByRequest(r, action, target, succeed, detail)
+		if action == AuditActionBan && succeed {
+			newAuthAgent.Events.Publish(AuthEvent{
+				Type:    EventBan,
+				IpAddr:  target,
+				Reason:  detail,
+				Request: r,
+			})
+		}
+	}
+
+	//Count the authentication events
+	newAuthAgent.metrics.subscribe(newAuthAgent.Events)
+
 	poolManager := NewSwitchableAccountPoolManager(sysdb, &newAuthAgent, key)
 	newAuthAgent.SwitchableAccountManager = poolManager
 
+	//Apply the default cookie attributes to the session stores
+	newAuthAgent.SetCookieOptions(DefaultCookieOptions())
+
+	//Load the session limit and tracked sessions
+	sysdb.Read("auth_sessionconf", "maxconcurrent", &newAuthAgent.MaxConcurrentSessions)
+	sysdb.Read("auth_sessionconf", "maxage", &newAuthAgent.SessionMaxAge)
+	sysdb.Read("auth_sessionconf", "idletimeout", &newAuthAgent.SessionIdleTimeout)
+	newAuthAgent.loadTrackedSessionsOnly()
+	err = newAuthAgent.LoadSessionRecordsFromDB()
+	if err != nil {
+		log.Println("[System Auth] Unable to load session records: " + err.Error())
+	}
+
+	//Load the password policy
+	newAuthAgent.loadPasswordPolicy()
+	newAuthAgent.loadPasswordMaxAge()
+
+	//Load the username policy
+	newAuthAgent.loadUsernamePolicy()
+
+	//Load the registration rate limit
+	newAuthAgent.loadRegistrationLimit()
+
+	//Load the login redirect allow-list
+	newAuthAgent.loadRedirectAllowList()
+
+	//Load the login rejection reason verbosity
+	newAuthAgent.loadVerboseLoginReason()
+
+	//Load the group session policies
+	newAuthAgent.loadGroupPolicies()
+
+	//Load the step-up window of sensitive operations
+	newAuthAgent.loadStepUpWindow()
+
+	//Load the maintenance mode
+	newAuthAgent.loadMaintenanceMode()
+
 	//Create a timer to listen to its token storage
 	go func(listeningAuthAgent *AuthAgent) {
 		for {
@@ -144,6 +412,11 @@ func NewAuthenticationAgent(sessionName string, key []byte, sysdb *db.Database,
 				return
 			case <-ticker.C:
 				listeningAuthAgent.ClearTokenStore()
+				listeningAuthAgent.RemoveExpiredSessions()
+				listeningAuthAgent.RemoveExpiredStepUpTokens()
+				listeningAuthAgent.RemoveExpiredWebAuthnCeremonies()
+				listeningAuthAgent.RemoveExpiredSessionKey()
+				listeningAuthAgent.ExpDelayHandler.RemoveExpiredRetryCounters()
 			}
 		}
 	}(&newAuthAgent)
@@ -159,14 +432,37 @@ func (a *AuthAgent) Close() {
 
 	//Close the auth logger database
 	a.Logger.Close()
+
+	//Close the audit log
+	a.AuditLogger.Close()
 }
 
 // This function will handle an http request and redirect to the given login address if not logged in
 func (a *AuthAgent) HandleCheckAuth(w http.ResponseWriter, r *http.Request, handler func(http.ResponseWriter, *http.Request)) {
 	if a.CheckAuth(r) {
+		//Sessions with expired password can only access the password change endpoints
+		if !isPasswordChangeExempt(r.URL.Path) && a.PasswordChangePending(w, r) {
+			if a.PasswordChangeRedirectionHandler != nil {
+				a.PasswordChangeRedirectionHandler(w, r)
+			} else {
+				sendErrorResponse(w, "Password change required")
+			}
+			return
+		}
+
+		//Sessions of non-admin users are paused during maintenance
+		if !isMaintenanceExempt(r.URL.Path) && a.MaintenancePending(w, r) {
+			if a.MaintenanceRedirectionHandler != nil {
+				a.MaintenanceRedirectionHandler(w, r)
+			} else {
+				sendAuthErrorResponse(w, AuthErrMaintenance, a.GetMaintenanceMessage())
+			}
+			return
+		}
+
 		//User already logged in
 		handler(w, r)
-	} else {
+	} else if !a.HandleAPIKeyScopeDenied(w, r) {
 		//User not logged in
 		a.LoginRedirectionHandler(w, r)
 	}
@@ -174,6 +470,10 @@ func (a *AuthAgent) HandleCheckAuth(w http.ResponseWriter, r *http.Request, hand
 
 // Handle login request, require POST username and password
 func (a *AuthAgent) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if !limitRequestBody(w, r, a.getMaxAuthBodySize()) {
+		a.publishEvent(r, EventLoginFailure, "", LoginFailureOther)
+		return
+	}
 
 	//Get username from request using POST mode
 	username, err := utils.PostPara(r, "username")
@@ -182,16 +482,22 @@ func (a *AuthAgent) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		log.Println("[System Auth] Someone trying to login with username: " + username)
 		//Write to log
 		a.Logger.LogAuth(r, false)
-		sendErrorResponse(w, "Username not defined or empty.")
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "Username not defined or empty")
+		a.publishEvent(r, EventLoginFailure, username, LoginFailureMissingCredentials)
+		sendAuthErrorResponse(w, AuthErrMissingUsername, "Username not defined or empty.")
 		return
 	}
 
+	//Allow login with the registered email of the account
+	username = a.ResolveLoginUsername(username)
+
 	//Get password from request using POST mode
 	password, err := utils.PostPara(r, "password")
 	if err != nil {
 		//Password not defined
 		a.Logger.LogAuth(r, false)
-		sendErrorResponse(w, "Password not defined or empty.")
+		a.publishEvent(r, EventLoginFailure, username, LoginFailureMissingCredentials)
+		sendAuthErrorResponse(w, AuthErrMissingPassword, "Password not defined or empty.")
 		return
 	}
 
@@ -207,34 +513,115 @@ func (a *AuthAgent) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		//Too many request! (maybe the account is under brute force attack?)
 		a.ExpDelayHandler.AddUserRetrycount(username, r)
-		sendErrorResponse(w, "Too many request! Next retry in "+strconv.Itoa(int(nextRetryIn))+" seconds")
+		a.publishEvent(r, EventLoginFailure, username, LoginFailureRateLimited)
+		sendAuthErrorResponse(w, AuthErrRateLimited, "Too many request! Next retry in "+strconv.Itoa(int(nextRetryIn))+" seconds")
+		return
+	}
+
+	//Require CAPTCHA if there are too many failed logins from this IP
+	if err := a.validateLoginCaptcha(r); err != nil {
+		a.recordCaptchaFailure(r)
+		a.Logger.LogAuth(r, false)
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, err.Error())
+		a.publishEvent(r, EventLoginFailure, username, LoginFailureCaptcha)
+		sendAuthErrorResponse(w, getAuthErrorCode(err), err.Error())
+		return
+	}
+
+	//Reject login to locked accounts
+	if a.UserIsLocked(username) {
+		a.Logger.LogAuth(r, false)
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "Account locked")
+		a.publishEvent(r, EventLoginFailure, username, LoginFailureAccountLocked)
+		sendAuthErrorResponse(w, a.LoginRejectionCode(accountLockedReason), a.LoginRejectionReason(accountLockedReason))
 		return
 	}
 
+	//Reject requests from blocked countries before checking the password
+	if clientIP, err := network.GetIpFromRequest(r); err == nil {
+		if ok, reason := a.ValidateLoginGeoLocation(username, clientIP); !ok {
+			a.LogAuditEvent(r, AuditActionLogin, "", username, false, reason.Error())
+			a.publishEvent(r, EventLoginFailure, username, LoginFailureGeoBlocked)
+			sendAuthErrorResponse(w, AuthErrGeoBlocked, reason.Error())
+			return
+		}
+	}
+
 	//Check the database and see if this user is in the database
-	passwordCorrect, rejectionReason := a.ValidateUsernameAndPasswordWithReason(username, password)
+	passwordCorrect, rejectionReason := a.validateCredentials(username, password)
 	//The database contain this user information. Check its password if it is correct
 	if passwordCorrect {
 		//Password correct
 		//Check if this request origin is allowed to access
 		ok, reasons := a.ValidateLoginRequest(w, r)
 		if !ok {
-			sendErrorResponse(w, reasons.Error())
+			code := getAuthErrorCode(reasons)
+			if reasons == nil {
+				reasons = errors.New("Unable to resolve request origin")
+				code = AuthErrOriginUnknown
+			}
+			a.LogAuditEvent(r, AuditActionLogin, username, username, false, reasons.Error())
+			a.publishEvent(r, EventLoginFailure, username, LoginFailureOriginDenied)
+			sendAuthErrorResponse(w, code, reasons.Error())
+			return
+		}
+
+		//Check if the user groups allow login at this time
+		if ok, reason := a.ValidateLoginTimeWindow(username); !ok {
+			a.Logger.LogAuth(r, false)
+			a.LogAuditEvent(r, AuditActionLogin, username, username, false, reason.Error())
+			a.publishEvent(r, EventLoginFailure, username, LoginFailureOutsideTimeWindow)
+			sendAuthErrorResponse(w, AuthErrOutsideLoginHours, reason.Error())
+			return
+		}
+
+		//Only administrators can login during maintenance
+		if a.MaintenanceBlocksUser(username) {
+			a.Logger.LogAuth(r, false)
+			a.LogAuditEvent(r, AuditActionLogin, username, username, false, "System under maintenance")
+			a.publishEvent(r, EventLoginFailure, username, LoginFailureMaintenance)
+			sendAuthErrorResponse(w, AuthErrMaintenance, a.GetMaintenanceMessage())
+			return
+		}
+
+		//Check the 2FA code if the user has 2FA enabled or required by group policy
+		if ok, code, reason, failure := a.validateLoginSecondFactor(w, r, username); !ok {
+			sendAuthErrorResponse(w, code, reason)
+			if failure != "" {
+				a.Logger.LogAuth(r, false)
+				a.LogAuditEvent(r, AuditActionLogin, username, username, false, reason)
+				a.publishEvent(r, EventLoginFailure, username, failure)
+			}
 			return
 		}
 
 		// Set user as authenticated
-		a.LoginUserByRequest(w, r, username, rememberme)
+		err = a.LoginUserByRequest(w, r, username, rememberme)
+		if err != nil {
+			code := getAuthErrorCode(err)
+			a.Logger.LogAuth(r, false)
+			a.LogAuditEvent(r, AuditActionLogin, username, username, false, err.Error())
+			a.publishEvent(r, EventLoginFailure, username, getLoginFailureType(code))
+			sendAuthErrorResponse(w, code, err.Error())
+			return
+		}
 
 		//Reset user retry count if any
 		a.ExpDelayHandler.ResetUserRetryCount(username, r)
+		a.resetUserLoginFailure(username)
+		a.resetCaptchaFailure(r)
 
 		//Check if the current switchable account pool owner is this user.
 		a.SwitchableAccountManager.MatchPoolCreatorOrResetPoolID(username, w, r)
 
+		//Notify the user if this login is from a new device. Must be done before logging this login
+		a.checkNewDeviceLogin(r, username)
+
 		//Print the login message to console
 		log.Println(username + " logged in.")
 		a.Logger.LogAuth(r, true)
+		a.LogAuditEvent(r, AuditActionLogin, username, username, true, "")
+		a.publishEvent(r, EventLoginSuccess, username, "")
 		sendOK(w)
 	} else {
 		//Password incorrect
@@ -242,32 +629,133 @@ func (a *AuthAgent) HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 		//Add to retry count
 		a.ExpDelayHandler.AddUserRetrycount(username, r)
-		sendErrorResponse(w, rejectionReason)
+		a.recordLoginFailure(r)
+		a.recordCaptchaFailure(r)
+		if a.recordUserLoginFailure(r, username) {
+			rejectionReason = accountLockedReason
+		}
+		sendAuthErrorResponse(w, a.LoginRejectionCode(rejectionReason), a.LoginRejectionReason(rejectionReason))
 		a.Logger.LogAuth(r, false)
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, rejectionReason)
+		a.publishEvent(r, EventLoginFailure, username, LoginFailureInvalidCredentials)
 		return
 	}
 }
 
+// Check the 2FA code of a login request with correct password, shared by the login paths accepting passwords.
+// Trusted devices can skip the second factor unless it is required by group policy. Return the error code, reason
+// and login failure type (see metrics.go) if rejected. The failure type is empty if the code is not given yet
+func (a *AuthAgent) validateLoginSecondFactor(w http.ResponseWriter, r *http.Request, username string) (bool, AuthErrorCode, string, string) {
+	require2FA := a.UserRequire2FA(username)
+	if require2FA && !a.UserHasTOTPEnabled(username) {
+		log.Println(username + " login request rejected: 2FA required by group policy but not enrolled")
+		return false, AuthErr2FANotEnrolled, "2FA is required for your account but not set up. Please contact your system administrator", LoginFailure2FARequired
+	}
+	if !a.UserHasTOTPEnabled(username) || (!require2FA && a.IsTrustedDevice(username, r)) {
+		return true, "", "", ""
+	}
+
+	totpCode, err := utils.PostPara(r, "totp")
+	if err != nil {
+		//Password correct but 2FA code not given yet
+		return false, AuthErr2FARequired, "2FA code required", ""
+	}
+	if !a.ValidateTOTPCode(username, totpCode) {
+		log.Println(username + " login request rejected: Invalid 2FA code")
+		a.ExpDelayHandler.AddUserRetrycount(username, r)
+		a.recordLoginFailure(r)
+		a.recordCaptchaFailure(r)
+		a.recordUserLoginFailure(r, username)
+		return false, AuthErrInvalid2FA, "Invalid 2FA code", LoginFailureInvalid2FA
+	}
+
+	//Remember this device if requested
+	trustDevice, _ := utils.PostPara(r, "trustdevice")
+	if trustDevice == "true" {
+		_, err := a.TrustDevice(w, r, username)
+		if err != nil {
+			log.Println("[System Auth] Unable to trust device for " + username + ": " + err.Error())
+		}
+	}
+	return true, "", "", ""
+}
+
+// Resolve the username from the login name, which can be a username or the registered email of the account.
+// Usernames take priority over emails. The login name is returned as is if it cannot be resolved
+func (a *AuthAgent) ResolveLoginUsername(loginName string) string {
+	if a.LookupUsernameByEmail == nil || !strings.Contains(loginName, "@") || a.UserExists(loginName) {
+		return loginName
+	}
+
+	username, err := a.LookupUsernameByEmail(loginName)
+	if err != nil {
+		//Email not registered or shared by multiple accounts
+		return loginName
+	}
+	return username
+}
+
 func (a *AuthAgent) ValidateUsernameAndPassword(username string, password string) bool {
 	succ, _ := a.ValidateUsernameAndPasswordWithReason(username, password)
 	return succ
 }
 
-// validate the username and password, return reasons if the auth failed
+// validate the username and password, return reasons if the auth failed.
+// The reason is safe to be shown to clients, see LoginRejectionReason.
+// Emails are not accepted here as the callers (e.g. FTP, WebDAV) use the given name as the account name,
+// resolve them with ResolveLoginUsername first
 func (a *AuthAgent) ValidateUsernameAndPasswordWithReason(username string, password string) (bool, string) {
-	hashedPassword := Hash(password)
+	succ, _, reason := a.ValidateUsernameAndPasswordWithCode(username, password)
+	return succ, reason
+}
+
+// validate the username and password, return the error code and reason if the auth failed. See errorcode.go
+func (a *AuthAgent) ValidateUsernameAndPasswordWithCode(username string, password string) (bool, AuthErrorCode, string) {
+	return a.validateUsernameAndPasswordByRequest(nil, username, password)
+}
+
+// validate the username and password given in the request (can be nil). Incorrect passwords are counted toward
+// the account lockout like the failed logins, see lockout.go
+func (a *AuthAgent) validateUsernameAndPasswordByRequest(r *http.Request, username string, password string) (bool, AuthErrorCode, string) {
+	succ, reason := a.validateCredentials(username, password)
+	if !succ {
+		if reason == incorrectPasswordReason && a.recordUserLoginFailure(r, username) {
+			reason = accountLockedReason
+		}
+		log.Println("[System Auth] " + username + " login rejected: " + reason)
+		return false, a.LoginRejectionCode(reason), a.LoginRejectionReason(reason)
+	}
+	a.resetUserLoginFailure(username)
+	return true, "", ""
+}
+
+// validate the username and password, return the specific reason if the auth failed
+func (a *AuthAgent) validateCredentials(username string, password string) (bool, string) {
 	var passwordInDB string
+	if !a.Database.KeyExists("auth", "passhash/"+username) {
+		return false, userNotFoundReason
+	}
 	err := a.Database.Read("auth", "passhash/"+username, &passwordInDB)
-	if err != nil {
+	if err != nil || passwordInDB == "" {
 		//User not found or db exception
-		//log.Println("[System Auth] " + username + " login with incorrect password")
-		return false, "Invalid username or password"
+		return false, userNotFoundReason
 	}
 
-	if passwordInDB == hashedPassword {
+	if VerifyPassword(password, passwordInDB) {
+		//Upgrade the legacy or weaker hash to the current cost. See passwordhash.go
+		a.rehashPasswordIfNeeded(username, password, passwordInDB)
+		if a.UserIsPendingVerification(username) {
+			return false, accountPendingVerificationReason
+		}
+		if a.UserIsDisabled(username) {
+			return false, accountDisabledReason
+		}
+		if a.UserIsLocked(username) {
+			return false, accountLockedReason
+		}
 		return true, ""
 	} else {
-		return false, "Invalid username or password"
+		return false, incorrectPasswordReason
 	}
 }
 
@@ -287,46 +775,116 @@ func (a *AuthAgent) ValidateLoginIpAccess(ipv4 string) (bool, error) {
 	//Check if the account is whitelisted
 	if a.WhitelistManager.Enabled && !a.WhitelistManager.IsWhitelisted(ipv4) {
 		//Whitelist enabled but this IP is not whitelisted
-		return false, errors.New("Your IP is not whitelisted on this host")
+		return false, errIPNotWhitelisted
 	}
 
 	//Check if the account is banned
 	if a.BlacklistManager.Enabled && a.BlacklistManager.IsBanned(ipv4) {
 		//This user is banned
-		return false, errors.New("Your IP is banned by this host")
+		return false, errIPBanned
+	}
+
+	//Check if the request origin country is allowed
+	return a.ValidateLoginGeoLocation("", ipv4)
+}
+
+// Check if the request origin country is allowed by the geo-IP filter. Blocked attempts are logged
+func (a *AuthAgent) ValidateLoginGeoLocation(username string, ipAddr string) (bool, error) {
+	if a.GeoFilterManager == nil {
+		return true, nil
+	}
+
+	allowed, country := a.GeoFilterManager.IsAllowed(ipAddr)
+	if !allowed {
+		if country == "" {
+			country = "unknown"
+		}
+		log.Println("[System Auth] Login request from " + ipAddr + " (" + country + ") blocked by geo-IP filter")
+		a.Logger.LogAuthEvent(username, ipAddr, false, "geoip-blocked")
+		return false, errGeoBlocked
 	}
 	return true, nil
 }
 
-// Login the user by creating a valid session for this user
-func (a *AuthAgent) LoginUserByRequest(w http.ResponseWriter, r *http.Request, username string, rememberme bool) {
-	session, _ := a.SessionStore.Get(r, a.SessionName)
+// Check if the user is allowed to login at the current time by the login time windows of the user groups
+func (a *AuthAgent) ValidateLoginTimeWindow(username string) (bool, error) {
+	if a.LoginTimeWindows == nil {
+		return true, nil
+	}
 
-	session.Values["authenticated"] = true
-	session.Values["username"] = username
-	session.Values["rememberMe"] = rememberme
+	usergroups := []string{}
+	a.Database.Read("auth", "group/"+username, &usergroups)
+	allowed, reason := a.LoginTimeWindows.IsAllowed(usergroups, time.Now())
+	if !allowed {
+		log.Println("[System Auth] Login request of " + username + " rejected outside login time window")
+		return false, &loginTimeWindowError{reason: reason}
+	}
+	return true, nil
+}
+
+// Login the user by creating a valid session for this user.
+// Return error without creating the session if the user is not allowed to login at this time or the session cannot be saved.
+// The error code of the returned error can be resolved by getAuthErrorCode
+func (a *AuthAgent) LoginUserByRequest(w http.ResponseWriter, r *http.Request, username string, rememberme bool) error {
+	return a.loginUser(w, r, username, rememberme, "")
+}
+
+// Login the user with this request, set impersonator to the admin username for impersonation sessions.
+// The login time windows are enforced here so every login method respect them
+func (a *AuthAgent) loginUser(w http.ResponseWriter, r *http.Request, username string, rememberme bool, impersonator string) error {
+	if impersonator == "" {
+		if ok, reason := a.ValidateLoginTimeWindow(username); !ok {
+			return reason
+		}
+	}
 
-	CookieSetSameSitePolicy := http.SameSiteNoneMode
-	if r.TLS == nil {
-		//Connection is done via http
-		CookieSetSameSitePolicy = http.SameSiteLaxMode
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
+	a.SessionCache.Invalidate(a.getSessionToken(r))
+
+	//Remove the previous session record of this client if any (e.g. account switching)
+	if previousSessionID, ok := session.Values["sessionid"].(string); ok {
+		a.RevokeSession(previousSessionID)
 	}
 
 	//Check if remember me is clicked. If yes, set the maxage to 1 week.
+	cookieMaxAge := int64(3600 * 1) //One hour
 	if rememberme {
-		session.Options = &sessions.Options{
-			MaxAge:   3600 * 24 * 7, //One week
-			Path:     "/",
-			SameSite: CookieSetSameSitePolicy,
-		}
+		cookieMaxAge = 3600 * 24 * 7 //One week
+	}
+
+	//Do not keep the cookie longer than the session lifetime
+	if maxAge := a.GetUserSessionMaxAge(username); maxAge > 0 && cookieMaxAge > maxAge {
+		cookieMaxAge = maxAge
+	}
+
+	var sessionRecord *SessionRecord
+	if impersonator == "" {
+		sessionRecord = a.newSessionRecord(username, r, cookieMaxAge)
 	} else {
-		session.Options = &sessions.Options{
-			MaxAge:   3600 * 1, //One hour
-			Path:     "/",
-			SameSite: CookieSetSameSitePolicy,
-		}
+		//Impersonation do not count as a login of the target user
+		sessionRecord = a.storeSessionRecord(username, impersonator, r, cookieMaxAge)
 	}
-	session.Save(r, w)
+
+	session.Values["authenticated"] = true
+	session.Values["username"] = username
+	session.Values["rememberMe"] = rememberme
+	session.Values["sessionid"] = sessionRecord.ID
+	if impersonator == "" {
+		a.flagPasswordChange(username, session.Values)
+	}
+
+	session.Options = a.sessionCookieOptions(r, int(cookieMaxAge))
+	err := session.Save(r, w)
+	if err != nil {
+		a.RevokeSession(sessionRecord.ID)
+		return err
+	}
+
+	//Evict the oldest sessions if the user exceed the concurrent session limit
+	if impersonator == "" {
+		a.enforceSessionLimit(username, sessionRecord.ID)
+	}
+	return nil
 }
 
 // Handle logout, reply OK after logged out. WILL NOT DO REDIRECTION
@@ -336,15 +894,30 @@ func (a *AuthAgent) HandleLogout(w http.ResponseWriter, r *http.Request) {
 		log.Println(username + " logged out.")
 	}
 
+	//Logout ends the impersonation without touching the account pool of the admin
+	impersonator := a.GetImpersonator(r)
+	if impersonator != "" {
+		a.Logout(w, r)
+		a.LogAuditEvent(r, AuditActionImpersonStop, impersonator, username, true, "logged out")
+		a.publishEvent(r, EventLogout, username, "")
+		w.Write([]byte("OK"))
+		return
+	}
+
 	//Clear user switchable account pools
 	fallbackAccount, _ := a.SwitchableAccountManager.HandleLogoutforUser(w, r)
 
 	// Revoke users authentication
 	err := a.Logout(w, r)
 	if err != nil {
+		a.LogAuditEvent(r, AuditActionLogout, username, username, false, err.Error())
 		sendErrorResponse(w, "Logout failed")
 		return
 	}
+	a.LogAuditEvent(r, AuditActionLogout, username, username, true, "")
+	if username != "" {
+		a.publishEvent(r, EventLogout, username, "")
+	}
 
 	if fallbackAccount != "" {
 		//Switch to fallback account
@@ -355,12 +928,18 @@ func (a *AuthAgent) HandleLogout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *AuthAgent) Logout(w http.ResponseWriter, r *http.Request) error {
-	session, err := a.SessionStore.Get(r, a.SessionName)
+	session, err := a.getSessionStore().Get(r, a.SessionName)
 	if err != nil {
 		return err
 	}
+	if sessionID, ok := session.Values["sessionid"].(string); ok {
+		a.RevokeSession(sessionID)
+	}
+	a.SessionCache.Invalidate(a.getSessionToken(r))
 	session.Values["authenticated"] = false
 	session.Values["username"] = nil
+	session.Values["sessionid"] = nil
+	session.Options = a.sessionCookieOptions(r, -1) //Expire the cookie
 	session.Save(r, w)
 
 	return nil
@@ -368,9 +947,27 @@ func (a *AuthAgent) Logout(w http.ResponseWriter, r *http.Request) error {
 
 // Get the current session username from request
 func (a *AuthAgent) GetUserName(w http.ResponseWriter, r *http.Request) (string, error) {
+	if requestHasAPIKey(r) {
+		//Headless client authenticated by API key
+		apiKey, err := a.ValidateAPIKeyRequest(r)
+		if err != nil {
+			return "", errors.New("User not logged in")
+		}
+		return apiKey.Owner, nil
+	}
+
+	if a.requestHasClientCert(r) {
+		//Machine client authenticated by TLS client certificate
+		username, err := a.ValidateClientCertRequest(r)
+		if err != nil {
+			return "", errors.New("User not logged in")
+		}
+		return username, nil
+	}
+
 	if a.CheckAuth(r) {
 		//This user has logged in.
-		session, _ := a.SessionStore.Get(r, a.SessionName)
+		session, _ := a.getSessionStore().Get(r, a.SessionName)
 		return session.Values["username"].(string), nil
 	} else {
 		//This user has not logged in.
@@ -389,6 +986,9 @@ func (a *AuthAgent) CheckLogin(w http.ResponseWriter, r *http.Request) {
 
 // Handle new user register. Require POST username, password, group.
 func (a *AuthAgent) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	if !limitRequestBody(w, r, a.getMaxAuthBodySize()) {
+		return
+	}
 	userCount := a.GetUserCounts()
 
 	//Get username from request
@@ -412,23 +1012,86 @@ func (a *AuthAgent) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Check if the number of users in the system is == 0. If yes, there are no need to login before registering new user
-	if userCount > 0 {
+	//Optional email of the new user, must not be used by other accounts
+	email, _ := utils.PostPara(r, "email")
+	email = strings.TrimSpace(email)
+
+	//Until an administrator exists, only localhost or the setup token holder can create accounts
+	bootstrapAuthorizedBy := ""
+	if !a.AdminExists() {
+		bootstrapAuthorizedBy, err = a.validateBootstrapRequest(r)
+		if err != nil {
+			log.Println("[System Auth] Bootstrap account creation rejected for request from " + r.RemoteAddr)
+			a.LogAuditEvent(r, AuditActionBootstrap, "", newusername, false, err.Error())
+			sendAuthErrorResponse(w, AuthErrBootstrapRequired, err.Error())
+			return
+		}
+	} else if userCount > 0 {
+		//Check if the number of users in the system is == 0. If yes, there are no need to login before registering new user
 		//Require login to create new user
 		if a.CheckAuth(r) == false {
 			//System have more than one person and this user is not logged in
-			sendErrorResponse(w, "Login is needed to create new user")
+			sendAuthErrorResponse(w, AuthErrLoginRequired, "Login is needed to create new user")
+			return
+		}
+
+	}
+
+	//Check if the username fulfill the username policy
+	newusername = a.NormalizeUsername(newusername)
+	if ok, reason := a.ValidateUsernameWithPolicy(newusername); !ok {
+		sendAuthErrorResponse(w, AuthErrUsernameRejected, reason)
+		return
+	}
+
+	//Check if the password fulfill the password policy
+	if ok, reason := a.ValidatePasswordWithPolicy(password); !ok {
+		sendAuthErrorResponse(w, AuthErrPasswordRejected, reason)
+		return
+	}
+
+	//Check if the email is available
+	if email != "" {
+		if a.CheckUserEmail == nil || a.StoreUserEmail == nil {
+			sendAuthErrorResponse(w, AuthErrEmailRejected, "Email is not supported on this host")
 			return
 		}
+		err = a.CheckUserEmail(newusername, email)
+		if err != nil {
+			sendAuthErrorResponse(w, AuthErrEmailRejected, err.Error())
+			return
+		}
+	}
 
+	//Check if too many accounts are registered recently
+	err = a.CheckRegistrationRateLimit(r)
+	if err != nil {
+		sendAuthErrorResponse(w, AuthErrRegistrationLimited, err.Error())
+		return
 	}
 
 	//Ok to proceed create this user
 	err = a.CreateUserAccount(newusername, password, []string{group})
 	if err != nil {
-		sendErrorResponse(w, err.Error())
+		a.LogAuditEventByRequest(r, AuditActionRegister, newusername, false, err.Error())
+		sendAuthErrorResponse(w, AuthErrInternal, err.Error())
 		return
 	}
+	if email != "" {
+		err = a.StoreUserEmail(newusername, email)
+		if err != nil {
+			//Email taken in the meantime. Rollback the account so the request can be retried
+			a.UnregisterUser(newusername)
+			a.LogAuditEventByRequest(r, AuditActionRegister, newusername, false, err.Error())
+			sendAuthErrorResponse(w, AuthErrEmailRejected, err.Error())
+			return
+		}
+	}
+	a.LogAuditEventByRequest(r, AuditActionRegister, newusername, true, "group: "+group)
+	a.RecordRegistration(r, newusername)
+	if bootstrapAuthorizedBy != "" && a.AdminExists() {
+		a.completeBootstrap(r, newusername, bootstrapAuthorizedBy)
+	}
 
 	//Return to the client with OK
 	sendOK(w)
@@ -438,11 +1101,30 @@ func (a *AuthAgent) HandleRegister(w http.ResponseWriter, r *http.Request) {
 
 // Check authentication from request header's session value
 func (a *AuthAgent) CheckAuth(r *http.Request) bool {
-	session, _ := a.SessionStore.Get(r, a.SessionName)
+	//API key take precedence over the session cookie
+	if requestHasAPIKey(r) {
+		_, err := a.ValidateAPIKeyRequest(r)
+		return err == nil
+	}
+	if a.requestHasClientCert(r) {
+		_, err := a.ValidateClientCertRequest(r)
+		return err == nil
+	}
+
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
 	// Check if user is authenticated
 	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
 		return false
 	}
+
+	//Check if the session has been revoked. Sessions created before session tracking has no id
+	sessionID, ok := session.Values["sessionid"].(string)
+	if ok && !a.validateSessionID(sessionID) {
+		return false
+	} else if !ok && a.trackedSessionsOnly {
+		//Untracked sessions are revoked by global session revocation. See sessionrevoke.go
+		return false
+	}
 	return true
 }
 
@@ -473,9 +1155,11 @@ func (a *AuthAgent) HandleUnregister(w http.ResponseWriter, r *http.Request) {
 
 	err = a.UnregisterUser(username)
 	if err != nil {
+		a.LogAuditEventByRequest(r, AuditActionUnregister, username, false, err.Error())
 		sendErrorResponse(w, err.Error())
 		return
 	}
+	a.LogAuditEventByRequest(r, AuditActionUnregister, username, true, "")
 
 	//Return to the client with OK
 	sendOK(w)
@@ -495,10 +1179,27 @@ func (a *AuthAgent) UnregisterUser(username string) error {
 	a.Database.Delete("auth", "group/"+username)
 	a.Database.Delete("auth", "acstatus/"+username)
 	a.Database.Delete("auth", "profilepic/"+username)
+	a.Database.Delete("auth", "totp/"+username)
+	a.Database.Delete("auth", "createtime/"+username)
+	a.Database.Delete("auth", "lastlogin/"+username)
+	a.Database.Delete("auth", "locked/"+username)
+	a.Database.Delete("auth", "disabled/"+username)
+	a.Database.Delete("auth", "passwordchanged/"+username)
+	a.Database.Delete("auth", "mustchangepw/"+username)
+	a.removePendingAccountRecord(username)
+	a.RemoveUserWebAuthnCredentials(username)
+	a.removePasswordResetToken(username)
+	a.RevokeAllUserAPIKeys(username)
+	a.RevokeAllUserTrustedDevices(username)
+	a.RemoveUserKnownDevices(username)
 
 	//Remove the user's autologin tokens
 	a.RemoveAutologinTokenByUsername(username)
 
+	//Remove the user's login sessions
+	a.RevokeAllUserSessions(username)
+	a.SessionCache.InvalidateUser(username)
+
 	//Remove user from switchable accounts
 	a.SwitchableAccountManager.RemoveUserFromAllSwitchableAccountPool(username)
 	return nil
@@ -545,22 +1246,20 @@ func (a *AuthAgent) UserExists(username string) bool {
 }
 
 // Update the session expire time given the request header.
+// Requests authenticated by API key or client certificate have no session to extend
 func (a *AuthAgent) UpdateSessionExpireTime(w http.ResponseWriter, r *http.Request) bool {
-	session, _ := a.SessionStore.Get(r, a.SessionName)
-	if session.Values["authenticated"].(bool) {
+	if a.requestIsHeadless(r) {
+		return false
+	}
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
+	if auth, ok := session.Values["authenticated"].(bool); ok && auth {
 		//User authenticated. Extend its expire time
-		rememberme := session.Values["rememberMe"].(bool)
+		rememberme, _ := session.Values["rememberMe"].(bool)
 		//Extend the session expire time
 		if rememberme {
-			session.Options = &sessions.Options{
-				MaxAge: 3600 * 24 * 7, //One week
-				Path:   "/",
-			}
+			session.Options = a.sessionCookieOptions(r, 3600*24*7) //One week
 		} else {
-			session.Options = &sessions.Options{
-				MaxAge: 3600 * 1, //One hour
-				Path:   "/",
-			}
+			session.Options = a.sessionCookieOptions(r, 3600*1) //One hour
 		}
 		session.Save(r, w)
 		return true
@@ -571,10 +1270,7 @@ func (a *AuthAgent) UpdateSessionExpireTime(w http.ResponseWriter, r *http.Reque
 
 // Create user account
 func (a *AuthAgent) CreateUserAccount(newusername string, password string, group []string) error {
-	key := newusername
-
-	hashedPassword := Hash(password)
-	err := a.Database.Write("auth", "passhash/"+key, hashedPassword)
+	err := a.SetUserPassword(newusername, password)
 	if err != nil {
 		return err
 	}
@@ -584,9 +1280,26 @@ func (a *AuthAgent) CreateUserAccount(newusername string, password string, group
 	if err != nil {
 		return err
 	}
+
+	//Record the account creation time
+	a.Database.Write("auth", "createtime/"+newusername, time.Now().Unix())
 	return nil
 }
 
+// Get the account creation time of the user, return 0 if unknown (accounts created before it is recorded)
+func (a *AuthAgent) GetUserCreationTime(username string) int64 {
+	creationTime := int64(0)
+	a.Database.Read("auth", "createtime/"+username, &creationTime)
+	return creationTime
+}
+
+// Get the last login time of the user, return 0 if unknown
+func (a *AuthAgent) GetUserLastLoginTime(username string) int64 {
+	lastLoginTime := int64(0)
+	a.Database.Read("auth", "lastlogin/"+username, &lastLoginTime)
+	return lastLoginTime
+}
+
 // Hash the given raw string into sha512 hash
 func Hash(raw string) string {
 	h := sha512.New()
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"imuslab.com/arozos/mod/auth/accesscontrol/timewindow"
+)
+
+func TestResolveLoginUsername(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+	a.CreateUserAccount("alice", "password", []string{"user"})
+	a.CreateUserAccount("bob@example.com", "password", []string{"user"})
+
+	if got := a.ResolveLoginUsername("alice@example.com"); got != "alice@example.com" {
+		t.Errorf("Expected login name to be unchanged without email lookup, got %s", got)
+	}
+
+	a.LookupUsernameByEmail = func(email string) (string, error) {
+		switch strings.ToLower(email) {
+		case "alice@example.com":
+			return "alice", nil
+		case "bob@example.com":
+			return "alice", nil
+		}
+		return "", errors.New("Email not registered")
+	}
+
+	tests := map[string]string{
+		"alice":             "alice",
+		"Alice@Example.com": "alice",
+		"bob@example.com":   "bob@example.com", //Existing username takes priority
+		"eve@example.com":   "eve@example.com",
+	}
+	for loginName, expected := range tests {
+		if got := a.ResolveLoginUsername(loginName); got != expected {
+			t.Errorf("ResolveLoginUsername(%s) = %s, expected %s", loginName, got, expected)
+		}
+	}
+}
+
+func TestLoginTimeWindowEnforced(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+	a.LoginTimeWindows = timewindow.NewTimeWindowManager(a.Database)
+	a.CreateUserAccount("alice", "password", []string{"student"})
+
+	//Students can only login tomorrow
+	tomorrow := strings.ToLower(time.Now().Add(24 * time.Hour).Weekday().String()[:3])
+	a.LoginTimeWindows.SetSchedule("student", &timewindow.Schedule{
+		Windows: []timewindow.Window{{Days: []string{tomorrow}, Start: "00:00", End: "24:00"}},
+	})
+
+	//Every login method share the same login path, which must not create the session
+	w := httptest.NewRecorder()
+	err := a.LoginUserByRequest(w, httptest.NewRequest("POST", "/system/auth/webauthn/login/finish", nil), "alice", false)
+	if err == nil || len(w.Result().Cookies()) != 0 {
+		t.Fatal("Expected login rejected outside login time window")
+	}
+	if code := getAuthErrorCode(err); code != AuthErrOutsideLoginHours {
+		t.Errorf("Expected outside login hours code, got %s", code)
+	}
+
+	a.LoginTimeWindows.SetSchedule("student", nil)
+	w = httptest.NewRecorder()
+	if err := a.LoginUserByRequest(w, httptest.NewRequest("POST", "/system/auth/webauthn/login/finish", nil), "alice", false); err != nil || len(w.Result().Cookies()) == 0 {
+		t.Errorf("Expected login allowed without login time window, got %v", err)
+	}
+
+	//Other login failures are not reported as outside login hours
+	a.SessionStore = sessions.NewCookieStore(nil)
+	err = a.LoginUserByRequest(httptest.NewRecorder(), httptest.NewRequest("POST", "/system/auth/login", nil), "alice", false)
+	if err == nil || getAuthErrorCode(err) != AuthErrInternal {
+		t.Errorf("Expected session save failure reported as internal error, got %v", err)
+	}
+	if len(a.ListUserSessions("alice")) != 1 {
+		t.Error("Expected session record of the failed login removed")
+	}
+}
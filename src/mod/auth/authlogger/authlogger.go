@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"imuslab.com/arozos/mod/database"
+	"imuslab.com/arozos/mod/network"
 	"imuslab.com/arozos/mod/utils"
 )
 
@@ -25,6 +26,12 @@ import (
 
 type Logger struct {
 	database *database.Database
+	enricher *enricher //Reverse DNS and geo location lookup of the login records, nil if disabled. See enrich.go
+
+	//IP address privacy, see ipprivacy.go
+	settingsdb *database.Database //System database storing the IP mode settings
+	ipMode     string             //How the IP is stored in the login records
+	ipSalt     []byte             //Salt of the hashed IP mode
 }
 
 type LoginRecord struct {
@@ -34,6 +41,10 @@ type LoginRecord struct {
 	IpAddr         string
 	AuthType       string
 	Port           int
+	Hostname       string `json:",omitempty"` //Reverse DNS hostname of the IP, empty if unknown
+	Country        string `json:",omitempty"` //Country code of the IP, empty if unknown
+	City           string `json:",omitempty"` //City of the IP, empty if unknown
+	IpMode         string `json:",omitempty"` //Mode of IpAddr if it is not the full address, see ipprivacy.go
 }
 
 //New Logger create a new logger object
@@ -52,16 +63,8 @@ func NewLogger() (*Logger, error) {
 func (l *Logger) LogAuth(r *http.Request, loginStatus bool) error {
 	username, _ := utils.PostPara(r, "username")
 	timestamp := time.Now().Unix()
-	//handling the reverse proxy remote IP issue
-	remoteIP := r.Header.Get("X-FORWARDED-FOR")
-	if remoteIP != "" {
-		//grab the last known remote IP from header
-		remoteIPs := strings.Split(remoteIP, ", ")
-		remoteIP = remoteIPs[len(remoteIPs)-1]
-	} else {
-		//if there is no X-FORWARDED-FOR, use default remote IP
-		remoteIP = r.RemoteAddr
-	}
+	//handling the reverse proxy remote IP issue, headers are only honored from trusted proxies
+	remoteIP := network.GetRemoteAddrFromRequest(r)
 	return l.LogAuthByRequestInfo(username, remoteIP, timestamp, loginStatus, "web")
 }
 
@@ -104,10 +107,13 @@ func (l *Logger) LogAuthByRequestInfo(username string, remoteAddr string, timest
 		Timestamp:      timestamp,
 		TargetUsername: username,
 		LoginSucceed:   loginSucceed,
-		IpAddr:         remoteAddrInfo[0],
+		IpAddr:         l.AnonymizeIP(remoteAddrInfo[0]),
 		AuthType:       authType,
 		Port:           port,
 	}
+	if l.GetIPMode() != IPModeFull {
+		thisRecord.IpMode = l.GetIPMode()
+	}
 
 	//Write the log to it
 	entryKey := strconv.Itoa(int(time.Now().UnixNano()))
@@ -118,12 +124,46 @@ func (l *Logger) LogAuthByRequestInfo(username string, remoteAddr string, timest
 		return err
 	}
 
+	l.queueEnrichment(tableName, entryKey, remoteAddrInfo[0])
 	return nil
 
 }
 
+//Log an authentication event that is not triggered by a login request, e.g. session eviction
+func (l *Logger) LogAuthEvent(username string, ipAddr string, succeed bool, authType string) error {
+	current := time.Now().UTC()
+	tableName := current.Format("Jan-2006")
+	if !l.database.TableExists(tableName) {
+		l.database.NewTable(tableName)
+	}
+
+	thisRecord := LoginRecord{
+		Timestamp:      time.Now().Unix(),
+		TargetUsername: username,
+		LoginSucceed:   succeed,
+		IpAddr:         l.AnonymizeIP(ipAddr),
+		AuthType:       authType,
+		Port:           -1,
+	}
+	if l.GetIPMode() != IPModeFull {
+		thisRecord.IpMode = l.GetIPMode()
+	}
+
+	entryKey := strconv.Itoa(int(time.Now().UnixNano()))
+	err := l.database.Write(tableName, entryKey, thisRecord)
+	if err != nil {
+		return err
+	}
+
+	l.queueEnrichment(tableName, entryKey, ipAddr)
+	return nil
+}
+
 //Close the database when system shutdown
 func (l *Logger) Close() {
+	if l.enricher != nil {
+		l.enricher.close()
+	}
 	l.database.Close()
 }
 
@@ -159,6 +199,28 @@ func (l *Logger) ListRecords(key string) ([]LoginRecord, error) {
 	}
 }
 
+//Check the login records if the user has succeeded to login before, and if any of them is from the given IP
+func (l *Logger) HasLoginFrom(username string, ipAddr string) (bool, bool) {
+	userSeen := false
+	anonymizedIP := l.AnonymizeIP(ipAddr)
+	for _, tableName := range l.ListSummary() {
+		records, err := l.ListRecords(tableName)
+		if err != nil {
+			continue
+		}
+		for _, record := range records {
+			if !record.LoginSucceed || record.TargetUsername != username {
+				continue
+			}
+			userSeen = true
+			if record.IpAddr == ipAddr || record.IpAddr == anonymizedIP {
+				return true, true
+			}
+		}
+	}
+	return userSeen, false
+}
+
 //Extract the address information from the request object, the first one is the remote address from the last hop,
 //and the 2nd one is the source address filled in by the client (not accurate)
 func getIpAddressFromRequest(r *http.Request) (string, []string) {
@@ -1,6 +1,8 @@
 package authlogger
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -202,3 +204,45 @@ func TestLogAuthByRequestInfo(t *testing.T) {
 		}
 	}
 }
+
+type mockGeoLocator struct{}
+
+func (m mockGeoLocator) LookupCountry(ip net.IP) (string, error) {
+	return "HK", nil
+}
+
+func (m mockGeoLocator) LookupCity(ip net.IP) (string, error) {
+	return "Hong Kong", nil
+}
+
+func TestEnrichLookup(t *testing.T) {
+	lookupCount := 0
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookupCount++
+		return []string{"host.example.com."}, nil
+	}
+	defer func() { lookupAddr = net.DefaultResolver.LookupAddr }()
+
+	e := &enricher{
+		reverseDNS: true,
+		geoLocator: mockGeoLocator{},
+		cache:      map[string]*enrichResult{},
+	}
+
+	result := e.lookup("[203.0.113.1]")
+	if result.Hostname != "host.example.com" || result.Country != "HK" || result.City != "Hong Kong" {
+		t.Errorf("Unexpected enrich result: %+v", result)
+	}
+
+	//Second lookup should hit the cache
+	e.lookup("203.0.113.1")
+	if lookupCount != 1 {
+		t.Errorf("Expected 1 reverse DNS lookup, got %d", lookupCount)
+	}
+
+	//Invalid IP should be left unresolved
+	result = e.lookup("not-an-ip")
+	if result.Hostname != "" || result.Country != "" {
+		t.Errorf("Expected empty result for invalid IP, got %+v", result)
+	}
+}
@@ -0,0 +1,186 @@
+package authlogger
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Login Record Enrichment
+
+	Resolve the reverse DNS hostname and the geo location (country / city)
+	of the IP in the login records. The lookups are done in a background
+	worker after the record is written, then the record is updated in place,
+	so the login path is never blocked by slow DNS or database lookups.
+
+	Lookup results are cached by IP. If a lookup failed or is disabled,
+	the record keep only the IP address. The original IP is passed with
+	the job, as the stored one might be anonymized. See ipprivacy.go
+*/
+
+const (
+	enrichQueueSize    = 256
+	enrichCacheSize    = 1024
+	enrichCacheTTL     = time.Hour
+	enrichLookupTimout = 3 * time.Second
+)
+
+// Resolve the country of an IP, e.g. geofilter.MaxMindResolver
+type GeoLocator interface {
+	LookupCountry(ip net.IP) (string, error)
+}
+
+// Optional interface of GeoLocator for resolving the city of an IP
+type CityLocator interface {
+	LookupCity(ip net.IP) (string, error)
+}
+
+// Reverse DNS lookup, replaceable for testing
+var lookupAddr = net.DefaultResolver.LookupAddr
+
+type enrichJob struct {
+	TableName string
+	EntryKey  string
+	IpAddr    string //Original IP of the record, never stored
+}
+
+type enrichResult struct {
+	Hostname string
+	Country  string
+	City     string
+	Expire   time.Time
+}
+
+type enricher struct {
+	reverseDNS bool
+	geoLocator GeoLocator
+	cache      map[string]*enrichResult
+	cacheMutex sync.Mutex
+	queue      chan enrichJob
+	stop       chan bool
+	workerDone sync.WaitGroup
+}
+
+// Enable the enrichment of login records. Set reverseDNS to false and geoLocator to nil to keep only the IP
+func (l *Logger) EnableEnrichment(reverseDNS bool, geoLocator GeoLocator) {
+	if !reverseDNS && geoLocator == nil {
+		return
+	}
+	if l.enricher != nil {
+		l.enricher.close()
+	}
+
+	l.enricher = &enricher{
+		reverseDNS: reverseDNS,
+		geoLocator: geoLocator,
+		cache:      map[string]*enrichResult{},
+		queue:      make(chan enrichJob, enrichQueueSize),
+		stop:       make(chan bool),
+	}
+	l.enricher.workerDone.Add(1)
+	go l.runEnrichWorker(l.enricher)
+}
+
+// Queue the record for enrichment, the record is dropped from enrichment if the queue is full
+func (l *Logger) queueEnrichment(tableName string, entryKey string, ipAddr string) {
+	if l.enricher == nil {
+		return
+	}
+	select {
+	case l.enricher.queue <- enrichJob{TableName: tableName, EntryKey: entryKey, IpAddr: ipAddr}:
+	default:
+	}
+}
+
+func (l *Logger) runEnrichWorker(e *enricher) {
+	defer e.workerDone.Done()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case job := <-e.queue:
+			thisRecord := LoginRecord{}
+			err := l.database.Read(job.TableName, job.EntryKey, &thisRecord)
+			if err != nil || job.IpAddr == "" {
+				continue
+			}
+
+			result := e.lookup(job.IpAddr)
+			hostname := result.Hostname
+			if thisRecord.IpMode != "" {
+				//Hostname identify the client as much as the IP
+				hostname = ""
+			}
+			if hostname == "" && result.Country == "" && result.City == "" {
+				continue
+			}
+			thisRecord.Hostname = hostname
+			thisRecord.Country = result.Country
+			thisRecord.City = result.City
+			l.database.Write(job.TableName, job.EntryKey, thisRecord)
+		}
+	}
+}
+
+// Lookup the hostname and geo location of the IP, use cached result if available
+func (e *enricher) lookup(ipAddr string) *enrichResult {
+	ipAddr = strings.Trim(ipAddr, "[]")
+	now := time.Now()
+
+	e.cacheMutex.Lock()
+	cached, ok := e.cache[ipAddr]
+	e.cacheMutex.Unlock()
+	if ok && cached.Expire.After(now) {
+		return cached
+	}
+
+	result := &enrichResult{Expire: now.Add(enrichCacheTTL)}
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return result
+	}
+
+	if e.reverseDNS {
+		ctx, cancel := context.WithTimeout(context.Background(), enrichLookupTimout)
+		hostnames, err := lookupAddr(ctx, ipAddr)
+		cancel()
+		if err == nil && len(hostnames) > 0 {
+			result.Hostname = strings.TrimSuffix(hostnames[0], ".")
+		}
+	}
+
+	if e.geoLocator != nil {
+		if country, err := e.geoLocator.LookupCountry(ip); err == nil {
+			result.Country = country
+		}
+		if cityLocator, ok := e.geoLocator.(CityLocator); ok {
+			if city, err := cityLocator.LookupCity(ip); err == nil {
+				result.City = city
+			}
+		}
+	}
+
+	e.cacheMutex.Lock()
+	if len(e.cache) >= enrichCacheSize {
+		for cachedIP, cachedResult := range e.cache {
+			if !cachedResult.Expire.After(now) {
+				delete(e.cache, cachedIP)
+			}
+		}
+		if len(e.cache) >= enrichCacheSize {
+			e.cache = map[string]*enrichResult{}
+		}
+	}
+	e.cache[ipAddr] = result
+	e.cacheMutex.Unlock()
+	return result
+}
+
+// Stop the enrichment worker, pending records are left with IP only
+func (e *enricher) close() {
+	close(e.stop)
+	e.workerDone.Wait()
+}
@@ -0,0 +1,175 @@
+package authlogger
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Login Record Export
+
+	Export the full login history for offline analysis (e.g. SIEM), in CSV
+	or JSON with optional time range. Records are written to the response
+	as they are read, one month table at a time, so the export of a long
+	history never hold more than a month of records in memory.
+
+	The enrichment fields (hostname, country and city) are included as
+	stored, empty if the enrichment is disabled or not finished yet.
+*/
+
+var exportCSVHeader = []string{"Time", "Timestamp", "Username", "Succeed", "IP Address", "IP Mode", "Port", "Auth Type", "Hostname", "Country", "City"}
+
+// Call handleRecord with each record between startTime and endTime (unix seconds, 0 for no bound) in chronological order.
+// Stop and return the error if handleRecord returns one
+func (l *Logger) ExportRecords(startTime int64, endTime int64, handleRecord func(LoginRecord) error) error {
+	type monthTable struct {
+		name  string
+		start time.Time
+	}
+	tables := []monthTable{}
+	for _, tableName := range l.ListSummary() {
+		monthStart, err := time.Parse("Jan-2006", tableName)
+		if err != nil {
+			continue
+		}
+		//Tables are named by the UTC month of the records
+		monthEnd := monthStart.AddDate(0, 1, 0)
+		if (startTime > 0 && monthEnd.Unix() <= startTime) || (endTime > 0 && monthStart.Unix() > endTime) {
+			continue
+		}
+		tables = append(tables, monthTable{name: tableName, start: monthStart})
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].start.Before(tables[j].start)
+	})
+
+	for _, table := range tables {
+		records, err := l.ListRecords(table.name)
+		if err != nil {
+			continue
+		}
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].Timestamp < records[j].Timestamp
+		})
+		for _, record := range records {
+			if (startTime > 0 && record.Timestamp < startTime) || (endTime > 0 && record.Timestamp > endTime) {
+				continue
+			}
+			if err := handleRecord(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Parse the time range bound given as unix seconds or date (YYYY-MM-DD, UTC). The end date include the whole day
+func parseExportTime(value string, isEnd bool) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if unixTime, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unixTime, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, errors.New("invalid time given: " + value)
+	}
+	if isEnd {
+		return date.AddDate(0, 0, 1).Unix() - 1, nil
+	}
+	return date.Unix(), nil
+}
+
+// Escape the values that spreadsheet programs would run as formula
+func escapeCSVField(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+	return value
+}
+
+// Handle export of the login records. Accept GET format (json / csv, default json),
+// start and end (unix seconds or YYYY-MM-DD, optional)
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (l *Logger) HandleExport(w http.ResponseWriter, r *http.Request) {
+	format, _ := utils.GetPara(r, "format")
+	startString, _ := utils.GetPara(r, "start")
+	endString, _ := utils.GetPara(r, "end")
+	startTime, err := parseExportTime(startString, false)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	endTime, err := parseExportTime(endString, true)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	//Send the records of each month to client as soon as they are written
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	filename := "authlog_" + time.Now().UTC().Format("2006-01-02")
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".csv\"")
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Write(exportCSVHeader)
+		count := 0
+		l.ExportRecords(startTime, endTime, func(record LoginRecord) error {
+			csvWriter.Write([]string{
+				time.Unix(record.Timestamp, 0).UTC().Format(time.RFC3339),
+				strconv.FormatInt(record.Timestamp, 10),
+				escapeCSVField(record.TargetUsername),
+				strconv.FormatBool(record.LoginSucceed),
+				record.IpAddr,
+				record.IpMode,
+				strconv.Itoa(record.Port),
+				escapeCSVField(record.AuthType),
+				escapeCSVField(record.Hostname),
+				record.Country,
+				escapeCSVField(record.City),
+			})
+			count++
+			if count%1000 == 0 {
+				csvWriter.Flush()
+				flush()
+			}
+			return csvWriter.Error()
+		})
+		csvWriter.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".json\"")
+	w.Write([]byte("["))
+	count := 0
+	l.ExportRecords(startTime, endTime, func(record LoginRecord) error {
+		js, _ := json.Marshal(record)
+		if count > 0 {
+			js = append([]byte(","), js...)
+		}
+		count++
+		if count%1000 == 0 {
+			flush()
+		}
+		_, err := w.Write(js)
+		return err
+	})
+	w.Write([]byte("]"))
+}
@@ -1,9 +1,11 @@
 package authlogger
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -90,3 +92,42 @@ func TestHandleTableListing(t *testing.T) {
 		t.Errorf("HandleTableListing returned unexpected body: got %v want %v", rr.Body.String(), expectedBody)
 	}
 }
+
+func TestHandleExport(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+
+	logger, err := NewLogger()
+	if err != nil {
+		t.Fatalf("Failed to create a new logger: %v", err)
+	}
+	defer logger.Close()
+
+	tt := time.Now().Unix()
+	logger.LogAuthByRequestInfo("=cmd", "192.168.1.1:8080", tt, false, "custom")
+	logger.LogAuthByRequestInfo("testUser", "192.168.1.2:8080", tt, true, "custom")
+
+	rr := httptest.NewRecorder()
+	logger.HandleExport(rr, httptest.NewRequest("GET", "/export?format=csv", nil))
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Time,Timestamp,Username") {
+		t.Fatalf("Expected header and 2 records in CSV, got %q", rr.Body.String())
+	}
+	if !strings.Contains(lines[1], ",'=cmd,false,192.168.1.1,") {
+		t.Errorf("Expected formula in username to be escaped, got %q", lines[1])
+	}
+
+	//Time range filter
+	rr = httptest.NewRecorder()
+	logger.HandleExport(rr, httptest.NewRequest("GET", "/export?start="+fmt.Sprint(tt+3600), nil))
+	if rr.Body.String() != "[]" {
+		t.Errorf("Expected no records after the range start, got %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	logger.HandleExport(rr, httptest.NewRequest("GET", "/export?end="+time.Now().UTC().Format("2006-01-02"), nil))
+	records := []LoginRecord{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &records); err != nil || len(records) != 2 || records[1].TargetUsername != "testUser" {
+		t.Errorf("Expected 2 records in JSON export, got %q", rr.Body.String())
+	}
+}
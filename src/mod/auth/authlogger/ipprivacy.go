@@ -0,0 +1,143 @@
+package authlogger
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"imuslab.com/arozos/mod/database"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	IP Address Privacy
+
+	Choose how the client IP is stored in the login records
+
+	full => the IP address as is (default)
+	truncated => the /24 (IPv4) or /48 (IPv6) network of the IP, e.g. 192.168.1.0/24
+	hashed => salted hash of the IP, e.g. hash:1a2b..., same IP always get the same hash
+		so repeated attempts from an IP can still be traced
+
+	The salt is generated once per install. Enrichment (geo location) is done
+	on the original IP before it is dropped, reverse DNS hostname is only kept
+	in full mode. The settings are stored in the system database as
+
+	auth_logger/ipmode => IP mode
+	auth_logger/ipsalt => hex encoded salt
+*/
+
+const (
+	IPModeFull      = "full"
+	IPModeTruncated = "truncated"
+	IPModeHashed    = "hashed"
+)
+
+// Prefix of the hashed IP addresses
+const hashedIPPrefix = "hash:"
+
+// Load the IP mode and salt from the system database, generate the salt if not exists
+func (l *Logger) LoadIPModeSettings(sysdb *database.Database) error {
+	sysdb.NewTable("auth_logger")
+	l.settingsdb = sysdb
+
+	salt := ""
+	if sysdb.KeyExists("auth_logger", "ipsalt") {
+		sysdb.Read("auth_logger", "ipsalt", &salt)
+	}
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil || len(saltBytes) == 0 {
+		saltBytes = make([]byte, 32)
+		if _, err := rand.Read(saltBytes); err != nil {
+			return err
+		}
+		err = sysdb.Write("auth_logger", "ipsalt", hex.EncodeToString(saltBytes))
+		if err != nil {
+			return err
+		}
+	}
+	l.ipSalt = saltBytes
+
+	mode := IPModeFull
+	if sysdb.KeyExists("auth_logger", "ipmode") {
+		sysdb.Read("auth_logger", "ipmode", &mode)
+	}
+	if !isValidIPMode(mode) {
+		mode = IPModeFull
+	}
+	l.ipMode = mode
+	return nil
+}
+
+// Get the current IP mode
+func (l *Logger) GetIPMode() string {
+	if l.ipMode == "" {
+		return IPModeFull
+	}
+	return l.ipMode
+}
+
+// Set and save the IP mode of the new login records
+func (l *Logger) SetIPMode(mode string) error {
+	if !isValidIPMode(mode) {
+		return errors.New("invalid IP mode")
+	}
+	if mode == IPModeHashed && len(l.ipSalt) == 0 {
+		return errors.New("IP hashing salt not loaded")
+	}
+	l.ipMode = mode
+	if l.settingsdb != nil {
+		return l.settingsdb.Write("auth_logger", "ipmode", mode)
+	}
+	return nil
+}
+
+// Convert the IP into the form stored in the login records under the current IP mode
+func (l *Logger) AnonymizeIP(ipAddr string) string {
+	ip := net.ParseIP(strings.Trim(ipAddr, "[]"))
+	if ip == nil {
+		//Not an IP, e.g. unknown
+		return ipAddr
+	}
+
+	switch l.GetIPMode() {
+	case IPModeTruncated:
+		if ipv4 := ip.To4(); ipv4 != nil {
+			return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+	case IPModeHashed:
+		mac := hmac.New(sha256.New, l.ipSalt)
+		mac.Write([]byte(ip.String()))
+		return hashedIPPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return ipAddr
+}
+
+func isValidIPMode(mode string) bool {
+	return mode == IPModeFull || mode == IPModeTruncated || mode == IPModeHashed
+}
+
+// Handle the IP mode settings. Leave mode empty for reading the current settings
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (l *Logger) HandleIPModeSettings(w http.ResponseWriter, r *http.Request) {
+	mode, err := utils.PostPara(r, "mode")
+	if err != nil {
+		js, _ := json.Marshal(l.GetIPMode())
+		utils.SendJSONResponse(w, string(js))
+		return
+	}
+
+	err = l.SetIPMode(mode)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	utils.SendOK(w)
+}
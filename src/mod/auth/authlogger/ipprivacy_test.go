@@ -0,0 +1,67 @@
+package authlogger
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"imuslab.com/arozos/mod/database"
+)
+
+func TestIPMode(t *testing.T) {
+	teardownSuite := setupSuite(t)
+	defer teardownSuite(t)
+
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "sys.db"), false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer sysdb.Close()
+
+	logger, err := NewLogger()
+	if err != nil {
+		t.Fatalf("Failed to create a new logger: %v", err)
+	}
+	defer logger.Close()
+	if err := logger.LoadIPModeSettings(sysdb); err != nil {
+		t.Fatalf("Failed to load IP mode settings: %v", err)
+	}
+	if logger.GetIPMode() != IPModeFull || logger.AnonymizeIP("192.168.1.23") != "192.168.1.23" {
+		t.Fatal("Expected full IP mode by default")
+	}
+
+	if err := logger.SetIPMode("scrambled"); err == nil {
+		t.Error("Expected invalid IP mode to be rejected")
+	}
+
+	logger.SetIPMode(IPModeTruncated)
+	if ip := logger.AnonymizeIP("192.168.1.23"); ip != "192.168.1.0/24" {
+		t.Errorf("Unexpected truncated IPv4: %s", ip)
+	}
+	if ip := logger.AnonymizeIP("[2001:db8:abcd:12::1]"); ip != "2001:db8:abcd::/48" {
+		t.Errorf("Unexpected truncated IPv6: %s", ip)
+	}
+
+	//Same IP always get the same hash for tracing repeated attempts
+	logger.SetIPMode(IPModeHashed)
+	hashed := logger.AnonymizeIP("192.168.1.23")
+	if !strings.HasPrefix(hashed, hashedIPPrefix) || strings.Contains(hashed, "192.168") {
+		t.Fatalf("Unexpected hashed IP: %s", hashed)
+	}
+	if logger.AnonymizeIP("192.168.1.23") != hashed || logger.AnonymizeIP("192.168.1.24") == hashed {
+		t.Error("Expected stable and distinct IP hashes")
+	}
+
+	logger.LogAuthByRequestInfo("testUser", "192.168.1.23:8080", 0, false, "web")
+	records, _ := logger.ListRecords(logger.ListSummary()[0])
+	if len(records) != 1 || records[0].IpAddr != hashed || records[0].IpMode != IPModeHashed {
+		t.Errorf("Unexpected login record: %+v", records)
+	}
+
+	//Mode and salt persist across restarts
+	reloaded := &Logger{}
+	reloaded.LoadIPModeSettings(sysdb)
+	if reloaded.GetIPMode() != IPModeHashed || reloaded.AnonymizeIP("192.168.1.23") != hashed {
+		t.Error("Expected IP mode and salt to be persisted")
+	}
+}
@@ -6,60 +6,122 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gorilla/sessions"
 	uuid "github.com/satori/go.uuid"
 	"imuslab.com/arozos/mod/utils"
 )
 
-// Autologin token. This token will not expire until admin removal
+/*
+	Autologin Tokens
+
+	Autologin tokens let machines like billboards login on boot via
+	/api/auth/login?token={token}. Each token has an expiry time (0 for
+	never expire) and can be revoked individually by its ID, so a token
+	issued to a lost device can be removed without affecting the others.
+	Expired tokens are rejected on login and removed by the nightly task.
+
+	auth/altoken/{token} => AutoLoginToken, or the owner username for tokens created before expiry support
+*/
+
 type AutoLoginToken struct {
-	Owner string
-	Token string
+	ID           string //ID of this token for listing and revoking
+	Owner        string //Username of the token owner
+	Token        string `json:"-"`
+	CreationTime int64  //Creation time of this token
+	ExpireTime   int64  //Expire time of this token, 0 = never expire
+	LastUsed     int64  //Last time this token is used to login, 0 if never used
+}
+
+// Check if the token is expired at the given unix time
+func (t *AutoLoginToken) IsExpired(now int64) bool {
+	return t.ExpireTime > 0 && t.ExpireTime < now
 }
 
+// Create a new autologin token for the user with the default lifetime
 func (a *AuthAgent) NewAutologinToken(username string) string {
+	return a.NewAutologinTokenWithTTL(username, a.AutoLoginTokenTTL)
+}
+
+// Create a new autologin token for the user that expire after ttl seconds, 0 = never expire
+func (a *AuthAgent) NewAutologinTokenWithTTL(username string, ttl int64) string {
 	//Generate a new token
-	newTokenUUID := uuid.NewV4().String() + "-" + strconv.Itoa(int(time.Now().Unix()))
-	a.autoLoginTokens = append(a.autoLoginTokens, &AutoLoginToken{
-		Owner: username,
-		Token: newTokenUUID,
-	})
+	now := time.Now().Unix()
+	newTokenUUID := uuid.NewV4().String() + "-" + strconv.Itoa(int(now))
+	newToken := AutoLoginToken{
+		ID:           uuid.NewV4().String(),
+		Owner:        username,
+		Token:        newTokenUUID,
+		CreationTime: now,
+	}
+	if ttl > 0 {
+		newToken.ExpireTime = now + ttl
+	}
+
+	a.autoLoginMutex.Lock()
+	a.autoLoginTokens = append(a.autoLoginTokens, &newToken)
+	a.autoLoginMutex.Unlock()
 
 	//Save the token to sysdb
-	a.Database.Write("auth", "altoken/"+newTokenUUID, username)
+	a.Database.Write("auth", "altoken/"+newTokenUUID, newToken)
 
 	//Return the new token
 	return newTokenUUID
 }
 
 func (a *AuthAgent) RemoveAutologinToken(token string) {
-	newTokenArray := []*AutoLoginToken{}
-	for _, alt := range a.autoLoginTokens {
-		if alt.Token != token {
-			newTokenArray = append(newTokenArray, alt)
-		} else {
-			//Delete this from the database
-			a.Database.Delete("auth", "altoken/"+alt.Token)
-		}
-	}
-	a.autoLoginTokens = newTokenArray
+	a.removeAutologinTokens(func(alt *AutoLoginToken) bool {
+		return alt.Token == token
+	})
 }
 
 func (a *AuthAgent) RemoveAutologinTokenByUsername(username string) {
+	a.removeAutologinTokens(func(alt *AutoLoginToken) bool {
+		return alt.Owner == username
+	})
+}
+
+// Revoke an autologin token of the user by its ID
+func (a *AuthAgent) RevokeAutologinToken(username string, tokenID string) error {
+	if a.removeAutologinTokens(func(alt *AutoLoginToken) bool {
+		return alt.Owner == username && alt.ID == tokenID
+	}) == 0 {
+		return errors.New("autologin token not found")
+	}
+	return nil
+}
+
+// Remove the autologin tokens that are expired
+func (a *AuthAgent) RemoveExpiredAutologinTokens() {
+	now := time.Now().Unix()
+	removedCount := a.removeAutologinTokens(func(alt *AutoLoginToken) bool {
+		return alt.IsExpired(now)
+	})
+	if removedCount > 0 {
+		log.Println("[System Auth] Removed " + strconv.Itoa(removedCount) + " expired autologin tokens")
+	}
+}
+
+// Remove the tokens matching the filter from memory and database, return the number of removed tokens
+func (a *AuthAgent) removeAutologinTokens(filter func(alt *AutoLoginToken) bool) int {
+	a.autoLoginMutex.Lock()
+	defer a.autoLoginMutex.Unlock()
 	newTokenArray := []*AutoLoginToken{}
+	removedCount := 0
 	for _, alt := range a.autoLoginTokens {
-		if alt.Owner != username {
+		if !filter(alt) {
 			newTokenArray = append(newTokenArray, alt)
 		} else {
 			//Delete this from the database
 			a.Database.Delete("auth", "altoken/"+alt.Token)
+			removedCount++
 		}
 	}
 	a.autoLoginTokens = newTokenArray
+	return removedCount
 }
 
 func (a *AuthAgent) LoadAutologinTokenFromDB() error {
@@ -67,25 +129,43 @@ func (a *AuthAgent) LoadAutologinTokenFromDB() error {
 	if err != nil {
 		return err
 	}
+	a.autoLoginMutex.Lock()
+	defer a.autoLoginMutex.Unlock()
 	for _, keypairs := range entries {
-		if strings.Contains(string(keypairs[0]), "altoken/") {
-			key := string(keypairs[0])
-			owner := ""
-			json.Unmarshal(keypairs[1], &owner)
-			token := strings.Split(key, "/")[1]
-			a.autoLoginTokens = append(a.autoLoginTokens, &AutoLoginToken{
-				Owner: owner,
-				Token: token,
-			})
+		if strings.HasPrefix(string(keypairs[0]), "altoken/") {
+			token := strings.TrimPrefix(string(keypairs[0]), "altoken/")
+			thisToken := AutoLoginToken{}
+			if json.Unmarshal(keypairs[1], &thisToken) != nil {
+				//Token created before expiry support, upgrade it to a never expiring token
+				owner := ""
+				json.Unmarshal(keypairs[1], &owner)
+				thisToken = AutoLoginToken{
+					ID:    uuid.NewV4().String(),
+					Owner: owner,
+				}
+				//The creation time is appended to the token
+				if idx := strings.LastIndex(token, "-"); idx >= 0 {
+					thisToken.CreationTime, _ = strconv.ParseInt(token[idx+1:], 10, 64)
+				}
+				a.Database.Write("auth", "altoken/"+token, thisToken)
+			}
+			thisToken.Token = token
+			a.autoLoginTokens = append(a.autoLoginTokens, &thisToken)
 		}
 	}
 
 	return nil
 }
 
+// Get the owner of the token, expired tokens are rejected
 func (a *AuthAgent) GetUsernameFromToken(token string) (string, error) {
+	a.autoLoginMutex.Lock()
+	defer a.autoLoginMutex.Unlock()
 	for _, alt := range a.autoLoginTokens {
 		if alt.Token == token {
+			if alt.IsExpired(time.Now().Unix()) {
+				return "", errors.New("Token expired")
+			}
 			return alt.Owner, nil
 		}
 	}
@@ -93,16 +173,37 @@ func (a *AuthAgent) GetUsernameFromToken(token string) (string, error) {
 	return "", errors.New("Invalid Token")
 }
 
+// Get the tokens of the user sorted by creation time, set username to empty string to list all
 func (a *AuthAgent) GetTokensFromUsername(username string) []*AutoLoginToken {
+	a.autoLoginMutex.Lock()
 	results := []*AutoLoginToken{}
 	for _, alt := range a.autoLoginTokens {
-		if alt.Owner == username {
-			results = append(results, alt)
+		if username == "" || alt.Owner == username {
+			thisToken := *alt
+			results = append(results, &thisToken)
 		}
 	}
+	a.autoLoginMutex.Unlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreationTime < results[j].CreationTime
+	})
 	return results
 }
 
+// Update the last used time of the token
+func (a *AuthAgent) touchAutologinToken(token string) {
+	a.autoLoginMutex.Lock()
+	defer a.autoLoginMutex.Unlock()
+	for _, alt := range a.autoLoginTokens {
+		if alt.Token == token {
+			alt.LastUsed = time.Now().Unix()
+			a.Database.Write("auth", "altoken/"+alt.Token, alt)
+			return
+		}
+	}
+}
+
 func (a *AuthAgent) HandleAutologinTokenLogin(w http.ResponseWriter, r *http.Request) {
 	//Get the authentication token from the request
 	if a.AllowAutoLogin == false {
@@ -112,7 +213,7 @@ func (a *AuthAgent) HandleAutologinTokenLogin(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	session, _ := a.SessionStore.Get(r, a.SessionName)
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
 	token, err := utils.GetPara(r, "token")
 	if err != nil {
 		//Username not defined
@@ -135,6 +236,29 @@ func (a *AuthAgent) HandleAutologinTokenLogin(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	//Check if autologin is forbidden by the user's group policy or the account is disabled or locked
+	if !a.UserAllowAutoLogin(username) || a.UserIsDisabled(username) || a.UserIsLocked(username) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - Forbidden"))
+		log.Println("[System Auth] Autologin of " + username + " rejected by group policy, disabled or locked account")
+		return
+	}
+
+	//Check if the user groups allow login at this time
+	if ok, reason := a.ValidateLoginTimeWindow(username); !ok {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - " + reason.Error()))
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "Autologin: "+reason.Error())
+		return
+	}
+
+	//Only administrators can login during maintenance
+	if a.MaintenanceBlocksUser(username) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 - " + a.GetMaintenanceMessage()))
+		return
+	}
+
 	//Check if the current client has already logged in another account
 	currentlyLoggedUsername, err := a.GetUserName(w, r)
 	if err == nil && currentlyLoggedUsername != username {
@@ -150,21 +274,26 @@ func (a *AuthAgent) HandleAutologinTokenLogin(w http.ResponseWriter, r *http.Req
 	}
 
 	//Ok. Allow this client to login
+	if previousSessionID, ok := session.Values["sessionid"].(string); ok {
+		a.RevokeSession(previousSessionID)
+	}
+	sessionRecord := a.newSessionRecord(username, r, 3600*1)
 	session.Values["authenticated"] = true
 	session.Values["username"] = username
 	session.Values["rememberMe"] = false
+	session.Values["sessionid"] = sessionRecord.ID
+	a.flagPasswordChange(username, session.Values)
 
+	a.touchAutologinToken(token)
 	log.Println(username + " logged in via auto-login token")
 
-	//Check if remember me is clicked. If yes, set the maxage to 1 week.
-	session.Options = &sessions.Options{
-		MaxAge: 3600 * 1, //1 hour
-		Path:   "/",
-	}
+	session.Options = a.sessionCookieOptions(r, 3600*1) //1 hour
 
 	session.Save(r, w)
+	a.enforceSessionLimit(username, sessionRecord.ID)
 
 	redirectTarget, _ := utils.GetPara(r, "redirect")
+	redirectTarget = a.SanitizeRedirectTarget(r, redirectTarget)
 	if redirectTarget != "" {
 		//Redirect to target website
 		http.Redirect(w, r, redirectTarget, http.StatusTemporaryRedirect)
@@ -187,3 +316,43 @@ func (a *AuthAgent) ValidateAutoLoginToken(token string) (bool, string) {
 	//Token is valid
 	return true, username
 }
+
+/*
+	Autologin Token Handlers
+*/
+
+// Handle listing of the current user's autologin tokens. The tokens are not shown, only their ID and times
+func (a *AuthAgent) HandleListAutologinTokens(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	js, _ := json.Marshal(a.GetTokensFromUsername(username))
+	sendJSONResponse(w, string(js))
+}
+
+// Handle revoking of the current user's autologin token. Require POST id
+func (a *AuthAgent) HandleRevokeAutologinToken(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	tokenID, err := utils.PostPara(r, "id")
+	if err != nil {
+		sendErrorResponse(w, "Invalid token id given")
+		return
+	}
+
+	err = a.RevokeAutologinToken(username, tokenID)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] " + username + " revoked autologin token " + tokenID)
+	sendOK(w)
+}
@@ -3,6 +3,7 @@ package autologin
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	user "imuslab.com/arozos/mod/user"
 	"imuslab.com/arozos/mod/utils"
@@ -40,7 +41,7 @@ func (a *AutoLoginHandler) HandleUserTokensListing(w http.ResponseWriter, r *htt
 	utils.SendJSONResponse(w, string(jsonString))
 }
 
-//Handle User Token Creation, require username. Please use adminrouter to handle this function
+//Handle User Token Creation, require username and optional ttl in seconds. Please use adminrouter to handle this function
 func (a *AutoLoginHandler) HandleUserTokenCreation(w http.ResponseWriter, r *http.Request) {
 	username, err := utils.GetPara(r, "username")
 	if err != nil {
@@ -55,8 +56,19 @@ func (a *AutoLoginHandler) HandleUserTokenCreation(w http.ResponseWriter, r *htt
 		return
 	}
 
+	//Use the default lifetime if ttl is not given, 0 = never expire
+	ttl := authAgent.AutoLoginTokenTTL
+	ttlString, err := utils.GetPara(r, "ttl")
+	if err == nil {
+		ttl, err = strconv.ParseInt(ttlString, 10, 64)
+		if err != nil || ttl < 0 {
+			utils.SendErrorResponse(w, "Invalid ttl")
+			return
+		}
+	}
+
 	//Generate and send the token to client
-	token := authAgent.NewAutologinToken(username)
+	token := authAgent.NewAutologinTokenWithTTL(username, ttl)
 	jsonString, _ := json.Marshal(token)
 	utils.SendJSONResponse(w, string(jsonString))
 }
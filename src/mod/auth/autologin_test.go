@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutologinTokenExpiry(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+	a.AutoLoginTokenTTL = 3600
+
+	//Token created before expiry support is stored with the owner only
+	a.Database.Write("auth", "altoken/legacy-1600000000", "alice")
+	if err := a.LoadAutologinTokenFromDB(); err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	tokens := a.GetTokensFromUsername("alice")
+	if len(tokens) != 1 || tokens[0].ID == "" || tokens[0].CreationTime != 1600000000 || tokens[0].ExpireTime != 0 {
+		t.Fatalf("Legacy token not upgraded: %+v", tokens)
+	}
+
+	kiosk := a.NewAutologinToken("alice")
+	expired := a.NewAutologinTokenWithTTL("alice", 1)
+	if username, err := a.GetUsernameFromToken(kiosk); err != nil || username != "alice" {
+		t.Fatalf("Valid token rejected: %v", err)
+	}
+
+	//Expire the second token
+	a.autoLoginMutex.Lock()
+	for _, alt := range a.autoLoginTokens {
+		if alt.Token == expired {
+			alt.ExpireTime = time.Now().Unix() - 1
+		}
+	}
+	a.autoLoginMutex.Unlock()
+	if _, err := a.GetUsernameFromToken(expired); err == nil {
+		t.Fatal("Expired token accepted")
+	}
+	a.RemoveExpiredAutologinTokens()
+	if len(a.GetTokensFromUsername("alice")) != 2 || a.Database.KeyExists("auth", "altoken/"+expired) {
+		t.Fatal("Expired token not removed")
+	}
+
+	//Revoke a single token by ID, only by its owner
+	var kioskID string
+	for _, thisToken := range a.GetTokensFromUsername("alice") {
+		if thisToken.ExpireTime > 0 {
+			kioskID = thisToken.ID
+		}
+	}
+	if a.RevokeAutologinToken("bob", kioskID) == nil {
+		t.Fatal("Token revoked by another user")
+	}
+	if err := a.RevokeAutologinToken("alice", kioskID); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if _, err := a.GetUsernameFromToken(kiosk); err == nil {
+		t.Fatal("Revoked token accepted")
+	}
+
+	//Reload keeps the metadata of the remaining token
+	reloaded := &AuthAgent{Database: a.Database}
+	reloaded.LoadAutologinTokenFromDB()
+	tokens = reloaded.GetTokensFromUsername("alice")
+	if len(tokens) != 1 || tokens[0].ID != a.GetTokensFromUsername("alice")[0].ID {
+		t.Fatalf("Token metadata not persisted: %+v", tokens)
+	}
+}
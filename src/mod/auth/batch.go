@@ -10,10 +10,14 @@ package auth
 */
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"imuslab.com/arozos/mod/utils"
 )
@@ -23,37 +27,61 @@ import (
 
 	This function allow mass import of user accounts for organization purpses.
 	Must be in the format of:{ username, default password, default group } format.
-	Each user occupied one new line
+	Each user occupied one new line. Multiple groups can be given by seperating them with ";"
+	An optional fourth column sets the email of the user, which must not be used by other accounts.
+
+	Set POST dryrun=true to validate the csv without creating any account.
+	A per-row report (see CSVImportRow) is returned in dry run mode.
 */
 func (a *AuthAgent) HandleCreateUserAccountsFromCSV(w http.ResponseWriter, r *http.Request) {
+	if !limitRequestBody(w, r, a.getMaxCSVImportBodySize()) {
+		return
+	}
 	csvContent, err := utils.PostPara(r, "csv")
 	if err != nil {
 		sendErrorResponse(w, "Invalid csv")
 		return
 	}
 
-	//Process the csv and create user accounts
-	newusers := [][]string{}
-	csvContent = strings.ReplaceAll(csvContent, "\r\n", "\n")
-	lines := strings.Split(csvContent, "\n")
-	for _, line := range lines {
-		data := strings.Split(line, ",")
-		if len(data) >= 3 {
-			newusers = append(newusers, data)
-		}
+	//Process the csv and check what will happen to each row
+	report := a.PlanCSVImport(csvContent)
+
+	dryrun, _ := utils.PostPara(r, "dryrun")
+	if dryrun == "true" {
+		js, _ := json.Marshal(report)
+		sendJSONResponse(w, string(js))
+		return
 	}
 
 	errors := []string{}
 
 	//Ok. Add the valid users to the system
-	for _, userCreationSetting := range newusers {
-		//Check if this user already exists
-		if a.UserExists(userCreationSetting[0]) {
-			errors = append(errors, "User "+userCreationSetting[0]+" already exists! Skipping.")
+	for _, row := range report {
+		if row.Action != CSVImportActionCreate {
+			if row.Username != "" {
+				errors = append(errors, "User "+row.Username+": "+strings.Join(row.Errors, ", ")+". Skipping.")
+				a.LogAuditEventByRequest(r, AuditActionCSVImport, row.Username, false, strings.Join(row.Errors, ", "))
+			}
 			continue
 		}
 
-		a.CreateUserAccount(userCreationSetting[0], userCreationSetting[1], []string{userCreationSetting[2]})
+		err = a.CreateUserAccount(row.Username, row.password, row.Groups)
+		if err != nil {
+			errors = append(errors, "User "+row.Username+": "+err.Error()+". Skipping.")
+			a.LogAuditEventByRequest(r, AuditActionCSVImport, row.Username, false, err.Error())
+			continue
+		}
+		if row.Email != "" {
+			err = a.StoreUserEmail(row.Username, row.Email)
+			if err != nil {
+				//Email taken in the meantime. Rollback the account
+				a.UnregisterUser(row.Username)
+				errors = append(errors, "User "+row.Username+": "+err.Error()+". Skipping.")
+				a.LogAuditEventByRequest(r, AuditActionCSVImport, row.Username, false, err.Error())
+				continue
+			}
+		}
+		a.LogAuditEventByRequest(r, AuditActionCSVImport, row.Username, true, "group: "+strings.Join(row.Groups, ";"))
 	}
 
 	js, _ := json.Marshal(errors)
@@ -61,6 +89,154 @@ func (a *AuthAgent) HandleCreateUserAccountsFromCSV(w http.ResponseWriter, r *ht
 
 }
 
+const (
+	CSVImportActionCreate = "create"
+	CSVImportActionSkip   = "skip"
+)
+
+type CSVImportRow struct {
+	Line     int      //Line number in the csv, starting from 1
+	Username string   //Username of the new account
+	Groups   []string //Permission groups of the new account
+	Email    string   //Email of the new account, empty if not given
+	Action   string   //create or skip
+	Errors   []string //Reasons of skipping this row
+	password string
+}
+
+// Parse and validate the csv for import, return what will happen to each row without creating any account
+func (a *AuthAgent) PlanCSVImport(csvContent string) []*CSVImportRow {
+	report := []*CSVImportRow{}
+	seenUsernames := map[string]int{}
+	seenEmails := map[string]int{}
+
+	csvContent = strings.ReplaceAll(csvContent, "\r\n", "\n")
+	lines := strings.Split(csvContent, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		data := strings.Split(line, ",")
+		if i == 0 && strings.EqualFold(strings.TrimSpace(data[0]), "username") {
+			//Header line
+			continue
+		}
+
+		row := CSVImportRow{
+			Line:     i + 1,
+			Username: a.NormalizeUsername(data[0]),
+			Groups:   []string{},
+			Errors:   []string{},
+		}
+		report = append(report, &row)
+		if len(data) < 3 {
+			row.Errors = append(row.Errors, "expect username, password and group columns")
+		} else {
+			row.password = data[1]
+			row.Groups = strings.Split(strings.TrimSpace(data[2]), ";")
+		}
+		if len(data) >= 4 {
+			row.Email = strings.TrimSpace(data[3])
+		}
+
+		//Check the username
+		if row.Username == "" {
+			row.Errors = append(row.Errors, "username is empty")
+		} else if firstLine, ok := seenUsernames[row.Username]; ok {
+			row.Errors = append(row.Errors, "duplicated username with line "+strconv.Itoa(firstLine))
+		} else if a.UserExists(row.Username) {
+			row.Errors = append(row.Errors, "user already exists")
+		} else if ok, reason := a.ValidateUsernameWithPolicy(row.Username); !ok {
+			row.Errors = append(row.Errors, reason)
+		}
+		if row.Username != "" {
+			if _, ok := seenUsernames[row.Username]; !ok {
+				seenUsernames[row.Username] = row.Line
+			}
+		}
+
+		if len(data) >= 3 {
+			//Check the groups
+			for _, group := range row.Groups {
+				if group == "" || (a.GroupExists != nil && !a.GroupExists(group)) {
+					row.Errors = append(row.Errors, "invalid group: "+group)
+				}
+			}
+
+			//Check the password policy
+			if ok, reason := a.ValidatePasswordWithPolicy(row.password); !ok {
+				row.Errors = append(row.Errors, reason)
+			}
+		}
+
+		//Check the email
+		if row.Email != "" {
+			normalizedEmail := strings.ToLower(row.Email)
+			if a.CheckUserEmail == nil || a.StoreUserEmail == nil {
+				row.Errors = append(row.Errors, "email is not supported on this host")
+			} else if firstLine, ok := seenEmails[normalizedEmail]; ok {
+				row.Errors = append(row.Errors, "duplicated email with line "+strconv.Itoa(firstLine))
+			} else if err := a.CheckUserEmail(row.Username, row.Email); err != nil {
+				row.Errors = append(row.Errors, err.Error())
+			}
+			if _, ok := seenEmails[normalizedEmail]; !ok {
+				seenEmails[normalizedEmail] = row.Line
+			}
+		}
+
+		row.Action = CSVImportActionCreate
+		if len(row.Errors) > 0 {
+			row.Action = CSVImportActionSkip
+		}
+	}
+
+	return report
+}
+
+/*
+	HandleExportUserAccountsToCSV
+
+	Export the user accounts as csv in the format of
+	{ username, group(s), creation date, last login }
+	Groups are seperated by ";" and dates are in RFC3339 format (empty if unknown).
+	Insert the password column after username to re-import it with HandleCreateUserAccountsFromCSV
+
+	Optional GET paramter: group, only export users in the given group
+*/
+func (a *AuthAgent) HandleExportUserAccountsToCSV(w http.ResponseWriter, r *http.Request) {
+	groupFilter, _ := utils.GetPara(r, "group")
+
+	usernames := a.ListUsers()
+	sort.Strings(usernames)
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"users.csv\"")
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write([]string{"Username", "Group(s)", "Creation Date", "Last Login"})
+	for _, username := range usernames {
+		usergroup := []string{}
+		a.Database.Read("auth", "group/"+username, &usergroup)
+		if groupFilter != "" && !inSlice(usergroup, groupFilter) {
+			continue
+		}
+
+		csvWriter.Write([]string{
+			username,
+			strings.Join(usergroup, ";"),
+			formatCSVTime(a.GetUserCreationTime(username)),
+			formatCSVTime(a.GetUserLastLoginTime(username)),
+		})
+	}
+	csvWriter.Flush()
+}
+
+func formatCSVTime(unixTime int64) string {
+	if unixTime <= 0 {
+		return ""
+	}
+	return time.Unix(unixTime, 0).Format(time.RFC3339)
+}
+
 /*
 	HandleUserDeleteByGroup handles user batch delete request by group name
 	Set exact = true will only delete users which the user is
@@ -81,8 +257,65 @@ func (a *AuthAgent) HandleUserDeleteByGroup(w http.ResponseWriter, r *http.Reque
 		requireExact = false
 	}
 
+	deletePendingUsernames := a.listUsersByGroup(group, requireExact)
+	for _, username := range deletePendingUsernames {
+		err = a.UnregisterUser(username)
+		if err != nil {
+			a.LogAuditEventByRequest(r, AuditActionGroupDelete, username, false, err.Error())
+			continue
+		}
+		a.LogAuditEventByRequest(r, AuditActionGroupDelete, username, true, "group: "+group)
+	}
+
+	sendOK(w)
+
+}
+
+/*
+	HandleUserDisableByGroup handles user batch disable request by group name
+	The accounts are suspended with their files and settings kept. See disable.go
+	Set exact = true will only disable users which the user is
+	1. inside the given group and
+	2. that group is his / her only group
+
+	Require paramter: group, exact
+	Optional paramter: reason
+*/
+func (a *AuthAgent) HandleUserDisableByGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := utils.PostPara(r, "group")
+	if err != nil {
+		sendErrorResponse(w, "Invalid group")
+		return
+	}
+
+	requireExact := true //Default true
+	exact, _ := utils.PostPara(r, "exact")
+	if exact == "false" {
+		requireExact = false
+	}
+	reason, _ := utils.PostPara(r, "reason")
+
+	actor, _ := a.GetUserName(w, r)
+	for _, username := range a.listUsersByGroup(group, requireExact) {
+		if username == actor || a.UserIsDisabled(username) {
+			//Never lock the admin out of their own account
+			continue
+		}
+		err = a.DisableUserAccount(username, actor, reason)
+		if err != nil {
+			a.LogAuditEvent(r, AuditActionDisable, actor, username, false, err.Error())
+			continue
+		}
+		a.LogAuditEvent(r, AuditActionDisable, actor, username, true, "group: "+group)
+	}
+
+	sendOK(w)
+}
+
+// List the users inside the given group. If exact is set, only users with this group as their only group are listed
+func (a *AuthAgent) listUsersByGroup(group string, exact bool) []string {
 	entries, _ := a.Database.ListTable("auth")
-	deletePendingUsernames := []string{}
+	results := []string{}
 
 	for _, keypairs := range entries {
 		if strings.Contains(string(keypairs[0]), "group/") {
@@ -91,25 +324,19 @@ func (a *AuthAgent) HandleUserDeleteByGroup(w http.ResponseWriter, r *http.Reque
 			usergroup := []string{}
 			a.Database.Read("auth", "group/"+username, &usergroup)
 
-			if requireExact {
+			if exact {
 				if len(usergroup) == 1 && usergroup[0] == group {
-					deletePendingUsernames = append(deletePendingUsernames, username)
+					results = append(results, username)
 				}
 			} else {
 				if inSlice(usergroup, group) {
-					deletePendingUsernames = append(deletePendingUsernames, username)
+					results = append(results, username)
 				}
 			}
 		}
 
 	}
-
-	for _, username := range deletePendingUsernames {
-		a.UnregisterUser(username)
-	}
-
-	sendOK(w)
-
+	return results
 }
 
 /*
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlanCSVImport(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+	a.passwordPolicy = PasswordPolicy{MinLength: 8}
+	a.GroupExists = func(group string) bool {
+		return group == "user" || group == "administrator"
+	}
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	csv := "username,password,group\r\n" +
+		"bob,password123,user;administrator\r\n" +
+		"alice,password123,user\r\n" +
+		"carol,short,user\r\n" +
+		"dave,password123,nosuchgroup\r\n" +
+		"bob,password123,user\r\n" +
+		"erin,password123\r\n"
+
+	report := a.PlanCSVImport(csv)
+	expected := []struct {
+		Username string
+		Action   string
+	}{
+		{"bob", CSVImportActionCreate},
+		{"alice", CSVImportActionSkip},
+		{"carol", CSVImportActionSkip},
+		{"dave", CSVImportActionSkip},
+		{"bob", CSVImportActionSkip},
+		{"erin", CSVImportActionSkip},
+	}
+	if len(report) != len(expected) {
+		t.Fatalf("Expected %d rows, got %d", len(expected), len(report))
+	}
+	for i, row := range report {
+		if row.Username != expected[i].Username || row.Action != expected[i].Action {
+			t.Errorf("Row %d: expected %s %s, got %s %s %v", i, expected[i].Username, expected[i].Action, row.Username, row.Action, row.Errors)
+		}
+	}
+	if report[0].Line != 2 || len(report[0].Groups) != 2 {
+		t.Errorf("Unexpected first row: %+v", report[0])
+	}
+
+	//Dry run must not create any account
+	if a.UserExists("bob") {
+		t.Error("Expected no account to be created when planning the import")
+	}
+}
+
+func TestPlanCSVImportEmail(t *testing.T) {
+	//Email directory with alice@example.com taken
+	a := newTestAuthAgent(t, "auth")
+	a.CheckUserEmail = func(username string, email string) error {
+		if strings.EqualFold(email, "alice@example.com") {
+			return errors.New("Email already registered by another account")
+		}
+		return nil
+	}
+	a.StoreUserEmail = func(username string, email string) error { return nil }
+
+	csv := "bob,password123,user,bob@example.com\n" +
+		"carol,password123,user,ALICE@example.com\n" +
+		"dave,password123,user,Bob@Example.com\n" +
+		"erin,password123,user\n"
+	report := a.PlanCSVImport(csv)
+	if report[0].Action != CSVImportActionCreate || report[0].Email != "bob@example.com" {
+		t.Errorf("Expected row with new email created, got %+v", report[0])
+	}
+	if report[1].Action != CSVImportActionSkip || report[2].Action != CSVImportActionSkip {
+		t.Error("Expected rows with used or duplicated emails skipped")
+	}
+	if report[3].Action != CSVImportActionCreate || report[3].Email != "" {
+		t.Errorf("Expected row without email created, got %+v", report[3])
+	}
+
+	//Emails cannot be imported without the email directory
+	a.CheckUserEmail = nil
+	if report := a.PlanCSVImport(csv); report[0].Action != CSVImportActionSkip || report[3].Action != CSVImportActionCreate {
+		t.Error("Expected emails rejected without email directory")
+	}
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+/*
+	Request Body Limit
+
+	Limit the size of the request body of the public login and register
+	endpoints, so a malicious client cannot exhaust the memory with a huge
+	form. The CSV account import is limited separately, as a large user
+	list is a legit use case. Oversized requests are rejected with 413
+	before the parameters are read.
+*/
+
+const (
+	DefaultMaxAuthBodySize      int64 = 64 << 10 //64KB, far larger than any login or register form
+	DefaultMaxCSVImportBodySize int64 = 10 << 20 //10MB
+)
+
+// Limit the request body to maxSize bytes and parse the form. Reply 413 and return false if the body is too large
+func limitRequestBody(w http.ResponseWriter, r *http.Request, maxSize int64) bool {
+	if r.ContentLength > maxSize {
+		sendRequestTooLarge(w)
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	err := r.ParseForm()
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		sendRequestTooLarge(w)
+		return false
+	}
+	return true
+}
+
+func sendRequestTooLarge(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	sendAuthErrorResponse(w, AuthErrRequestTooLarge, "Request body too large")
+}
+
+// Get the max request body size of login and register in bytes
+func (a *AuthAgent) getMaxAuthBodySize() int64 {
+	if a.MaxAuthBodySize <= 0 {
+		return DefaultMaxAuthBodySize
+	}
+	return a.MaxAuthBodySize
+}
+
+// Get the max request body size of the CSV account import in bytes
+func (a *AuthAgent) getMaxCSVImportBodySize() int64 {
+	if a.MaxCSVImportBodySize <= 0 {
+		return DefaultMaxCSVImportBodySize
+	}
+	return a.MaxCSVImportBodySize
+}
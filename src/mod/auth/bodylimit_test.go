@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Reader producing an endless form body without holding it in memory
+type endlessBody struct{}
+
+func (endlessBody) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	a := &AuthAgent{MaxAuthBodySize: 1024, MaxCSVImportBodySize: 4096}
+
+	//Small form is accepted and parsed
+	form := url.Values{"username": {"alice"}, "password": {"secret"}}
+	r := httptest.NewRequest("POST", "/system/auth/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	if !limitRequestBody(w, r, a.getMaxAuthBodySize()) || r.Form.Get("username") != "alice" {
+		t.Fatalf("Expected small form to be accepted, got %d", w.Code)
+	}
+
+	//Declared oversized body is rejected without reading
+	r = httptest.NewRequest("POST", "/system/auth/login", strings.NewReader(strings.Repeat("a", 2048)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	if limitRequestBody(w, r, a.getMaxAuthBodySize()) || w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized body, got %d", w.Code)
+	}
+
+	//Endless body without content length is cut at the limit
+	r = httptest.NewRequest("POST", "/system/auth/register", io.NopCloser(endlessBody{}))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	a.HandleRegister(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for endless register body, got %d", w.Code)
+	}
+
+	//CSV import has its own limit
+	csvForm := url.Values{"csv": {strings.Repeat("a", 2048)}}
+	r = httptest.NewRequest("POST", "/system/auth/csvimport", strings.NewReader(csvForm.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	if !limitRequestBody(w, r, a.getMaxCSVImportBodySize()) {
+		t.Errorf("Expected CSV body within the CSV limit to be accepted, got %d", w.Code)
+	}
+
+	if (&AuthAgent{}).getMaxAuthBodySize() != DefaultMaxAuthBodySize {
+		t.Error("Expected default limit when not configured")
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Admin Bootstrap
+
+	Until at least one administrator exists, accounts can only be created
+	from localhost, or with the one-time setup token printed to the console
+	on startup (X-Setup-Token header or POST setuptoken). This prevent a
+	stranger from claiming the administrator account of an internet exposed
+	fresh install before the operator does. Public registration is closed
+	during bootstrap.
+
+	Requests passed through a reverse proxy are never treated as localhost,
+	even if the proxy runs on the same host, and must present the token.
+
+	The token is kept in memory only and removed once the first administrator
+	is created. After that the normal registration rules apply.
+*/
+
+const setupTokenHeader = "X-Setup-Token"
+
+var ErrBootstrapRequired = errors.New("The administrator account must be created from localhost or with the setup token printed to the console")
+
+// Check if at least one user of an administrator group exists
+func (a *AuthAgent) AdminExists() bool {
+	for _, username := range a.ListUsers() {
+		if a.UserIsAdmin(username) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if the user is in an administrator group
+func (a *AuthAgent) UserIsAdmin(username string) bool {
+	isAdminGroup := a.IsAdminGroup
+	if isAdminGroup == nil {
+		//Permission handler not ready, only the default administrator group is known
+		isAdminGroup = func(group string) bool {
+			return group == "administrator"
+		}
+	}
+
+	usergroups := []string{}
+	a.Database.Read("auth", "group/"+username, &usergroups)
+	for _, group := range usergroups {
+		if isAdminGroup(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start the admin bootstrap if no administrator exists. Generate and print the one-time setup token if useSetupToken is set
+func (a *AuthAgent) InitBootstrap(useSetupToken bool) error {
+	if a.AdminExists() {
+		return nil
+	}
+
+	log.Println("[System Auth] No administrator account found. Administrator account can only be created from localhost")
+	if !useSetupToken {
+		return nil
+	}
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	token := hex.EncodeToString(b)
+
+	a.bootstrapMutex.Lock()
+	a.setupTokenHash = Hash(token)
+	a.bootstrapMutex.Unlock()
+
+	log.Println("[System Auth] ============================================================")
+	log.Println("[System Auth] To create the administrator account remotely, use setup token")
+	log.Println("[System Auth]     " + token)
+	log.Println("[System Auth] The token is valid until the administrator account is created")
+	log.Println("[System Auth] ============================================================")
+	return nil
+}
+
+// Check if the request come from localhost directly, without passing through a proxy
+func isDirectLocalRequest(r *http.Request) bool {
+	for _, header := range []string{network.HeaderXForwardedFor, network.HeaderXRealIP, "Forwarded"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Check if the request present the valid setup token
+func (a *AuthAgent) validateSetupToken(r *http.Request) bool {
+	token := r.Header.Get(setupTokenHeader)
+	if token == "" {
+		token, _ = utils.PostPara(r, "setuptoken")
+	}
+	token = strings.TrimSpace(token)
+
+	a.bootstrapMutex.Lock()
+	defer a.bootstrapMutex.Unlock()
+	if token == "" || a.setupTokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(Hash(token)), []byte(a.setupTokenHash)) == 1
+}
+
+// Check if the request is allowed to create the administrator account during bootstrap.
+// Return the way the request is authorized ("localhost" or "setup token")
+func (a *AuthAgent) validateBootstrapRequest(r *http.Request) (string, error) {
+	if isDirectLocalRequest(r) {
+		return "localhost", nil
+	}
+	if a.validateSetupToken(r) {
+		return "setup token", nil
+	}
+	return "", ErrBootstrapRequired
+}
+
+// Remove the setup token after the bootstrap is completed
+func (a *AuthAgent) completeBootstrap(r *http.Request, username string, authorizedBy string) {
+	a.bootstrapMutex.Lock()
+	a.setupTokenHash = ""
+	a.bootstrapMutex.Unlock()
+
+	clientIP, _ := network.GetIpFromRequest(r)
+	log.Println("[System Auth] Bootstrap completed. Administrator account " + username + " created from " + clientIP + " (authorized by " + authorizedBy + ")")
+	a.LogAuditEvent(r, AuditActionBootstrap, username, username, true, "authorized by "+authorizedBy)
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBootstrapRegister(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_policy")
+	if err := a.InitBootstrap(true); err != nil {
+		t.Fatalf("Failed to init bootstrap: %v", err)
+	}
+	a.setupTokenHash = Hash("setup-token")
+
+	register := func(remoteAddr string, token string, forwardedFor string) string {
+		form := url.Values{"username": {"admin"}, "password": {"Sup3r-Secret-Pass"}, "group": {"administrator"}}
+		if token != "" {
+			form.Set("setuptoken", token)
+		}
+		r := httptest.NewRequest("POST", "/system/auth/register", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		a.HandleRegister(w, r)
+		return w.Body.String()
+	}
+
+	if resp := register("203.0.113.5:4000", "", ""); !strings.Contains(resp, string(AuthErrBootstrapRequired)) {
+		t.Errorf("Expected remote bootstrap without token rejected, got %s", resp)
+	}
+	if resp := register("127.0.0.1:4000", "", "203.0.113.5"); !strings.Contains(resp, string(AuthErrBootstrapRequired)) {
+		t.Errorf("Expected proxied bootstrap without token rejected, got %s", resp)
+	}
+	if resp := register("203.0.113.5:4000", "wrong-token", ""); !strings.Contains(resp, string(AuthErrBootstrapRequired)) {
+		t.Errorf("Expected bootstrap with wrong token rejected, got %s", resp)
+	}
+	if a.AdminExists() {
+		t.Fatal("Expected no administrator created by rejected requests")
+	}
+
+	if resp := register("203.0.113.5:4000", "setup-token", ""); !strings.Contains(resp, "OK") {
+		t.Fatalf("Expected bootstrap with setup token accepted, got %s", resp)
+	}
+	if !a.AdminExists() {
+		t.Error("Expected administrator exists after bootstrap")
+	}
+	if a.setupTokenHash != "" {
+		t.Error("Expected setup token removed after bootstrap")
+	}
+}
+
+func TestBootstrapLocalRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/system/auth/register", nil)
+	r.RemoteAddr = "[::1]:4000"
+	if !isDirectLocalRequest(r) {
+		t.Error("Expected IPv6 loopback request treated as local")
+	}
+	r.Header.Set("X-Real-IP", "203.0.113.5")
+	if isDirectLocalRequest(r) {
+		t.Error("Expected proxied request not treated as local")
+	}
+}
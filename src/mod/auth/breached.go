@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Breached Password Check
+
+	If RejectBreached is set in the password policy, new passwords are
+	checked against a HaveIBeenPwned compatible range API. Only the first
+	5 characters of the password's SHA-1 hash are sent (k-anonymity), the
+	API replies with all hash suffixes of that range and the match is done
+	locally, so neither the password nor its full hash leaves the server.
+
+	The check fails open: if the API cannot be reached, the password is
+	accepted so an outage of the service never blocks password changes.
+	Login is never checked. The feature is disabled if BreachedPasswordChecker is nil
+*/
+
+// Default range API of HaveIBeenPwned
+const DefaultBreachedPasswordAPI = "https://api.pwnedpasswords.com/range/"
+
+// Check if a password is found in known data breaches
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// Checker for the HaveIBeenPwned compatible range API, GET {Endpoint}{first 5 hash characters}
+type RangeAPIBreachedPasswordChecker struct {
+	Endpoint string //e.g. https://api.pwnedpasswords.com/range/
+	MinCount int    //Number of breaches a password must be seen in to be rejected, default 1
+	client   *http.Client
+}
+
+// Create a checker for the range API at the given endpoint
+func NewRangeAPIBreachedPasswordChecker(endpoint string) *RangeAPIBreachedPasswordChecker {
+	return &RangeAPIBreachedPasswordChecker{
+		Endpoint: endpoint,
+		MinCount: 1,
+		client:   &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+func (c *RangeAPIBreachedPasswordChecker) IsBreached(password string) (bool, error) {
+	hash := sha1.Sum([]byte(password))
+	hashString := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := hashString[:5], hashString[5:]
+
+	req, err := http.NewRequest("GET", c.Endpoint+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	//Ask for padded response so the range cannot be guessed from the response size
+	req.Header.Set("Add-Padding", "true")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.New("range API replied with status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	minCount := c.MinCount
+	if minCount <= 0 {
+		minCount = 1
+	}
+
+	//Each line is {hash suffix}:{count}, padding entries have count 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		hashSuffix, countString, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(hashSuffix, suffix) {
+			continue
+		}
+		count, _ := strconv.Atoi(countString)
+		return count >= minCount, nil
+	}
+	return false, scanner.Err()
+}
+
+// Check the password against the breached password checker if enabled in the policy, fail open on error
+func (a *AuthAgent) validatePasswordNotBreached(password string) (bool, string) {
+	if !a.passwordPolicy.RejectBreached || a.BreachedPasswordChecker == nil {
+		return true, ""
+	}
+	breached, err := a.BreachedPasswordChecker.IsBreached(password)
+	if err != nil {
+		log.Println("[System Auth] Unable to check password against breached password list, password accepted: " + err.Error())
+		return true, ""
+	}
+	if breached {
+		return false, "This password has appeared in a data breach. Please choose another one."
+	}
+	return true, ""
+}
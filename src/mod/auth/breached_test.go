@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBreachedPasswordCheck(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	hash := sha1.Sum([]byte("Tr0ub4dor&3"))
+	hashString := strings.ToUpper(hex.EncodeToString(hash[:]))
+	requestedPaths := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.Path)
+		if r.URL.Path != "/range/"+hashString[:5] {
+			w.Write([]byte("0000000000000000000000000000000000A:0\r\n"))
+			return
+		}
+		w.Write([]byte("0000000000000000000000000000000000A:3\r\n" + hashString[5:] + ":42\r\n"))
+	}))
+
+	a := &AuthAgent{
+		passwordPolicy:          PasswordPolicy{RejectBreached: true},
+		BreachedPasswordChecker: NewRangeAPIBreachedPasswordChecker(server.URL + "/range/"),
+	}
+	if ok, _ := a.ValidatePasswordWithPolicy("Tr0ub4dor&3"); ok {
+		t.Error("Expected breached password rejected")
+	}
+	if ok, reason := a.ValidatePasswordWithPolicy("correct horse battery staple"); !ok {
+		t.Errorf("Expected password not in the range accepted, got %s", reason)
+	}
+	for _, path := range requestedPaths {
+		if len(path) != len("/range/")+5 {
+			t.Errorf("Expected only the hash prefix sent, got %s", path)
+		}
+	}
+
+	//Fail open if the API is unreachable
+	server.Close()
+	if ok, reason := a.ValidatePasswordWithPolicy("Tr0ub4dor&3"); !ok {
+		t.Errorf("Expected password accepted when the API is unreachable, got %s", reason)
+	}
+
+	//Not checked unless enabled in the policy
+	a.passwordPolicy.RejectBreached = false
+	requestedPaths = []string{}
+	a.ValidatePasswordWithPolicy("Tr0ub4dor&3")
+	if len(requestedPaths) != 0 {
+		t.Error("Expected no request when the check is disabled")
+	}
+}
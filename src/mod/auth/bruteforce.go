@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"imuslab.com/arozos/mod/network"
+)
+
+/*
+	Brute-force Protection
+
+	This script count the failed login attempts from each IP and
+	ban the IP via the BlacklistManager automatically after
+	AutoBanThreshold failures within AutoBanWindow seconds.
+	The ban is lifted after AutoBanDuration seconds.
+*/
+
+// Record a failed login attempt from the request origin, ban the IP if the threshold is reached
+func (a *AuthAgent) recordLoginFailure(r *http.Request) {
+	if a.AutoBanThreshold <= 0 {
+		return
+	}
+
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		return
+	}
+
+	if a.recordLoginFailureFromIP(clientIP, time.Now().Unix()) {
+		reason := strconv.Itoa(a.AutoBanThreshold) + " failed logins within " + strconv.Itoa(int(a.AutoBanWindow)) + " seconds"
+		err = a.BlacklistManager.AutoBan(clientIP, a.AutoBanDuration, reason)
+		a.Logger.LogAuthEvent("", clientIP, false, "auto-ban")
+		a.LogAuditEvent(r, AuditActionAutoBan, "", clientIP, err == nil, reason)
+		if err == nil {
+			a.Events.Publish(AuthEvent{
+				Type:    EventBan,
+				IpAddr:  clientIP,
+				Reason:  reason,
+				Auto:    true,
+				Request: r,
+			})
+		}
+	}
+}
+
+// Record a failure at the given time, return true if the IP reached the threshold within the window
+func (a *AuthAgent) recordLoginFailureFromIP(ip string, now int64) bool {
+	a.loginFailureMutex.Lock()
+	defer a.loginFailureMutex.Unlock()
+
+	//Drop the failures that are outside of the window
+	failures := []int64{now}
+	for _, failureTime := range a.loginFailures[ip] {
+		if now-failureTime < a.AutoBanWindow {
+			failures = append(failures, failureTime)
+		}
+	}
+
+	if len(failures) >= a.AutoBanThreshold {
+		delete(a.loginFailures, ip)
+		return true
+	}
+	a.loginFailures[ip] = failures
+	return false
+}
+
+// Remove the failure records that are outside of the window and prune expired auto bans
+func (a *AuthAgent) RunBruteForceProtectionCleanup() {
+	a.loginFailureMutex.Lock()
+	now := time.Now().Unix()
+	for ip, failures := range a.loginFailures {
+		if len(failures) == 0 || now-failures[0] >= a.AutoBanWindow {
+			delete(a.loginFailures, ip)
+		}
+	}
+	a.loginFailureMutex.Unlock()
+
+	a.registrationMutex.Lock()
+	a.pruneRegistrationRecords(now)
+	a.registrationMutex.Unlock()
+
+	a.BlacklistManager.PruneExpiredAutoBans()
+}
@@ -0,0 +1,28 @@
+package auth
+
+import "testing"
+
+func TestRecordLoginFailureFromIP(t *testing.T) {
+	a := &AuthAgent{
+		AutoBanThreshold: 3,
+		AutoBanWindow:    60,
+		loginFailures:    map[string][]int64{},
+	}
+
+	//Failures outside of the window should not count
+	if a.recordLoginFailureFromIP("10.0.0.1", 0) || a.recordLoginFailureFromIP("10.0.0.1", 100) || a.recordLoginFailureFromIP("10.0.0.1", 200) {
+		t.Fatal("IP should not be banned for failures spread outside of the window")
+	}
+
+	if a.recordLoginFailureFromIP("10.0.0.2", 0) || a.recordLoginFailureFromIP("10.0.0.2", 10) {
+		t.Fatal("IP should not be banned before reaching the threshold")
+	}
+	if !a.recordLoginFailureFromIP("10.0.0.2", 20) {
+		t.Fatal("IP should be banned after reaching the threshold within the window")
+	}
+
+	//Counter is reset after ban
+	if _, ok := a.loginFailures["10.0.0.2"]; ok {
+		t.Error("Failure records should be cleared after ban")
+	}
+}
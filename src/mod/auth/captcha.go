@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	CAPTCHA Challenge
+
+	After CaptchaThreshold failed logins from an IP, the login request
+	from that IP must carry a CAPTCHA token (POST captcha) that is
+	validated by the CaptchaVerifier. The counter is reset after a
+	successful login from the IP or by the nightly retry counter reset.
+
+	The feature is disabled if CaptchaVerifier is nil
+*/
+
+// Validate a CAPTCHA token from the login form, e.g. hCaptcha, reCAPTCHA or a self-hosted one
+type CaptchaVerifier interface {
+	VerifyCaptcha(token string, remoteIP string) (bool, error)
+}
+
+// Verifier for the hCaptcha / reCAPTCHA compatible siteverify API
+type SiteVerifyCaptchaVerifier struct {
+	VerifyURL string //e.g. https://hcaptcha.com/siteverify
+	Secret    string
+	client    *http.Client
+}
+
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+type CaptchaStatus struct {
+	Enabled  bool
+	Required bool
+	SiteKey  string
+}
+
+// Create a verifier for the siteverify API of the CAPTCHA provider
+func NewSiteVerifyCaptchaVerifier(verifyURL string, secret string) *SiteVerifyCaptchaVerifier {
+	return &SiteVerifyCaptchaVerifier{
+		VerifyURL: verifyURL,
+		Secret:    secret,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (v *SiteVerifyCaptchaVerifier) VerifyCaptcha(token string, remoteIP string) (bool, error) {
+	form := url.Values{}
+	form.Set("secret", v.Secret)
+	form.Set("response", token)
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	resp, err := v.client.PostForm(v.VerifyURL, form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	result := siteVerifyResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
+
+// Check if the request origin must solve a CAPTCHA before login
+func (a *AuthAgent) CaptchaRequired(r *http.Request) bool {
+	if a.CaptchaVerifier == nil {
+		return false
+	}
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		return true
+	}
+
+	a.captchaFailureMutex.Lock()
+	defer a.captchaFailureMutex.Unlock()
+	return a.captchaFailures[clientIP] >= a.CaptchaThreshold
+}
+
+// Validate the CAPTCHA token of the login request if it is required
+func (a *AuthAgent) validateLoginCaptcha(r *http.Request) error {
+	if !a.CaptchaRequired(r) {
+		return nil
+	}
+
+	token, err := utils.PostPara(r, "captcha")
+	if err != nil {
+		return errCaptchaRequired
+	}
+
+	clientIP, _ := network.GetIpFromRequest(r)
+	passed, err := a.CaptchaVerifier.VerifyCaptcha(token, clientIP)
+	if err != nil {
+		return errCaptchaUnavailable
+	} else if !passed {
+		return errCaptchaInvalid
+	}
+	return nil
+}
+
+// Count a failed login from the request origin toward the CAPTCHA threshold
+func (a *AuthAgent) recordCaptchaFailure(r *http.Request) {
+	if a.CaptchaVerifier == nil {
+		return
+	}
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		return
+	}
+
+	a.captchaFailureMutex.Lock()
+	a.captchaFailures[clientIP]++
+	a.captchaFailureMutex.Unlock()
+}
+
+// Reset the CAPTCHA failure counter of the request origin after a successful login
+func (a *AuthAgent) resetCaptchaFailure(r *http.Request) {
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		return
+	}
+
+	a.captchaFailureMutex.Lock()
+	delete(a.captchaFailures, clientIP)
+	a.captchaFailureMutex.Unlock()
+}
+
+// Return if the login form should render the CAPTCHA widget
+func (a *AuthAgent) HandleCaptchaStatus(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(CaptchaStatus{
+		Enabled:  a.CaptchaVerifier != nil,
+		Required: a.CaptchaRequired(r),
+		SiteKey:  a.CaptchaSiteKey,
+	})
+	sendJSONResponse(w, string(js))
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type mockCaptchaVerifier struct{}
+
+func (m mockCaptchaVerifier) VerifyCaptcha(token string, remoteIP string) (bool, error) {
+	return token == "valid", nil
+}
+
+func TestCaptchaChallenge(t *testing.T) {
+	a := &AuthAgent{
+		CaptchaThreshold: 2,
+		captchaFailures:  map[string]int{},
+	}
+
+	r := httptest.NewRequest("POST", "/system/auth/login", nil)
+	r.RemoteAddr = "203.0.113.1:1234"
+
+	//Disabled without a verifier
+	a.recordCaptchaFailure(r)
+	a.recordCaptchaFailure(r)
+	if a.CaptchaRequired(r) || a.validateLoginCaptcha(r) != nil {
+		t.Fatal("Expected CAPTCHA to be disabled without a verifier")
+	}
+
+	a.CaptchaVerifier = mockCaptchaVerifier{}
+	a.recordCaptchaFailure(r)
+	if a.CaptchaRequired(r) {
+		t.Fatal("Expected CAPTCHA not required before reaching the threshold")
+	}
+	a.recordCaptchaFailure(r)
+	if !a.CaptchaRequired(r) {
+		t.Fatal("Expected CAPTCHA required after reaching the threshold")
+	}
+
+	for token, expectPass := range map[string]bool{"": false, "invalid": false, "valid": true} {
+		form := url.Values{}
+		if token != "" {
+			form.Set("captcha", token)
+		}
+		req := httptest.NewRequest("POST", "/system/auth/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = r.RemoteAddr
+		if err := a.validateLoginCaptcha(req); (err == nil) != expectPass {
+			t.Errorf("Unexpected CAPTCHA validation result for token %q: %v", token, err)
+		}
+	}
+
+	//Other IPs are not affected and successful login reset the counter
+	other := httptest.NewRequest("POST", "/system/auth/login", nil)
+	other.RemoteAddr = "203.0.113.2:1234"
+	if a.CaptchaRequired(other) {
+		t.Error("Expected CAPTCHA not required for other IPs")
+	}
+	a.resetCaptchaFailure(r)
+	if a.CaptchaRequired(r) {
+		t.Error("Expected CAPTCHA not required after reset")
+	}
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"imuslab.com/arozos/mod/network"
+)
+
+/*
+	Client Certificate Authentication
+
+	Allow machine-to-machine clients to authenticate with a TLS client
+	certificate (mutual TLS) instead of an API key. The HTTPS server asks
+	for a client certificate issued by the configured CA but does not
+	require one, so browsers without such certificate are not affected.
+
+	A verified certificate is only honored for the configured path prefixes
+	(matched by whole path segments), where it authenticates the request as the user it is mapped to. The
+	mapping is a JSON file, each entry match the certificate by its subject
+	common name (cn) and / or one of its subject alternative names (san,
+	i.e. DNS name, email address, IP address or URI), e.g.
+
+	[
+		{"cn": "backup-bot", "username": "backup"},
+		{"san": "nas01.internal", "username": "nasbot"},
+		{"cn": "ci", "san": "spiffe://internal/ci", "username": "deploy"}
+	]
+
+	If both cn and san are set, the certificate must match both. The first
+	matching entry wins
+*/
+
+type ClientCertMapping struct {
+	CommonName string `json:"cn,omitempty"`  //Subject common name of the certificate
+	SAN        string `json:"san,omitempty"` //One of the subject alternative names of the certificate
+	Username   string `json:"username"`      //User authenticated by the matching certificate
+}
+
+type ClientCertAuth struct {
+	Paths    []string            //Path prefixes accepting client certificate authentication
+	Mappings []ClientCertMapping //Certificate to user mappings
+	caPool   *x509.CertPool
+}
+
+// Create a client certificate authenticator with the CA bundle and the mapping file (both PEM / JSON file paths)
+func NewClientCertAuth(caFile string, mappingFile string, paths []string) (*ClientCertAuth, error) {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no valid CA certificate found in " + caFile)
+	}
+
+	mappings, err := loadClientCertMappings(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cleanPaths := []string{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") {
+			return nil, errors.New("invalid client certificate path: " + path)
+		}
+		cleanPaths = append(cleanPaths, path)
+	}
+	if len(cleanPaths) == 0 {
+		return nil, errors.New("no path is set for client certificate authentication")
+	}
+
+	return &ClientCertAuth{
+		Paths:    cleanPaths,
+		Mappings: mappings,
+		caPool:   caPool,
+	}, nil
+}
+
+func loadClientCertMappings(mappingFile string) ([]ClientCertMapping, error) {
+	content, err := os.ReadFile(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+	mappings := []ClientCertMapping{}
+	err = json.Unmarshal(content, &mappings)
+	if err != nil {
+		return nil, errors.New("unable to parse client certificate mapping: " + err.Error())
+	}
+	for _, mapping := range mappings {
+		if mapping.Username == "" || (mapping.CommonName == "" && mapping.SAN == "") {
+			return nil, errors.New("client certificate mapping require username and cn or san")
+		}
+	}
+	return mappings, nil
+}
+
+// Get the TLS config of the HTTPS server for requesting the client certificates
+func (c *ClientCertAuth) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  c.caPool,
+	}
+}
+
+// Check if the path accept client certificate authentication
+func (c *ClientCertAuth) InScope(path string) bool {
+	for _, prefix := range c.Paths {
+		if matchPathPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the username mapped to the certificate, return empty string if no mapping matches
+func (c *ClientCertAuth) MatchUser(cert *x509.Certificate) string {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, mapping := range c.Mappings {
+		if mapping.CommonName != "" && mapping.CommonName != cert.Subject.CommonName {
+			continue
+		}
+		if mapping.SAN != "" && !inSlice(sans, mapping.SAN) {
+			continue
+		}
+		return mapping.Username
+	}
+	return ""
+}
+
+// Check if the request carry a verified client certificate for a path accepting client certificate authentication
+func (a *AuthAgent) requestHasClientCert(r *http.Request) bool {
+	return a.ClientCertAuth != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && a.ClientCertAuth.InScope(r.URL.Path)
+}
+
+// Check if the request is authenticated by its own credential (API key or client certificate) instead of the session cookie
+func (a *AuthAgent) requestIsHeadless(r *http.Request) bool {
+	return requestHasAPIKey(r) || a.requestHasClientCert(r)
+}
+
+// Validate the client certificate of the request and return the username it is mapped to
+func (a *AuthAgent) ValidateClientCertRequest(r *http.Request) (string, error) {
+	if !a.requestHasClientCert(r) {
+		return "", errors.New("client certificate not found")
+	}
+
+	//The chain is verified by the TLS handshake against the client CA
+	username := a.ClientCertAuth.MatchUser(r.TLS.VerifiedChains[0][0])
+	if username == "" {
+		return "", errors.New("client certificate not mapped to any user")
+	}
+
+	if !a.UserExists(username) {
+		return "", errors.New("client certificate user not exists")
+	}
+	if a.UserIsDisabled(username) {
+		return "", errors.New("client certificate user account disabled")
+	}
+
+	//The same IP access control as login applies
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		return "", err
+	}
+	if ok, reason := a.ValidateLoginIpAccess(clientIP); !ok {
+		return "", reason
+	}
+	return username, nil
+}
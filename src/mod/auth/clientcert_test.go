@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"imuslab.com/arozos/mod/auth/accesscontrol/blacklist"
+	"imuslab.com/arozos/mod/auth/accesscontrol/whitelist"
+)
+
+func TestClientCertAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	//Self-signed CA for the client certificates
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0644)
+
+	mappingFile := filepath.Join(dir, "clientcert.json")
+	os.WriteFile(mappingFile, []byte(`[{"cn": "backup-bot", "username": "alice"}, {"san": "nas01.internal", "username": "bob"}]`), 0644)
+
+	if _, err := NewClientCertAuth(caFile, mappingFile, []string{""}); err == nil {
+		t.Error("Expected error without client certificate paths")
+	}
+	clientCertAuth, err := NewClientCertAuth(caFile, mappingFile, []string{"/system/file_system/"})
+	if err != nil {
+		t.Fatalf("Failed to create client certificate auth: %v", err)
+	}
+	if config := clientCertAuth.TLSConfig(); config.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("Client certificate should be optional in handshake, got %v", config.ClientAuth)
+	}
+
+	a := newTestAuthAgent(t, "auth")
+	a.WhitelistManager = whitelist.NewWhitelistManager(a.Database)
+	a.BlacklistManager = blacklist.NewBlacklistManager(a.Database)
+	a.ClientCertAuth = clientCertAuth
+	a.CreateUserAccount("alice", "password", []string{"user"})
+	a.CreateUserAccount("bob", "password", []string{"user"})
+
+	requestWithCert := func(path string, commonName string, dnsNames []string) bool {
+		r := httptest.NewRequest("GET", path, nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}, DNSNames: dnsNames}
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return a.CheckAuth(r)
+	}
+
+	r := httptest.NewRequest("GET", "/system/file_system/listDir", nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "backup-bot"}, DNSNames: []string{"nas01.internal"}}
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if username, err := a.GetUserName(nil, r); err != nil || username != "alice" {
+		t.Errorf("Expected the first matching mapping alice, got %q: %v", username, err)
+	}
+
+	if !requestWithCert("/system/file_system/listDir", "other", []string{"nas01.internal"}) {
+		t.Error("Expected certificate matched by SAN to be authenticated")
+	}
+	if requestWithCert("/system/file_system/listDir", "unknown", nil) {
+		t.Error("Expected unmapped certificate not to be authenticated")
+	}
+	if requestWithCert("/system/users/list", "backup-bot", nil) {
+		t.Error("Expected client certificate not to be honored out of scope")
+	}
+	if requestWithCert("/system/file_system_ext/listDir", "backup-bot", nil) {
+		t.Error("Expected path sharing the prefix without its segment to be out of scope")
+	}
+
+	//Unverified certificate is ignored
+	r = httptest.NewRequest("GET", "/system/file_system/listDir", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if a.CheckAuth(r) {
+		t.Error("Expected unverified client certificate not to be authenticated")
+	}
+}
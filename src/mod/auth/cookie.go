@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+/*
+	Cookie Attributes
+
+	Attributes applied to all cookies set by the auth agent. HttpOnly is
+	always on. Secure is also enabled automatically on TLS connections,
+	set Secure explicitly when TLS is terminated by a reverse proxy.
+*/
+
+type CookieOptions struct {
+	Domain   string        //Cookie domain, empty for host only cookie
+	Path     string        //Cookie path, default to "/"
+	Secure   bool          //Always set the Secure attribute, even on plain http connections
+	SameSite http.SameSite //SameSite policy, default to Lax
+}
+
+// Get the default cookie attributes
+func DefaultCookieOptions() CookieOptions {
+	return CookieOptions{
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Parse the SameSite policy from its name (lax / strict / none)
+func ParseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return http.SameSiteDefaultMode, errors.New("invalid SameSite mode: " + mode)
+}
+
+// Set the cookie attributes, also used as the default of the session stores
+func (a *AuthAgent) SetCookieOptions(options CookieOptions) error {
+	if options.Path == "" {
+		options.Path = "/"
+	}
+	if options.SameSite == http.SameSiteNoneMode && !options.Secure {
+		return errors.New("SameSite=None requires the Secure attribute")
+	}
+	a.CookieOptions = options
+
+	a.SessionStore.Options = a.sessionCookieOptions(nil, a.SessionStore.Options.MaxAge)
+	if a.SwitchableAccountManager != nil {
+		store := a.SwitchableAccountManager.SessionStore
+		store.Options = a.sessionCookieOptions(nil, store.Options.MaxAge)
+	}
+	return nil
+}
+
+// Check if the cookie set in this request should carry the Secure attribute
+func (a *AuthAgent) cookieSecure(r *http.Request) bool {
+	return a.CookieOptions.Secure || (r != nil && r.TLS != nil)
+}
+
+// Build the session cookie options with the given max age
+func (a *AuthAgent) sessionCookieOptions(r *http.Request, maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Domain:   a.CookieOptions.Domain,
+		Path:     a.CookieOptions.Path,
+		MaxAge:   maxAge,
+		Secure:   a.cookieSecure(r),
+		HttpOnly: true,
+		SameSite: a.CookieOptions.SameSite,
+	}
+}
+
+// Build a cookie with the configured attributes
+func (a *AuthAgent) newCookie(r *http.Request, name string, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   a.CookieOptions.Domain,
+		Path:     a.CookieOptions.Path,
+		MaxAge:   maxAge,
+		Secure:   a.cookieSecure(r),
+		HttpOnly: true,
+		SameSite: a.CookieOptions.SameSite,
+	}
+}
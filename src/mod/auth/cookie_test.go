@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestCookieOptions(t *testing.T) {
+	a := &AuthAgent{SessionStore: sessions.NewCookieStore([]byte("0123456789abcdef"))}
+	if err := a.SetCookieOptions(DefaultCookieOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	//Default to HttpOnly, SameSite=Lax and Secure only on TLS
+	r := httptest.NewRequest("GET", "/", nil)
+	options := a.sessionCookieOptions(r, 3600)
+	if !options.HttpOnly || options.Secure || options.SameSite != http.SameSiteLaxMode || options.Path != "/" {
+		t.Errorf("Unexpected default options: %+v", options)
+	}
+	r.TLS = &tls.ConnectionState{}
+	if !a.sessionCookieOptions(r, 3600).Secure {
+		t.Error("Expected Secure cookie on TLS connection")
+	}
+	if !a.SessionStore.Options.HttpOnly || a.SessionStore.Options.MaxAge != 86400*30 {
+		t.Errorf("Unexpected session store options: %+v", a.SessionStore.Options)
+	}
+
+	//SameSite=None requires Secure
+	if err := a.SetCookieOptions(CookieOptions{SameSite: http.SameSiteNoneMode}); err == nil {
+		t.Error("Expected SameSite=None without Secure to be rejected")
+	}
+
+	sameSite, err := ParseSameSite("None")
+	if err != nil || sameSite != http.SameSiteNoneMode {
+		t.Fatalf("Unable to parse SameSite mode: %v", err)
+	}
+	if _, err := ParseSameSite("sometimes"); err == nil {
+		t.Error("Expected invalid SameSite mode to be rejected")
+	}
+
+	//Proxy setups set Secure on plain http connections
+	err = a.SetCookieOptions(CookieOptions{Domain: "example.com", Secure: true, SameSite: sameSite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := a.newCookie(httptest.NewRequest("GET", "/", nil), "test", "value", 60)
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteNoneMode || cookie.Domain != "example.com" || cookie.Path != "/" {
+		t.Errorf("Unexpected cookie: %+v", cookie)
+	}
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+/*
+	Auth Configuration Diagnostic
+
+	Dump the effective authentication configuration, resolved from the
+	startup flags and the settings stored in database, for debugging auth
+	issues across environments. Lists are reported by their number of
+	entries only. Secrets (session key, CAPTCHA secret, setup token,
+	credentials in URLs) are never included.
+*/
+
+type AuthConfigDiagnostic struct {
+	Session        SessionDiagnostic
+	AccessControl  AccessControlDiagnostic
+	BruteForce     BruteForceDiagnostic
+	Password       PasswordDiagnostic
+	Registration   RegistrationDiagnostic
+	TwoFactor      TwoFactorDiagnostic
+	AllowAutoLogin bool
+	AutoLoginCount int   //Number of autologin tokens
+	AutoLoginTTL   int64 //Default lifetime of new autologin tokens in seconds, 0 = never expire
+	TokenExpire    int64 //Lifetime of the access tokens in seconds, 0 = token access disabled
+	VerboseReason  bool  //Specific login rejection reasons are shown to clients
+	RedirectAllow  []string
+	MaxBodySize    int64 //Max request body size of login and register in bytes
+	MaxCSVBodySize int64 //Max request body size of the CSV account import in bytes
+	Maintenance    MaintenanceMode
+}
+
+type SessionDiagnostic struct {
+	Name                  string
+	MaxAge                int64
+	IdleTimeout           int64
+	MaxConcurrentSessions int
+	ActiveSessions        int
+	TrackedSessionsOnly   bool
+	CookieDomain          string
+	CookiePath            string
+	CookieSecure          bool
+	CookieSameSite        string
+	SessionKey            SessionKeyStatus
+	StepUpWindow          int64
+}
+
+type AccessControlDiagnostic struct {
+	WhitelistEnabled      bool
+	WhitelistEntries      int
+	BlacklistEnabled      bool
+	BlacklistEntries      int
+	GeoFilterEnabled      bool
+	GeoFilterActive       bool //Enabled and the country resolver is available
+	GeoFilterMode         string
+	GeoFilterCountries    int
+	LoginTimeWindowGroups int
+	ClientCertEnabled     bool
+	ClientCertMappings    int
+}
+
+type BruteForceDiagnostic struct {
+	AutoBanThreshold    int
+	AutoBanWindow       int64
+	AutoBanDuration     int64
+	LockoutThreshold    int
+	LockoutNightlyClear bool
+	CaptchaEnabled      bool
+	CaptchaThreshold    int
+	CaptchaVerifyURL    string
+}
+
+type PasswordDiagnostic struct {
+	Policy               PasswordPolicy
+	BreachedCheckEnabled bool //RejectBreached is set and a checker is configured
+	BreachedCheckAPI     string
+	MaxAge               int64
+	HashCost             int
+	ResetEnabled         bool
+	ResetTTL             int64
+}
+
+type RegistrationDiagnostic struct {
+	UserCount         int
+	AdminExists       bool
+	SetupTokenPending bool //The one-time setup token is issued and not used yet
+	RateLimit         RegistrationLimit
+	UsernamePolicy    UsernamePolicy
+}
+
+type TwoFactorDiagnostic struct {
+	TOTPWindow       int
+	TrustedDeviceTTL int64
+	NewDeviceNotice  bool
+	KnownDeviceTTL   int64
+	MaxKnownDevices  int
+	WebAuthnUsers    int //Number of users with WebAuthn credentials
+}
+
+// Get the effective authentication configuration with the secrets redacted
+func (a *AuthAgent) GetConfigDiagnostic() *AuthConfigDiagnostic {
+	result := AuthConfigDiagnostic{
+		AllowAutoLogin: a.AllowAutoLogin,
+		AutoLoginCount: len(a.GetTokensFromUsername("")),
+		AutoLoginTTL:   a.AutoLoginTokenTTL,
+		TokenExpire:    a.ExpireTime,
+		VerboseReason:  a.verboseLoginReason,
+		RedirectAllow:  a.GetRedirectAllowList(),
+		MaxBodySize:    a.getMaxAuthBodySize(),
+		MaxCSVBodySize: a.getMaxCSVImportBodySize(),
+		Maintenance:    a.GetMaintenanceMode(),
+	}
+
+	activeSessions := 0
+	a.sessionRecords.Range(func(key, value interface{}) bool {
+		activeSessions++
+		return true
+	})
+	result.Session = SessionDiagnostic{
+		Name:                  a.SessionName,
+		MaxAge:                a.SessionMaxAge,
+		IdleTimeout:           a.SessionIdleTimeout,
+		MaxConcurrentSessions: a.MaxConcurrentSessions,
+		ActiveSessions:        activeSessions,
+		TrackedSessionsOnly:   a.trackedSessionsOnly,
+		CookieDomain:          a.CookieOptions.Domain,
+		CookiePath:            a.CookieOptions.Path,
+		CookieSecure:          a.CookieOptions.Secure,
+		CookieSameSite:        sameSiteName(a.CookieOptions.SameSite),
+		SessionKey:            a.GetSessionKeyStatus(),
+		StepUpWindow:          a.stepUpWindow,
+	}
+
+	if a.WhitelistManager != nil {
+		result.AccessControl.WhitelistEnabled = a.WhitelistManager.Enabled
+		result.AccessControl.WhitelistEntries = len(a.WhitelistManager.ListWhitelistedIpRanges())
+	}
+	if a.BlacklistManager != nil {
+		result.AccessControl.BlacklistEnabled = a.BlacklistManager.Enabled
+		result.AccessControl.BlacklistEntries = len(a.BlacklistManager.ListBannedIpRanges())
+	}
+	if a.GeoFilterManager != nil {
+		result.AccessControl.GeoFilterEnabled = a.GeoFilterManager.Enabled
+		result.AccessControl.GeoFilterActive = a.GeoFilterManager.Active()
+		result.AccessControl.GeoFilterMode = a.GeoFilterManager.Mode
+		result.AccessControl.GeoFilterCountries = len(a.GeoFilterManager.Countries)
+	}
+	if a.LoginTimeWindows != nil {
+		result.AccessControl.LoginTimeWindowGroups = len(a.LoginTimeWindows.ListSchedules())
+	}
+	if a.ClientCertAuth != nil {
+		result.AccessControl.ClientCertEnabled = true
+		result.AccessControl.ClientCertMappings = len(a.ClientCertAuth.Mappings)
+	}
+
+	result.BruteForce = BruteForceDiagnostic{
+		AutoBanThreshold:    a.AutoBanThreshold,
+		AutoBanWindow:       a.AutoBanWindow,
+		AutoBanDuration:     a.AutoBanDuration,
+		LockoutThreshold:    a.LockoutThreshold,
+		LockoutNightlyClear: a.LockoutNightlyClear,
+		CaptchaEnabled:      a.CaptchaVerifier != nil,
+		CaptchaThreshold:    a.CaptchaThreshold,
+	}
+	if verifier, ok := a.CaptchaVerifier.(*SiteVerifyCaptchaVerifier); ok {
+		result.BruteForce.CaptchaVerifyURL = redactURL(verifier.VerifyURL)
+	}
+
+	result.Password = PasswordDiagnostic{
+		Policy:               a.passwordPolicy,
+		BreachedCheckEnabled: a.passwordPolicy.RejectBreached && a.BreachedPasswordChecker != nil,
+		MaxAge:               a.PasswordMaxAge,
+		HashCost:             a.PasswordHashCost,
+		ResetEnabled:         a.SendPasswordReset != nil,
+		ResetTTL:             a.PasswordResetTTL,
+	}
+	if checker, ok := a.BreachedPasswordChecker.(*RangeAPIBreachedPasswordChecker); ok {
+		result.Password.BreachedCheckAPI = redactURL(checker.Endpoint)
+	}
+
+	a.bootstrapMutex.Lock()
+	setupTokenPending := a.setupTokenHash != ""
+	a.bootstrapMutex.Unlock()
+	result.Registration = RegistrationDiagnostic{
+		UserCount:         a.GetUserCounts(),
+		AdminExists:       a.AdminExists(),
+		SetupTokenPending: setupTokenPending,
+		RateLimit:         a.GetRegistrationLimit(),
+		UsernamePolicy:    a.GetUsernamePolicy(),
+	}
+
+	webAuthnUsers, _ := a.Database.ListTable("auth_webauthn")
+	result.TwoFactor = TwoFactorDiagnostic{
+		TOTPWindow:       a.TOTPWindow,
+		TrustedDeviceTTL: a.TrustedDeviceTTL,
+		NewDeviceNotice:  a.SendNewDeviceNotice != nil,
+		KnownDeviceTTL:   a.KnownDeviceTTL,
+		MaxKnownDevices:  a.MaxKnownDevices,
+		WebAuthnUsers:    len(webAuthnUsers),
+	}
+	return &result
+}
+
+// Remove the credentials and query from the URL, e.g. API keys given as parameter
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "[redacted]"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+	return u.String()
+}
+
+// Get the name of the SameSite policy
+func sameSiteName(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	}
+	return "default"
+}
+
+// Handle dumping the effective authentication configuration
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleConfigDiagnostic(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(a.GetConfigDiagnostic())
+	sendJSONResponse(w, string(js))
+}
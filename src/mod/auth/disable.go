@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Account Disable
+
+	Suspend a user account without deleting it, so the files and
+	settings of the user are kept. A disabled account cannot login
+	and all of its sessions are revoked until it is enabled again.
+
+	The disable records are stored as
+	auth/disabled/{username} => AccountDisable
+*/
+
+type AccountDisable struct {
+	DisableTime int64  //Unix time when the account is disabled
+	DisabledBy  string //Username of the admin who disabled the account
+	Reason      string //Reason of disabling the account, can be empty
+}
+
+type DisabledAccount struct {
+	Username string
+	AccountDisable
+}
+
+// Reason returned to the client when a disabled account try to login
+const accountDisabledReason = "Account disabled. Please contact your system administrator"
+
+// Rejection reason of accounts that have not verified their email, see verification.go
+const accountPendingVerificationReason = "Account pending email verification"
+
+// Check if the user account is disabled
+func (a *AuthAgent) UserIsDisabled(username string) bool {
+	return a.Database.KeyExists("auth", "disabled/"+username)
+}
+
+// Disable the user account and revoke all of its sessions
+func (a *AuthAgent) DisableUserAccount(username string, disabledBy string, reason string) error {
+	if !a.UserExists(username) {
+		return errors.New("user not exists")
+	}
+
+	err := a.Database.Write("auth", "disabled/"+username, AccountDisable{
+		DisableTime: time.Now().Unix(),
+		DisabledBy:  disabledBy,
+		Reason:      reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	//Kick out all existing sessions and logins of the disabled account
+	a.RevokeAllUserSessions(username)
+	a.RemoveAutologinTokenByUsername(username)
+	a.SessionCache.InvalidateUser(username)
+
+	log.Println("[System Auth] Account " + username + " disabled")
+	return nil
+}
+
+// Enable a disabled user account
+func (a *AuthAgent) EnableUserAccount(username string) error {
+	if !a.UserIsDisabled(username) {
+		return errors.New("account is not disabled")
+	}
+	log.Println("[System Auth] Account " + username + " enabled")
+	return a.Database.Delete("auth", "disabled/"+username)
+}
+
+// List all the disabled accounts
+func (a *AuthAgent) ListDisabledAccounts() []*DisabledAccount {
+	results := []*DisabledAccount{}
+	entries, err := a.Database.ListTable("auth")
+	if err != nil {
+		return results
+	}
+
+	for _, keypairs := range entries {
+		key := string(keypairs[0])
+		if !strings.HasPrefix(key, "disabled/") {
+			continue
+		}
+
+		thisRecord := AccountDisable{}
+		if json.Unmarshal(keypairs[1], &thisRecord) != nil {
+			continue
+		}
+		results = append(results, &DisabledAccount{
+			Username:       strings.TrimPrefix(key, "disabled/"),
+			AccountDisable: thisRecord,
+		})
+	}
+	return results
+}
+
+// Handle listing of the disabled accounts
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleListDisabledAccounts(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(a.ListDisabledAccounts())
+	sendJSONResponse(w, string(js))
+}
+
+// Handle disabling of an account. Accept POST username and optional reason
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleDisableAccount(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		sendErrorResponse(w, "Invalid username given")
+		return
+	}
+	reason, _ := utils.PostPara(r, "reason")
+
+	actor, _ := a.GetUserName(w, r)
+	if actor == username {
+		sendErrorResponse(w, "You cannot disable your own account")
+		return
+	}
+
+	err = a.DisableUserAccount(username, actor, reason)
+	if err != nil {
+		a.LogAuditEvent(r, AuditActionDisable, actor, username, false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	a.LogAuditEvent(r, AuditActionDisable, actor, username, true, reason)
+	sendOK(w)
+}
+
+// Handle enabling of an account. Accept POST username
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleEnableAccount(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		sendErrorResponse(w, "Invalid username given")
+		return
+	}
+
+	err = a.EnableUserAccount(username)
+	if err != nil {
+		a.LogAuditEventByRequest(r, AuditActionEnable, username, false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	a.LogAuditEventByRequest(r, AuditActionEnable, username, true, "")
+	sendOK(w)
+}
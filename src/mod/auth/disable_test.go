@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisableUserAccount(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_sessions")
+	a.CreateUserAccount("alice", "password", []string{"user"})
+	a.CreateUserAccount("bob", "password", []string{"user", "administrator"})
+
+	now := time.Now().Unix()
+	a.sessionRecords.Store("s1", &SessionRecord{ID: "s1", Username: "alice", CreationTime: now, LastSeen: now})
+	if !a.validateSessionID("s1") {
+		t.Fatal("Expected session to be valid before disabling")
+	}
+
+	if err := a.DisableUserAccount("nobody", "admin", ""); err == nil {
+		t.Error("Expected error when disabling an unknown user")
+	}
+	if err := a.DisableUserAccount("alice", "admin", "offboarding"); err != nil {
+		t.Fatalf("Failed to disable account: %v", err)
+	}
+
+	if ok, reason := a.ValidateUsernameAndPasswordWithReason("alice", "password"); ok || reason != accountDisabledReason {
+		t.Errorf("Expected disabled account to be rejected, got %v %q", ok, reason)
+	}
+	if a.validateSessionID("s1") {
+		t.Error("Expected session of disabled account to be revoked")
+	}
+
+	disabled := a.ListDisabledAccounts()
+	if len(disabled) != 1 || disabled[0].Username != "alice" || disabled[0].Reason != "offboarding" {
+		t.Errorf("Unexpected disabled accounts: %+v", disabled)
+	}
+
+	//Group listing used by the bulk disable handler
+	if users := a.listUsersByGroup("user", true); len(users) != 1 || users[0] != "alice" {
+		t.Errorf("Expected only alice in exact group match, got %v", users)
+	}
+	if users := a.listUsersByGroup("user", false); len(users) != 2 {
+		t.Errorf("Expected 2 users in group, got %v", users)
+	}
+
+	if err := a.EnableUserAccount("alice"); err != nil {
+		t.Fatalf("Failed to enable account: %v", err)
+	}
+	if ok, _ := a.ValidateUsernameAndPasswordWithReason("alice", "password"); !ok {
+		t.Error("Expected enabled account to login")
+	}
+	if err := a.EnableUserAccount("alice"); err == nil {
+		t.Error("Expected error when enabling an account that is not disabled")
+	}
+}
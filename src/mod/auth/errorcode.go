@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+/*
+	Authentication Error Codes
+
+	Login, register and account switch failures are replied with a
+	machine-readable code along with the English message, so the frontend
+	can show a localized message, e.g.
+
+	{"error":"Invalid 2FA code","code":"AUTH_INVALID_2FA"}
+
+	Clients reading the error field only are not affected. The codes are
+
+	Request
+	AUTH_REQUEST_TOO_LARGE       Request body exceed the size limit
+	AUTH_MISSING_USERNAME        Username not given
+	AUTH_MISSING_PASSWORD        Password not given
+	AUTH_RATE_LIMITED            Too many login attempts, retry later
+	AUTH_CAPTCHA_REQUIRED        CAPTCHA token required after repeated failures
+	AUTH_CAPTCHA_INVALID         CAPTCHA token rejected
+	AUTH_CAPTCHA_UNAVAILABLE     CAPTCHA provider cannot be reached
+
+	Credentials
+	AUTH_INVALID_CREDENTIALS     Generic failure, given instead of the three codes below unless verbose reasons are enabled
+	AUTH_USER_NOT_FOUND          User not exists
+	AUTH_WRONG_PASSWORD          Incorrect password
+	AUTH_ACCOUNT_LOCKED          Account locked after too many failed logins
+	AUTH_ACCOUNT_DISABLED        Account disabled by admin
+	AUTH_PENDING_VERIFICATION    Email of the account not verified yet
+
+	Access control
+	AUTH_IP_BLOCKED              Client IP not whitelisted or banned
+	AUTH_GEO_BLOCKED             Login from the client location not allowed
+	AUTH_ORIGIN_UNKNOWN          Client IP cannot be resolved
+	AUTH_OUTSIDE_LOGIN_HOURS     Login not allowed at this time by the group login time window
+	AUTH_MAINTENANCE             System under maintenance, only administrators can login
+
+	Two-factor authentication
+	AUTH_2FA_REQUIRED            2FA code required, prompt the user for it
+	AUTH_2FA_NOT_ENROLLED        2FA required by group policy but not set up
+	AUTH_INVALID_2FA             Incorrect 2FA code
+
+	Registration
+	AUTH_LOGIN_REQUIRED          Login required to create new users
+	AUTH_USERNAME_REJECTED       Username rejected by the username policy
+	AUTH_PASSWORD_REJECTED       Password rejected by the password policy
+	AUTH_EMAIL_REJECTED          Email malformed or used by another account
+	AUTH_REGISTRATION_LIMITED    Too many accounts registered recently
+	AUTH_BOOTSTRAP_REQUIRED      No administrator yet, use localhost or the setup token
+
+	AUTH_INTERNAL_ERROR          Other server side failures
+*/
+
+type AuthErrorCode string
+
+const (
+	AuthErrRequestTooLarge     AuthErrorCode = "AUTH_REQUEST_TOO_LARGE"
+	AuthErrMissingUsername     AuthErrorCode = "AUTH_MISSING_USERNAME"
+	AuthErrMissingPassword     AuthErrorCode = "AUTH_MISSING_PASSWORD"
+	AuthErrRateLimited         AuthErrorCode = "AUTH_RATE_LIMITED"
+	AuthErrCaptchaRequired     AuthErrorCode = "AUTH_CAPTCHA_REQUIRED"
+	AuthErrCaptchaInvalid      AuthErrorCode = "AUTH_CAPTCHA_INVALID"
+	AuthErrCaptchaUnavailable  AuthErrorCode = "AUTH_CAPTCHA_UNAVAILABLE"
+	AuthErrInvalidCredentials  AuthErrorCode = "AUTH_INVALID_CREDENTIALS"
+	AuthErrUserNotFound        AuthErrorCode = "AUTH_USER_NOT_FOUND"
+	AuthErrWrongPassword       AuthErrorCode = "AUTH_WRONG_PASSWORD"
+	AuthErrAccountLocked       AuthErrorCode = "AUTH_ACCOUNT_LOCKED"
+	AuthErrAccountDisabled     AuthErrorCode = "AUTH_ACCOUNT_DISABLED"
+	AuthErrPendingVerification AuthErrorCode = "AUTH_PENDING_VERIFICATION"
+	AuthErrIPBlocked           AuthErrorCode = "AUTH_IP_BLOCKED"
+	AuthErrGeoBlocked          AuthErrorCode = "AUTH_GEO_BLOCKED"
+	AuthErrOriginUnknown       AuthErrorCode = "AUTH_ORIGIN_UNKNOWN"
+	AuthErrOutsideLoginHours   AuthErrorCode = "AUTH_OUTSIDE_LOGIN_HOURS"
+	AuthErrMaintenance         AuthErrorCode = "AUTH_MAINTENANCE"
+	AuthErr2FARequired         AuthErrorCode = "AUTH_2FA_REQUIRED"
+	AuthErr2FANotEnrolled      AuthErrorCode = "AUTH_2FA_NOT_ENROLLED"
+	AuthErrInvalid2FA          AuthErrorCode = "AUTH_INVALID_2FA"
+	AuthErrLoginRequired       AuthErrorCode = "AUTH_LOGIN_REQUIRED"
+	AuthErrUsernameRejected    AuthErrorCode = "AUTH_USERNAME_REJECTED"
+	AuthErrPasswordRejected    AuthErrorCode = "AUTH_PASSWORD_REJECTED"
+	AuthErrEmailRejected       AuthErrorCode = "AUTH_EMAIL_REJECTED"
+	AuthErrRegistrationLimited AuthErrorCode = "AUTH_REGISTRATION_LIMITED"
+	AuthErrBootstrapRequired   AuthErrorCode = "AUTH_BOOTSTRAP_REQUIRED"
+	AuthErrInternal            AuthErrorCode = "AUTH_INTERNAL_ERROR"
+)
+
+// Errors of the login checks that map to a specific code
+var (
+	errIPNotWhitelisted   = errors.New("Your IP is not whitelisted on this host")
+	errIPBanned           = errors.New("Your IP is banned by this host")
+	errGeoBlocked         = errors.New("Login from your location is not allowed by this host")
+	errCaptchaRequired    = errors.New("CAPTCHA required")
+	errCaptchaInvalid     = errors.New("Invalid CAPTCHA")
+	errCaptchaUnavailable = errors.New("Unable to verify CAPTCHA")
+)
+
+// Error of the login rejected by the login time window of the user groups, see ValidateLoginTimeWindow
+type loginTimeWindowError struct {
+	reason string
+}
+
+func (e *loginTimeWindowError) Error() string {
+	return e.reason
+}
+
+// Get the code of the error returned by the login checks
+func getAuthErrorCode(err error) AuthErrorCode {
+	switch {
+	case errors.Is(err, errIPNotWhitelisted), errors.Is(err, errIPBanned):
+		return AuthErrIPBlocked
+	case errors.Is(err, errGeoBlocked):
+		return AuthErrGeoBlocked
+	case errors.Is(err, errCaptchaRequired):
+		return AuthErrCaptchaRequired
+	case errors.Is(err, errCaptchaInvalid):
+		return AuthErrCaptchaInvalid
+	case errors.Is(err, errCaptchaUnavailable):
+		return AuthErrCaptchaUnavailable
+	}
+	var timeWindowErr *loginTimeWindowError
+	if errors.As(err, &timeWindowErr) {
+		return AuthErrOutsideLoginHours
+	}
+	return AuthErrInternal
+}
+
+// Get the code of the credential validation failure reason, see validateCredentials
+func getCredentialErrorCode(reason string) AuthErrorCode {
+	switch reason {
+	case userNotFoundReason:
+		return AuthErrUserNotFound
+	case incorrectPasswordReason:
+		return AuthErrWrongPassword
+	case accountLockedReason:
+		return AuthErrAccountLocked
+	case accountDisabledReason:
+		return AuthErrAccountDisabled
+	case accountPendingVerificationReason:
+		return AuthErrPendingVerification
+	}
+	return AuthErrInvalidCredentials
+}
+
+// Return the code of the rejection reason that is safe to be shown to external clients, see LoginRejectionReason
+func (a *AuthAgent) LoginRejectionCode(reason string) AuthErrorCode {
+	if !a.verboseLoginReason {
+		switch reason {
+		case userNotFoundReason, incorrectPasswordReason, accountLockedReason:
+			return AuthErrInvalidCredentials
+		}
+	}
+	return getCredentialErrorCode(reason)
+}
+
+// Send the error message with its code
+func sendAuthErrorResponse(w http.ResponseWriter, code AuthErrorCode, errMsg string) {
+	js, _ := json.Marshal(struct {
+		Error string        `json:"error"`
+		Code  AuthErrorCode `json:"code"`
+	}{
+		Error: errMsg,
+		Code:  code,
+	})
+	sendJSONResponse(w, string(js))
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthErrorCode(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_policy")
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	//Unknown user and wrong password share the same code by default
+	_, unknownUserCode, _ := a.ValidateUsernameAndPasswordWithCode("nobody", "password")
+	_, wrongPasswordCode, _ := a.ValidateUsernameAndPasswordWithCode("alice", "wrong")
+	if unknownUserCode != AuthErrInvalidCredentials || wrongPasswordCode != AuthErrInvalidCredentials {
+		t.Errorf("Expected generic codes, got %q and %q", unknownUserCode, wrongPasswordCode)
+	}
+	if a.LoginRejectionCode(accountDisabledReason) != AuthErrAccountDisabled {
+		t.Error("Expected disabled account code to be shown")
+	}
+
+	a.SetVerboseLoginReason(true)
+	_, unknownUserCode, _ = a.ValidateUsernameAndPasswordWithCode("nobody", "password")
+	_, wrongPasswordCode, _ = a.ValidateUsernameAndPasswordWithCode("alice", "wrong")
+	if unknownUserCode != AuthErrUserNotFound || wrongPasswordCode != AuthErrWrongPassword {
+		t.Errorf("Expected specific codes in verbose mode, got %q and %q", unknownUserCode, wrongPasswordCode)
+	}
+
+	if getAuthErrorCode(errCaptchaRequired) != AuthErrCaptchaRequired || getAuthErrorCode(errIPBanned) != AuthErrIPBlocked {
+		t.Error("Unexpected code for login check errors")
+	}
+
+	//Oversized request carries its code
+	r := httptest.NewRequest("POST", "/system/auth/login", strings.NewReader(strings.Repeat("a", 2048)))
+	w := httptest.NewRecorder()
+	limitRequestBody(w, r, 1024)
+	resp := map[string]string{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["code"] != string(AuthErrRequestTooLarge) || resp["error"] == "" {
+		t.Errorf("Unexpected error response %q", w.Body.String())
+	}
+}
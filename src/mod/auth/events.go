@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"imuslab.com/arozos/mod/network"
+)
+
+/*
+	Auth Event Bus
+
+	Publish / subscribe of the authentication events, so features that react
+	to logins, logouts and bans (e.g. metrics, notifications) can subscribe
+	to the events instead of being wired into the handlers one by one.
+
+	Subscribers are called synchronously in the goroutine of the request,
+	in the order of subscription. Handlers must return quickly and start
+	their own goroutine for slow work like sending emails. A panicking
+	handler is recovered and logged without affecting the others.
+
+	Usage:
+	unsubscribe := authAgent.Events.Subscribe(func(event auth.AuthEvent) {
+		//Handle the event
+	}, auth.EventLoginSuccess, auth.EventLogout)
+*/
+
+type AuthEventType string
+
+const (
+	EventLoginSuccess  AuthEventType = "login-success"  //Username logged in
+	EventLoginFailure  AuthEventType = "login-failure"  //Login of Username rejected for Reason, one of the LoginFailure reasons in metrics.go
+	EventLogout        AuthEventType = "logout"         //Username logged out
+	EventRegister      AuthEventType = "register"       //New account Username registered
+	EventAccountSwitch AuthEventType = "account-switch" //Switched from PreviousUsername to Username
+	EventBan           AuthEventType = "ban"            //IpAddr banned for Reason, Auto if banned by brute-force protection
+)
+
+type AuthEvent struct {
+	Type             AuthEventType
+	Time             int64         //Unix time of the event
+	Username         string        //Username of the account, can be empty if unknown (e.g. missing username on login)
+	PreviousUsername string        //Username before the account switch
+	IpAddr           string        //IP of the client, or the banned IP range for ban
+	Reason           string        //Reason of the login failure or ban
+	Auto             bool          //The ban is issued by brute-force protection
+	Request          *http.Request `json:"-"` //Request triggering the event, can be nil
+}
+
+type AuthEventHandler func(event AuthEvent)
+
+type authEventSubscription struct {
+	id      int
+	handler AuthEventHandler
+	types   map[AuthEventType]bool //Empty for all types
+}
+
+type AuthEventBus struct {
+	subscriptions []*authEventSubscription
+	nextID        int
+	mutex         sync.RWMutex
+}
+
+func NewAuthEventBus() *AuthEventBus {
+	return &AuthEventBus{
+		subscriptions: []*authEventSubscription{},
+	}
+}
+
+// Subscribe to the given event types, or all events if no type is given. Return a function to unsubscribe
+func (b *AuthEventBus) Subscribe(handler AuthEventHandler, eventTypes ...AuthEventType) func() {
+	types := map[AuthEventType]bool{}
+	for _, eventType := range eventTypes {
+		types[eventType] = true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.nextID++
+	id := b.nextID
+	b.subscriptions = append(b.subscriptions, &authEventSubscription{
+		id:      id,
+		handler: handler,
+		types:   types,
+	})
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		remaining := []*authEventSubscription{}
+		for _, subscription := range b.subscriptions {
+			if subscription.id != id {
+				remaining = append(remaining, subscription)
+			}
+		}
+		b.subscriptions = remaining
+	}
+}
+
+// Deliver the event to the subscribers of its type. Publishing to a nil bus does nothing
+func (b *AuthEventBus) Publish(event AuthEvent) {
+	if b == nil {
+		return
+	}
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+
+	b.mutex.RLock()
+	subscriptions := append([]*authEventSubscription{}, b.subscriptions...)
+	b.mutex.RUnlock()
+
+	for _, subscription := range subscriptions {
+		if len(subscription.types) > 0 && !subscription.types[event.Type] {
+			continue
+		}
+		deliverAuthEvent(subscription.handler, event)
+	}
+}
+
+func deliverAuthEvent(handler AuthEventHandler, event AuthEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println("[System Auth] Auth event handler panic on "+string(event.Type)+":", err, string(debug.Stack()))
+		}
+	}()
+	handler(event)
+}
+
+// Publish an event triggered by the request, the client IP is resolved from the request
+func (a *AuthAgent) publishEvent(r *http.Request, eventType AuthEventType, username string, reason string) {
+	event := AuthEvent{
+		Type:     eventType,
+		Username: username,
+		Reason:   reason,
+		Request:  r,
+	}
+	if r != nil {
+		event.IpAddr, _ = network.GetIpFromRequest(r)
+	}
+	a.Events.Publish(event)
+}
@@ -0,0 +1,53 @@
+package auth
+
+import "testing"
+
+func TestAuthEventBus(t *testing.T) {
+	bus := NewAuthEventBus()
+
+	received := []AuthEvent{}
+	unsubscribe := bus.Subscribe(func(event AuthEvent) {
+		received = append(received, event)
+	}, EventLoginSuccess, EventLogout)
+
+	//A panicking subscriber must not stop the delivery to the others
+	allCount := 0
+	bus.Subscribe(func(event AuthEvent) {
+		panic("subscriber failure")
+	})
+	bus.Subscribe(func(event AuthEvent) {
+		allCount++
+	})
+
+	bus.Publish(AuthEvent{Type: EventLoginSuccess, Username: "alice"})
+	bus.Publish(AuthEvent{Type: EventLoginFailure, Username: "alice", Reason: LoginFailureInvalidCredentials})
+	bus.Publish(AuthEvent{Type: EventLogout, Username: "alice"})
+	if len(received) != 2 || received[0].Type != EventLoginSuccess || received[1].Type != EventLogout || received[0].Time == 0 {
+		t.Fatalf("Unexpected filtered events: %+v", received)
+	}
+	if allCount != 3 {
+		t.Errorf("Expected 3 events for the unfiltered subscriber, got %d", allCount)
+	}
+
+	unsubscribe()
+	bus.Publish(AuthEvent{Type: EventLoginSuccess, Username: "alice"})
+	if len(received) != 2 {
+		t.Error("Event delivered after unsubscribe")
+	}
+
+	//Agents without event bus must not panic
+	var nilBus *AuthEventBus
+	nilBus.Publish(AuthEvent{Type: EventLogout})
+
+	//Metrics are counted from the events
+	a := &AuthAgent{Events: NewAuthEventBus(), metrics: NewAuthMetrics()}
+	a.metrics.subscribe(a.Events)
+	a.publishEvent(nil, EventLoginSuccess, "alice", "")
+	a.publishEvent(nil, EventLoginFailure, "alice", LoginFailureInvalid2FA)
+	a.Events.Publish(AuthEvent{Type: EventBan, IpAddr: "10.0.0.1", Auto: true})
+	a.Events.Publish(AuthEvent{Type: EventRegister, Username: "bob"})
+	snapshot := a.GetMetrics()
+	if snapshot.LoginSuccess != 1 || snapshot.LoginFailures[LoginFailureInvalid2FA] != 1 || snapshot.AutoBans != 1 || snapshot.Registrations != 1 {
+		t.Errorf("Unexpected counters: %+v", snapshot)
+	}
+}
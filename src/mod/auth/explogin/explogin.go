@@ -1,13 +1,12 @@
 package explogin
 
 import (
-	"errors"
 	"math"
-	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
+
+	"imuslab.com/arozos/mod/network"
 )
 
 /*
@@ -16,8 +15,15 @@ import (
 	so as to prevent someone from brute forcing your password
 
 	Author: tobychui
+
+	Retry counters are reset after RetryWindow seconds without failed
+	attempts, counting from the end of the current delay. The nightly
+	ResetAllUserRetryCounter remains as a backstop
 */
 
+//Time source, replaceable for testing
+var timeNow = time.Now
+
 type UserLoginEntry struct {
 	Username             string //Username of account
 	TargetIP             string //Request IP address
@@ -30,8 +36,12 @@ type ExpLoginHandler struct {
 	LoginRecord  *sync.Map //Sync map to store UserLoginEntry, username+ip as key
 	BaseDelay    int       //Base delay exponent
 	DelayCeiling int       //Max delay time
+	RetryWindow  int64     //Quiet period in seconds before the retry count reset, 0 to only reset nightly
 }
 
+//Default quiet period before the retry count reset
+const DefaultRetryWindow = 900
+
 //Create a new exponential login handler object
 func NewExponentialLoginHandler(baseDelay int, ceiling int) *ExpLoginHandler {
 	recordMap := sync.Map{}
@@ -40,6 +50,7 @@ func NewExponentialLoginHandler(baseDelay int, ceiling int) *ExpLoginHandler {
 		LoginRecord:  &recordMap,
 		BaseDelay:    baseDelay,
 		DelayCeiling: ceiling,
+		RetryWindow:  DefaultRetryWindow,
 	}
 }
 
@@ -61,9 +72,15 @@ func (e *ExpLoginHandler) AllowImmediateAccess(username string, r *http.Request)
 
 	//Record exists. Check his retry count and target
 	targerRecord := val.(*UserLoginEntry)
-	if targerRecord.NextAllowedTimestamp > time.Now().Unix() {
+	now := timeNow().Unix()
+	if e.retryCountExpired(targerRecord, now) {
+		//No failed attempt within the retry window. Start over
+		e.LoginRecord.Delete(key)
+		return true, 0
+	}
+	if targerRecord.NextAllowedTimestamp > now {
 		//Return next login request time left in seconds
-		return false, targerRecord.NextAllowedTimestamp - time.Now().Unix()
+		return false, targerRecord.NextAllowedTimestamp - now
 	}
 
 	//Ok to login now
@@ -79,14 +96,15 @@ func (e *ExpLoginHandler) AddUserRetrycount(username string, r *http.Request) {
 	}
 
 	key := username + "/" + userip
+	now := timeNow().Unix()
 	val, ok := e.LoginRecord.Load(key)
-	if !ok {
+	if !ok || e.retryCountExpired(val.(*UserLoginEntry), now) {
 		//Create an entry for the retry
 		thisUserNewRecord := UserLoginEntry{
 			Username:             username,
 			TargetIP:             userip,
-			PreviousTryTimestamp: time.Now().Unix(),
-			NextAllowedTimestamp: time.Now().Unix() + e.getDelayTimeFromRetryCount(1),
+			PreviousTryTimestamp: now,
+			NextAllowedTimestamp: now + e.getDelayTimeFromRetryCount(1),
 			RetryCount:           1,
 		}
 
@@ -95,8 +113,8 @@ func (e *ExpLoginHandler) AddUserRetrycount(username string, r *http.Request) {
 		//Add to the value in the structure
 		matchingLoginEntry := val.(*UserLoginEntry)
 		matchingLoginEntry.RetryCount++
-		matchingLoginEntry.PreviousTryTimestamp = time.Now().Unix()
-		matchingLoginEntry.NextAllowedTimestamp = time.Now().Unix() + e.getDelayTimeFromRetryCount(matchingLoginEntry.RetryCount)
+		matchingLoginEntry.PreviousTryTimestamp = now
+		matchingLoginEntry.NextAllowedTimestamp = now + e.getDelayTimeFromRetryCount(matchingLoginEntry.RetryCount)
 
 		//Store it back to the map
 		e.LoginRecord.Store(key, matchingLoginEntry)
@@ -123,6 +141,29 @@ func (e *ExpLoginHandler) ResetAllUserRetryCounter() {
 	})
 }
 
+//Remove the retry records that have no failed attempt within the retry window
+func (e *ExpLoginHandler) RemoveExpiredRetryCounters() {
+	now := timeNow().Unix()
+	e.LoginRecord.Range(func(key interface{}, value interface{}) bool {
+		if e.retryCountExpired(value.(*UserLoginEntry), now) {
+			e.LoginRecord.Delete(key)
+		}
+		return true
+	})
+}
+
+//Check if the retry window passed since the previous failed attempt or the end of its delay, whichever is later
+func (e *ExpLoginHandler) retryCountExpired(entry *UserLoginEntry, now int64) bool {
+	if e.RetryWindow <= 0 {
+		return false
+	}
+	quietSince := entry.PreviousTryTimestamp
+	if entry.NextAllowedTimestamp > quietSince {
+		quietSince = entry.NextAllowedTimestamp
+	}
+	return now-quietSince >= e.RetryWindow
+}
+
 //Get the next delay time
 func (e *ExpLoginHandler) getDelayTimeFromRetryCount(retryCount int) int64 {
 	delaySecs := int64(math.Floor((math.Pow(2, float64(retryCount)) - 1) * 0.5))
@@ -140,28 +181,6 @@ func (e *ExpLoginHandler) getDelayTimeFromRetryCount(retryCount int) int64 {
 */
 
 func getIpFromRequest(r *http.Request) (string, error) {
-	ip := r.Header.Get("X-REAL-IP")
-	netIP := net.ParseIP(ip)
-	if netIP != nil {
-		return ip, nil
-	}
-
-	ips := r.Header.Get("X-FORWARDED-FOR")
-	splitIps := strings.Split(ips, ",")
-	for _, ip := range splitIps {
-		netIP := net.ParseIP(ip)
-		if netIP != nil {
-			return ip, nil
-		}
-	}
-
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "", err
-	}
-	netIP = net.ParseIP(ip)
-	if netIP != nil {
-		return ip, nil
-	}
-	return "", errors.New("No IP information found")
+	//Proxy headers are only honored from trusted proxies, see network/clientip.go
+	return network.GetIpFromRequest(r)
 }
@@ -3,6 +3,7 @@ package explogin
 import (
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestAllowImmediateAccess_FirstAttempt(t *testing.T) {
@@ -140,3 +141,58 @@ func TestAllowImmediateAccess_IPNotFound(t *testing.T) {
 		t.Error("Access should be allowed even if IP information is not found")
 	}
 }
+
+func TestRetryWindowDecay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	handler := NewExponentialLoginHandler(2, 10)
+	handler.RetryWindow = 900
+	username := "testuser"
+	request, _ := http.NewRequest("GET", "/", nil)
+
+	//Failures in a row increase the delay
+	for i := 0; i < 3; i++ {
+		handler.AddUserRetrycount(username, request)
+		now = now.Add(time.Second)
+	}
+	if allowed, _ := handler.AllowImmediateAccess(username, request); allowed {
+		t.Fatal("Access should be denied after repeated failures")
+	}
+
+	//A quiet period shorter than the window keeps the counter
+	now = now.Add(5 * time.Minute)
+	if allowed, _ := handler.AllowImmediateAccess(username, request); !allowed {
+		t.Fatal("Access should be allowed after the delay")
+	}
+	handler.AddUserRetrycount(username, request)
+	entry, _ := handler.LoginRecord.Load(username + "/0.0.0.0")
+	if entry.(*UserLoginEntry).RetryCount != 4 {
+		t.Errorf("Retry count should keep increasing within the window, got %d", entry.(*UserLoginEntry).RetryCount)
+	}
+
+	//The counter reset after a quiet period longer than the window
+	now = now.Add(16 * time.Minute)
+	handler.AddUserRetrycount(username, request)
+	entry, _ = handler.LoginRecord.Load(username + "/0.0.0.0")
+	if entry.(*UserLoginEntry).RetryCount != 1 {
+		t.Errorf("Retry count should reset after the window, got %d", entry.(*UserLoginEntry).RetryCount)
+	}
+
+	//Expired records are cleared by the periodic cleanup
+	now = now.Add(16 * time.Minute)
+	handler.RemoveExpiredRetryCounters()
+	if _, exists := handler.LoginRecord.Load(username + "/0.0.0.0"); exists {
+		t.Error("Expired retry record should be removed")
+	}
+
+	//Window disabled, only the nightly reset clears the counter
+	handler.RetryWindow = 0
+	handler.AddUserRetrycount(username, request)
+	now = now.Add(24 * time.Hour)
+	handler.RemoveExpiredRetryCounters()
+	if _, exists := handler.LoginRecord.Load(username + "/0.0.0.0"); !exists {
+		t.Error("Retry record should be kept when the window is disabled")
+	}
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Group Session Policies
+
+	Per group overrides of the session rules. Users without any group
+	policy follow the global settings, so nothing changes until an admin
+	set a policy for one of the groups.
+
+	When a user belongs to multiple groups with policies
+	- SessionMaxAge: the shortest lifetime applies
+	- DisableAutoLogin / Require2FA: applies if set by any of the groups
+	- MaxConcurrentSessions: the largest limit applies (see session.go)
+
+	Users in a group requiring 2FA must enroll 2FA before the policy is
+	set, otherwise they cannot login until the policy is removed.
+
+	The policies are stored as
+	auth_grouppolicy/{groupname} => GroupPolicy
+*/
+
+type GroupPolicy struct {
+	SessionMaxAge         *int64 `json:",omitempty"` //Absolute session lifetime in seconds, 0 = never expire, nil = use global setting
+	MaxConcurrentSessions *int   `json:",omitempty"` //Max number of concurrent sessions, 0 = unlimited, nil = use global setting
+	DisableAutoLogin      bool   //Forbid autologin tokens for users in this group
+	Require2FA            bool   //Require a 2FA code on every login, trusted devices cannot skip it
+}
+
+// Load the group policies from database into memory
+func (a *AuthAgent) loadGroupPolicies() {
+	a.groupPolicyMutex.Lock()
+	defer a.groupPolicyMutex.Unlock()
+	a.groupPolicies = map[string]*GroupPolicy{}
+	entries, err := a.Database.ListTable("auth_grouppolicy")
+	if err != nil {
+		return
+	}
+	for _, keypairs := range entries {
+		thisPolicy := GroupPolicy{}
+		if json.Unmarshal(keypairs[1], &thisPolicy) == nil {
+			a.groupPolicies[string(keypairs[0])] = &thisPolicy
+		}
+	}
+}
+
+// Get the policy of a group, return nil if not set
+func (a *AuthAgent) GetGroupPolicy(group string) *GroupPolicy {
+	a.groupPolicyMutex.RLock()
+	defer a.groupPolicyMutex.RUnlock()
+	return a.groupPolicies[group]
+}
+
+// Set the policy of a group, set policy to nil to remove it
+func (a *AuthAgent) SetGroupPolicy(group string, policy *GroupPolicy) error {
+	if group == "" {
+		return errors.New("invalid group name given")
+	}
+
+	a.groupPolicyMutex.Lock()
+	defer a.groupPolicyMutex.Unlock()
+	if a.groupPolicies == nil {
+		a.groupPolicies = map[string]*GroupPolicy{}
+	}
+
+	if policy == nil {
+		delete(a.groupPolicies, group)
+		return a.Database.Delete("auth_grouppolicy", group)
+	}
+
+	if (policy.SessionMaxAge != nil && *policy.SessionMaxAge < 0) || (policy.MaxConcurrentSessions != nil && *policy.MaxConcurrentSessions < 0) {
+		return errors.New("invalid group policy given")
+	}
+	a.groupPolicies[group] = policy
+	return a.Database.Write("auth_grouppolicy", group, policy)
+}
+
+// Get the policies of the groups the user belongs to
+func (a *AuthAgent) getUserGroupPolicies(username string) []*GroupPolicy {
+	results := []*GroupPolicy{}
+	a.groupPolicyMutex.RLock()
+	policyCount := len(a.groupPolicies)
+	a.groupPolicyMutex.RUnlock()
+	if policyCount == 0 {
+		//No group policy set, skip the group lookup
+		return results
+	}
+
+	usergroups := []string{}
+	a.Database.Read("auth", "group/"+username, &usergroups)
+	for _, group := range usergroups {
+		if thisPolicy := a.GetGroupPolicy(group); thisPolicy != nil {
+			results = append(results, thisPolicy)
+		}
+	}
+	return results
+}
+
+// Get the absolute session lifetime of the user in seconds, 0 means never expire
+func (a *AuthAgent) GetUserSessionMaxAge(username string) int64 {
+	groupMaxAgeFound := false
+	maxAge := int64(0)
+	for _, thisPolicy := range a.getUserGroupPolicies(username) {
+		if thisPolicy.SessionMaxAge == nil {
+			continue
+		}
+		groupMaxAge := *thisPolicy.SessionMaxAge
+		if !groupMaxAgeFound || (groupMaxAge > 0 && (maxAge == 0 || groupMaxAge < maxAge)) {
+			maxAge = groupMaxAge
+		}
+		groupMaxAgeFound = true
+	}
+
+	if groupMaxAgeFound {
+		return maxAge
+	}
+	return a.SessionMaxAge
+}
+
+// Check if the user is allowed to login with autologin tokens
+func (a *AuthAgent) UserAllowAutoLogin(username string) bool {
+	if !a.AllowAutoLogin {
+		return false
+	}
+	for _, thisPolicy := range a.getUserGroupPolicies(username) {
+		if thisPolicy.DisableAutoLogin {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if the user must provide a 2FA code on every login
+func (a *AuthAgent) UserRequire2FA(username string) bool {
+	for _, thisPolicy := range a.getUserGroupPolicies(username) {
+		if thisPolicy.Require2FA {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle the group policy settings. Accept POST group and policy (JSON), or remove=true to remove the group policy.
+// Leave group empty for listing all policies
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleGroupPolicySettings(w http.ResponseWriter, r *http.Request) {
+	group, err := utils.PostPara(r, "group")
+	if err != nil {
+		//Read mode
+		a.groupPolicyMutex.RLock()
+		js, _ := json.Marshal(a.groupPolicies)
+		a.groupPolicyMutex.RUnlock()
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	remove, _ := utils.PostPara(r, "remove")
+	if remove == "true" {
+		err = a.SetGroupPolicy(group, nil)
+		if err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+		log.Println("[System Auth] Group policy of " + group + " removed")
+		sendOK(w)
+		return
+	}
+
+	policyJSON, err := utils.PostPara(r, "policy")
+	if err != nil {
+		sendErrorResponse(w, "Invalid policy given")
+		return
+	}
+
+	newPolicy := GroupPolicy{}
+	err = json.Unmarshal([]byte(policyJSON), &newPolicy)
+	if err != nil {
+		sendErrorResponse(w, "Invalid policy given")
+		return
+	}
+
+	err = a.SetGroupPolicy(group, &newPolicy)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Group policy of " + group + " updated")
+	sendOK(w)
+}
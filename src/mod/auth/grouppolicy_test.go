@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestGroupPolicy(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_sessionconf", "auth_grouppolicy")
+	a.AllowAutoLogin = true
+	a.SessionMaxAge = 86400
+	a.MaxConcurrentSessions = 3
+	a.Database.Write("auth", "group/alice", []string{"administrator", "user"})
+	a.Database.Write("auth", "group/guest", []string{"guest"})
+
+	//Without group policies, the global settings apply
+	if a.GetUserSessionMaxAge("alice") != 86400 || !a.UserAllowAutoLogin("guest") || a.UserRequire2FA("alice") || a.GetUserMaxConcurrentSessions("alice") != 3 {
+		t.Fatal("Expected global settings without group policies")
+	}
+
+	adminMaxAge := int64(3600)
+	userMaxAge := int64(0)
+	adminSessions := 1
+	a.SetGroupPolicy("administrator", &GroupPolicy{SessionMaxAge: &adminMaxAge, MaxConcurrentSessions: &adminSessions, Require2FA: true})
+	a.SetGroupPolicy("user", &GroupPolicy{SessionMaxAge: &userMaxAge})
+	a.SetGroupPolicy("guest", &GroupPolicy{DisableAutoLogin: true})
+
+	//Shortest lifetime applies
+	if maxAge := a.GetUserSessionMaxAge("alice"); maxAge != 3600 {
+		t.Errorf("Expected the shortest session lifetime 3600, got %d", maxAge)
+	}
+	if a.GetUserSessionMaxAge("guest") != 86400 {
+		t.Error("Expected global session lifetime for group policy without lifetime")
+	}
+	if limit := a.GetUserMaxConcurrentSessions("alice"); limit != 1 {
+		t.Errorf("Expected group policy session limit 1, got %d", limit)
+	}
+	if !a.UserRequire2FA("alice") || a.UserRequire2FA("guest") {
+		t.Error("Expected 2FA required only for administrators")
+	}
+	if a.UserAllowAutoLogin("guest") || !a.UserAllowAutoLogin("alice") {
+		t.Error("Expected autologin disabled only for guests")
+	}
+
+	//Policies are persisted and removable
+	a.loadGroupPolicies()
+	if a.GetGroupPolicy("guest") == nil || !a.GetGroupPolicy("guest").DisableAutoLogin {
+		t.Fatal("Expected group policy to be loaded from database")
+	}
+	a.SetGroupPolicy("guest", nil)
+	a.loadGroupPolicies()
+	if a.GetGroupPolicy("guest") != nil || !a.UserAllowAutoLogin("guest") {
+		t.Error("Expected group policy to be removed")
+	}
+}
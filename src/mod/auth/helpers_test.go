@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"imuslab.com/arozos/mod/database"
+)
+
+// Create an auth agent on a temporary database with the given tables. The session store,
+// name and cache are set for the tests logging in users, other fields are left to the test
+func newTestAuthAgent(t testing.TB, tables ...string) *AuthAgent {
+	t.Helper()
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "auth.db"), false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() {
+		sysdb.Close()
+	})
+	for _, table := range tables {
+		sysdb.NewTable(table)
+	}
+
+	return &AuthAgent{
+		Database:     sysdb,
+		SessionStore: sessions.NewCookieStore([]byte("0123456789abcdef")),
+		SessionName:  "ao_auth",
+		SessionCache: NewSessionCache(16, time.Minute),
+	}
+}
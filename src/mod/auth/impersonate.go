@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	User Impersonation
+
+	This script allow admins to assume the session of another user for troubleshooting.
+	The impersonation session is created for the target user, so the target's
+	group permissions apply instead of the admin's. The admin username is kept in
+	the session record (see SessionRecord.Impersonator) for returning to the admin account.
+
+	Impersonation sessions do not count toward the target's concurrent session limit
+	and do not update the target's last login time.
+
+	Starting an impersonation requires the admin's password or a step-up token.
+	Every start and stop is written to the audit log with the admin as actor
+	and the impersonated user as target.
+*/
+
+// Get the admin impersonating the current user with this request, return empty string if not impersonating
+func (a *AuthAgent) GetImpersonator(r *http.Request) string {
+	if a.requestIsHeadless(r) {
+		return ""
+	}
+
+	sessionRecord, err := a.GetSessionRecord(a.getRequestSessionID(r))
+	if err != nil {
+		return ""
+	}
+	return sessionRecord.Impersonator
+}
+
+// Handle impersonation start, require POST username and the admin's password in POST password unless a step-up token
+// is given (see stepup.go). Optional POST reason is written to the audit log
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (m *SwitchableAccountPoolManager) HandleImpersonateStart(w http.ResponseWriter, r *http.Request) {
+	if m.authAgent.requestIsHeadless(r) {
+		utils.SendErrorResponse(w, "impersonation is not supported with API key or client certificate")
+		return
+	}
+
+	adminUsername, err := m.authAgent.GetUserName(w, r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	if m.authAgent.GetImpersonator(r) != "" {
+		utils.SendErrorResponse(w, "already impersonating another user")
+		return
+	}
+
+	targetUsername, err := utils.PostPara(r, "username")
+	if err != nil {
+		utils.SendErrorResponse(w, "invalid or empty username given")
+		return
+	}
+	reason, _ := utils.PostPara(r, "reason")
+
+	if targetUsername == adminUsername {
+		utils.SendErrorResponse(w, "cannot impersonate yourself")
+		return
+	}
+
+	if !m.authAgent.UserExists(targetUsername) {
+		m.authAgent.LogAuditEvent(r, AuditActionImpersonStart, adminUsername, targetUsername, false, "user not exists")
+		utils.SendErrorResponse(w, "user not exists")
+		return
+	}
+
+	if m.authAgent.UserIsDisabled(targetUsername) {
+		m.authAgent.LogAuditEvent(r, AuditActionImpersonStart, adminUsername, targetUsername, false, "account disabled")
+		utils.SendErrorResponse(w, "target account is disabled")
+		return
+	}
+
+	//Impersonation gives access to another account, confirm with the admin's password as other sensitive operations
+	if !m.authAgent.ValidateStepUpToken(r, adminUsername) {
+		password, err := utils.PostPara(r, "password")
+		if err != nil || !m.authAgent.ValidateUsernameAndPassword(adminUsername, password) {
+			m.authAgent.LogAuditEvent(r, AuditActionImpersonStart, adminUsername, targetUsername, false, "Password confirmation failed")
+			utils.SendErrorResponse(w, "Password confirmation failed")
+			return
+		}
+	}
+
+	err = m.authAgent.loginUser(w, r, targetUsername, false, adminUsername)
+	if err != nil {
+		m.authAgent.LogAuditEvent(r, AuditActionImpersonStart, adminUsername, targetUsername, false, err.Error())
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+	m.authAgent.LogAuditEvent(r, AuditActionImpersonStart, adminUsername, targetUsername, true, reason)
+	log.Println("[System Auth] " + adminUsername + " started impersonating " + targetUsername)
+	utils.SendOK(w)
+}
+
+// Handle impersonation stop and return to the admin account
+func (m *SwitchableAccountPoolManager) HandleImpersonateStop(w http.ResponseWriter, r *http.Request) {
+	adminUsername := m.authAgent.GetImpersonator(r)
+	if adminUsername == "" {
+		utils.SendErrorResponse(w, "not impersonating any user")
+		return
+	}
+
+	targetUsername, err := m.authAgent.GetUserName(w, r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	if !m.authAgent.UserExists(adminUsername) || m.authAgent.UserIsDisabled(adminUsername) {
+		//Admin account removed or disabled during impersonation. Logout instead
+		m.authAgent.Logout(w, r)
+		m.authAgent.LogAuditEvent(r, AuditActionImpersonStop, adminUsername, targetUsername, true, "admin account no longer available")
+		utils.SendErrorResponse(w, "admin account no longer available, logged out")
+		return
+	}
+
+	err = m.authAgent.LoginUserByRequest(w, r, adminUsername, false)
+	if err != nil {
+		//Admin account not allowed to login at this time. Logout instead
+		m.authAgent.Logout(w, r)
+		m.authAgent.LogAuditEvent(r, AuditActionImpersonStop, adminUsername, targetUsername, true, err.Error())
+		utils.SendErrorResponse(w, err.Error()+", logged out")
+		return
+	}
+	m.authAgent.LogAuditEvent(r, AuditActionImpersonStop, adminUsername, targetUsername, true, "")
+	log.Println("[System Auth] " + adminUsername + " stopped impersonating " + targetUsername)
+	utils.SendOK(w)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestImpersonation(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	a := newTestAuthAgent(t, "auth", "auth_sessions")
+	a.MaxConcurrentSessions = 1
+	a.SwitchableAccountManager = NewSwitchableAccountPoolManager(a.Database, a, key)
+	a.CreateUserAccount("admin", "password", []string{"administrator"})
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	newRequest := func(cookies []*http.Cookie, form url.Values) *http.Request {
+		r := httptest.NewRequest("POST", "/system/auth/impersonate", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return r
+	}
+
+	//Alice already logged in on another device
+	w := httptest.NewRecorder()
+	a.LoginUserByRequest(w, newRequest(nil, nil), "alice", false)
+	aliceSessions := a.ListUserSessions("alice")
+
+	w = httptest.NewRecorder()
+	a.LoginUserByRequest(w, newRequest(nil, nil), "admin", false)
+	adminCookies := w.Result().Cookies()
+
+	//Impersonation require the admin's password
+	w = httptest.NewRecorder()
+	a.SwitchableAccountManager.HandleImpersonateStart(w, newRequest(adminCookies, url.Values{"username": {"alice"}, "password": {"wrongpassword"}}))
+	if !strings.Contains(w.Body.String(), "error") || len(w.Result().Cookies()) != 0 {
+		t.Fatal("Expected impersonation rejected without the admin's password")
+	}
+
+	w = httptest.NewRecorder()
+	a.SwitchableAccountManager.HandleImpersonateStart(w, newRequest(adminCookies, url.Values{"username": {"alice"}, "password": {"password"}}))
+	if strings.Contains(w.Body.String(), "error") {
+		t.Fatalf("Failed to start impersonation: %s", w.Body.String())
+	}
+	impersonateCookies := w.Result().Cookies()
+
+	r := newRequest(impersonateCookies, nil)
+	if username, _ := a.GetUserName(nil, r); username != "alice" {
+		t.Errorf("Expected impersonated session of alice, got %s", username)
+	}
+	if a.GetImpersonator(r) != "admin" {
+		t.Errorf("Expected impersonator to be admin, got %q", a.GetImpersonator(r))
+	}
+	if a.CheckAuth(newRequest(adminCookies, nil)) {
+		t.Error("Expected admin session to be replaced by the impersonation session")
+	}
+	if _, err := a.GetSessionRecord(aliceSessions[0].ID); err != nil {
+		t.Error("Expected impersonation not to evict the sessions of the target user")
+	}
+
+	w = httptest.NewRecorder()
+	a.SwitchableAccountManager.HandleImpersonateStart(w, newRequest(impersonateCookies, url.Values{"username": {"admin"}}))
+	if !strings.Contains(w.Body.String(), "error") {
+		t.Error("Expected nested impersonation to be rejected")
+	}
+
+	w = httptest.NewRecorder()
+	a.SwitchableAccountManager.HandleImpersonateStop(w, newRequest(impersonateCookies, nil))
+	if strings.Contains(w.Body.String(), "error") {
+		t.Fatalf("Failed to stop impersonation: %s", w.Body.String())
+	}
+	r = newRequest(w.Result().Cookies(), nil)
+	if username, _ := a.GetUserName(nil, r); username != "admin" || a.GetImpersonator(r) != "" {
+		t.Errorf("Expected to return to admin account, got %s", username)
+	}
+	if a.CheckAuth(newRequest(impersonateCookies, nil)) {
+		t.Error("Expected impersonation session to be revoked")
+	}
+
+	w = httptest.NewRecorder()
+	a.SwitchableAccountManager.HandleImpersonateStop(w, r)
+	if !strings.Contains(w.Body.String(), "error") {
+		t.Error("Expected stop without impersonation to be rejected")
+	}
+}
@@ -19,6 +19,7 @@ func (ldap *ldapHandler) HandleLoginPage(w http.ResponseWriter, r *http.Request)
 	}
 	//load the template from file and inject necessary variables
 	red, _ := utils.GetPara(r, "redirect")
+	red = ldap.ag.SanitizeRedirectTarget(r, red)
 
 	//Append the redirection addr into the template
 	imgsrc := "./web/" + ldap.iconSystem
@@ -130,7 +131,12 @@ func (ldap *ldapHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 			utils.SendJSONResponse(w, "{\"redirect\":\"system/auth/ldap/newPassword?username="+username+"&displayname="+username+"&authkey="+authkey+"\"}")
 		} else {
 			// Set user as authenticated
-			ldap.ag.LoginUserByRequest(w, r, username, rememberme)
+			err = ldap.ag.LoginUserByRequest(w, r, username, rememberme)
+			if err != nil {
+				ldap.ag.Logger.LogAuth(r, false)
+				utils.SendErrorResponse(w, err.Error())
+				return
+			}
 			//Print the login message to console
 			log.Println(username + " logged in.")
 			ldap.ag.Logger.LogAuth(r, true)
@@ -185,8 +191,13 @@ func (ldap *ldapHandler) HandleSetPassword(w http.ResponseWriter, r *http.Reques
 			convertedInfo := ldap.convertGroup(ldapUser)
 			//create user account and login
 			ldap.ag.CreateUserAccount(username, password, convertedInfo.EquivGroup)
+			err = ldap.ag.LoginUserByRequest(w, r, username, false)
+			if err != nil {
+				ldap.ag.Logger.LogAuth(r, false)
+				utils.SendErrorResponse(w, err.Error())
+				return
+			}
 			ldap.ag.Logger.LogAuth(r, true)
-			ldap.ag.LoginUserByRequest(w, r, username, false)
 			utils.SendOK(w)
 			return
 		} else {
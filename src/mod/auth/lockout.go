@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Account Lockout
+
+	Lock an account after too many failed logins. Unlike the
+	exponential login delay, a locked account cannot login
+	until it is unlocked by an administrator (or by the nightly
+	reset if LockoutNightlyClear is enabled)
+
+	Incorrect passwords given to the other services checking the
+	password (e.g. WebDAV, FTP, account switching) are counted as
+	failed logins, and locked accounts are rejected by them as well.
+
+	The lock records are stored as
+	auth/locked/{username} => AccountLock
+*/
+
+type AccountLock struct {
+	LockTime int64  //Unix time when the account is locked
+	Failures int    //Number of failed logins before the lock
+	Reason   string //Reason of the lock
+}
+
+type LockedAccount struct {
+	Username string
+	AccountLock
+}
+
+// Reason returned to the client when a locked account try to login
+const accountLockedReason = "Account locked due to too many failed login attempts. Please contact your system administrator"
+
+// Check if the user account is locked
+func (a *AuthAgent) UserIsLocked(username string) bool {
+	return a.Database.KeyExists("auth", "locked/"+username)
+}
+
+// Lock the user account with the given reason
+func (a *AuthAgent) LockUserAccount(username string, failures int, reason string) error {
+	if !a.UserExists(username) {
+		return errors.New("user not exists")
+	}
+
+	lock := AccountLock{
+		LockTime: time.Now().Unix(),
+		Failures: failures,
+		Reason:   reason,
+	}
+	err := a.Database.Write("auth", "locked/"+username, lock)
+	if err != nil {
+		return err
+	}
+
+	//Kick out all existing sessions of the locked account
+	a.RevokeAllUserSessions(username)
+	a.SessionCache.InvalidateUser(username)
+
+	log.Println("[System Auth] Account " + username + " locked: " + reason)
+	if a.AccountLockedHandler != nil {
+		go a.AccountLockedHandler(username, lock)
+	}
+	return nil
+}
+
+// Unlock the user account and reset its failure counter
+func (a *AuthAgent) UnlockUserAccount(username string) error {
+	if !a.UserIsLocked(username) {
+		return errors.New("account is not locked")
+	}
+
+	a.accountFailureMutex.Lock()
+	delete(a.accountFailures, username)
+	a.accountFailureMutex.Unlock()
+
+	return a.Database.Delete("auth", "locked/"+username)
+}
+
+// List all the locked accounts
+func (a *AuthAgent) ListLockedAccounts() []*LockedAccount {
+	results := []*LockedAccount{}
+	entries, err := a.Database.ListTable("auth")
+	if err != nil {
+		return results
+	}
+
+	for _, keypairs := range entries {
+		key := string(keypairs[0])
+		if !strings.HasPrefix(key, "locked/") {
+			continue
+		}
+
+		thisLock := AccountLock{}
+		if json.Unmarshal(keypairs[1], &thisLock) != nil {
+			continue
+		}
+		results = append(results, &LockedAccount{
+			Username:    strings.TrimPrefix(key, "locked/"),
+			AccountLock: thisLock,
+		})
+	}
+	return results
+}
+
+// Record a failed login of the user, lock the account if the threshold is reached.
+// Return true if the account is locked by this failure
+func (a *AuthAgent) recordUserLoginFailure(r *http.Request, username string) bool {
+	if a.LockoutThreshold <= 0 || !a.UserExists(username) {
+		return false
+	}
+
+	a.accountFailureMutex.Lock()
+	if a.accountFailures == nil {
+		a.accountFailures = map[string]int{}
+	}
+	a.accountFailures[username]++
+	failures := a.accountFailures[username]
+	if failures < a.LockoutThreshold {
+		a.accountFailureMutex.Unlock()
+		return false
+	}
+	delete(a.accountFailures, username)
+	a.accountFailureMutex.Unlock()
+
+	reason := strconv.Itoa(failures) + " consecutive failed login attempts"
+	err := a.LockUserAccount(username, failures, reason)
+	if err != nil {
+		log.Println("[System Auth] Unable to lock account " + username + ": " + err.Error())
+		return false
+	}
+	a.LogAuditEvent(r, AuditActionLock, "", username, true, reason)
+	return true
+}
+
+// Reset the failure counter of the user after a successful login
+func (a *AuthAgent) resetUserLoginFailure(username string) {
+	a.accountFailureMutex.Lock()
+	delete(a.accountFailures, username)
+	a.accountFailureMutex.Unlock()
+}
+
+// Reset all login retry counters, also clear the account lockouts if LockoutNightlyClear is set
+func (a *AuthAgent) ResetAllUserRetryCounter() {
+	a.ExpDelayHandler.ResetAllUserRetryCounter()
+
+	a.accountFailureMutex.Lock()
+	a.accountFailures = map[string]int{}
+	a.accountFailureMutex.Unlock()
+
+	a.captchaFailureMutex.Lock()
+	a.captchaFailures = map[string]int{}
+	a.captchaFailureMutex.Unlock()
+
+	if a.LockoutNightlyClear {
+		for _, lockedAccount := range a.ListLockedAccounts() {
+			a.UnlockUserAccount(lockedAccount.Username)
+			log.Println("[System Auth] Account " + lockedAccount.Username + " unlocked by nightly reset")
+		}
+	}
+}
+
+// Handle listing of the locked accounts
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleListLockedAccounts(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(a.ListLockedAccounts())
+	sendJSONResponse(w, string(js))
+}
+
+// Handle unlocking of an account. Accept POST username
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		sendErrorResponse(w, "Invalid username given")
+		return
+	}
+
+	err = a.UnlockUserAccount(username)
+	if err != nil {
+		a.LogAuditEventByRequest(r, AuditActionUnlock, username, false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	a.LogAuditEventByRequest(r, AuditActionUnlock, username, true, "")
+	sendOK(w)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"imuslab.com/arozos/mod/auth/explogin"
+)
+
+func TestAccountLockout(t *testing.T) {
+	lockedUsers := make(chan string, 1)
+	a := newTestAuthAgent(t, "auth")
+	a.ExpDelayHandler = explogin.NewExponentialLoginHandler(2, 10800)
+	a.LockoutThreshold = 3
+	a.accountFailures = map[string]int{}
+	a.AccountLockedHandler = func(username string, lock AccountLock) {
+		lockedUsers <- username
+	}
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	//Unknown users are never locked
+	for i := 0; i < 5; i++ {
+		if a.recordUserLoginFailure(nil, "nobody") {
+			t.Fatal("Expected unknown user not to be locked")
+		}
+	}
+
+	//Successful login reset the counter
+	a.recordUserLoginFailure(nil, "alice")
+	a.recordUserLoginFailure(nil, "alice")
+	a.resetUserLoginFailure("alice")
+	if a.recordUserLoginFailure(nil, "alice") || a.recordUserLoginFailure(nil, "alice") {
+		t.Fatal("Expected account not to be locked before reaching the threshold")
+	}
+	if !a.recordUserLoginFailure(nil, "alice") || !a.UserIsLocked("alice") {
+		t.Fatal("Expected account to be locked after reaching the threshold")
+	}
+
+	select {
+	case username := <-lockedUsers:
+		if username != "alice" {
+			t.Errorf("Expected lock event of alice, got %q", username)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected lock event to be emitted")
+	}
+
+	locked := a.ListLockedAccounts()
+	if len(locked) != 1 || locked[0].Username != "alice" || locked[0].Failures != 3 {
+		t.Fatalf("Expected alice in locked account list, got %v", locked)
+	}
+	if len(a.ListUsers()) != 1 {
+		t.Error("Lock record should not be listed as a user")
+	}
+
+	//Nightly reset only clear lockouts if enabled
+	a.ResetAllUserRetryCounter()
+	if !a.UserIsLocked("alice") {
+		t.Error("Expected lockout to persist after nightly reset")
+	}
+	a.LockoutNightlyClear = true
+	a.ResetAllUserRetryCounter()
+	if a.UserIsLocked("alice") {
+		t.Error("Expected lockout to be cleared by nightly reset")
+	}
+
+	if a.UnlockUserAccount("alice") == nil {
+		t.Error("Expected error when unlocking an account that is not locked")
+	}
+
+	//Password checks of other services (e.g. WebDAV, FTP) count toward the lockout and honor it
+	for i := 0; i < 2; i++ {
+		a.ValidateUsernameAndPassword("alice", "wrongpassword")
+	}
+	if a.UserIsLocked("alice") {
+		t.Fatal("Expected account not to be locked before reaching the threshold")
+	}
+	if ok, reason := a.ValidateUsernameAndPasswordWithReason("alice", "wrongpassword"); ok || reason != a.LoginRejectionReason(accountLockedReason) || !a.UserIsLocked("alice") {
+		t.Fatalf("Expected account locked by failed password checks, got %s", reason)
+	}
+	if a.ValidateUsernameAndPassword("alice", "password") {
+		t.Error("Expected locked account rejected with correct password")
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Maintenance Mode
+
+	Block the non-admin users during upgrades while the administrators can
+	still login. While maintenance mode is on, login of non-admin users
+	(password, passkey, OpenID Connect, autologin and account switching)
+	is rejected with AUTH_MAINTENANCE, and their active sessions are paused:
+	protected endpoints are sent to the MaintenanceRedirectionHandler
+	(or replied with the maintenance error) instead of being revoked, so
+	the users continue where they left off once the maintenance is over.
+
+	auth_policy/maintenance => MaintenanceMode
+*/
+
+const defaultMaintenanceMessage = "System under maintenance. Please try again later."
+
+type MaintenanceMode struct {
+	Enabled bool
+	Message string //Message shown to the non-admin users, default message if empty
+	Since   int64  //Unix time maintenance mode is turned on
+	By      string //Admin turned on maintenance mode
+}
+
+// Endpoints accessible by paused sessions during maintenance
+var maintenanceExemptPaths = []string{
+	"/system/auth/logout",
+	"/system/auth/checkLogin",
+}
+
+// Load the maintenance mode from database, warn if the system is started under maintenance
+func (a *AuthAgent) loadMaintenanceMode() {
+	if !a.Database.KeyExists("auth_policy", "maintenance") {
+		return
+	}
+	mode := MaintenanceMode{}
+	a.Database.Read("auth_policy", "maintenance", &mode)
+	a.maintenanceMutex.Lock()
+	a.maintenance = mode
+	a.maintenanceMutex.Unlock()
+	if mode.Enabled {
+		log.Println("[System Auth] Maintenance mode is on since " + time.Unix(mode.Since, 0).Format(time.RFC3339) + ". Only administrators can login")
+	}
+}
+
+// Get the current maintenance mode
+func (a *AuthAgent) GetMaintenanceMode() MaintenanceMode {
+	a.maintenanceMutex.RLock()
+	defer a.maintenanceMutex.RUnlock()
+	return a.maintenance
+}
+
+// Turn maintenance mode on or off and save it. by is the admin changing the mode
+func (a *AuthAgent) SetMaintenanceMode(enabled bool, message string, by string) error {
+	mode := MaintenanceMode{}
+	if enabled {
+		mode = MaintenanceMode{
+			Enabled: true,
+			Message: strings.TrimSpace(message),
+			Since:   time.Now().Unix(),
+			By:      by,
+		}
+	}
+	err := a.Database.Write("auth_policy", "maintenance", mode)
+	if err != nil {
+		return err
+	}
+
+	a.maintenanceMutex.Lock()
+	a.maintenance = mode
+	a.maintenanceMutex.Unlock()
+	return nil
+}
+
+// Get the message shown to the users blocked by maintenance mode
+func (a *AuthAgent) GetMaintenanceMessage() string {
+	message := a.GetMaintenanceMode().Message
+	if message == "" {
+		return defaultMaintenanceMessage
+	}
+	return message
+}
+
+// Check if the user is blocked by maintenance mode. Administrators are never blocked
+func (a *AuthAgent) MaintenanceBlocksUser(username string) bool {
+	if !a.GetMaintenanceMode().Enabled {
+		return false
+	}
+	return !a.UserIsAdmin(username)
+}
+
+// Check if the logged in user of this request is paused by maintenance mode
+func (a *AuthAgent) MaintenancePending(w http.ResponseWriter, r *http.Request) bool {
+	if !a.GetMaintenanceMode().Enabled {
+		return false
+	}
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		return false
+	}
+	return a.MaintenanceBlocksUser(username)
+}
+
+// Check if the endpoint is accessible by sessions paused by maintenance mode
+func isMaintenanceExempt(path string) bool {
+	for _, exemptPath := range maintenanceExemptPaths {
+		if path == exemptPath {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle the maintenance mode. Leave enabled empty for reading the current mode,
+// POST enabled=true and optional message to turn it on, enabled=false to turn it off
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleMaintenanceMode(w http.ResponseWriter, r *http.Request) {
+	enabled, err := utils.PostPara(r, "enabled")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.GetMaintenanceMode())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	if enabled != "true" && enabled != "false" {
+		sendErrorResponse(w, "Invalid enabled value given")
+		return
+	}
+
+	adminUsername, _ := a.GetUserName(w, r)
+	message, _ := utils.PostPara(r, "message")
+	err = a.SetMaintenanceMode(enabled == "true", message, adminUsername)
+	if err != nil {
+		a.LogAuditEvent(r, AuditActionMaintenance, adminUsername, "*", false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	if enabled == "true" {
+		log.Println("[System Auth] Maintenance mode turned on by " + adminUsername + ". Non-admin users are blocked until it is turned off")
+		a.LogAuditEvent(r, AuditActionMaintenance, adminUsername, "*", true, "on: "+a.GetMaintenanceMessage())
+	} else {
+		log.Println("[System Auth] Maintenance mode turned off by " + adminUsername)
+		a.LogAuditEvent(r, AuditActionMaintenance, adminUsername, "*", true, "off")
+	}
+	sendOK(w)
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_policy")
+	a.SwitchableAccountManager = NewSwitchableAccountPoolManager(a.Database, a, []byte("0123456789abcdef"))
+	a.CreateUserAccount("admin", "password", []string{"administrator"})
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	//Login both users before the maintenance
+	login := func(username string) []*http.Cookie {
+		w := httptest.NewRecorder()
+		a.LoginUserByRequest(w, httptest.NewRequest("POST", "/system/auth/login", nil), username, false)
+		return w.Result().Cookies()
+	}
+	adminCookies := login("admin")
+	aliceCookies := login("alice")
+
+	handled := 0
+	checkAuth := func(path string, cookies []*http.Cookie) string {
+		r := httptest.NewRequest("GET", path, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		a.HandleCheckAuth(w, r, func(w http.ResponseWriter, r *http.Request) {
+			handled++
+		})
+		return w.Body.String()
+	}
+
+	if a.MaintenanceBlocksUser("alice") {
+		t.Fatal("Expected no user blocked outside maintenance")
+	}
+
+	a.SetMaintenanceMode(true, "", "admin")
+	if !a.MaintenanceBlocksUser("alice") || a.MaintenanceBlocksUser("admin") {
+		t.Fatal("Expected only non-admin users blocked during maintenance")
+	}
+
+	//Sessions of non-admin users are paused, except logout
+	if resp := checkAuth("/system/file_system/listDir", aliceCookies); !strings.Contains(resp, string(AuthErrMaintenance)) || !strings.Contains(resp, defaultMaintenanceMessage) {
+		t.Errorf("Expected paused session to get the maintenance error, got %s", resp)
+	}
+
+	//Admins cannot switch into non-admin accounts either
+	form := url.Values{"username": {"alice"}, "password": {"password"}}
+	r := httptest.NewRequest("POST", "/system/auth/u/switch", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range adminCookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	a.SwitchableAccountManager.HandleAccountSwitch(w, r)
+	if !strings.Contains(w.Body.String(), string(AuthErrMaintenance)) {
+		t.Errorf("Expected account switch rejected during maintenance, got %s", w.Body.String())
+	}
+
+	checkAuth("/system/auth/logout", aliceCookies)
+	checkAuth("/system/file_system/listDir", adminCookies)
+	if handled != 2 {
+		t.Errorf("Expected logout and admin request handled, got %d", handled)
+	}
+
+	//Maintenance mode is persisted
+	reloaded := &AuthAgent{Database: a.Database}
+	reloaded.loadMaintenanceMode()
+	if mode := reloaded.GetMaintenanceMode(); !mode.Enabled || mode.By != "admin" || mode.Since == 0 {
+		t.Errorf("Expected maintenance mode to be persisted, got %+v", mode)
+	}
+
+	//Paused sessions continue after the maintenance
+	a.SetMaintenanceMode(false, "", "admin")
+	checkAuth("/system/file_system/listDir", aliceCookies)
+	if handled != 3 {
+		t.Error("Expected paused session to resume after maintenance")
+	}
+}
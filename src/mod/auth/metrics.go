@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Auth Metrics
+
+	In-memory counters of the authentication events for monitoring.
+	The counters are updated by subscribing to the auth event bus (see events.go),
+	reset on restart and exposed via HandleMetrics in JSON or Prometheus
+	text format (GET format=prometheus)
+*/
+
+// Reasons of failed logins, used as the metric label
+const (
+	LoginFailureMissingCredentials = "missing_credentials"
+	LoginFailureRateLimited        = "rate_limited"
+	LoginFailureCaptcha            = "captcha"
+	LoginFailureAccountLocked      = "account_locked"
+	LoginFailureGeoBlocked         = "geo_blocked"
+	LoginFailureOriginDenied       = "origin_denied"
+	LoginFailureOutsideTimeWindow  = "outside_time_window"
+	LoginFailureMaintenance        = "maintenance"
+	LoginFailure2FARequired        = "2fa_not_enrolled"
+	LoginFailureInvalid2FA         = "invalid_2fa"
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureOther              = "other"
+)
+
+var loginFailureReasons = []string{
+	LoginFailureMissingCredentials,
+	LoginFailureRateLimited,
+	LoginFailureCaptcha,
+	LoginFailureAccountLocked,
+	LoginFailureGeoBlocked,
+	LoginFailureOriginDenied,
+	LoginFailureOutsideTimeWindow,
+	LoginFailureMaintenance,
+	LoginFailure2FARequired,
+	LoginFailureInvalid2FA,
+	LoginFailureInvalidCredentials,
+	LoginFailureOther,
+}
+
+// Get the failed login reason of the error code returned by the login, see LoginUserByRequest
+func getLoginFailureType(code AuthErrorCode) string {
+	if code == AuthErrOutsideLoginHours {
+		return LoginFailureOutsideTimeWindow
+	}
+	return LoginFailureOther
+}
+
+type AuthMetrics struct {
+	loginSuccess  uint64
+	loginFailures map[string]*uint64 //Fixed set of reasons, created once so no lock is needed
+	registrations uint64
+	manualBans    uint64
+	autoBans      uint64
+	startTime     int64
+}
+
+type AuthMetricsSnapshot struct {
+	LoginSuccess   uint64
+	LoginFailures  map[string]uint64
+	Registrations  uint64
+	ManualBans     uint64
+	AutoBans       uint64
+	ActiveSessions int
+	BannedIPs      int
+	Uptime         int64
+}
+
+func NewAuthMetrics() *AuthMetrics {
+	failures := map[string]*uint64{}
+	for _, reason := range loginFailureReasons {
+		failures[reason] = new(uint64)
+	}
+	return &AuthMetrics{
+		loginFailures: failures,
+		startTime:     time.Now().Unix(),
+	}
+}
+
+func (m *AuthMetrics) recordLoginSuccess() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.loginSuccess, 1)
+}
+
+func (m *AuthMetrics) recordLoginFailure(reason string) {
+	if m == nil {
+		return
+	}
+	counter, ok := m.loginFailures[reason]
+	if !ok {
+		counter = m.loginFailures[LoginFailureOther]
+	}
+	atomic.AddUint64(counter, 1)
+}
+
+func (m *AuthMetrics) recordRegistration() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.registrations, 1)
+}
+
+func (m *AuthMetrics) recordBan(auto bool) {
+	if m == nil {
+		return
+	}
+	if auto {
+		atomic.AddUint64(&m.autoBans, 1)
+	} else {
+		atomic.AddUint64(&m.manualBans, 1)
+	}
+}
+
+// Update the counters on the events published to the bus
+func (m *AuthMetrics) subscribe(bus *AuthEventBus) {
+	bus.Subscribe(func(event AuthEvent) {
+		switch event.Type {
+		case EventLoginSuccess:
+			m.recordLoginSuccess()
+		case EventLoginFailure:
+			m.recordLoginFailure(event.Reason)
+		case EventRegister:
+			m.recordRegistration()
+		case EventBan:
+			m.recordBan(event.Auto)
+		}
+	}, EventLoginSuccess, EventLoginFailure, EventRegister, EventBan)
+}
+
+// Get the current value of the counters and gauges
+func (a *AuthAgent) GetMetrics() *AuthMetricsSnapshot {
+	snapshot := AuthMetricsSnapshot{
+		LoginFailures: map[string]uint64{},
+	}
+	if m := a.metrics; m != nil {
+		snapshot.LoginSuccess = atomic.LoadUint64(&m.loginSuccess)
+		for reason, counter := range m.loginFailures {
+			snapshot.LoginFailures[reason] = atomic.LoadUint64(counter)
+		}
+		snapshot.Registrations = atomic.LoadUint64(&m.registrations)
+		snapshot.ManualBans = atomic.LoadUint64(&m.manualBans)
+		snapshot.AutoBans = atomic.LoadUint64(&m.autoBans)
+		snapshot.Uptime = time.Now().Unix() - m.startTime
+	}
+
+	//Gauges are calculated on request to keep the login path fast
+	now := time.Now().Unix()
+	a.sessionRecords.Range(func(key, value interface{}) bool {
+		if !a.sessionExpired(value.(*SessionRecord), now) {
+			snapshot.ActiveSessions++
+		}
+		return true
+	})
+	if a.BlacklistManager != nil {
+		snapshot.BannedIPs = len(a.BlacklistManager.ListBannedIpRanges())
+	}
+
+	return &snapshot
+}
+
+// Render the metrics in Prometheus text exposition format
+func (s *AuthMetricsSnapshot) PrometheusText() string {
+	var sb strings.Builder
+	writeMetric := func(name string, metricType string, help string, values map[string]uint64, labelName string) {
+		sb.WriteString("# HELP " + name + " " + help + "\n")
+		sb.WriteString("# TYPE " + name + " " + metricType + "\n")
+		labels := []string{}
+		for label := range values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			if labelName == "" {
+				sb.WriteString(name + " " + strconv.FormatUint(values[label], 10) + "\n")
+			} else {
+				sb.WriteString(name + "{" + labelName + "=\"" + label + "\"} " + strconv.FormatUint(values[label], 10) + "\n")
+			}
+		}
+	}
+
+	writeMetric("arozos_auth_login_success_total", "counter", "Number of successful logins", map[string]uint64{"": s.LoginSuccess}, "")
+	writeMetric("arozos_auth_login_failures_total", "counter", "Number of failed logins by reason", s.LoginFailures, "reason")
+	writeMetric("arozos_auth_registrations_total", "counter", "Number of registered accounts", map[string]uint64{"": s.Registrations}, "")
+	writeMetric("arozos_auth_bans_total", "counter", "Number of IP bans by type", map[string]uint64{"manual": s.ManualBans, "auto": s.AutoBans}, "type")
+	writeMetric("arozos_auth_active_sessions", "gauge", "Number of active login sessions", map[string]uint64{"": uint64(s.ActiveSessions)}, "")
+	writeMetric("arozos_auth_banned_ips", "gauge", "Number of banned IP ranges", map[string]uint64{"": uint64(s.BannedIPs)}, "")
+	return sb.String()
+}
+
+// Handle metrics request, GET format=prometheus for Prometheus text format, JSON otherwise
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	snapshot := a.GetMetrics()
+	format, _ := utils.GetPara(r, "format")
+	if format == "prometheus" {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(snapshot.PrometheusText()))
+		return
+	}
+
+	js, _ := json.Marshal(snapshot)
+	sendJSONResponse(w, string(js))
+}
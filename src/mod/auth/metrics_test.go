@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthMetrics(t *testing.T) {
+	a := &AuthAgent{metrics: NewAuthMetrics()}
+	a.metrics.recordLoginSuccess()
+	a.metrics.recordLoginFailure(LoginFailureInvalidCredentials)
+	a.metrics.recordLoginFailure(LoginFailureInvalidCredentials)
+	a.metrics.recordLoginFailure("unknown reason")
+	a.metrics.recordRegistration()
+	a.metrics.recordBan(true)
+
+	now := time.Now().Unix()
+	a.sessionRecords.Store("s1", &SessionRecord{ID: "s1", Username: "alice", CreationTime: now, LastSeen: now})
+
+	snapshot := a.GetMetrics()
+	if snapshot.LoginSuccess != 1 || snapshot.LoginFailures[LoginFailureInvalidCredentials] != 2 || snapshot.LoginFailures[LoginFailureOther] != 1 {
+		t.Errorf("Unexpected login counters: %+v", snapshot)
+	}
+	if snapshot.Registrations != 1 || snapshot.AutoBans != 1 || snapshot.ManualBans != 0 || snapshot.ActiveSessions != 1 {
+		t.Errorf("Unexpected counters: %+v", snapshot)
+	}
+
+	text := snapshot.PrometheusText()
+	for _, line := range []string{
+		"arozos_auth_login_success_total 1\n",
+		"arozos_auth_login_failures_total{reason=\"invalid_credentials\"} 2\n",
+		"arozos_auth_bans_total{type=\"auto\"} 1\n",
+		"arozos_auth_active_sessions 1\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("Expected %q in Prometheus output", line)
+		}
+	}
+
+	//Agents without metrics must not panic
+	var nilMetrics *AuthMetrics
+	nilMetrics.recordLoginSuccess()
+}
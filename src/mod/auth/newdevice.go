@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	New Device Login Notification
+
+	Notify the user when the account is logged in from a device, i.e. a
+	combination of IP address and User-Agent, that is not seen before.
+	The known devices of a user are bootstrapped from the connection log
+	so existing users are not notified for the IPs they used before.
+
+	The records are stored as
+	auth_knowndevice/devices/{username} => []KnownDevice
+	auth_knowndevice/optout/{username} => true
+
+	Devices not seen for KnownDeviceTTL seconds are forgotten and at most
+	MaxKnownDevices devices are kept per user, least recently seen first out
+*/
+
+// Hook for notifying the user about a login from a new device
+type NewDeviceNotifier func(username string, device KnownDevice) error
+
+type KnownDevice struct {
+	ID        string //ID of this device for listing and forgetting
+	IpAddr    string //IP address of the device
+	UserAgent string //User-Agent of the device
+	FirstSeen int64  //First login time from this device
+	LastSeen  int64  //Last login time from this device
+}
+
+// Get the ID of the device with the given IP address and User-Agent
+func getKnownDeviceID(ipAddr string, userAgent string) string {
+	return Hash(ipAddr + "|" + userAgent)[:16]
+}
+
+// List the known devices of the user, most recently seen first
+func (a *AuthAgent) ListKnownDevices(username string) []KnownDevice {
+	devices := []KnownDevice{}
+	if !a.Database.KeyExists("auth_knowndevice", "devices/"+username) {
+		return devices
+	}
+	a.Database.Read("auth_knowndevice", "devices/"+username, &devices)
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].LastSeen > devices[j].LastSeen
+	})
+	return devices
+}
+
+// Write the known devices of the user, the expired and excess devices are pruned
+func (a *AuthAgent) writeKnownDevices(username string, devices []KnownDevice) error {
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].LastSeen > devices[j].LastSeen
+	})
+
+	results := []KnownDevice{}
+	now := time.Now().Unix()
+	for _, thisDevice := range devices {
+		if a.KnownDeviceTTL > 0 && thisDevice.LastSeen+a.KnownDeviceTTL < now {
+			continue
+		}
+		if a.MaxKnownDevices > 0 && len(results) >= a.MaxKnownDevices {
+			break
+		}
+		results = append(results, thisDevice)
+	}
+
+	if len(results) == 0 {
+		return a.Database.Delete("auth_knowndevice", "devices/"+username)
+	}
+	return a.Database.Write("auth_knowndevice", "devices/"+username, results)
+}
+
+// Record the device sending this request as a known device of the user.
+// Return the device record and true if it is not seen before
+func (a *AuthAgent) recordKnownDevice(r *http.Request, username string) (KnownDevice, bool) {
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		clientIP = "unknown"
+	}
+
+	now := time.Now().Unix()
+	thisDevice := KnownDevice{
+		ID:        getKnownDeviceID(clientIP, r.UserAgent()),
+		IpAddr:    clientIP,
+		UserAgent: r.UserAgent(),
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+
+	//Keep the current device in front so it is not pruned in favor of devices seen in the same second
+	devices := a.ListKnownDevices(username)
+	for i, knownDevice := range devices {
+		if knownDevice.ID == thisDevice.ID {
+			knownDevice.LastSeen = now
+			a.writeKnownDevices(username, append([]KnownDevice{knownDevice}, append(devices[:i], devices[i+1:]...)...))
+			return knownDevice, false
+		}
+	}
+
+	isNew := true
+	if len(devices) == 0 {
+		//No known devices yet. Use the connection log history to avoid notifying
+		//on the first login of the account or from the IPs used before
+		isNew = a.hasLoginFromOtherIP(username, clientIP)
+	}
+
+	a.writeKnownDevices(username, append([]KnownDevice{thisDevice}, devices...))
+	return thisDevice, isNew
+}
+
+// Check the connection log if the user logged in before, but never from the given IP
+func (a *AuthAgent) hasLoginFromOtherIP(username string, ipAddr string) bool {
+	if a.Logger == nil {
+		return false
+	}
+	userSeen, ipSeen := a.Logger.HasLoginFrom(username, ipAddr)
+	return userSeen && !ipSeen
+}
+
+// Check if the login is from a new device and notify the user if enabled
+func (a *AuthAgent) checkNewDeviceLogin(r *http.Request, username string) {
+	thisDevice, isNew := a.recordKnownDevice(r, username)
+	if !isNew || a.SendNewDeviceNotice == nil || !a.NewDeviceNotificationEnabled(username) {
+		return
+	}
+
+	log.Println("[System Auth] " + username + " logged in from a new device at " + thisDevice.IpAddr)
+	go func() {
+		err := a.SendNewDeviceNotice(username, thisDevice)
+		if err != nil {
+			log.Println("[System Auth] Unable to send new device notification to " + username + ": " + err.Error())
+		}
+	}()
+}
+
+// Check if the user want to be notified on login from new devices, enabled by default
+func (a *AuthAgent) NewDeviceNotificationEnabled(username string) bool {
+	return !a.Database.KeyExists("auth_knowndevice", "optout/"+username)
+}
+
+// Opt in or out the new device login notification of the user
+func (a *AuthAgent) SetNewDeviceNotification(username string, enabled bool) error {
+	if enabled {
+		return a.Database.Delete("auth_knowndevice", "optout/"+username)
+	}
+	return a.Database.Write("auth_knowndevice", "optout/"+username, true)
+}
+
+// Forget a known device of the user by its ID, the next login from it will be notified again
+func (a *AuthAgent) ForgetKnownDevice(username string, deviceID string) error {
+	devices := a.ListKnownDevices(username)
+	for i, thisDevice := range devices {
+		if thisDevice.ID == deviceID {
+			return a.writeKnownDevices(username, append(devices[:i], devices[i+1:]...))
+		}
+	}
+	return errors.New("known device not found")
+}
+
+// Forget all known devices and the notification preference of the user
+func (a *AuthAgent) RemoveUserKnownDevices(username string) {
+	a.Database.Delete("auth_knowndevice", "devices/"+username)
+	a.Database.Delete("auth_knowndevice", "optout/"+username)
+}
+
+// Remove the known devices that are not seen for KnownDeviceTTL seconds
+func (a *AuthAgent) RemoveExpiredKnownDevices() {
+	if !a.Database.TableExists("auth_knowndevice") {
+		return
+	}
+	entries, err := a.Database.ListTable("auth_knowndevice")
+	if err != nil {
+		return
+	}
+
+	for _, keypairs := range entries {
+		key := string(keypairs[0])
+		if !strings.HasPrefix(key, "devices/") {
+			continue
+		}
+		devices := []KnownDevice{}
+		if json.Unmarshal(keypairs[1], &devices) != nil {
+			continue
+		}
+		a.writeKnownDevices(strings.TrimPrefix(key, "devices/"), devices)
+	}
+}
+
+/*
+	Known Device Handlers
+*/
+
+// Handle listing of the current user's known devices and notification preference
+func (a *AuthAgent) HandleListKnownDevices(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	js, _ := json.Marshal(struct {
+		Notify  bool
+		Devices []KnownDevice
+	}{
+		Notify:  a.NewDeviceNotificationEnabled(username),
+		Devices: a.ListKnownDevices(username),
+	})
+	sendJSONResponse(w, string(js))
+}
+
+// Handle opt in or out of the new device login notification. Require POST enable
+func (a *AuthAgent) HandleSetNewDeviceNotification(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	enable, err := utils.PostPara(r, "enable")
+	if err != nil || (enable != "true" && enable != "false") {
+		sendErrorResponse(w, "Invalid enable value given")
+		return
+	}
+
+	err = a.SetNewDeviceNotification(username, enable == "true")
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	sendOK(w)
+}
+
+// Handle forgetting of the current user's known device. Require POST id, or all=true to forget all devices
+func (a *AuthAgent) HandleForgetKnownDevice(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	forgetAll, _ := utils.PostPara(r, "all")
+	if forgetAll == "true" {
+		a.Database.Delete("auth_knowndevice", "devices/"+username)
+		log.Println("[System Auth] " + username + " forgot all known devices")
+		sendOK(w)
+		return
+	}
+
+	deviceID, err := utils.PostPara(r, "id")
+	if err != nil {
+		sendErrorResponse(w, "Invalid device id given")
+		return
+	}
+
+	err = a.ForgetKnownDevice(username, deviceID)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	sendOK(w)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewDeviceLogin(t *testing.T) {
+	notified := make(chan KnownDevice, 10)
+	a := newTestAuthAgent(t, "auth", "auth_knowndevice")
+	a.MaxKnownDevices = 2
+	a.KnownDeviceTTL = 3600
+	a.SendNewDeviceNotice = func(username string, device KnownDevice) error {
+		notified <- device
+		return nil
+	}
+
+	login := func(ipAddr string, userAgent string) {
+		r := httptest.NewRequest("POST", "/system/auth/login", nil)
+		r.RemoteAddr = ipAddr + ":12345"
+		r.Header.Set("User-Agent", userAgent)
+		a.checkNewDeviceLogin(r, "alice")
+	}
+	expectNotification := func(expected bool, msg string) {
+		select {
+		case device := <-notified:
+			if !expected {
+				t.Errorf("%s: unexpected notification for %+v", msg, device)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if expected {
+				t.Errorf("%s: expected a notification", msg)
+			}
+		}
+	}
+
+	//The first login of the account has no history to compare with
+	login("10.0.0.1", "browser-a")
+	expectNotification(false, "first login")
+
+	login("10.0.0.1", "browser-a")
+	expectNotification(false, "known device")
+
+	login("10.0.0.2", "browser-a")
+	expectNotification(true, "new IP")
+
+	login("10.0.0.2", "browser-b")
+	expectNotification(true, "new user agent")
+
+	devices := a.ListKnownDevices("alice")
+	if len(devices) != 2 || devices[0].UserAgent != "browser-b" {
+		t.Fatalf("Expected the 2 latest devices to be kept, got %+v", devices)
+	}
+
+	//Opt out of the notification
+	a.SetNewDeviceNotification("alice", false)
+	if a.NewDeviceNotificationEnabled("alice") {
+		t.Error("Expected notification to be disabled")
+	}
+	login("10.0.0.3", "browser-c")
+	expectNotification(false, "opted out")
+	a.SetNewDeviceNotification("alice", true)
+
+	//Forgotten devices are notified again
+	devices = a.ListKnownDevices("alice")
+	if err := a.ForgetKnownDevice("alice", devices[0].ID); err != nil {
+		t.Fatalf("Failed to forget device: %v", err)
+	}
+	login("10.0.0.3", "browser-c")
+	expectNotification(true, "forgotten device")
+
+	//Expired devices are pruned
+	a.KnownDeviceTTL = 1
+	devices = a.ListKnownDevices("alice")
+	for i := range devices {
+		devices[i].LastSeen -= 10
+	}
+	a.Database.Write("auth_knowndevice", "devices/alice", devices)
+	a.RemoveExpiredKnownDevices()
+	if len(a.ListKnownDevices("alice")) != 0 {
+		t.Error("Expected expired devices to be removed")
+	}
+}
@@ -0,0 +1,323 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+	ID Token Verification
+
+	Verify the ID token issued by the identity provider, which is a JWT
+	signed by one of the keys published in the JWKS of the provider.
+	Only asymmetric signatures (RS256 / RS384 / RS512 / ES256 / ES384 / ES512)
+	are accepted, so a leaked client secret cannot be used to forge tokens.
+*/
+
+// Allowed clock difference between this host and the identity provider
+const clockSkew = 60 * time.Second
+
+// Minimal interval between JWKS refreshes triggered by unknown key id
+const jwksRefreshInterval = time.Minute
+
+type provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	keys          map[string]crypto.PublicKey //Signing keys by key id
+	keysFetchTime time.Time
+	discoverTime  time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Get the provider metadata from the discovery document of the issuer
+func discoverProvider(client *http.Client, issuer string) (*provider, error) {
+	thisProvider := provider{}
+	err := getJSON(client, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &thisProvider)
+	if err != nil {
+		return nil, errors.New("unable to load discovery document: " + err.Error())
+	}
+	if thisProvider.Issuer != issuer {
+		return nil, errors.New("issuer mismatch in discovery document: " + thisProvider.Issuer)
+	}
+	if thisProvider.AuthorizationEndpoint == "" || thisProvider.TokenEndpoint == "" || thisProvider.JWKSURI == "" {
+		return nil, errors.New("discovery document missing required endpoints")
+	}
+	thisProvider.keys = map[string]crypto.PublicKey{}
+	thisProvider.discoverTime = time.Now()
+	return &thisProvider, nil
+}
+
+// Reload the signing keys of the provider
+func (p *provider) fetchKeys(client *http.Client) error {
+	keySet := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err := getJSON(client, p.JWKSURI, &keySet)
+	if err != nil {
+		return errors.New("unable to load signing keys: " + err.Error())
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			//Skip key types not supported
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+	p.keysFetchTime = time.Now()
+	return nil
+}
+
+// Get the signing key with the key id, reload the keys if not found as the provider might have rotated them
+func (p *provider) getKey(client *http.Client, kid string) (crypto.PublicKey, error) {
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchTime) < jwksRefreshInterval {
+		return nil, errors.New("signing key not found")
+	}
+	if err := p.fetchKeys(client); err != nil {
+		return nil, err
+	}
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, errors.New("signing key not found")
+}
+
+func (p *provider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		//Provider with a single key might not set the key id
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Verify the ID token and return its claims
+func (p *provider) verifyIDToken(client *http.Client, rawToken string, clientID string, nonce string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed id token header")
+	}
+
+	key, err := p.getKey(client, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id token signature")
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed id token claims")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, errors.New("id token issuer mismatch")
+	}
+	audiences := claimStrings(claims, "aud")
+	if !inSlice(audiences, clientID) {
+		return nil, errors.New("id token not issued for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && len(audiences) > 1 && azp != clientID {
+		return nil, errors.New("id token authorized party mismatch")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("id token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(clockSkew)) {
+		return nil, errors.New("id token issued in the future")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("id token missing subject")
+	}
+	return claims, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return errors.New("unsupported id token algorithm: " + alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("signing key type mismatch")
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature) != nil {
+			return errors.New("invalid id token signature")
+		}
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("signing key type mismatch")
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid id token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid id token signature")
+		}
+	default:
+		return errors.New("unsupported id token algorithm: " + alg)
+	}
+	return nil
+}
+
+// Parse the RSA or EC public key from the JSON web key
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve: " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid ec key")
+		}
+		return &key, nil
+	}
+	return nil, errors.New("unsupported key type: " + jwk.Kty)
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, target)
+}
+
+// Get the claim as a list of strings. The claim can be a single string or an array of strings
+func claimStrings(claims map[string]interface{}, name string) []string {
+	results := []string{}
+	switch value := claims[name].(type) {
+	case string:
+		results = append(results, value)
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				results = append(results, s)
+			}
+		}
+	}
+	return results
+}
+
+func getJSON(client *http.Client, url string, target interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected response status " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func inSlice(slice []string, val string) bool {
+	for _, item := range slice {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,569 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	auth "imuslab.com/arozos/mod/auth"
+	syncdb "imuslab.com/arozos/mod/auth/oauth2/syncdb"
+	db "imuslab.com/arozos/mod/database"
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	OpenID Connect Single Sign-On
+
+	Allow users to login with an OpenID Connect identity provider (e.g.
+	Keycloak, Authentik, Azure AD) using the authorization code flow with
+	PKCE. Local password login is not affected.
+
+	The identity is matched by the (issuer, subject) pair of the ID token.
+	Unknown identities can be linked to the existing account with the same
+	verified email, or provisioned as new accounts in the default group.
+	Only the hashed identity and the username it links to are stored:
+
+	oidc/config => Config
+	oidc/identity/{sha256 of issuer and subject} => identityLink
+
+	If the group claim mapping is set, the groups of the user are updated
+	from the claim on each login.
+*/
+
+const (
+	callbackPath    = "/system/auth/oidc/callback"
+	stateCookieName = "oidc_state"
+	loginTimeout    = 10 * time.Minute //Time allowed for the user to login on the identity provider
+	providerTTL     = time.Hour        //Reload the discovery document after this duration
+)
+
+type Config struct {
+	Enabled       bool              `json:"enabled"`
+	ButtonLabel   string            `json:"button_label"`   //Label of the SSO button on login page
+	Issuer        string            `json:"issuer"`         //Issuer URL, e.g. https://sso.example.com/realms/main
+	ClientID      string            `json:"client_id"`      //Client ID registered on the identity provider
+	ClientSecret  string            `json:"client_secret"`  //Client secret, empty for public clients
+	RedirectURL   string            `json:"redirect_url"`   //Base URL of this host, e.g. https://nas.example.com
+	Scopes        []string          `json:"scopes"`         //Extra scopes besides openid
+	UsernameClaim string            `json:"username_claim"` //Claim used as the username of new accounts, default preferred_username
+	LinkByEmail   bool              `json:"link_by_email"`  //Link unknown identities to the account with the same verified email
+	AutoProvision bool              `json:"auto_provision"` //Create accounts for unknown identities
+	DefaultGroup  string            `json:"default_group"`  //Group of the new accounts if no group is mapped
+	GroupClaim    string            `json:"group_claim"`    //Claim containing the groups of the user, default groups
+	GroupMapping  map[string]string `json:"group_mapping"`  //Identity provider group => arozos group
+}
+
+type identityLink struct {
+	Username   string
+	LinkedTime int64
+}
+
+type pendingLogin struct {
+	Redirect  string
+	Nonce     string
+	Verifier  string
+	StartTime int64
+}
+
+type Handler struct {
+	ag       *auth.AuthAgent
+	db       *db.Database
+	states   *syncdb.SyncDB
+	client   *http.Client
+	config   Config
+	provider *provider
+	mutex    sync.Mutex
+}
+
+// Create a new OpenID Connect handler
+func NewOIDCHandler(authAgent *auth.AuthAgent, coreDb *db.Database) *Handler {
+	err := coreDb.NewTable("oidc")
+	if err != nil {
+		log.Println("Failed to create oidc database. Terminating.")
+		panic(err)
+	}
+
+	config := Config{}
+	if coreDb.KeyExists("oidc", "config") {
+		coreDb.Read("oidc", "config", &config)
+	}
+
+	return &Handler{
+		ag:     authAgent,
+		db:     coreDb,
+		states: syncdb.NewSyncDB(),
+		client: &http.Client{Timeout: 10 * time.Second},
+		config: config,
+	}
+}
+
+// Get the current config
+func (h *Handler) GetConfig() Config {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.config
+}
+
+// Get the label of the SSO button on login page
+func (h *Handler) GetButtonLabel() string {
+	label := h.GetConfig().ButtonLabel
+	if label == "" {
+		return "Login with SSO"
+	}
+	return label
+}
+
+// Validate and save the config
+func (h *Handler) SetConfig(config Config) error {
+	config.Issuer = strings.TrimSpace(config.Issuer)
+	config.ClientID = strings.TrimSpace(config.ClientID)
+	config.RedirectURL = strings.TrimSuffix(strings.TrimSpace(config.RedirectURL), "/")
+	if config.Enabled {
+		if err := validateEndpointURL(config.Issuer); err != nil {
+			return errors.New("invalid issuer: " + err.Error())
+		}
+		if err := validateEndpointURL(config.RedirectURL); err != nil {
+			return errors.New("invalid redirect url: " + err.Error())
+		}
+		if config.ClientID == "" {
+			return errors.New("client id is required")
+		}
+	}
+	if config.AutoProvision && config.DefaultGroup != "" && h.ag.GroupExists != nil && !h.ag.GroupExists(config.DefaultGroup) {
+		return errors.New("default group not exists")
+	}
+
+	err := h.db.Write("oidc", "config", config)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	if h.config.Issuer != config.Issuer {
+		h.provider = nil
+	}
+	h.config = config
+	h.mutex.Unlock()
+	return nil
+}
+
+// Identity provider endpoints must use https, except on loopback for testing
+func validateEndpointURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return errors.New("url not valid")
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	if ip := net.ParseIP(u.Hostname()); u.Scheme == "http" && (u.Hostname() == "localhost" || (ip != nil && ip.IsLoopback())) {
+		return nil
+	}
+	return errors.New("https is required")
+}
+
+// Get the provider metadata of the issuer, discover it if not loaded or outdated
+func (h *Handler) getProvider(issuer string) (*provider, error) {
+	if h.provider != nil && h.provider.Issuer == issuer && time.Since(h.provider.discoverTime) < providerTTL {
+		return h.provider, nil
+	}
+	newProvider, err := discoverProvider(h.client, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if h.provider != nil && h.provider.Issuer == issuer {
+		//Keep the loaded signing keys
+		newProvider.keys = h.provider.keys
+		newProvider.keysFetchTime = h.provider.keysFetchTime
+	}
+	h.provider = newProvider
+	return newProvider, nil
+}
+
+func (h *Handler) oauthConfig(config Config, p *provider) *oauth2.Config {
+	scopes := []string{"openid"}
+	if len(config.Scopes) == 0 {
+		scopes = append(scopes, "profile", "email")
+	}
+	for _, scope := range config.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.RedirectURL + callbackPath,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.AuthorizationEndpoint,
+			TokenURL: p.TokenEndpoint,
+		},
+	}
+}
+
+// Start the login on the identity provider
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	config := h.GetConfig()
+	if !config.Enabled {
+		utils.SendTextResponse(w, "Single sign-on disabled")
+		return
+	}
+
+	h.mutex.Lock()
+	p, err := h.getProvider(config.Issuer)
+	h.mutex.Unlock()
+	if err != nil {
+		log.Println("[System Auth] OpenID Connect provider not available: " + err.Error())
+		utils.SendTextResponse(w, "Identity provider not available. Please try again later.")
+		return
+	}
+
+	redirect, _ := utils.GetPara(r, "redirect")
+	redirect = h.ag.SanitizeRedirectTarget(r, redirect)
+	if redirect == "" {
+		redirect = "/"
+	}
+
+	pending := pendingLogin{
+		Redirect:  redirect,
+		Nonce:     randomToken(),
+		Verifier:  oauth2.GenerateVerifier(),
+		StartTime: time.Now().Unix(),
+	}
+	js, _ := json.Marshal(pending)
+	state := h.states.Store(string(js))
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     "/system/auth/oidc/",
+		MaxAge:   int(loginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := h.oauthConfig(config, p).AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", pending.Nonce), oauth2.S256ChallengeOption(pending.Verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Handle the redirect back from the identity provider
+func (h *Handler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	config := h.GetConfig()
+	if !config.Enabled {
+		utils.SendTextResponse(w, "Single sign-on disabled")
+		return
+	}
+
+	//The state is single use
+	state, _ := utils.GetPara(r, "state")
+	stateCookie, err := r.Cookie(stateCookieName)
+	if err != nil || state == "" || stateCookie.Value != state {
+		utils.SendTextResponse(w, "Invalid login state.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookieName, Value: "", Path: "/system/auth/oidc/", MaxAge: -1})
+	pendingJSON := h.states.Read(state)
+	h.states.Delete(state)
+
+	pending := pendingLogin{}
+	if pendingJSON == "" || json.Unmarshal([]byte(pendingJSON), &pending) != nil || time.Since(time.Unix(pending.StartTime, 0)) > loginTimeout {
+		utils.SendTextResponse(w, "Login session expired. Please try again.")
+		return
+	}
+
+	if idpError, err := utils.GetPara(r, "error"); err == nil {
+		log.Println("[System Auth] OpenID Connect login rejected by identity provider: " + idpError)
+		utils.SendTextResponse(w, "Login rejected by identity provider.")
+		return
+	}
+
+	code, err := utils.GetPara(r, "code")
+	if err != nil {
+		utils.SendTextResponse(w, "Invalid authorization code.")
+		return
+	}
+
+	h.mutex.Lock()
+	p, err := h.getProvider(config.Issuer)
+	h.mutex.Unlock()
+	if err != nil {
+		log.Println("[System Auth] OpenID Connect provider not available: " + err.Error())
+		utils.SendTextResponse(w, "Identity provider not available. Please try again later.")
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, h.client)
+	token, err := h.oauthConfig(config, p).Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		log.Println("[System Auth] OpenID Connect code exchange failed: " + err.Error())
+		utils.SendTextResponse(w, "Code exchange failed.")
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		utils.SendTextResponse(w, "Identity provider did not return an ID token.")
+		return
+	}
+
+	h.mutex.Lock()
+	claims, err := p.verifyIDToken(h.client, rawIDToken, config.ClientID, pending.Nonce, time.Now())
+	h.mutex.Unlock()
+	if err != nil {
+		log.Println("[System Auth] OpenID Connect ID token rejected: " + err.Error())
+		h.ag.LogAuditEvent(r, auth.AuditActionLogin, "", "", false, "OpenID Connect ID token rejected: "+err.Error())
+		utils.SendTextResponse(w, "Invalid ID token.")
+		return
+	}
+
+	username, err := h.resolveUser(r, config, p.Issuer, claims)
+	if err != nil {
+		h.ag.Logger.LogAuthByRequestInfo(username, network.GetRemoteAddrFromRequest(r), time.Now().Unix(), false, "oidc")
+		h.ag.LogAuditEvent(r, auth.AuditActionLogin, "", username, false, "OpenID Connect: "+err.Error())
+		utils.SendTextResponse(w, err.Error())
+		return
+	}
+
+	if err := h.validateLogin(r, username); err != nil {
+		h.ag.Logger.LogAuthByRequestInfo(username, network.GetRemoteAddrFromRequest(r), time.Now().Unix(), false, "oidc")
+		h.ag.LogAuditEvent(r, auth.AuditActionLogin, username, username, false, "OpenID Connect: "+err.Error())
+		utils.SendTextResponse(w, err.Error())
+		return
+	}
+
+	err = h.ag.LoginUserByRequest(w, r, username, false)
+	if err != nil {
+		h.ag.Logger.LogAuthByRequestInfo(username, network.GetRemoteAddrFromRequest(r), time.Now().Unix(), false, "oidc")
+		h.ag.LogAuditEvent(r, auth.AuditActionLogin, username, username, false, "OpenID Connect: "+err.Error())
+		utils.SendTextResponse(w, err.Error())
+		return
+	}
+	if h.ag.SwitchableAccountManager != nil {
+		h.ag.SwitchableAccountManager.MatchPoolCreatorOrResetPoolID(username, w, r)
+	}
+	log.Println(username + " logged in via OpenID Connect.")
+	h.ag.Logger.LogAuthByRequestInfo(username, network.GetRemoteAddrFromRequest(r), time.Now().Unix(), true, "oidc")
+	h.ag.LogAuditEvent(r, auth.AuditActionLogin, username, username, true, "OpenID Connect")
+	http.Redirect(w, r, pending.Redirect, http.StatusFound)
+}
+
+// The same account and request origin checks as password login. The second factor is left to the identity provider
+func (h *Handler) validateLogin(r *http.Request, username string) error {
+	if h.ag.UserIsLocked(username) {
+		return errors.New("Account locked")
+	}
+	if h.ag.UserIsDisabled(username) {
+		return errors.New("Account disabled")
+	}
+	if h.ag.MaintenanceBlocksUser(username) {
+		//Only administrators can login during maintenance
+		return errors.New(h.ag.GetMaintenanceMessage())
+	}
+	ok, reason := h.ag.ValidateLoginRequest(nil, r)
+	if !ok {
+		if reason == nil {
+			reason = errors.New("Unable to resolve request origin")
+		}
+		return reason
+	}
+	return nil
+}
+
+// Get the user of the identity, link or provision the account if allowed
+func (h *Handler) resolveUser(r *http.Request, config Config, issuer string, claims map[string]interface{}) (string, error) {
+	sub, _ := claims["sub"].(string)
+	identityKey := getIdentityKey(issuer, sub)
+	mappedGroups := h.mapGroups(config, claims)
+
+	//Linked identity. The link is invalid if the user is removed and another account is created with the same name
+	if h.db.KeyExists("oidc", identityKey) {
+		link := identityLink{}
+		h.db.Read("oidc", identityKey, &link)
+		if h.ag.UserExists(link.Username) && h.ag.GetUserCreationTime(link.Username) <= link.LinkedTime {
+			h.syncGroups(link.Username, mappedGroups)
+			return link.Username, nil
+		}
+		h.db.Delete("oidc", identityKey)
+	}
+
+	//Existing account with the same verified email
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+	if config.LinkByEmail && email != "" && emailVerified && h.ag.LookupUsernameByEmail != nil {
+		if username, err := h.ag.LookupUsernameByEmail(email); err == nil && h.ag.UserExists(username) {
+			h.linkIdentity(identityKey, username)
+			h.syncGroups(username, mappedGroups)
+			log.Println("[System Auth] OpenID Connect identity linked to " + username + " by email")
+			return username, nil
+		}
+	}
+
+	if !config.AutoProvision {
+		return "", errors.New("No account is linked to this identity. Please contact your system administrator.")
+	}
+	if h.ag.GetUserCounts() == 0 {
+		return "", errors.New("System not initialized yet.")
+	}
+
+	username := h.ag.NormalizeUsername(getProvisionUsername(config, claims))
+	if username == "" {
+		return "", errors.New("Identity provider did not provide a username.")
+	}
+	if h.ag.UserExists(username) {
+		return username, errors.New("Username " + username + " already exists. Please contact your system administrator.")
+	}
+	if ok, reason := h.ag.ValidateUsernameWithPolicy(username); !ok {
+		return username, errors.New(reason)
+	}
+
+	groups := mappedGroups
+	if len(groups) == 0 && config.DefaultGroup != "" {
+		groups = []string{config.DefaultGroup}
+	}
+	if len(groups) == 0 {
+		return username, errors.New("No group is assigned to this identity. Please contact your system administrator.")
+	}
+
+	//The account can only login via single sign-on until the password is reset
+	err := h.ag.CreateUserAccount(username, randomToken(), groups)
+	if err != nil {
+		return username, errors.New("Unable to create account")
+	}
+	h.linkIdentity(identityKey, username)
+
+	//Keep the verified email so the account can reset its password and be found by email
+	if email != "" && emailVerified && h.ag.StoreUserEmail != nil {
+		err = h.ag.StoreUserEmail(username, email)
+		if err != nil {
+			log.Println("[System Auth] Email of OpenID Connect account " + username + " not stored: " + err.Error())
+		}
+	}
+	h.ag.LogAuditEvent(r, auth.AuditActionRegister, username, username, true, "Provisioned via OpenID Connect")
+	log.Println("[System Auth] OpenID Connect account provisioned: " + username)
+	return username, nil
+}
+
+func (h *Handler) linkIdentity(identityKey string, username string) {
+	h.db.Write("oidc", identityKey, identityLink{
+		Username:   username,
+		LinkedTime: time.Now().Unix(),
+	})
+}
+
+// Get the arozos groups mapped from the group claim, skipping groups not exists
+func (h *Handler) mapGroups(config Config, claims map[string]interface{}) []string {
+	groups := []string{}
+	if len(config.GroupMapping) == 0 {
+		return groups
+	}
+	groupClaim := config.GroupClaim
+	if groupClaim == "" {
+		groupClaim = "groups"
+	}
+	for _, idpGroup := range claimStrings(claims, groupClaim) {
+		group, ok := config.GroupMapping[idpGroup]
+		if !ok || inSlice(groups, group) {
+			continue
+		}
+		if h.ag.GroupExists != nil && !h.ag.GroupExists(group) {
+			continue
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Update the groups of the user to the mapped groups. Users without any mapped group keep their groups
+func (h *Handler) syncGroups(username string, groups []string) {
+	if len(groups) == 0 {
+		return
+	}
+	h.db.Write("auth", "group/"+username, groups)
+}
+
+// Get the username for a new account from the configured claim, or the local part of the email
+func getProvisionUsername(config Config, claims map[string]interface{}) string {
+	usernameClaim := config.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+	if username, ok := claims[usernameClaim].(string); ok && strings.TrimSpace(username) != "" {
+		return strings.TrimSpace(username)
+	}
+	if email, ok := claims["email"].(string); ok {
+		localPart, _, _ := strings.Cut(email, "@")
+		return strings.TrimSpace(localPart)
+	}
+	return ""
+}
+
+func getIdentityKey(issuer string, sub string) string {
+	hash := sha256.Sum256([]byte(issuer + "\x00" + sub))
+	return "identity/" + hex.EncodeToString(hash[:])
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Check if single sign-on is enabled, for showing the SSO button on login page
+func (h *Handler) HandleCheck(w http.ResponseWriter, r *http.Request) {
+	js, _ := json.Marshal(struct {
+		Enabled  bool   `json:"enabled"`
+		Label    string `json:"label"`
+		LoginURL string `json:"login_url"`
+	}{
+		Enabled:  h.GetConfig().Enabled,
+		Label:    h.GetButtonLabel(),
+		LoginURL: "/system/auth/oidc/login",
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Handle the config settings. Leave config empty for reading the current settings.
+// The client secret is not returned, and is kept if not given on update
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (h *Handler) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	configJSON, err := utils.PostPara(r, "config")
+	if err != nil {
+		//Read mode
+		config := h.GetConfig()
+		config.ClientSecret = ""
+		js, _ := json.Marshal(config)
+		utils.SendJSONResponse(w, string(js))
+		return
+	}
+
+	newConfig := Config{}
+	err = json.Unmarshal([]byte(configJSON), &newConfig)
+	if err != nil {
+		utils.SendErrorResponse(w, "Invalid config given")
+		return
+	}
+	if newConfig.ClientSecret == "" {
+		newConfig.ClientSecret = h.GetConfig().ClientSecret
+	}
+
+	err = h.SetConfig(newConfig)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] OpenID Connect config updated")
+	utils.SendOK(w)
+}
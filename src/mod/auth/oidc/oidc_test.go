@@ -0,0 +1,240 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	auth "imuslab.com/arozos/mod/auth"
+	"imuslab.com/arozos/mod/database"
+)
+
+type testProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+// Start an identity provider serving the discovery document and the signing key
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p := &testProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *testProvider) sign(t *testing.T, header map[string]interface{}, claims map[string]interface{}) string {
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *testProvider) claims(sub string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   p.server.URL,
+		"aud":   "arozos",
+		"sub":   sub,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": "nonce",
+	}
+}
+
+func newTestHandler(t *testing.T, p *testProvider) *Handler {
+	sysdb, err := database.NewDatabase(filepath.Join(t.TempDir(), "oidc.db"), false)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { sysdb.Close() })
+	sysdb.NewTable("auth")
+
+	ag := &auth.AuthAgent{
+		Database:    sysdb,
+		GroupExists: func(group string) bool { return group == "users" || group == "staff" },
+	}
+	ag.CreateUserAccount("admin", "password", []string{"administrator"})
+
+	h := NewOIDCHandler(ag, sysdb)
+	err = h.SetConfig(Config{
+		Enabled:     true,
+		Issuer:      p.server.URL,
+		ClientID:    "arozos",
+		RedirectURL: "http://localhost:8080",
+	})
+	if err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	return h
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newTestProvider(t)
+	h := newTestHandler(t, p)
+	thisProvider, err := h.getProvider(p.server.URL)
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+
+	header := map[string]interface{}{"alg": "RS256", "kid": "test-key"}
+	verify := func(token string) error {
+		_, err := thisProvider.verifyIDToken(h.client, token, "arozos", "nonce", time.Now())
+		return err
+	}
+
+	if err := verify(p.sign(t, header, p.claims("alice"))); err != nil {
+		t.Fatalf("Valid token rejected: %v", err)
+	}
+
+	invalidClaims := map[string]func(map[string]interface{}){
+		"wrong audience": func(c map[string]interface{}) { c["aud"] = "other" },
+		"wrong issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"wrong nonce":    func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no subject":     func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, modify := range invalidClaims {
+		claims := p.claims("alice")
+		modify(claims)
+		if err := verify(p.sign(t, header, claims)); err == nil {
+			t.Errorf("Expected token with %s to be rejected", name)
+		}
+	}
+
+	//Tampered claims
+	token := p.sign(t, header, p.claims("alice"))
+	parts := strings.Split(token, ".")
+	forgedClaims, _ := json.Marshal(p.claims("admin"))
+	if err := verify(parts[0] + "." + base64.RawURLEncoding.EncodeToString(forgedClaims) + "." + parts[2]); err == nil {
+		t.Error("Expected tampered token to be rejected")
+	}
+
+	//Unsigned token
+	noneHeader, _ := json.Marshal(map[string]string{"alg": "none", "kid": "test-key"})
+	if err := verify(base64.RawURLEncoding.EncodeToString(noneHeader) + "." + parts[1] + "."); err == nil {
+		t.Error("Expected unsigned token to be rejected")
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	p := newTestProvider(t)
+	h := newTestHandler(t, p)
+	config := h.GetConfig()
+	claims := p.claims("subject-1")
+	claims["preferred_username"] = "alice"
+
+	//Unknown identity without auto provision
+	if _, err := h.resolveUser(nil, config, p.server.URL, claims); err == nil {
+		t.Fatal("Expected unknown identity to be rejected")
+	}
+
+	config.AutoProvision = true
+	config.DefaultGroup = "users"
+	username, err := h.resolveUser(nil, config, p.server.URL, claims)
+	if err != nil || username != "alice" {
+		t.Fatalf("Expected alice to be provisioned, got %q, %v", username, err)
+	}
+	groups := []string{}
+	h.db.Read("auth", "group/alice", &groups)
+	if len(groups) != 1 || groups[0] != "users" {
+		t.Errorf("Expected alice in default group, got %v", groups)
+	}
+
+	//Linked identity, the username claim is not used anymore and groups are synced from mapping
+	claims["preferred_username"] = "alice2"
+	claims["groups"] = []interface{}{"idp-staff", "idp-unknown"}
+	config.GroupMapping = map[string]string{"idp-staff": "staff"}
+	username, err = h.resolveUser(nil, config, p.server.URL, claims)
+	if err != nil || username != "alice" {
+		t.Fatalf("Expected linked identity to resolve to alice, got %q, %v", username, err)
+	}
+	h.db.Read("auth", "group/alice", &groups)
+	if len(groups) != 1 || groups[0] != "staff" {
+		t.Errorf("Expected alice groups synced from claim, got %v", groups)
+	}
+
+	//Existing username cannot be taken over by another identity
+	other := p.claims("subject-2")
+	other["preferred_username"] = "admin"
+	if _, err := h.resolveUser(nil, config, p.server.URL, other); err == nil {
+		t.Error("Expected provisioning with existing username to be rejected")
+	}
+}
+
+func TestHandleLogin(t *testing.T) {
+	p := newTestProvider(t)
+	h := newTestHandler(t, p)
+
+	r := httptest.NewRequest("GET", "/system/auth/oidc/login?redirect=/desktop.system", nil)
+	w := httptest.NewRecorder()
+	h.HandleLogin(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected redirect, got %d", w.Code)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), p.server.URL+"/authorize") {
+		t.Fatalf("Unexpected redirect location %q", w.Header().Get("Location"))
+	}
+	query := location.Query()
+	if query.Get("nonce") == "" || query.Get("code_challenge_method") != "S256" || query.Get("redirect_uri") != "http://localhost:8080"+callbackPath {
+		t.Errorf("Unexpected authorization request %v", query)
+	}
+	if !strings.Contains(query.Get("scope"), "openid") {
+		t.Errorf("Expected openid scope, got %q", query.Get("scope"))
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookieName || cookies[0].Value != query.Get("state") || !cookies[0].HttpOnly {
+		t.Errorf("Expected state cookie matching the state parameter, got %v", cookies)
+	}
+
+	//Callback with mismatched state is rejected before contacting the provider
+	r = httptest.NewRequest("GET", callbackPath+"?state=other&code=abc", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	h.HandleCallback(w, r)
+	if !strings.Contains(w.Body.String(), "Invalid login state") {
+		t.Errorf("Expected invalid state response, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Password Expiry
+
+	Require users to change their password when it is older than the
+	max password age, or when an admin force a change at next login
+	(e.g. after importing accounts with temporary passwords).
+
+	Login still succeed, but the session is flagged and protected
+	endpoints redirect to the change password page until it is done.
+	The records are stored as
+
+	auth/passwordchanged/{username} => unix time of last password change
+	auth/mustchangepw/{username} => true if forced by admin
+	auth_policy/passwordmaxage => max password age in seconds, 0 = never expire
+*/
+
+type PasswordStatus struct {
+	Username    string
+	LastChanged int64 //Unix time of the last password change, 0 if unknown
+	ExpireTime  int64 //Unix time when the password expire, 0 if never
+	ForceChange bool  //If a change is forced by admin at next login
+	MustChange  bool  //If the user must change the password at next login
+}
+
+// Session value key of the password change flag
+const passwordChangeSessionKey = "mustchangepw"
+
+// Endpoints allowed for sessions that must change their password first
+var passwordChangeExemptPaths = []string{
+	"/system/users/userinfo",
+	"/system/auth/logout",
+	"/system/auth/checkLogin",
+	"/system/auth/whoami",
+}
+
+// Load the max password age from database
+func (a *AuthAgent) loadPasswordMaxAge() {
+	if a.Database.KeyExists("auth_policy", "passwordmaxage") {
+		a.Database.Read("auth_policy", "passwordmaxage", &a.PasswordMaxAge)
+	}
+}
+
+// Set the max password age in seconds, 0 = never expire
+func (a *AuthAgent) SetPasswordMaxAge(maxAge int64) error {
+	if maxAge < 0 {
+		return errors.New("invalid max password age")
+	}
+	a.PasswordMaxAge = maxAge
+	return a.Database.Write("auth_policy", "passwordmaxage", maxAge)
+}
+
+// Get the time of the last password change, fallback to the account creation time
+func (a *AuthAgent) GetPasswordChangeTime(username string) int64 {
+	changeTime := int64(0)
+	if a.Database.KeyExists("auth", "passwordchanged/"+username) {
+		a.Database.Read("auth", "passwordchanged/"+username, &changeTime)
+		return changeTime
+	}
+	a.Database.Read("auth", "createtime/"+username, &changeTime)
+	return changeTime
+}
+
+// Record a password change of the user and clear the forced change flag
+func (a *AuthAgent) recordPasswordChange(username string) {
+	a.Database.Write("auth", "passwordchanged/"+username, time.Now().Unix())
+	a.Database.Delete("auth", "mustchangepw/"+username)
+}
+
+// Force or cancel a password change of the user at next login
+func (a *AuthAgent) SetForcePasswordChange(username string, force bool) error {
+	if !a.UserExists(username) {
+		return errors.New("user not exists")
+	}
+	if !force {
+		return a.Database.Delete("auth", "mustchangepw/"+username)
+	}
+	return a.Database.Write("auth", "mustchangepw/"+username, true)
+}
+
+// Get the password status of the user
+func (a *AuthAgent) GetPasswordStatus(username string) *PasswordStatus {
+	status := PasswordStatus{
+		Username:    username,
+		LastChanged: a.GetPasswordChangeTime(username),
+		ForceChange: a.Database.KeyExists("auth", "mustchangepw/"+username),
+	}
+	if a.PasswordMaxAge > 0 && status.LastChanged > 0 {
+		status.ExpireTime = status.LastChanged + a.PasswordMaxAge
+	}
+	status.MustChange = status.ForceChange || (status.ExpireTime > 0 && time.Now().Unix() >= status.ExpireTime)
+	return &status
+}
+
+// Check if the user must change the password before using the system
+func (a *AuthAgent) PasswordChangeRequired(username string) bool {
+	return a.GetPasswordStatus(username).MustChange
+}
+
+// Flag the login session if the user must change the password. Accounts without any record start aging from now
+func (a *AuthAgent) flagPasswordChange(username string, sessionValues map[interface{}]interface{}) {
+	if a.GetPasswordChangeTime(username) == 0 {
+		a.Database.Write("auth", "passwordchanged/"+username, time.Now().Unix())
+	}
+
+	if a.PasswordChangeRequired(username) {
+		sessionValues[passwordChangeSessionKey] = true
+	} else {
+		delete(sessionValues, passwordChangeSessionKey)
+	}
+}
+
+// Check if the session of this request must change the password first. Clear the flag once it is changed
+func (a *AuthAgent) PasswordChangePending(w http.ResponseWriter, r *http.Request) bool {
+	if a.requestIsHeadless(r) {
+		return false
+	}
+
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
+	if flagged, ok := session.Values[passwordChangeSessionKey].(bool); !ok || !flagged {
+		return false
+	}
+
+	username, ok := session.Values["username"].(string)
+	if ok && a.PasswordChangeRequired(username) {
+		return true
+	}
+
+	//Password changed since login
+	delete(session.Values, passwordChangeSessionKey)
+	session.Save(r, w)
+	return false
+}
+
+// Check if the endpoint is accessible before the password is changed
+func isPasswordChangeExempt(path string) bool {
+	for _, exemptPath := range passwordChangeExemptPaths {
+		if path == exemptPath {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle the max password age settings. Leave maxage empty for reading the current settings
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandlePasswordExpirySettings(w http.ResponseWriter, r *http.Request) {
+	maxAge, err := utils.PostPara(r, "maxage")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.PasswordMaxAge)
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	maxAgeInt, err := strconv.ParseInt(maxAge, 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "Invalid max password age given")
+		return
+	}
+
+	err = a.SetPasswordMaxAge(maxAgeInt)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Max password age updated to " + maxAge + " seconds")
+	sendOK(w)
+}
+
+// Handle the password status of a user. Require GET username
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandlePasswordStatus(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.GetPara(r, "username")
+	if err != nil || !a.UserExists(username) {
+		sendErrorResponse(w, "Invalid username given")
+		return
+	}
+
+	js, _ := json.Marshal(a.GetPasswordStatus(username))
+	sendJSONResponse(w, string(js))
+}
+
+// Handle forcing a password change at next login. Require POST username and force (true / false)
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleForcePasswordChange(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		sendErrorResponse(w, "Invalid username given")
+		return
+	}
+	force, _ := utils.PostPara(r, "force")
+
+	err = a.SetForcePasswordChange(username, force != "false")
+	if err != nil {
+		a.LogAuditEventByRequest(r, AuditActionForcePwChange, username, false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	a.LogAuditEventByRequest(r, AuditActionForcePwChange, username, true, "force="+strconv.FormatBool(force != "false"))
+	sendOK(w)
+}
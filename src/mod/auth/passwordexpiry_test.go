@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPasswordExpiry(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_policy")
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	//Passwords never expire by default
+	if a.PasswordChangeRequired("alice") {
+		t.Fatal("Expected no password change required by default")
+	}
+
+	//Expire passwords older than 90 days
+	if err := a.SetPasswordMaxAge(-1); err == nil {
+		t.Error("Expected negative max age to be rejected")
+	}
+	a.SetPasswordMaxAge(90 * 86400)
+	a.Database.Write("auth", "passwordchanged/alice", time.Now().Unix()-91*86400)
+	status := a.GetPasswordStatus("alice")
+	if !status.MustChange || status.ForceChange || status.ExpireTime == 0 {
+		t.Errorf("Expected expired password, got %+v", status)
+	}
+
+	sessionValues := map[interface{}]interface{}{}
+	a.flagPasswordChange("alice", sessionValues)
+	if flagged, _ := sessionValues[passwordChangeSessionKey].(bool); !flagged {
+		t.Error("Expected session to be flagged for password change")
+	}
+
+	//Changing the password reset the age and clear the session flag on next login
+	a.SetUserPassword("alice", "newpassword")
+	a.flagPasswordChange("alice", sessionValues)
+	if _, ok := sessionValues[passwordChangeSessionKey]; ok {
+		t.Error("Expected session flag to be cleared after password change")
+	}
+
+	//Admin forced change is cleared by the next password change
+	if err := a.SetForcePasswordChange("nobody", true); err == nil {
+		t.Error("Expected error when forcing change on unknown user")
+	}
+	a.SetForcePasswordChange("alice", true)
+	if status := a.GetPasswordStatus("alice"); !status.MustChange || !status.ForceChange {
+		t.Errorf("Expected forced change, got %+v", status)
+	}
+	a.SetUserPassword("alice", "anotherpassword")
+	if a.PasswordChangeRequired("alice") {
+		t.Error("Expected forced change to be cleared after password change")
+	}
+
+	//Upgrading the hash cost does not count as a password change
+	changeTime := time.Now().Unix() - 100
+	a.Database.Write("auth", "passwordchanged/alice", changeTime)
+	a.PasswordHashCost = 5
+	ok, _ := a.ValidateUsernameAndPasswordWithReason("alice", "anotherpassword")
+	if !ok || a.GetPasswordChangeTime("alice") != changeTime {
+		t.Error("Expected password age to be kept after rehashing")
+	}
+
+	if !isPasswordChangeExempt("/system/users/userinfo") || isPasswordChangeExempt("/system/file_system/listDir") {
+		t.Error("Unexpected password change exempt paths")
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+	Password Hashing
+
+	Passwords are stored as bcrypt hashes with the cost set in
+	PasswordHashCost. Older versions store unsalted sha512 hashes,
+	which are still accepted and upgraded to bcrypt on the next
+	successful login. The same happens to bcrypt hashes created with
+	a different cost, so raising the cost roll out as users login.
+
+	bcrypt only use the first 72 bytes of the input, so the password
+	is pre-hashed with sha256 before passing to bcrypt.
+*/
+
+// Get the bcrypt cost for new password hashes
+func (a *AuthAgent) getPasswordHashCost() int {
+	if a.PasswordHashCost < bcrypt.MinCost || a.PasswordHashCost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+	return a.PasswordHashCost
+}
+
+// Hash the password with bcrypt and the given cost
+func HashPassword(password string, cost int) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword(bcryptInput(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// Check if the password match the stored hash, both bcrypt and legacy sha512 hashes are supported
+func VerifyPassword(password string, passwordHash string) bool {
+	if isBcryptHash(passwordHash) {
+		return bcrypt.CompareHashAndPassword([]byte(passwordHash), bcryptInput(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(Hash(password)), []byte(passwordHash)) == 1
+}
+
+// Check if the stored hash should be replaced by a hash with the given cost
+func passwordNeedsRehash(passwordHash string, cost int) bool {
+	if !isBcryptHash(passwordHash) {
+		//Legacy sha512 hash
+		return true
+	}
+	hashCost, err := bcrypt.Cost([]byte(passwordHash))
+	return err != nil || hashCost != cost
+}
+
+func isBcryptHash(passwordHash string) bool {
+	return strings.HasPrefix(passwordHash, "$2")
+}
+
+func bcryptInput(password string) []byte {
+	h := sha256.Sum256([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(h[:]))
+}
+
+// Hash and store the password of the user with the current hashing cost
+func (a *AuthAgent) SetUserPassword(username string, password string) error {
+	err := a.writePasswordHash(username, password)
+	if err != nil {
+		return err
+	}
+	a.recordPasswordChange(username)
+	return nil
+}
+
+func (a *AuthAgent) writePasswordHash(username string, password string) error {
+	hashedPassword, err := HashPassword(password, a.getPasswordHashCost())
+	if err != nil {
+		return err
+	}
+	return a.Database.Write("auth", "passhash/"+username, hashedPassword)
+}
+
+// Replace the stored hash with one using the current cost if needed. Password must be verified by the caller
+func (a *AuthAgent) rehashPasswordIfNeeded(username string, password string, passwordHash string) {
+	if !passwordNeedsRehash(passwordHash, a.getPasswordHashCost()) {
+		return
+	}
+	//Upgrading the hash is not a password change, keep the password age
+	err := a.writePasswordHash(username, password)
+	if err != nil {
+		log.Println("[System Auth] Unable to upgrade password hash of " + username + ": " + err.Error())
+	}
+}
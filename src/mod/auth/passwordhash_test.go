@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRehashOnLogin(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+	a.PasswordHashCost = bcrypt.MinCost
+	getHash := func(username string) string {
+		passwordHash := ""
+		a.Database.Read("auth", "passhash/"+username, &passwordHash)
+		return passwordHash
+	}
+
+	//Legacy sha512 hash is upgraded on login
+	a.Database.Write("auth", "passhash/alice", Hash("password"))
+	if !a.ValidateUsernameAndPassword("alice", "password") {
+		t.Fatal("Expected legacy hash to be accepted")
+	}
+	if cost, err := bcrypt.Cost([]byte(getHash("alice"))); err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("Expected legacy hash upgraded to bcrypt, got %q", getHash("alice"))
+	}
+
+	//No rehash if the cost is unchanged or the password is incorrect
+	currentHash := getHash("alice")
+	a.ValidateUsernameAndPassword("alice", "password")
+	if getHash("alice") != currentHash {
+		t.Error("Expected no rehash when the cost is unchanged")
+	}
+	a.PasswordHashCost = bcrypt.MinCost + 1
+	if a.ValidateUsernameAndPassword("alice", "wrongpassword") || getHash("alice") != currentHash {
+		t.Error("Expected no rehash for incorrect password")
+	}
+
+	//Rehash with the new cost on cost mismatch
+	if !a.ValidateUsernameAndPassword("alice", "password") {
+		t.Fatal("Expected password to be accepted")
+	}
+	if cost, _ := bcrypt.Cost([]byte(getHash("alice"))); cost != bcrypt.MinCost+1 {
+		t.Errorf("Expected hash rehashed with cost %d, got %d", bcrypt.MinCost+1, cost)
+	}
+	if !a.ValidateUsernameAndPassword("alice", "password") {
+		t.Error("Expected password to be accepted after rehash")
+	}
+
+	//Passwords longer than 72 bytes are not truncated
+	longPassword := string(make([]byte, 80)) + "a"
+	a.CreateUserAccount("bob", longPassword, []string{"user"})
+	if a.ValidateUsernameAndPassword("bob", longPassword[:80]+"b") {
+		t.Error("Expected long passwords differ after 72 bytes to be rejected")
+	}
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Password Policy
+
+	This script enforce the password strength policy on new passwords.
+	An empty policy means no restrictions. The policy is stored as
+
+	auth_policy/password => PasswordPolicy
+*/
+
+type PasswordPolicy struct {
+	MinLength     int  //Min number of characters, 0 = no restriction
+	RequireUpper  bool //Require at least one upper case letter
+	RequireLower  bool //Require at least one lower case letter
+	RequireDigit  bool //Require at least one digit
+	RequireSymbol bool //Require at least one symbol
+	RejectCommon  bool //Reject passwords found in the common password list
+
+	RejectBreached bool //Reject passwords found in data breaches, see breached.go
+}
+
+// A small list of the most common passwords
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567890", "12345", "1234567", "111111", "123123",
+	"000000", "654321", "666666", "121212", "112233", "password", "password1", "password123",
+	"qwerty", "qwerty123", "qwertyuiop", "1q2w3e4r", "1qaz2wsx", "abc123", "iloveyou", "admin",
+	"admin123", "welcome", "welcome1", "letmein", "monkey", "dragon", "football", "baseball",
+	"sunshine", "princess", "master", "shadow", "superman", "trustno1", "passw0rd", "zaq12wsx",
+	"changeme", "default", "login", "root", "toor", "guest", "test", "test123", "arozos",
+}
+
+// Load the password policy from database
+func (a *AuthAgent) loadPasswordPolicy() {
+	policy := PasswordPolicy{}
+	if a.Database.KeyExists("auth_policy", "password") {
+		a.Database.Read("auth_policy", "password", &policy)
+	}
+	a.passwordPolicy = policy
+}
+
+// Get the current password policy
+func (a *AuthAgent) GetPasswordPolicy() PasswordPolicy {
+	return a.passwordPolicy
+}
+
+// Set and save the password policy
+func (a *AuthAgent) SetPasswordPolicy(policy PasswordPolicy) error {
+	if policy.MinLength < 0 {
+		policy.MinLength = 0
+	}
+	a.passwordPolicy = policy
+	return a.Database.Write("auth_policy", "password", policy)
+}
+
+// Validate the new password against the password policy, return the rejection reason if not accepted
+func (a *AuthAgent) ValidatePasswordWithPolicy(password string) (bool, string) {
+	if ok, reason := a.passwordPolicy.Validate(password); !ok {
+		return false, reason
+	}
+	return a.validatePasswordNotBreached(password)
+}
+
+// Validate the password against this policy, return the rejection reason if not accepted
+func (p PasswordPolicy) Validate(password string) (bool, string) {
+	if p.MinLength > 0 && len([]rune(password)) < p.MinLength {
+		return false, "Password too short. Must be at least " + strconv.Itoa(p.MinLength) + " characters."
+	}
+
+	hasUpper, hasLower, hasDigit, hasSymbol := false, false, false, false
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		return false, "Password must contain at least one upper case letter."
+	}
+	if p.RequireLower && !hasLower {
+		return false, "Password must contain at least one lower case letter."
+	}
+	if p.RequireDigit && !hasDigit {
+		return false, "Password must contain at least one digit."
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return false, "Password must contain at least one symbol."
+	}
+
+	if p.RejectCommon {
+		for _, commonPassword := range commonPasswords {
+			if strings.EqualFold(password, commonPassword) {
+				return false, "Password is too common. Please choose another one."
+			}
+		}
+	}
+
+	return true, ""
+}
+
+// Handle the password policy settings. Leave policy empty for reading the current settings
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandlePasswordPolicySettings(w http.ResponseWriter, r *http.Request) {
+	policyJSON, err := utils.PostPara(r, "policy")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.GetPasswordPolicy())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	newPolicy := PasswordPolicy{}
+	err = json.Unmarshal([]byte(policyJSON), &newPolicy)
+	if err != nil {
+		sendErrorResponse(w, "Invalid policy given")
+		return
+	}
+
+	err = a.SetPasswordPolicy(newPolicy)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Password policy updated")
+	sendOK(w)
+}
@@ -0,0 +1,45 @@
+package auth
+
+import "testing"
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	//Empty policy should not restrict anything
+	if ok, reason := (PasswordPolicy{}).Validate("a"); !ok {
+		t.Errorf("Empty policy rejected password: %s", reason)
+	}
+
+	policy := PasswordPolicy{
+		MinLength:     8,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		RejectCommon:  true,
+	}
+
+	tests := []struct {
+		password string
+		accepted bool
+	}{
+		{"Ab1!", false},      //Too short
+		{"abcdefg1!", false}, //No upper
+		{"ABCDEFG1!", false}, //No lower
+		{"Abcdefgh!", false}, //No digit
+		{"Abcdefgh1", false}, //No symbol
+		{"Abcdefg1!", true},
+	}
+	for _, test := range tests {
+		ok, reason := policy.Validate(test.password)
+		if ok != test.accepted {
+			t.Errorf("Password %q: expected accepted=%v, got %v (%s)", test.password, test.accepted, ok, reason)
+		}
+		if !ok && reason == "" {
+			t.Errorf("Password %q rejected without reason", test.password)
+		}
+	}
+
+	commonOnly := PasswordPolicy{RejectCommon: true}
+	if ok, _ := commonOnly.Validate("Password123"); ok {
+		t.Errorf("Common password should be rejected regardless of case")
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Password Reset
+
+	This script handle the self-service password reset with one-time tokens.
+	The token is delivered to the user via the PasswordResetSender hook and
+	is stored as
+
+	auth/resettoken/{hashed token} => PasswordResetToken
+	auth/resetuser/{username} => hashed token
+
+	Only one token is valid per user. A token is removed once used, after
+	PasswordResetTTL seconds, or when the password has been changed by other means.
+*/
+
+// Hook for delivering the password reset token to the user
+type PasswordResetSender func(username string, token string) error
+
+type PasswordResetToken struct {
+	Username     string //Owner of this token
+	PasswordHash string //Password hash of the user when the token is issued
+	CreationTime int64  //Creation time of this token
+}
+
+// Create a one-time password reset token for the user. Previously issued token is invalidated
+func (a *AuthAgent) CreatePasswordResetToken(username string) (string, error) {
+	passwordHash := ""
+	err := a.Database.Read("auth", "passhash/"+username, &passwordHash)
+	if err != nil {
+		return "", errors.New("user not exists")
+	}
+
+	token, err := generateVerificationToken()
+	if err != nil {
+		return "", err
+	}
+
+	a.removePasswordResetToken(username)
+	tokenHash := Hash(token)
+	err = a.Database.Write("auth", "resettoken/"+tokenHash, PasswordResetToken{
+		Username:     username,
+		PasswordHash: passwordHash,
+		CreationTime: time.Now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	a.Database.Write("auth", "resetuser/"+username, tokenHash)
+	return token, nil
+}
+
+// Reset the password of the token owner. The token is consumed if the password is updated
+func (a *AuthAgent) ResetPasswordWithToken(token string, newPassword string) (string, error) {
+	tokenHash := Hash(strings.TrimSpace(token))
+	resetToken := PasswordResetToken{}
+	err := a.Database.Read("auth", "resettoken/"+tokenHash, &resetToken)
+	if err != nil || resetToken.Username == "" {
+		return "", errors.New("invalid or expired reset token")
+	}
+
+	if a.passwordResetTokenExpired(&resetToken, time.Now().Unix()) {
+		a.removePasswordResetToken(resetToken.Username)
+		return "", errors.New("invalid or expired reset token")
+	}
+
+	if ok, reason := a.ValidatePasswordWithPolicy(newPassword); !ok {
+		return "", errors.New(reason)
+	}
+
+	err = a.SetUserPassword(resetToken.Username, newPassword)
+	if err != nil {
+		return "", err
+	}
+
+	//Token is one-time use. Also logout all existing sessions of this user
+	a.removePasswordResetToken(resetToken.Username)
+	a.RevokeAllUserSessions(resetToken.Username)
+	a.RevokeAllUserTrustedDevices(resetToken.Username)
+	a.SessionCache.InvalidateUser(resetToken.Username)
+	return resetToken.Username, nil
+}
+
+// Remove the reset tokens that are expired or no longer valid
+func (a *AuthAgent) RemoveExpiredPasswordResetTokens() {
+	entries, err := a.Database.ListTable("auth")
+	if err != nil {
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, keypairs := range entries {
+		if !strings.HasPrefix(string(keypairs[0]), "resettoken/") {
+			continue
+		}
+
+		resetToken := PasswordResetToken{}
+		err = json.Unmarshal(keypairs[1], &resetToken)
+		if err != nil || a.passwordResetTokenExpired(&resetToken, now) {
+			a.Database.Delete("auth", string(keypairs[0]))
+			if resetToken.Username != "" {
+				a.Database.Delete("auth", "resetuser/"+resetToken.Username)
+			}
+		}
+	}
+}
+
+// Handle password reset request. Require POST username or email
+func (a *AuthAgent) HandlePasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	if a.SendPasswordReset == nil {
+		sendErrorResponse(w, "Password reset is not available on this host")
+		return
+	}
+
+	//Check if this request origin is allowed to access
+	if ok, reason := a.ValidateLoginRequest(w, r); !ok {
+		if reason == nil {
+			reason = errors.New("Unable to resolve request origin")
+		}
+		sendErrorResponse(w, reason.Error())
+		return
+	}
+
+	username, _ := utils.PostPara(r, "username")
+	if username == "" {
+		email, err := utils.PostPara(r, "email")
+		if err != nil || email == "" {
+			sendErrorResponse(w, "Username or email not given")
+			return
+		}
+		if a.LookupUsernameByEmail != nil {
+			username, _ = a.LookupUsernameByEmail(email)
+		}
+	}
+
+	//Always reply OK so the request cannot be used to probe for registered accounts
+	if username == "" || !a.UserExists(username) {
+		log.Println("[System Auth] Password reset requested for unknown account")
+		sendOK(w)
+		return
+	}
+
+	token, err := a.CreatePasswordResetToken(username)
+	if err != nil {
+		sendErrorResponse(w, "Unable to create reset token")
+		return
+	}
+
+	err = a.SendPasswordReset(username, token)
+	if err != nil {
+		a.removePasswordResetToken(username)
+		log.Println("[System Auth] Unable to deliver password reset token to " + username + ": " + err.Error())
+		sendErrorResponse(w, "Unable to deliver the password reset token")
+		return
+	}
+
+	log.Println("[System Auth] Password reset requested for " + username)
+	a.LogAuditEvent(r, AuditActionResetRequest, "", username, true, "")
+	sendOK(w)
+}
+
+// Handle password reset confirmation. Require POST token and password
+func (a *AuthAgent) HandlePasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	token, err := utils.PostPara(r, "token")
+	if err != nil {
+		sendErrorResponse(w, "Invalid reset token")
+		return
+	}
+
+	password, err := utils.PostPara(r, "password")
+	if err != nil {
+		sendErrorResponse(w, "Password not defined or empty")
+		return
+	}
+
+	username, err := a.ResetPasswordWithToken(token, password)
+	if err != nil {
+		a.LogAuditEvent(r, AuditActionResetConfirm, "", "", false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Password of " + username + " has been reset")
+	a.LogAuditEvent(r, AuditActionResetConfirm, username, username, true, "")
+	sendOK(w)
+}
+
+// Check if the token expired or the password has been changed since the token is issued
+func (a *AuthAgent) passwordResetTokenExpired(resetToken *PasswordResetToken, now int64) bool {
+	if now-resetToken.CreationTime > a.PasswordResetTTL {
+		return true
+	}
+	passwordHash := ""
+	a.Database.Read("auth", "passhash/"+resetToken.Username, &passwordHash)
+	return passwordHash != resetToken.PasswordHash
+}
+
+func (a *AuthAgent) removePasswordResetToken(username string) {
+	tokenHash := ""
+	err := a.Database.Read("auth", "resetuser/"+username, &tokenHash)
+	if err == nil {
+		a.Database.Delete("auth", "resettoken/"+tokenHash)
+	}
+	a.Database.Delete("auth", "resetuser/"+username)
+}
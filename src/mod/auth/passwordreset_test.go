@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestPasswordResetToken(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+	a.PasswordResetTTL = 3600
+	a.CreateUserAccount("alice", "oldpassword", []string{"user"})
+
+	//Issuing a new token invalidate the previous one
+	firstToken, err := a.CreatePasswordResetToken("alice")
+	if err != nil {
+		t.Fatalf("Failed to create reset token: %v", err)
+	}
+	token, _ := a.CreatePasswordResetToken("alice")
+	if _, err := a.ResetPasswordWithToken(firstToken, "newpassword"); err == nil {
+		t.Error("Expected superseded token to be rejected")
+	}
+
+	username, err := a.ResetPasswordWithToken(token, "newpassword")
+	if err != nil || username != "alice" {
+		t.Fatalf("Expected password reset to succeed, got %q: %v", username, err)
+	}
+	if !a.ValidateUsernameAndPassword("alice", "newpassword") {
+		t.Error("Expected new password to be accepted")
+	}
+
+	//Token is single use
+	if _, err := a.ResetPasswordWithToken(token, "anotherpassword"); err == nil {
+		t.Error("Expected used token to be rejected")
+	}
+
+	//Token is invalidated if the password changed by other means
+	token, _ = a.CreatePasswordResetToken("alice")
+	a.Database.Write("auth", "passhash/alice", Hash("changedbyadmin"))
+	if _, err := a.ResetPasswordWithToken(token, "anotherpassword"); err == nil {
+		t.Error("Expected token to be invalidated after password change")
+	}
+
+	//Expired token
+	a.PasswordResetTTL = -1
+	token, _ = a.CreatePasswordResetToken("alice")
+	if _, err := a.ResetPasswordWithToken(token, "anotherpassword"); err == nil {
+		t.Error("Expected expired token to be rejected")
+	}
+	a.RemoveExpiredPasswordResetTokens()
+	if a.Database.KeyExists("auth", "resetuser/alice") {
+		t.Error("Expected expired token to be removed")
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"strconv"
+)
+
+/*
+	Storage Quota Enforcement
+
+	The auth agent check the storage quota of a user before file writes,
+	so the quota policy is kept in one place instead of each file handler.
+	The usage and limit of the user is resolved by a StorageQuotaProvider,
+	e.g. the largest quota of the user's permission groups.
+
+	Quota is not enforced if no provider is set.
+*/
+
+// Interface for resolving the storage usage and quota of a user
+type StorageQuotaProvider interface {
+	//Return the used storage and the quota of the user in bytes, quota is -1 for unlimited
+	GetStorageQuota(username string) (used int64, quota int64, err error)
+}
+
+// Error returned when a write will exceed the storage quota of the user
+type QuotaExceededError struct {
+	Username  string //User that exceed the quota
+	Requested int64  //Size of the write in bytes
+	Used      int64  //Used storage in bytes before the write
+	Quota     int64  //Storage quota of the user in bytes
+}
+
+func (e *QuotaExceededError) Error() string {
+	return "User Storage Quota Exceeded (" + strconv.FormatInt(e.Used, 10) + " of " + strconv.FormatInt(e.Quota, 10) + " bytes used, " + strconv.FormatInt(e.Requested, 10) + " bytes requested)"
+}
+
+// Check if the user can write the given number of bytes. Return *QuotaExceededError if the quota is exceeded
+func (a *AuthAgent) CheckStorageQuota(username string, size int64) error {
+	if a.StorageQuota == nil {
+		return nil
+	}
+
+	used, quota, err := a.StorageQuota.GetStorageQuota(username)
+	if err != nil {
+		return err
+	}
+	if quota < 0 || used+size <= quota {
+		return nil
+	}
+
+	return &QuotaExceededError{
+		Username:  username,
+		Requested: size,
+		Used:      used,
+		Quota:     quota,
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+type staticQuotaProvider map[string][2]int64
+
+func (p staticQuotaProvider) GetStorageQuota(username string) (int64, int64, error) {
+	usage, ok := p[username]
+	if !ok {
+		return 0, 0, errors.New("user not found")
+	}
+	return usage[0], usage[1], nil
+}
+
+func TestCheckStorageQuota(t *testing.T) {
+	a := &AuthAgent{}
+	if err := a.CheckStorageQuota("alice", 1<<30); err != nil {
+		t.Errorf("Expected quota not enforced without provider, got %v", err)
+	}
+
+	a.StorageQuota = staticQuotaProvider{
+		"alice": {900, 1000},
+		"admin": {1 << 40, -1},
+	}
+	if err := a.CheckStorageQuota("alice", 100); err != nil {
+		t.Errorf("Expected write filling up the quota to be allowed, got %v", err)
+	}
+	if err := a.CheckStorageQuota("admin", 1<<40); err != nil {
+		t.Errorf("Expected unlimited quota, got %v", err)
+	}
+	if err := a.CheckStorageQuota("nobody", 1); err == nil {
+		t.Error("Expected error for unknown user")
+	}
+
+	err := a.CheckStorageQuota("alice", 101)
+	quotaErr := &QuotaExceededError{}
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("Expected QuotaExceededError, got %v", err)
+	}
+	if quotaErr.Used != 900 || quotaErr.Quota != 1000 || quotaErr.Requested != 101 {
+		t.Errorf("Unexpected quota error details: %+v", quotaErr)
+	}
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Login Redirect Allow-list
+
+	This script validate the redirect target given to the login pages
+	so a crafted link cannot send the user to an external site after login.
+
+	Relative paths and URLs of the same origin are always allowed.
+	Other absolute URLs must match one of the admin configured prefixes,
+	stored as
+	auth_policy/redirect => []string
+*/
+
+// Redirect target used when the given one is not allowed
+const DefaultRedirectTarget = "/"
+
+func (a *AuthAgent) loadRedirectAllowList() {
+	allowList := []string{}
+	if a.Database.KeyExists("auth_policy", "redirect") {
+		a.Database.Read("auth_policy", "redirect", &allowList)
+	}
+	a.redirectAllowList = allowList
+}
+
+// Get the additional redirect prefixes allowed by admin
+func (a *AuthAgent) GetRedirectAllowList() []string {
+	return a.redirectAllowList
+}
+
+// Set and save the additional redirect prefixes, each prefix must be an absolute http(s) URL
+func (a *AuthAgent) SetRedirectAllowList(prefixes []string) error {
+	allowList := []string{}
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		u, err := url.Parse(prefix)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("invalid redirect prefix: " + prefix)
+		}
+		allowList = append(allowList, prefix)
+	}
+	a.redirectAllowList = allowList
+	return a.Database.Write("auth_policy", "redirect", allowList)
+}
+
+// Return the redirect target if it is allowed, or DefaultRedirectTarget otherwise
+func (a *AuthAgent) SanitizeRedirectTarget(r *http.Request, target string) string {
+	if target == "" {
+		return ""
+	}
+	if a.IsAllowedRedirectTarget(r, target) {
+		return target
+	}
+	log.Println("[System Auth] Disallowed login redirect target replaced: " + target)
+	return DefaultRedirectTarget
+}
+
+// Check if the redirect target is a relative path, same origin or matches the allow-list
+func (a *AuthAgent) IsAllowedRedirectTarget(r *http.Request, target string) bool {
+	//Browsers treat backslashes as slashes and ignore control characters, e.g. /\evil.com
+	if strings.ContainsAny(target, "\\") || strings.IndexFunc(target, func(c rune) bool { return c < 0x20 || c == 0x7f }) >= 0 {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	if u.Scheme == "" && u.Host == "" && !strings.HasPrefix(target, "//") {
+		//Relative path
+		return true
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		//Protocol relative (//evil.com) or other schemes like javascript:
+		return false
+	}
+
+	//Absolute URL of the same origin
+	if r != nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, prefix := range a.redirectAllowList {
+		allowed, err := url.Parse(prefix)
+		if err != nil {
+			continue
+		}
+		if u.Scheme == allowed.Scheme && strings.EqualFold(u.Host, allowed.Host) && matchPathPrefix(u.Path, allowed.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match the path prefix by segments, so /app do not match /application
+func matchPathPrefix(path string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Handle the redirect allow-list settings, POST prefixes (JSON array) to update
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleRedirectAllowListSettings(w http.ResponseWriter, r *http.Request) {
+	prefixesJSON, err := utils.PostPara(r, "prefixes")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.GetRedirectAllowList())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	prefixes := []string{}
+	err = json.Unmarshal([]byte(prefixesJSON), &prefixes)
+	if err != nil {
+		sendErrorResponse(w, "Invalid prefixes given")
+		return
+	}
+
+	err = a.SetRedirectAllowList(prefixes)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Login redirect allow-list updated")
+	sendOK(w)
+}
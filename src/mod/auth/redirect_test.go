@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSanitizeRedirectTarget(t *testing.T) {
+	a := &AuthAgent{redirectAllowList: []string{"https://intranet.example.com/app"}}
+	r := httptest.NewRequest("GET", "http://arozos.local:8080/login.system", nil)
+
+	cases := map[string]string{
+		"":                "",
+		"/desktop.system": "/desktop.system",
+		"/SystemAO/file_system/file_explorer.html?dir=user:/": "/SystemAO/file_system/file_explorer.html?dir=user:/",
+		"desktop.system": "desktop.system",
+		"http://arozos.local:8080/desktop.system":  "http://arozos.local:8080/desktop.system",
+		"https://intranet.example.com/app/home":    "https://intranet.example.com/app/home",
+		"//evil.com":                               DefaultRedirectTarget,
+		"///evil.com":                              DefaultRedirectTarget,
+		"/\\evil.com":                              DefaultRedirectTarget,
+		"https://evil.com":                         DefaultRedirectTarget,
+		"https://evil.com/arozos.local:8080":       DefaultRedirectTarget,
+		"https://intranet.example.com/application": DefaultRedirectTarget,
+		"javascript:alert(1)":                      DefaultRedirectTarget,
+		"/\t/evil.com":                             DefaultRedirectTarget,
+	}
+	for target, expected := range cases {
+		if result := a.SanitizeRedirectTarget(r, target); result != expected {
+			t.Errorf("SanitizeRedirectTarget(%q) = %q, expected %q", target, result, expected)
+		}
+	}
+}
@@ -26,17 +26,23 @@ import (
 )
 
 type RegisterOptions struct {
-	Hostname   string
-	VendorIcon string
+	Hostname    string
+	VendorIcon  string
+	ExternalURL string //External URL of this host for the links in emails, e.g. https://cloud.example.com
 }
 
+// Hook for sending the verification email to newly registered users
+type VerificationEmailSender func(username string, email string, verifyURL string) error
+
 type RegisterHandler struct {
-	database          *db.Database
-	authAgent         *auth.AuthAgent
-	permissionHandler *permission.PermissionHandler
-	options           RegisterOptions
-	DefaultUserGroup  string
-	AllowRegistry     bool
+	database                 *db.Database
+	authAgent                *auth.AuthAgent
+	permissionHandler        *permission.PermissionHandler
+	options                  RegisterOptions
+	DefaultUserGroup         string
+	AllowRegistry            bool
+	RequireEmailVerification bool                    //New accounts cannot login until the email is verified
+	SendVerificationEmail    VerificationEmailSender //Required if RequireEmailVerification is true
 }
 
 func NewRegisterHandler(database *db.Database, authAgent *auth.AuthAgent, ph *permission.PermissionHandler, options RegisterOptions) *RegisterHandler {
@@ -83,7 +89,7 @@ func createDefaultGroup(ph *permission.PermissionHandler) {
 }
 
 func (h *RegisterHandler) HandleRegisterCheck(w http.ResponseWriter, r *http.Request) {
-	if h.AllowRegistry {
+	if h.AllowRegistry && h.authAgent.AdminExists() {
 		utils.SendJSONResponse(w, "true")
 	} else {
 		utils.SendJSONResponse(w, "false")
@@ -192,12 +198,60 @@ func (h *RegisterHandler) ListAllUserEmails() [][]interface{} {
 	return results
 }
 
+// Get the username registered with the given email, case insensitive.
+// Return error if the email is shared by more than one account
+func (h *RegisterHandler) GetUsernameByEmail(email string) (string, error) {
+	email = normalizeEmail(email)
+	matchingUsername := ""
+	for _, record := range h.ListAllUserEmails() {
+		if normalizeEmail(record[1].(string)) == email && record[2].(bool) {
+			if matchingUsername != "" && matchingUsername != record[0].(string) {
+				return "", errors.New("Email registered by multiple accounts")
+			}
+			matchingUsername = record[0].(string)
+		}
+	}
+	if matchingUsername == "" {
+		return "", errors.New("Email not registered")
+	}
+	return matchingUsername, nil
+}
+
+// Check if the email can be used by the given user. Emails are unique (case insensitive) among the registered accounts
+func (h *RegisterHandler) ValidateUserEmail(username string, email string) error {
+	if !isValidEmail(email) {
+		return errors.New("Invalid or malformed email")
+	}
+	email = normalizeEmail(email)
+	for _, record := range h.ListAllUserEmails() {
+		if record[0].(string) != username && record[2].(bool) && normalizeEmail(record[1].(string)) == email {
+			return errors.New("Email already registered by another account")
+		}
+	}
+	return nil
+}
+
+// Set the email of the given user after checking it is not used by other accounts
+func (h *RegisterHandler) SetUserEmail(username string, email string) error {
+	err := h.ValidateUserEmail(username, email)
+	if err != nil {
+		return err
+	}
+	return h.database.Write("register", "user/email/"+username, strings.TrimSpace(email))
+}
+
 // Handle the request for creating a new user
 func (h *RegisterHandler) HandleRegisterRequest(w http.ResponseWriter, r *http.Request) {
 	if h.AllowRegistry == false {
 		utils.SendErrorResponse(w, "Public account registry is currently closed")
 		return
 	}
+
+	//Public registry is closed until the administrator account is created, see auth/bootstrap.go
+	if !h.authAgent.AdminExists() {
+		utils.SendErrorResponse(w, "Public account registry is not available before the administrator account is created")
+		return
+	}
 	//Get input paramter
 	email, err := utils.PostPara(r, "email")
 	if err != nil {
@@ -210,6 +264,7 @@ func (h *RegisterHandler) HandleRegisterRequest(w http.ResponseWriter, r *http.R
 		utils.SendErrorResponse(w, "Invalid or malformed email")
 		return
 	}
+	email = strings.TrimSpace(email)
 
 	username, err := utils.PostPara(r, "username")
 	if username == "" || strings.TrimSpace(username) == "" || err != nil {
@@ -229,9 +284,16 @@ func (h *RegisterHandler) HandleRegisterRequest(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	//Check if the username is too short
-	if len(username) < 2 {
-		utils.SendErrorResponse(w, "Username too short. Must be at least 2 characters.")
+	//Check if the password fulfill the password policy
+	if ok, reason := h.authAgent.ValidatePasswordWithPolicy(password); !ok {
+		utils.SendErrorResponse(w, reason)
+		return
+	}
+
+	//Check if the username fulfill the username policy
+	username = h.authAgent.NormalizeUsername(username)
+	if ok, reason := h.authAgent.ValidateUsernameWithPolicy(username); !ok {
+		utils.SendErrorResponse(w, reason)
 		return
 	}
 
@@ -241,6 +303,13 @@ func (h *RegisterHandler) HandleRegisterRequest(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	//Check if too many accounts are registered recently
+	err = h.authAgent.CheckRegistrationRateLimit(r)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
 	//Get the default user group for public registration
 	defaultGroup := h.DefaultUserGroup
 	if h.permissionHandler.GroupExists(defaultGroup) == false {
@@ -250,6 +319,18 @@ func (h *RegisterHandler) HandleRegisterRequest(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	//Each email can only be used by one account
+	err = h.ValidateUserEmail(username, email)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	if h.RequireEmailVerification {
+		h.handlePendingRegisterRequest(w, r, username, password, email, defaultGroup)
+		return
+	}
+
 	//OK. Record this user to the system
 	err = h.authAgent.CreateUserAccount(username, password, []string{defaultGroup})
 	if err != nil {
@@ -259,12 +340,53 @@ func (h *RegisterHandler) HandleRegisterRequest(w http.ResponseWriter, r *http.R
 
 	//Write email to database as well
 	h.database.Write("register", "user/email/"+username, email)
+	h.authAgent.RecordRegistration(r, username)
 
 	utils.SendOK(w)
 	log.Println("New User Registered: ", email, username, strings.Repeat("*", len(password)))
 
 }
 
+// Create the account in pending state and send out the verification email
+func (h *RegisterHandler) handlePendingRegisterRequest(w http.ResponseWriter, r *http.Request, username string, password string, email string, group string) {
+	if h.SendVerificationEmail == nil {
+		log.Println("[Register] Email verification enabled but no email sender is configured")
+		utils.SendErrorResponse(w, "Email verification is not available on this host")
+		return
+	}
+
+	//The link must not be built from the request Host header, which is controlled by the client
+	if h.options.ExternalURL == "" {
+		log.Println("[Register] Email verification enabled but the external URL of this host is not configured")
+		utils.SendErrorResponse(w, "Email verification is not available on this host")
+		return
+	}
+
+	token, err := h.authAgent.CreatePendingUserAccount(username, password, []string{group}, email)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	//Write email to database as well, the email sender resolve the user email from it
+	h.database.Write("register", "user/email/"+username, email)
+
+	verifyURL := strings.TrimSuffix(h.options.ExternalURL, "/") + "/system/auth/register/verify?token=" + token
+	err = h.SendVerificationEmail(username, email, verifyURL)
+	if err != nil {
+		//Rollback the registration so the user can try again
+		h.authAgent.UnregisterUser(username)
+		h.database.Delete("register", "user/email/"+username)
+		log.Println("[Register] Unable to send verification email: " + err.Error())
+		utils.SendErrorResponse(w, "Unable to send verification email")
+		return
+	}
+
+	h.authAgent.RecordRegistration(r, username)
+	utils.SendOK(w)
+	log.Println("New User Registered (Pending Verification): ", email, username, strings.Repeat("*", len(password)))
+}
+
 // Change Email for the registered user
 func (h *RegisterHandler) HandleEmailChange(w http.ResponseWriter, r *http.Request) {
 	//Get username from request
@@ -283,14 +405,12 @@ func (h *RegisterHandler) HandleEmailChange(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	//Validate the email is a email
-	if !isValidEmail(email) {
-		utils.SendErrorResponse(w, "Invalid or malformed email")
+	//Write email to database as well, the email must not be used by other accounts
+	err = h.SetUserEmail(username, email)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
 		return
 	}
-
-	//Write email to database as well
-	h.database.Write("register", "user/email/"+username, email)
 }
 
 // Get user email by name
@@ -308,3 +428,8 @@ func isValidEmail(email string) bool {
 	_, err := mail.ParseAddress(email)
 	return err == nil
 }
+
+// Normalize the email for comparison
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
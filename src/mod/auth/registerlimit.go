@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Registration Rate Limit
+
+	This script limit the number of accounts that can be registered
+	from the same IP and in total within a time window, so bots cannot
+	flood the system with accounts when public registration is enabled.
+
+	The limits are stored as
+	auth_policy/registration => RegistrationLimit
+*/
+
+type RegistrationLimit struct {
+	PerIP  int   //Max number of registrations from the same IP within the window, 0 = unlimited
+	Global int   //Max number of registrations in total within the window, 0 = unlimited
+	Window int64 //Time window in seconds
+}
+
+// Default limits, generous enough for normal usage
+var defaultRegistrationLimit = RegistrationLimit{
+	PerIP:  10,
+	Global: 100,
+	Window: 3600,
+}
+
+func (a *AuthAgent) loadRegistrationLimit() {
+	limit := defaultRegistrationLimit
+	if a.Database.KeyExists("auth_policy", "registration") {
+		a.Database.Read("auth_policy", "registration", &limit)
+	}
+	a.registrationLimit = limit
+}
+
+// Get the current registration limits
+func (a *AuthAgent) GetRegistrationLimit() RegistrationLimit {
+	return a.registrationLimit
+}
+
+// Set and save the registration limits
+func (a *AuthAgent) SetRegistrationLimit(limit RegistrationLimit) error {
+	if limit.PerIP < 0 || limit.Global < 0 || limit.Window <= 0 {
+		return errors.New("invalid registration limit given")
+	}
+	a.registrationLimit = limit
+	return a.Database.Write("auth_policy", "registration", limit)
+}
+
+// Check if a new account can be registered by this request. Rejected attempts are logged
+func (a *AuthAgent) CheckRegistrationRateLimit(r *http.Request) error {
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		clientIP = "unknown"
+	}
+
+	err = a.checkRegistrationRateLimitFromIP(clientIP, time.Now().Unix())
+	if err != nil {
+		log.Println("[System Auth] Registration from " + clientIP + " rejected: " + err.Error())
+		a.Logger.LogAuthEvent("", clientIP, false, "register-ratelimit")
+		a.LogAuditEvent(r, AuditActionRegister, "", "", false, err.Error())
+	}
+	return err
+}
+
+// Record a successful registration of the account made by this request
+func (a *AuthAgent) RecordRegistration(r *http.Request, username string) {
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		clientIP = "unknown"
+	}
+	a.recordRegistrationFromIP(clientIP, time.Now().Unix())
+	a.Events.Publish(AuthEvent{
+		Type:     EventRegister,
+		Username: username,
+		IpAddr:   clientIP,
+		Request:  r,
+	})
+}
+
+func (a *AuthAgent) checkRegistrationRateLimitFromIP(ip string, now int64) error {
+	a.registrationMutex.Lock()
+	defer a.registrationMutex.Unlock()
+	a.pruneRegistrationRecords(now)
+
+	limit := a.registrationLimit
+	if limit.Global > 0 && len(a.globalRegistrations) >= limit.Global {
+		return errors.New("Too many registrations on this host. Please try again later")
+	}
+	if limit.PerIP > 0 && len(a.registrations[ip]) >= limit.PerIP {
+		return errors.New("Too many registrations from your IP. Please try again in " + strconv.Itoa(int(limit.Window/60)) + " minutes")
+	}
+	return nil
+}
+
+func (a *AuthAgent) recordRegistrationFromIP(ip string, now int64) {
+	a.registrationMutex.Lock()
+	defer a.registrationMutex.Unlock()
+	if a.registrations == nil {
+		a.registrations = map[string][]int64{}
+	}
+	a.registrations[ip] = append(a.registrations[ip], now)
+	a.globalRegistrations = append(a.globalRegistrations, now)
+}
+
+// Drop the registration records outside of the window. Caller must hold the registration mutex
+func (a *AuthAgent) pruneRegistrationRecords(now int64) {
+	window := a.registrationLimit.Window
+	for ip, records := range a.registrations {
+		remaining := []int64{}
+		for _, registerTime := range records {
+			if now-registerTime < window {
+				remaining = append(remaining, registerTime)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(a.registrations, ip)
+		} else {
+			a.registrations[ip] = remaining
+		}
+	}
+
+	remaining := []int64{}
+	for _, registerTime := range a.globalRegistrations {
+		if now-registerTime < window {
+			remaining = append(remaining, registerTime)
+		}
+	}
+	a.globalRegistrations = remaining
+}
+
+// Handle the registration rate limit settings. Accept POST limit as JSON, leave empty for reading the current settings
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleRegistrationLimitSettings(w http.ResponseWriter, r *http.Request) {
+	limitJSON, err := utils.PostPara(r, "limit")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.GetRegistrationLimit())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	newLimit := RegistrationLimit{}
+	err = json.Unmarshal([]byte(limitJSON), &newLimit)
+	if err != nil {
+		sendErrorResponse(w, "Invalid limit given")
+		return
+	}
+
+	err = a.SetRegistrationLimit(newLimit)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Registration rate limit updated")
+	sendOK(w)
+}
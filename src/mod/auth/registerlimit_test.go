@@ -0,0 +1,32 @@
+package auth
+
+import "testing"
+
+func TestRegistrationRateLimit(t *testing.T) {
+	a := &AuthAgent{
+		registrationLimit: RegistrationLimit{PerIP: 2, Global: 3, Window: 60},
+		registrations:     map[string][]int64{},
+	}
+
+	a.recordRegistrationFromIP("10.0.0.1", 0)
+	a.recordRegistrationFromIP("10.0.0.1", 10)
+	if a.checkRegistrationRateLimitFromIP("10.0.0.1", 20) == nil {
+		t.Error("IP should be limited after reaching the per IP limit")
+	}
+	if err := a.checkRegistrationRateLimitFromIP("10.0.0.2", 20); err != nil {
+		t.Errorf("Other IP should not be limited: %v", err)
+	}
+
+	a.recordRegistrationFromIP("10.0.0.2", 20)
+	if a.checkRegistrationRateLimitFromIP("10.0.0.3", 30) == nil {
+		t.Error("Registration should be limited after reaching the global limit")
+	}
+
+	//Records outside of the window should no longer count
+	if err := a.checkRegistrationRateLimitFromIP("10.0.0.1", 75); err != nil {
+		t.Errorf("IP should not be limited after the window passed: %v", err)
+	}
+	if _, ok := a.registrations["10.0.0.2"]; !ok {
+		t.Error("Records within the window should be kept")
+	}
+}
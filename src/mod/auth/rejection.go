@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Login Rejection Reason
+
+	To avoid username enumeration, login failures that can tell an unknown
+	user apart from a wrong password (or a locked account) are collapsed into
+	a single generic message for external clients. The specific reason is
+	still written to the system log and the audit log.
+
+	Reasons that are only given after the password is verified
+	(e.g. pending verification or disabled account) are not collapsed.
+
+	Admin can enable the verbose reasons, stored as
+	auth_policy/verbosereason => bool
+*/
+
+// Generic message returned to clients for failed logins
+const genericLoginFailureReason = "Invalid username or password"
+
+// Specific reasons of the login failure, only shown to clients if verbose reasons are enabled
+const (
+	userNotFoundReason      = "User not found"
+	incorrectPasswordReason = "Incorrect password"
+)
+
+func (a *AuthAgent) loadVerboseLoginReason() {
+	verbose := false
+	if a.Database.KeyExists("auth_policy", "verbosereason") {
+		a.Database.Read("auth_policy", "verbosereason", &verbose)
+	}
+	a.verboseLoginReason = verbose
+}
+
+// Check if the specific login rejection reasons are shown to clients
+func (a *AuthAgent) VerboseLoginReasonEnabled() bool {
+	return a.verboseLoginReason
+}
+
+// Set and save if the specific login rejection reasons are shown to clients
+func (a *AuthAgent) SetVerboseLoginReason(verbose bool) error {
+	a.verboseLoginReason = verbose
+	return a.Database.Write("auth_policy", "verbosereason", verbose)
+}
+
+// Return the rejection reason that is safe to be shown to external clients
+func (a *AuthAgent) LoginRejectionReason(reason string) string {
+	if a.verboseLoginReason {
+		return reason
+	}
+
+	switch reason {
+	case userNotFoundReason, incorrectPasswordReason, accountLockedReason:
+		return genericLoginFailureReason
+	}
+	return reason
+}
+
+// Handle the login rejection reason settings, POST verbose (true / false) to update
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleLoginRejectionReasonSettings(w http.ResponseWriter, r *http.Request) {
+	verbose, err := utils.PostPara(r, "verbose")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.VerboseLoginReasonEnabled())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	if verbose != "true" && verbose != "false" {
+		sendErrorResponse(w, "Invalid verbose value given")
+		return
+	}
+
+	err = a.SetVerboseLoginReason(verbose == "true")
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Verbose login rejection reasons set to " + verbose)
+	sendOK(w)
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestLoginRejectionReason(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_policy")
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	_, unknownUserReason := a.ValidateUsernameAndPasswordWithReason("nobody", "password")
+	_, wrongPasswordReason := a.ValidateUsernameAndPasswordWithReason("alice", "wrong")
+	if unknownUserReason != wrongPasswordReason || unknownUserReason != genericLoginFailureReason {
+		t.Errorf("Expected identical generic reasons, got %q and %q", unknownUserReason, wrongPasswordReason)
+	}
+	if a.LoginRejectionReason(accountLockedReason) != genericLoginFailureReason {
+		t.Error("Expected locked account reason to be hidden by default")
+	}
+
+	if err := a.SetVerboseLoginReason(true); err != nil {
+		t.Fatalf("Failed to enable verbose reasons: %v", err)
+	}
+	_, unknownUserReason = a.ValidateUsernameAndPasswordWithReason("nobody", "password")
+	_, wrongPasswordReason = a.ValidateUsernameAndPasswordWithReason("alice", "wrong")
+	if unknownUserReason != userNotFoundReason || wrongPasswordReason != incorrectPasswordReason {
+		t.Errorf("Expected specific reasons in verbose mode, got %q and %q", unknownUserReason, wrongPasswordReason)
+	}
+
+	//The setting should persist
+	b := &AuthAgent{Database: a.Database}
+	b.loadVerboseLoginReason()
+	if !b.VerboseLoginReasonEnabled() {
+		t.Error("Expected verbose reason setting to be loaded from database")
+	}
+}
@@ -0,0 +1,513 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Session Tracker
+
+	This script keep track of the login sessions issued by the auth agent
+	so they can be limited and revoked from the server side.
+
+	Session records are stored in the auth_sessions table as
+
+	auth_sessions/{sessionid} => SessionRecord
+
+	and the concurrent session limits are stored in the auth_sessionconf table as
+
+	auth_sessionconf/maxconcurrent => global limit
+	auth_sessionconf/maxconcurrent/{groupname} => group limit
+
+	and the session expiry settings (in seconds, 0 = never expire)
+
+	auth_sessionconf/maxage => absolute session lifetime
+	auth_sessionconf/idletimeout => idle timeout
+
+	Records are also removed once their cookie expired, i.e. no request
+	is made within the cookie lifetime (1 hour, 1 week with remember me),
+	so dead sessions do not count toward the concurrent session limits.
+*/
+
+// Cookie lifetime assumed for the records created before it is tracked
+const rememberMeCookieMaxAge = 3600 * 24 * 7
+
+type SessionRecord struct {
+	ID           string //Session ID, stored in the user session cookie
+	Username     string //Owner of this session
+	CreationTime int64  //Login time of this session
+	LastSeen     int64  //Last time a request is made with this session
+	IpAddr       string //IP address where the session is created
+	UserAgent    string //User agent of the client creating this session
+	Impersonator string //Admin impersonating the owner with this session, see impersonate.go
+	CookieMaxAge int64  //Lifetime of the session cookie in seconds, renewed on every request. 0 if not tracked
+}
+
+// Load the session records from database into memory
+func (a *AuthAgent) LoadSessionRecordsFromDB() error {
+	entries, err := a.Database.ListTable("auth_sessions")
+	if err != nil {
+		return err
+	}
+	for _, keypairs := range entries {
+		thisRecord := SessionRecord{}
+		err = json.Unmarshal(keypairs[1], &thisRecord)
+		if err == nil && thisRecord.ID != "" {
+			a.sessionRecords.Store(thisRecord.ID, &thisRecord)
+		}
+	}
+
+	return nil
+}
+
+// Create a new session record for the given user and request, cookieMaxAge is the lifetime of its session cookie
+func (a *AuthAgent) newSessionRecord(username string, r *http.Request, cookieMaxAge int64) *SessionRecord {
+	thisRecord := a.storeSessionRecord(username, "", r, cookieMaxAge)
+
+	//Update the last login time of the user
+	a.Database.Write("auth", "lastlogin/"+username, thisRecord.CreationTime)
+	return thisRecord
+}
+
+// Create and store a session record, set impersonator to empty string for normal login sessions
+func (a *AuthAgent) storeSessionRecord(username string, impersonator string, r *http.Request, cookieMaxAge int64) *SessionRecord {
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		clientIP = "unknown"
+	}
+
+	thisRecord := SessionRecord{
+		ID:           uuid.NewV4().String(),
+		Username:     username,
+		CreationTime: time.Now().Unix(),
+		LastSeen:     time.Now().Unix(),
+		IpAddr:       clientIP,
+		UserAgent:    r.UserAgent(),
+		Impersonator: impersonator,
+		CookieMaxAge: cookieMaxAge,
+	}
+
+	a.sessionRecords.Store(thisRecord.ID, &thisRecord)
+	a.Database.Write("auth_sessions", thisRecord.ID, thisRecord)
+	return &thisRecord
+}
+
+// Get a session record by its id
+func (a *AuthAgent) GetSessionRecord(sessionID string) (*SessionRecord, error) {
+	val, ok := a.sessionRecords.Load(sessionID)
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	return val.(*SessionRecord), nil
+}
+
+// List all active sessions of a given user, sorted by creation time (oldest first).
+// Sessions with expired cookie, lifetime or idle timeout are not listed even if not yet removed
+func (a *AuthAgent) ListUserSessions(username string) []*SessionRecord {
+	now := time.Now().Unix()
+	results := []*SessionRecord{}
+	a.sessionRecords.Range(func(key, value interface{}) bool {
+		thisRecord := value.(*SessionRecord)
+		if thisRecord.Username == username && !a.sessionExpired(thisRecord, now) {
+			results = append(results, thisRecord)
+		}
+		return true
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreationTime < results[j].CreationTime
+	})
+	return results
+}
+
+// Remove a session record, the session cookie holding this id will no longer be accepted
+func (a *AuthAgent) RevokeSession(sessionID string) error {
+	if _, ok := a.sessionRecords.Load(sessionID); !ok {
+		return errors.New("session not found")
+	}
+	a.sessionRecords.Delete(sessionID)
+	a.SessionCache.InvalidateSessionID(sessionID)
+	a.RevokeStepUpToken(sessionID)
+	return a.Database.Delete("auth_sessions", sessionID)
+}
+
+// Remove all session records of a given user, including the expired ones
+func (a *AuthAgent) RevokeAllUserSessions(username string) {
+	a.sessionRecords.Range(func(key, value interface{}) bool {
+		if value.(*SessionRecord).Username == username {
+			a.RevokeSession(key.(string))
+		}
+		return true
+	})
+}
+
+// Get the session id stored in the request cookie, return empty string if not found
+func (a *AuthAgent) getRequestSessionID(r *http.Request) string {
+	session, err := a.getSessionStore().Get(r, a.SessionName)
+	if err != nil {
+		return ""
+	}
+	sessionID, ok := session.Values["sessionid"].(string)
+	if !ok {
+		return ""
+	}
+	return sessionID
+}
+
+// Check if the session id stored in the cookie is still valid, and update its last seen time
+func (a *AuthAgent) validateSessionID(sessionID string) bool {
+	thisRecord, err := a.GetSessionRecord(sessionID)
+	if err != nil {
+		return false
+	}
+
+	//Remove the session if it exceeded its lifetime or has been idle for too long
+	now := time.Now().Unix()
+	if a.sessionExpired(thisRecord, now) {
+		a.RevokeSession(sessionID)
+		log.Println("[System Auth] Session " + sessionID + " of " + thisRecord.Username + " expired")
+		return false
+	}
+
+	//Kill the sessions of disabled accounts. See disable.go
+	if a.UserIsDisabled(thisRecord.Username) {
+		a.RevokeSession(sessionID)
+		return false
+	}
+
+	//Only write back to database once every minute to reduce IO
+	updatedRecord := *thisRecord
+	updatedRecord.LastSeen = now
+	a.sessionRecords.Store(sessionID, &updatedRecord)
+	if now-thisRecord.LastSeen > 60 {
+		a.Database.Write("auth_sessions", sessionID, updatedRecord)
+	}
+	return true
+}
+
+// Check if the session exceeded the max age or idle timeout, or its cookie expired at the given time
+func (a *AuthAgent) sessionExpired(thisRecord *SessionRecord, now int64) bool {
+	cookieMaxAge := thisRecord.CookieMaxAge
+	if cookieMaxAge <= 0 {
+		cookieMaxAge = rememberMeCookieMaxAge
+	}
+	if now-thisRecord.LastSeen > cookieMaxAge {
+		return true
+	}
+
+	maxAge := a.GetUserSessionMaxAge(thisRecord.Username)
+	if maxAge > 0 && now-thisRecord.CreationTime > maxAge {
+		return true
+	}
+	if a.SessionIdleTimeout > 0 && now-thisRecord.LastSeen > a.SessionIdleTimeout {
+		return true
+	}
+	return false
+}
+
+// Remove all session records that are expired
+func (a *AuthAgent) RemoveExpiredSessions() {
+	now := time.Now().Unix()
+	a.sessionRecords.Range(func(key, value interface{}) bool {
+		thisRecord := value.(*SessionRecord)
+		if a.sessionExpired(thisRecord, now) {
+			a.RevokeSession(thisRecord.ID)
+		}
+		return true
+	})
+}
+
+// Set the absolute session lifetime and idle timeout in seconds, 0 means never expire
+func (a *AuthAgent) SetSessionExpiry(maxAge int64, idleTimeout int64) error {
+	if maxAge < 0 || idleTimeout < 0 {
+		return errors.New("invalid session expiry given")
+	}
+	a.SessionMaxAge = maxAge
+	a.SessionIdleTimeout = idleTimeout
+	a.Database.Write("auth_sessionconf", "maxage", maxAge)
+	return a.Database.Write("auth_sessionconf", "idletimeout", idleTimeout)
+}
+
+// Get the max number of concurrent sessions allowed for the given user, 0 means unlimited
+func (a *AuthAgent) GetUserMaxConcurrentSessions(username string) int {
+	usergroups := []string{}
+	a.Database.Read("auth", "group/"+username, &usergroups)
+
+	//Use the largest limit defined by the user's groups
+	groupLimitFound := false
+	maxLimit := 0
+	for _, group := range usergroups {
+		groupLimit, err := a.GetGroupMaxConcurrentSessions(group)
+		if err != nil {
+			continue
+		}
+		if groupLimit == 0 {
+			//One of the group is unlimited
+			return 0
+		}
+		groupLimitFound = true
+		if groupLimit > maxLimit {
+			maxLimit = groupLimit
+		}
+	}
+
+	if groupLimitFound {
+		return maxLimit
+	}
+
+	return a.MaxConcurrentSessions
+}
+
+// Get the concurrent session limit of a group, return error if not set
+func (a *AuthAgent) GetGroupMaxConcurrentSessions(group string) (int, error) {
+	//Group policy take priority over the group limit settings. See grouppolicy.go
+	if thisPolicy := a.GetGroupPolicy(group); thisPolicy != nil && thisPolicy.MaxConcurrentSessions != nil {
+		return *thisPolicy.MaxConcurrentSessions, nil
+	}
+
+	if !a.Database.KeyExists("auth_sessionconf", "maxconcurrent/"+group) {
+		return 0, errors.New("group limit not set")
+	}
+	limit := 0
+	err := a.Database.Read("auth_sessionconf", "maxconcurrent/"+group, &limit)
+	if err != nil {
+		return 0, err
+	}
+	return limit, nil
+}
+
+// Set the global concurrent session limit, 0 means unlimited
+func (a *AuthAgent) SetMaxConcurrentSessions(limit int) error {
+	if limit < 0 {
+		return errors.New("invalid session limit given")
+	}
+	a.MaxConcurrentSessions = limit
+	return a.Database.Write("auth_sessionconf", "maxconcurrent", limit)
+}
+
+// Set the concurrent session limit of a group, set limit to -1 to remove the group limit
+func (a *AuthAgent) SetGroupMaxConcurrentSessions(group string, limit int) error {
+	if limit < 0 {
+		return a.Database.Delete("auth_sessionconf", "maxconcurrent/"+group)
+	}
+	return a.Database.Write("auth_sessionconf", "maxconcurrent/"+group, limit)
+}
+
+// Evict the oldest sessions of the user if the number of sessions exceed the limit. The current session is never evicted
+func (a *AuthAgent) enforceSessionLimit(username string, currentSessionID string) {
+	limit := a.GetUserMaxConcurrentSessions(username)
+	if limit <= 0 {
+		return
+	}
+
+	userSessions := []*SessionRecord{}
+	for _, thisSession := range a.ListUserSessions(username) {
+		if thisSession.ID != currentSessionID {
+			userSessions = append(userSessions, thisSession)
+		}
+	}
+
+	//Leave one slot for the current session
+	for len(userSessions) > limit-1 {
+		oldestSession := userSessions[0]
+		a.RevokeSession(oldestSession.ID)
+		log.Println("[System Auth] Session " + oldestSession.ID + " of " + username + " evicted due to concurrent session limit")
+		a.Logger.LogAuthEvent(username, oldestSession.IpAddr, false, "session-evicted")
+		userSessions = userSessions[1:]
+	}
+}
+
+// Handle listing of the current user's active sessions
+func (a *AuthAgent) HandleListUserSessions(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	type SessionInfo struct {
+		ID           string
+		CreationTime int64
+		LastSeen     int64
+		IpAddr       string
+		UserAgent    string
+		Current      bool //If this is the session making the request
+	}
+
+	currentSessionID := a.getRequestSessionID(r)
+	results := []*SessionInfo{}
+	for _, thisSession := range a.ListUserSessions(username) {
+		results = append(results, &SessionInfo{
+			ID:           thisSession.ID,
+			CreationTime: thisSession.CreationTime,
+			LastSeen:     thisSession.LastSeen,
+			IpAddr:       thisSession.IpAddr,
+			UserAgent:    thisSession.UserAgent,
+			Current:      thisSession.ID == currentSessionID,
+		})
+	}
+
+	js, _ := json.Marshal(results)
+	sendJSONResponse(w, string(js))
+}
+
+// Handle revoking one of the current user's sessions. Require POST id
+func (a *AuthAgent) HandleRevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	sessionID, err := utils.PostPara(r, "id")
+	if err != nil {
+		sendErrorResponse(w, "Invalid session id given")
+		return
+	}
+
+	//Users can only revoke their own sessions
+	thisSession, err := a.GetSessionRecord(sessionID)
+	if err != nil || thisSession.Username != username {
+		sendErrorResponse(w, "Session not found")
+		return
+	}
+
+	if sessionID == a.getRequestSessionID(r) {
+		sendErrorResponse(w, "Cannot revoke the current session. Logout instead")
+		return
+	}
+
+	err = a.RevokeSession(sessionID)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Session " + sessionID + " of " + username + " revoked by user")
+	sendOK(w)
+}
+
+// Handle the concurrent session limit settings. Leave limit empty for reading the current settings
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleSessionLimitSettings(w http.ResponseWriter, r *http.Request) {
+	group, _ := utils.PostPara(r, "group")
+	limit, err := utils.PostPara(r, "limit")
+	if err != nil {
+		//Read mode. List the global and group limits, Groups are the effective limits including the group policies
+		type SessionLimitSettings struct {
+			Global int
+			Groups map[string]int
+			Stored map[string]int //Group limits set in the session settings, overridden by group policy if defined there
+		}
+
+		settings := SessionLimitSettings{
+			Global: a.MaxConcurrentSessions,
+			Groups: map[string]int{},
+			Stored: map[string]int{},
+		}
+		entries, _ := a.Database.ListTable("auth_sessionconf")
+		for _, keypairs := range entries {
+			key := string(keypairs[0])
+			if strings.HasPrefix(key, "maxconcurrent/") {
+				groupLimit := 0
+				json.Unmarshal(keypairs[1], &groupLimit)
+				settings.Stored[strings.TrimPrefix(key, "maxconcurrent/")] = groupLimit
+			}
+		}
+		groups := []string{}
+		for group := range settings.Stored {
+			groups = append(groups, group)
+		}
+		a.groupPolicyMutex.RLock()
+		for group, thisPolicy := range a.groupPolicies {
+			if thisPolicy.MaxConcurrentSessions != nil {
+				groups = append(groups, group)
+			}
+		}
+		a.groupPolicyMutex.RUnlock()
+		for _, group := range groups {
+			settings.Groups[group], _ = a.GetGroupMaxConcurrentSessions(group)
+		}
+
+		js, _ := json.Marshal(settings)
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	limitInt, err := strconv.Atoi(limit)
+	if err != nil {
+		sendErrorResponse(w, "Invalid limit given")
+		return
+	}
+
+	if group == "" {
+		err = a.SetMaxConcurrentSessions(limitInt)
+	} else {
+		err = a.SetGroupMaxConcurrentSessions(group, limitInt)
+	}
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendOK(w)
+}
+
+// Handle the session lifetime and idle timeout settings. Leave maxage and idle empty for reading the current settings
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleSessionExpirySettings(w http.ResponseWriter, r *http.Request) {
+	maxAge, err := utils.PostPara(r, "maxage")
+	if err != nil {
+		//Read mode
+		type SessionExpirySettings struct {
+			MaxAge      int64
+			IdleTimeout int64
+		}
+
+		js, _ := json.Marshal(SessionExpirySettings{
+			MaxAge:      a.SessionMaxAge,
+			IdleTimeout: a.SessionIdleTimeout,
+		})
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	idleTimeout, err := utils.PostPara(r, "idle")
+	if err != nil {
+		sendErrorResponse(w, "Invalid idle timeout given")
+		return
+	}
+
+	maxAgeInt, err := strconv.ParseInt(maxAge, 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "Invalid max age given")
+		return
+	}
+
+	idleTimeoutInt, err := strconv.ParseInt(idleTimeout, 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "Invalid idle timeout given")
+		return
+	}
+
+	err = a.SetSessionExpiry(maxAgeInt, idleTimeoutInt)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	//Remove the sessions that are expired under the new settings
+	a.RemoveExpiredSessions()
+	sendOK(w)
+}
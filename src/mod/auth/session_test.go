@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionExpired(t *testing.T) {
+	record := &SessionRecord{ID: "session1", Username: "alice", CreationTime: 1000, LastSeen: 1500}
+
+	//Without expiry settings, sessions only expire with their cookie
+	a := &AuthAgent{}
+	if a.sessionExpired(record, 1500+rememberMeCookieMaxAge) {
+		t.Error("Expected session within cookie lifetime to be valid")
+	}
+	if !a.sessionExpired(record, 1501+rememberMeCookieMaxAge) {
+		t.Error("Expected session without request within cookie lifetime to expire")
+	}
+	shortCookie := &SessionRecord{ID: "session2", Username: "alice", CreationTime: 1000, LastSeen: 1500, CookieMaxAge: 3600}
+	if a.sessionExpired(shortCookie, 5100) || !a.sessionExpired(shortCookie, 5101) {
+		t.Error("Expected session to expire with its one hour cookie")
+	}
+
+	//Absolute lifetime
+	a = &AuthAgent{SessionMaxAge: 600}
+	if a.sessionExpired(record, 1600) {
+		t.Error("Expected session within max age to be valid")
+	}
+	if !a.sessionExpired(record, 1601) {
+		t.Error("Expected session exceeding max age to expire")
+	}
+
+	//Idle timeout counts from the last seen time
+	a = &AuthAgent{SessionIdleTimeout: 300}
+	if a.sessionExpired(record, 1800) {
+		t.Error("Expected recently used session to be valid")
+	}
+	if !a.sessionExpired(record, 1801) {
+		t.Error("Expected idle session to expire")
+	}
+}
+
+func TestListUserSessionsSkipExpired(t *testing.T) {
+	a := &AuthAgent{}
+	now := time.Now().Unix()
+	a.sessionRecords.Store("active", &SessionRecord{ID: "active", Username: "alice", CreationTime: now - 60, LastSeen: now, CookieMaxAge: 3600})
+	a.sessionRecords.Store("dead", &SessionRecord{ID: "dead", Username: "alice", CreationTime: now - 7200, LastSeen: now - 3601, CookieMaxAge: 3600})
+
+	sessions := a.ListUserSessions("alice")
+	if len(sessions) != 1 || sessions[0].ID != "active" {
+		t.Errorf("Expected only the session with valid cookie listed, got %+v", sessions)
+	}
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+	Session Cache
+
+	A small LRU cache keyed by the session cookie token, allowing
+	other modules (e.g. the user handler) to skip the session decoding
+	and database lookups on every request. Entries live for a short TTL
+	and are invalidated on logout, account switch and session revoke.
+
+	Set TTL to 0 to disable the cache (e.g. for debugging)
+*/
+
+type SessionCache struct {
+	TTL     time.Duration //Time to live of each entry, 0 = cache disabled
+	MaxSize int           //Max number of cached sessions
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type sessionCacheEntry struct {
+	Token      string
+	Username   string
+	SessionID  string
+	Value      interface{}
+	ExpireTime time.Time
+}
+
+// Create a new session cache with the given size and TTL
+func NewSessionCache(maxSize int, ttl time.Duration) *SessionCache {
+	return &SessionCache{
+		TTL:     ttl,
+		MaxSize: maxSize,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Check if the cache is enabled
+func (c *SessionCache) Enabled() bool {
+	return c != nil && c.TTL > 0 && c.MaxSize > 0
+}
+
+// Get the cached value of the token, return false if not found or expired
+func (c *SessionCache) Get(token string) (interface{}, bool) {
+	if !c.Enabled() || token == "" {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[token]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*sessionCacheEntry)
+	if time.Now().After(entry.ExpireTime) {
+		c.removeElement(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.Value, true
+}
+
+// Cache the value of the given token, evicting the least recently used entry if the cache is full
+func (c *SessionCache) Set(token string, username string, sessionID string, value interface{}) {
+	if !c.Enabled() || token == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[token]; ok {
+		c.removeElement(element)
+	}
+
+	c.entries[token] = c.lru.PushFront(&sessionCacheEntry{
+		Token:      token,
+		Username:   username,
+		SessionID:  sessionID,
+		Value:      value,
+		ExpireTime: time.Now().Add(c.TTL),
+	})
+
+	for c.lru.Len() > c.MaxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// Remove the cached value of the given token
+func (c *SessionCache) Invalidate(token string) {
+	c.invalidateMatching(func(entry *sessionCacheEntry) bool {
+		return entry.Token == token
+	})
+}
+
+// Remove the cached values of the given session id
+func (c *SessionCache) InvalidateSessionID(sessionID string) {
+	c.invalidateMatching(func(entry *sessionCacheEntry) bool {
+		return entry.SessionID == sessionID
+	})
+}
+
+// Remove all the cached values of the given user
+func (c *SessionCache) InvalidateUser(username string) {
+	c.invalidateMatching(func(entry *sessionCacheEntry) bool {
+		return entry.Username == username
+	})
+}
+
+// Remove all cached values
+func (c *SessionCache) Clear() {
+	c.invalidateMatching(func(entry *sessionCacheEntry) bool {
+		return true
+	})
+}
+
+func (c *SessionCache) invalidateMatching(match func(*sessionCacheEntry) bool) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*sessionCacheEntry)) {
+			c.removeElement(element)
+		}
+		element = next
+	}
+}
+
+// Caller must hold the cache mutex
+func (c *SessionCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*sessionCacheEntry).Token)
+}
+
+/*
+	AuthAgent helpers
+*/
+
+// Get the session cookie token of the request, return empty string if not found
+func (a *AuthAgent) getSessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(a.SessionName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Get the cached value of the request session, return false if not cached
+func (a *AuthAgent) GetCachedSessionValue(r *http.Request) (interface{}, bool) {
+	if a.requestIsHeadless(r) {
+		//API key and client certificate requests are not cached as they do not use the session cookie
+		return nil, false
+	}
+	return a.SessionCache.Get(a.getSessionToken(r))
+}
+
+// Cache a value for the request session, the request must be authenticated
+func (a *AuthAgent) CacheSessionValue(r *http.Request, username string, value interface{}) {
+	if !a.SessionCache.Enabled() || a.requestIsHeadless(r) {
+		return
+	}
+	a.SessionCache.Set(a.getSessionToken(r), username, a.getRequestSessionID(r), value)
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	c := NewSessionCache(2, time.Minute)
+	c.Set("token1", "alice", "session1", 1)
+	c.Set("token2", "bob", "session2", 2)
+
+	if v, ok := c.Get("token1"); !ok || v.(int) != 1 {
+		t.Fatal("Expected token1 to be cached")
+	}
+
+	//token2 is the least recently used and should be evicted
+	c.Set("token3", "alice", "session3", 3)
+	if _, ok := c.Get("token2"); ok {
+		t.Error("Expected token2 to be evicted")
+	}
+
+	c.InvalidateSessionID("session1")
+	if _, ok := c.Get("token1"); ok {
+		t.Error("Expected token1 to be invalidated by session id")
+	}
+
+	c.InvalidateUser("alice")
+	if _, ok := c.Get("token3"); ok {
+		t.Error("Expected token3 to be invalidated by username")
+	}
+
+	//Expired entries should not be returned
+	c = NewSessionCache(2, time.Nanosecond)
+	c.Set("token1", "alice", "session1", 1)
+	time.Sleep(time.Millisecond)
+	if _, ok := c.Get("token1"); ok {
+		t.Error("Expected token1 to be expired")
+	}
+
+	//Disabled cache should never return values
+	c = NewSessionCache(2, 0)
+	c.Set("token1", "alice", "session1", 1)
+	if _, ok := c.Get("token1"); ok {
+		t.Error("Expected disabled cache to return nothing")
+	}
+}
+
+// Create an auth agent with a logged in session, return the agent and the request holding the session cookie
+func setupSessionBenchmark(b *testing.B) (*AuthAgent, *http.Request) {
+	a := newTestAuthAgent(b, "auth")
+	a.SessionCache = NewSessionCache(1024, time.Minute)
+	a.CreateUserAccount("alice", "password", []string{"administrator"})
+
+	//Issue a session cookie for alice
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	session, _ := a.SessionStore.Get(r, a.SessionName)
+	session.Values["authenticated"] = true
+	session.Values["username"] = "alice"
+	session.Save(r, w)
+
+	r = httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return a, r
+}
+
+// The uncached lookup done by the user handler: decode session, check user exists and read the user groups
+func uncachedSessionLookup(a *AuthAgent, r *http.Request) (interface{}, error) {
+	username, err := a.GetUserName(nil, r)
+	if err != nil {
+		return nil, err
+	}
+	if !a.UserExists(username) {
+		return nil, errors.New("user not exists")
+	}
+	groups := []string{}
+	err = a.Database.Read("auth", "group/"+username, &groups)
+	return groups, err
+}
+
+func BenchmarkSessionLookupUncached(b *testing.B) {
+	a, r := setupSessionBenchmark(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := uncachedSessionLookup(a, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSessionLookupCached(b *testing.B) {
+	a, r := setupSessionBenchmark(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := a.GetCachedSessionValue(r); ok {
+			continue
+		}
+		value, err := uncachedSessionLookup(a, r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		a.CacheSessionValue(r, "alice", value)
+	}
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/sessions"
+	db "imuslab.com/arozos/mod/database"
+)
+
+/*
+	Session Key Rotation
+
+	Session cookies are signed with the primary session key. Rotating the
+	key gracefully generates a new primary key and keeps the replaced one
+	as the previous key, so sessions signed with it stay valid (and are
+	signed with the new key on their next save) instead of logging everyone
+	out at once. The previous key is dropped after SessionKeyOverlap, the
+	max lifetime of a session cookie, or on the next rotation.
+
+	To invalidate all sessions immediately, see RotateSessionKey in sessionrevoke.go
+
+	auth/sessionkey          => primary key
+	auth/sessionkey_previous => previous key, removed after the overlap
+	auth/sessionkey_rotated  => int64, unix time of the last rotation
+
+	The keys are stored hex encoded, as the raw key bytes are not valid
+	UTF-8 and would be altered by the JSON encoding of the database. Keys
+	stored before the encoding are loaded as is.
+*/
+
+// Time the previous session key is accepted after rotation in seconds, same as the max age of the session cookies
+const SessionKeyOverlap int64 = 86400 * 30
+
+type SessionKeyStatus struct {
+	Fixed             bool  //Session key is given by startup flag and cannot be rotated
+	LastRotated       int64 //Unix time of the last rotation, 0 if never rotated
+	PreviousKeyExpire int64 //Unix time the previous key is no longer accepted, 0 if there is no previous key
+}
+
+// Generate a random session key and store it as the primary key
+func GenerateSessionKey(sysdb *db.Database) ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, storeSessionKey(sysdb, "sessionkey", key)
+}
+
+// Load the primary session key from database, nil if not found
+func LoadSessionKey(sysdb *db.Database) []byte {
+	return loadSessionKey(sysdb, "sessionkey")
+}
+
+// Store the session key hex encoded under the given database key
+func storeSessionKey(sysdb *db.Database, dbKey string, key []byte) error {
+	return sysdb.Write("auth", dbKey, hex.EncodeToString(key))
+}
+
+// Load the session key stored under the given database key, nil if not found
+func loadSessionKey(sysdb *db.Database, dbKey string) []byte {
+	storedKey := ""
+	if !sysdb.KeyExists("auth", dbKey) || sysdb.Read("auth", dbKey, &storedKey) != nil || storedKey == "" {
+		return nil
+	}
+	key, err := hex.DecodeString(storedKey)
+	if err != nil {
+		//Key stored before hex encoding
+		return []byte(storedKey)
+	}
+	return key
+}
+
+// Load the previous session key kept by the last rotation. Call after SessionKeyFixed is set
+func (a *AuthAgent) LoadSessionKeyRotation() {
+	if a.SessionKeyFixed || !a.Database.KeyExists("auth", "sessionkey_previous") {
+		return
+	}
+	if time.Now().Unix() > a.GetSessionKeyRotatedTime()+SessionKeyOverlap {
+		a.RemoveExpiredSessionKey()
+		return
+	}
+
+	primaryKey := LoadSessionKey(a.Database)
+	previousKey := loadSessionKey(a.Database, "sessionkey_previous")
+	if primaryKey == nil || previousKey == nil {
+		return
+	}
+	a.setSessionKeys(primaryKey, previousKey)
+}
+
+// Replace the session stores with ones signing with the primary key and accepting the previous key (can be nil)
+func (a *AuthAgent) setSessionKeys(primaryKey []byte, previousKey []byte) {
+	keyPairs := [][]byte{primaryKey, nil}
+	if previousKey != nil {
+		keyPairs = append(keyPairs, previousKey, nil)
+	}
+
+	//Requests are served while the key rotates, swap in stores with the cookie options set
+	store := sessions.NewCookieStore(keyPairs...)
+	store.Options = a.sessionCookieOptions(nil, a.getSessionStore().Options.MaxAge)
+	a.sessionStoreMutex.Lock()
+	a.SessionStore = store
+	a.sessionStoreMutex.Unlock()
+
+	if a.SwitchableAccountManager != nil {
+		m := a.SwitchableAccountManager
+		store := sessions.NewCookieStore(keyPairs...)
+		store.Options = a.sessionCookieOptions(nil, m.getSessionStore().Options.MaxAge)
+		m.sessionStoreMutex.Lock()
+		m.SessionStore = store
+		m.sessionStoreMutex.Unlock()
+	}
+}
+
+// Get the session store, which is replaced when the session key rotates
+func (a *AuthAgent) getSessionStore() *sessions.CookieStore {
+	a.sessionStoreMutex.RLock()
+	defer a.sessionStoreMutex.RUnlock()
+	return a.SessionStore
+}
+
+// Get the unix time of the last session key rotation, 0 if never rotated
+func (a *AuthAgent) GetSessionKeyRotatedTime() int64 {
+	rotatedTime := int64(0)
+	if a.Database.KeyExists("auth", "sessionkey_rotated") {
+		a.Database.Read("auth", "sessionkey_rotated", &rotatedTime)
+	}
+	return rotatedTime
+}
+
+// Get the rotation status of the session key
+func (a *AuthAgent) GetSessionKeyStatus() SessionKeyStatus {
+	status := SessionKeyStatus{
+		Fixed:       a.SessionKeyFixed,
+		LastRotated: a.GetSessionKeyRotatedTime(),
+	}
+	if !a.SessionKeyFixed && a.Database.KeyExists("auth", "sessionkey_previous") {
+		status.PreviousKeyExpire = status.LastRotated + SessionKeyOverlap
+	}
+	return status
+}
+
+// Generate a new primary session key and keep the current one as the previous key. Existing sessions stay logged in
+func (a *AuthAgent) RotateSessionKeyGracefully() error {
+	if a.SessionKeyFixed {
+		return errors.New("session key is set by startup flag and cannot be rotated")
+	}
+
+	currentKey := LoadSessionKey(a.Database)
+	if currentKey == nil {
+		return errors.New("unable to read the current session key")
+	}
+
+	err := storeSessionKey(a.Database, "sessionkey_previous", currentKey)
+	if err != nil {
+		return err
+	}
+	key, err := GenerateSessionKey(a.Database)
+	if err != nil {
+		return err
+	}
+	a.Database.Write("auth", "sessionkey_rotated", time.Now().Unix())
+	a.setSessionKeys(key, currentKey)
+	return nil
+}
+
+// Drop the previous session key once the overlap after rotation is over
+func (a *AuthAgent) RemoveExpiredSessionKey() {
+	if a.SessionKeyFixed || !a.Database.KeyExists("auth", "sessionkey_previous") {
+		return
+	}
+	if time.Now().Unix() <= a.GetSessionKeyRotatedTime()+SessionKeyOverlap {
+		return
+	}
+
+	a.Database.Delete("auth", "sessionkey_previous")
+	primaryKey := LoadSessionKey(a.Database)
+	if primaryKey != nil {
+		a.setSessionKeys(primaryKey, nil)
+	}
+	log.Println("[System Auth] Previous session key expired and removed")
+}
+
+// Handle the session key rotation. GET for the rotation status, POST to rotate the key without logging out the users
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleSessionKeyRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		js, _ := json.Marshal(a.GetSessionKeyStatus())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	adminUsername, _ := a.GetUserName(w, r)
+	err := a.RotateSessionKeyGracefully()
+	if err != nil {
+		a.LogAuditEvent(r, AuditActionKeyRotate, adminUsername, "*", false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	status := a.GetSessionKeyStatus()
+	log.Println("[System Auth] " + adminUsername + " rotated the session key, previous key accepted until " + time.Unix(status.PreviousKeyExpire, 0).Format(time.RFC3339))
+	a.LogAuditEvent(r, AuditActionKeyRotate, adminUsername, "*", true, "previous key accepted for "+strconv.FormatInt(SessionKeyOverlap, 10)+" seconds")
+
+	js, _ := json.Marshal(status)
+	sendJSONResponse(w, string(js))
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestRotateSessionKeyGracefully(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_sessions", "auth_policy")
+	key, _ := GenerateSessionKey(a.Database)
+	a.SessionStore = sessions.NewCookieStore(key)
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	w := httptest.NewRecorder()
+	a.LoginUserByRequest(w, httptest.NewRequest("POST", "/system/auth/login", nil), "alice", false)
+	oldCookies := w.Result().Cookies()
+	isLoggedIn := func() bool {
+		a.SessionCache.Clear()
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, c := range oldCookies {
+			r.AddCookie(c)
+		}
+		return a.CheckAuth(r)
+	}
+	if !isLoggedIn() {
+		t.Fatal("Expected session valid before rotation")
+	}
+
+	if err := a.RotateSessionKeyGracefully(); err != nil {
+		t.Fatalf("Failed to rotate session key: %v", err)
+	}
+	if !isLoggedIn() {
+		t.Error("Expected session signed with the previous key valid after graceful rotation")
+	}
+
+	//Sessions signed with the rotated keys stay valid after restart
+	w = httptest.NewRecorder()
+	a.LoginUserByRequest(w, httptest.NewRequest("POST", "/system/auth/login", nil), "alice", false)
+	restarted := &AuthAgent{
+		Database:     a.Database,
+		SessionStore: sessions.NewCookieStore(LoadSessionKey(a.Database)),
+		SessionName:  a.SessionName,
+		SessionCache: NewSessionCache(16, time.Minute),
+	}
+	restarted.LoadSessionRecordsFromDB()
+	restarted.LoadSessionKeyRotation()
+	for _, cookies := range [][]*http.Cookie{oldCookies, w.Result().Cookies()} {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		if !restarted.CheckAuth(r) {
+			t.Error("Expected session valid after restart")
+		}
+	}
+
+	status := a.GetSessionKeyStatus()
+	if status.LastRotated == 0 || status.PreviousKeyExpire != status.LastRotated+SessionKeyOverlap {
+		t.Errorf("Unexpected session key status: %+v", status)
+	}
+
+	//Previous key is dropped after the overlap
+	a.Database.Write("auth", "sessionkey_rotated", time.Now().Unix()-SessionKeyOverlap-1)
+	a.RemoveExpiredSessionKey()
+	if isLoggedIn() {
+		t.Error("Expected session signed with the previous key rejected after the overlap")
+	}
+	if a.GetSessionKeyStatus().PreviousKeyExpire != 0 {
+		t.Error("Expected previous key removed after the overlap")
+	}
+}
+
+func TestStoreSessionKey(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+
+	//Raw key bytes are not valid UTF-8, they must survive the database round trip
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := storeSessionKey(a.Database, "sessionkey", key); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(LoadSessionKey(a.Database), key) {
+		t.Error("Expected stored session key unchanged")
+	}
+
+	//Keys stored before hex encoding are loaded as is
+	a.Database.Write("auth", "sessionkey", "legacy-session-key")
+	if string(LoadSessionKey(a.Database)) != "legacy-session-key" {
+		t.Error("Expected legacy session key loaded as is")
+	}
+	a.Database.Delete("auth", "sessionkey")
+	if LoadSessionKey(a.Database) != nil {
+		t.Error("Expected nil without stored session key")
+	}
+}
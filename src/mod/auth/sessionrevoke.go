@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Global Session Revocation
+
+	Log out every user after a suspected breach. All tracked sessions,
+	autologin tokens, trusted devices and account switching pools are
+	removed, and sessions created before session tracking (which have
+	no session id) are no longer accepted afterward.
+
+	auth_sessionconf/trackedonly => true after the first global revocation
+
+	For an even stronger reset, the session key can be rotated so every
+	session cookie issued before (including the admin's own) is invalid.
+	The new key is stored in auth/sessionkey unless the key is given by
+	startup flag (SessionKeyFixed)
+*/
+
+// Revoke the sessions, autologin tokens, trusted devices and account switching pools of every user.
+// Set exceptSessionID to keep the session of the admin performing the revocation. Return the number of sessions revoked
+func (a *AuthAgent) RevokeAllSessions(exceptSessionID string) int {
+	revokedCount := 0
+	a.sessionRecords.Range(func(key, value interface{}) bool {
+		thisRecord := value.(*SessionRecord)
+		if thisRecord.ID != exceptSessionID && a.RevokeSession(thisRecord.ID) == nil {
+			revokedCount++
+		}
+		return true
+	})
+
+	//Reject the untracked sessions created before session tracking from now on
+	a.trackedSessionsOnly = true
+	a.Database.Write("auth_sessionconf", "trackedonly", true)
+	a.SessionCache.Clear()
+
+	a.removeAutologinTokens(func(alt *AutoLoginToken) bool {
+		return true
+	})
+
+	for _, thisDevice := range a.ListTrustedDevices("") {
+		a.Database.Delete("auth_trusteddevice", thisDevice.tokenHash)
+	}
+
+	if a.SwitchableAccountManager != nil {
+		pools, _ := a.SwitchableAccountManager.GetAllPools()
+		for _, thisPool := range pools {
+			thisPool.Delete()
+		}
+	}
+	return revokedCount
+}
+
+// Replace the session key with a random one, all session cookies issued with the previous key become invalid
+func (a *AuthAgent) RotateSessionKey() error {
+	if a.SessionKeyFixed {
+		return errors.New("session key is set by startup flag and cannot be rotated")
+	}
+
+	key, err := GenerateSessionKey(a.Database)
+	if err != nil {
+		return err
+	}
+
+	//The previous key of graceful rotation must not be accepted either, see sessionkey.go
+	a.Database.Delete("auth", "sessionkey_previous")
+	a.Database.Write("auth", "sessionkey_rotated", time.Now().Unix())
+	a.SessionCache.Clear()
+	a.setSessionKeys(key, nil)
+	return nil
+}
+
+// Load the untracked session rejection flag set by the previous global revocation
+func (a *AuthAgent) loadTrackedSessionsOnly() {
+	if a.Database.KeyExists("auth_sessionconf", "trackedonly") {
+		a.Database.Read("auth_sessionconf", "trackedonly", &a.trackedSessionsOnly)
+	}
+}
+
+// Handle logging out all users. Accept POST keepself (default true) to keep the current session.
+// Set POST rotatekey=true and the admin's password in POST password to also rotate the session key,
+// which logs out the current admin as well
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Invalid request method")
+		return
+	}
+
+	adminUsername, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	rotateKey, _ := utils.PostPara(r, "rotatekey")
+	if rotateKey == "true" {
+		//Rotating the key cannot be undone, confirm with the admin's password
+		password, err := utils.PostPara(r, "password")
+		if err != nil || !a.ValidateUsernameAndPassword(adminUsername, password) {
+			a.LogAuditEvent(r, AuditActionKeyRotate, adminUsername, "*", false, "Password confirmation failed")
+			sendErrorResponse(w, "Password confirmation failed")
+			return
+		}
+	}
+
+	exceptSessionID := ""
+	keepSelf, _ := utils.PostPara(r, "keepself")
+	if keepSelf != "false" && rotateKey != "true" {
+		exceptSessionID = a.getRequestSessionID(r)
+	}
+
+	revokedCount := a.RevokeAllSessions(exceptSessionID)
+	log.Println("[System Auth] " + adminUsername + " revoked all login sessions (" + strconv.Itoa(revokedCount) + " sessions)")
+	a.LogAuditEvent(r, AuditActionRevokeAll, adminUsername, "*", true, strconv.Itoa(revokedCount)+" sessions revoked")
+
+	if rotateKey == "true" {
+		err = a.RotateSessionKey()
+		if err != nil {
+			a.LogAuditEvent(r, AuditActionKeyRotate, adminUsername, "*", false, err.Error())
+			sendErrorResponse(w, "Sessions revoked but unable to rotate session key: "+err.Error())
+			return
+		}
+		log.Println("[System Auth] " + adminUsername + " rotated the session key")
+		a.LogAuditEvent(r, AuditActionKeyRotate, adminUsername, "*", true, "")
+	}
+
+	sendJSONResponse(w, strconv.Itoa(revokedCount))
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestRevokeAllSessions(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	a := newTestAuthAgent(t, "auth", "auth_sessions", "auth_sessionconf", "auth_trusteddevice")
+	a.TrustedDeviceTTL = 3600
+	a.SwitchableAccountManager = NewSwitchableAccountPoolManager(a.Database, a, key)
+	a.CreateUserAccount("admin", "password", []string{"administrator"})
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	newRequest := func(cookies []*http.Cookie, form url.Values) *http.Request {
+		r := httptest.NewRequest("POST", "/system/auth/session/revokeall", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return r
+	}
+	login := func(username string) []*http.Cookie {
+		w := httptest.NewRecorder()
+		a.LoginUserByRequest(w, newRequest(nil, nil), username, false)
+		return w.Result().Cookies()
+	}
+
+	adminCookies := login("admin")
+	aliceCookies := login("alice")
+	a.NewAutologinToken("alice")
+	a.TrustDevice(httptest.NewRecorder(), newRequest(nil, nil), "alice")
+
+	//Session created before session tracking has no session id
+	w := httptest.NewRecorder()
+	legacyRequest := newRequest(nil, nil)
+	legacySession, _ := a.SessionStore.Get(legacyRequest, a.SessionName)
+	legacySession.Values["authenticated"] = true
+	legacySession.Values["username"] = "alice"
+	legacySession.Save(legacyRequest, w)
+	legacyCookies := w.Result().Cookies()
+	if !a.CheckAuth(newRequest(legacyCookies, nil)) {
+		t.Fatal("Expected untracked session to be accepted before revocation")
+	}
+
+	w = httptest.NewRecorder()
+	a.HandleRevokeAllSessions(w, newRequest(adminCookies, nil))
+	if w.Body.String() != "1" {
+		t.Fatalf("Expected 1 session revoked, got %s", w.Body.String())
+	}
+	if !a.CheckAuth(newRequest(adminCookies, nil)) {
+		t.Error("Expected admin session to be kept")
+	}
+	if a.CheckAuth(newRequest(aliceCookies, nil)) || a.CheckAuth(newRequest(legacyCookies, nil)) {
+		t.Error("Expected user sessions to be revoked")
+	}
+	if len(a.GetTokensFromUsername("alice")) != 0 || len(a.ListTrustedDevices("alice")) != 0 {
+		t.Error("Expected autologin tokens and trusted devices to be removed")
+	}
+	if !a.Database.KeyExists("auth_sessionconf", "trackedonly") {
+		t.Error("Expected untracked session rejection to be persisted")
+	}
+
+	//Key rotation require the admin's password
+	w = httptest.NewRecorder()
+	a.HandleRevokeAllSessions(w, newRequest(adminCookies, url.Values{"rotatekey": {"true"}, "password": {"wrong"}}))
+	if !strings.Contains(w.Body.String(), "error") || !a.CheckAuth(newRequest(adminCookies, nil)) {
+		t.Fatalf("Expected key rotation to be rejected, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	a.HandleRevokeAllSessions(w, newRequest(adminCookies, url.Values{"rotatekey": {"true"}, "password": {"password"}}))
+	if strings.Contains(w.Body.String(), "error") {
+		t.Fatalf("Failed to rotate session key: %s", w.Body.String())
+	}
+	if a.CheckAuth(newRequest(adminCookies, nil)) {
+		t.Error("Expected admin session to be invalid after key rotation")
+	}
+	newCookies := login("admin")
+	if !a.CheckAuth(newRequest(newCookies, nil)) {
+		t.Error("Expected new login to work after key rotation")
+	}
+	a.SessionStore = sessions.NewCookieStore(LoadSessionKey(a.Database))
+	if !a.CheckAuth(newRequest(newCookies, nil)) {
+		t.Error("Expected new login to work with the stored session key after restart")
+	}
+
+	a.SessionKeyFixed = true
+	if a.RotateSessionKey() == nil {
+		t.Error("Expected fixed session key not to be rotated")
+	}
+}
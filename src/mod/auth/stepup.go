@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Step-up Authentication
+
+	Sensitive operations ask the user to enter the password again (see
+	AuthValidateSecureRequest). After the password is confirmed, a short
+	lived step-up token is returned in the X-Stepup-Token response header.
+	Sensitive operations within the step-up window can present the token
+	(X-Stepup-Token request header or POST stepuptoken) instead of the password.
+
+	The token is bound to the login session, its user and the origin it is
+	issued to. It is kept in memory only and removed when the session is
+	logged out or revoked. Each session has at most one token.
+
+	The window is stored as (in seconds, 0 to disable step-up tokens)
+	auth_policy/stepupwindow => int64
+*/
+
+const stepUpTokenHeader = "X-Stepup-Token"
+
+// Default time a step-up token can be used after the password is confirmed, in seconds
+const DefaultStepUpWindow int64 = 300
+
+type stepUpToken struct {
+	tokenHash  string
+	username   string
+	origin     string
+	expireTime int64
+}
+
+func (a *AuthAgent) loadStepUpWindow() {
+	window := DefaultStepUpWindow
+	if a.Database.KeyExists("auth_policy", "stepupwindow") {
+		a.Database.Read("auth_policy", "stepupwindow", &window)
+	}
+	a.stepUpWindow = window
+}
+
+// Get the step-up window in seconds, 0 if step-up tokens are disabled
+func (a *AuthAgent) GetStepUpWindow() int64 {
+	return a.stepUpWindow
+}
+
+// Set and save the step-up window in seconds. Set to 0 to disable step-up tokens and remove the issued ones
+func (a *AuthAgent) SetStepUpWindow(window int64) error {
+	if window < 0 {
+		return errors.New("invalid step-up window given")
+	}
+	a.stepUpWindow = window
+	if window == 0 {
+		a.stepUpTokens.Range(func(key, value interface{}) bool {
+			a.stepUpTokens.Delete(key)
+			return true
+		})
+	}
+	return a.Database.Write("auth_policy", "stepupwindow", window)
+}
+
+// Get the origin of the request, from the Origin header or the requested host
+func getRequestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// Issue a step-up token for the session of the request after its password is confirmed, and return it in the response header.
+// Return empty string if step-up tokens are disabled or the request has no tracked session
+func (a *AuthAgent) IssueStepUpToken(w http.ResponseWriter, r *http.Request, username string) string {
+	sessionID := a.getRequestSessionID(r)
+	if a.stepUpWindow <= 0 || sessionID == "" {
+		return ""
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Println("[System Auth] Unable to generate step-up token: " + err.Error())
+		return ""
+	}
+	token := hex.EncodeToString(b)
+
+	//Replace the previous token of this session
+	a.stepUpTokens.Store(sessionID, &stepUpToken{
+		tokenHash:  Hash(token),
+		username:   username,
+		origin:     getRequestOrigin(r),
+		expireTime: time.Now().Unix() + a.stepUpWindow,
+	})
+
+	w.Header().Set(stepUpTokenHeader, token)
+	w.Header().Set("X-Stepup-Expires", strconv.FormatInt(a.stepUpWindow, 10))
+	return token
+}
+
+// Check if the request present a valid step-up token of the user issued to its session and origin
+func (a *AuthAgent) ValidateStepUpToken(r *http.Request, username string) bool {
+	if a.stepUpWindow <= 0 {
+		return false
+	}
+
+	token := r.Header.Get(stepUpTokenHeader)
+	if token == "" {
+		token, _ = utils.PostPara(r, "stepuptoken")
+	}
+	sessionID := a.getRequestSessionID(r)
+	if token == "" || sessionID == "" {
+		return false
+	}
+
+	val, ok := a.stepUpTokens.Load(sessionID)
+	if !ok {
+		return false
+	}
+	thisToken := val.(*stepUpToken)
+	if time.Now().Unix() > thisToken.expireTime {
+		a.stepUpTokens.Delete(sessionID)
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(Hash(token)), []byte(thisToken.tokenHash)) == 1 &&
+		thisToken.username == username &&
+		thisToken.origin == getRequestOrigin(r)
+}
+
+// Remove the step-up token of the session
+func (a *AuthAgent) RevokeStepUpToken(sessionID string) {
+	a.stepUpTokens.Delete(sessionID)
+}
+
+// Remove the expired step-up tokens
+func (a *AuthAgent) RemoveExpiredStepUpTokens() {
+	now := time.Now().Unix()
+	a.stepUpTokens.Range(func(key, value interface{}) bool {
+		if now > value.(*stepUpToken).expireTime {
+			a.stepUpTokens.Delete(key)
+		}
+		return true
+	})
+}
+
+// Handle the step-up window settings, POST window (in seconds, 0 to disable) to update
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleStepUpSettings(w http.ResponseWriter, r *http.Request) {
+	windowString, err := utils.PostPara(r, "window")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.GetStepUpWindow())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	window, err := strconv.ParseInt(windowString, 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "Invalid window given")
+		return
+	}
+
+	err = a.SetStepUpWindow(window)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	if window == 0 {
+		log.Println("[System Auth] Step-up tokens disabled")
+	} else {
+		log.Println("[System Auth] Step-up window updated to " + windowString + " seconds")
+	}
+	sendOK(w)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStepUpToken(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_sessions", "auth_policy")
+	a.loadStepUpWindow()
+	a.CreateUserAccount("alice", "password", []string{"user"})
+
+	login := func() []*http.Cookie {
+		w := httptest.NewRecorder()
+		a.LoginUserByRequest(w, httptest.NewRequest("POST", "/system/auth/login", nil), "alice", false)
+		return w.Result().Cookies()
+	}
+	newRequest := func(cookies []*http.Cookie, token string, origin string) *http.Request {
+		r := httptest.NewRequest("POST", "/system/power/shutdown", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		r.Header.Set(stepUpTokenHeader, token)
+		r.Header.Set("Origin", origin)
+		return r
+	}
+
+	cookies := login()
+	w := httptest.NewRecorder()
+	token := a.IssueStepUpToken(w, newRequest(cookies, "", "https://nas.local"), "alice")
+	if token == "" || w.Header().Get(stepUpTokenHeader) != token {
+		t.Fatalf("Expected step-up token in response header, got %q", w.Header().Get(stepUpTokenHeader))
+	}
+
+	if !a.ValidateStepUpToken(newRequest(cookies, token, "https://nas.local"), "alice") {
+		t.Error("Expected step-up token accepted within the window")
+	}
+	if a.ValidateStepUpToken(newRequest(cookies, token, "https://evil.example.com"), "alice") {
+		t.Error("Expected step-up token rejected from another origin")
+	}
+	if a.ValidateStepUpToken(newRequest(cookies, token, "https://nas.local"), "bob") {
+		t.Error("Expected step-up token rejected for another user")
+	}
+	if a.ValidateStepUpToken(newRequest(login(), token, "https://nas.local"), "alice") {
+		t.Error("Expected step-up token rejected in another session")
+	}
+
+	//Logout invalidate the token immediately
+	a.Logout(httptest.NewRecorder(), newRequest(cookies, "", ""))
+	if a.ValidateStepUpToken(newRequest(cookies, token, "https://nas.local"), "alice") {
+		t.Error("Expected step-up token rejected after logout")
+	}
+
+	//Disabled step-up always require the password
+	cookies = login()
+	a.SetStepUpWindow(0)
+	if a.IssueStepUpToken(httptest.NewRecorder(), newRequest(cookies, "", "https://nas.local"), "alice") != "" {
+		t.Error("Expected no step-up token issued when disabled")
+	}
+}
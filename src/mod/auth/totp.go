@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	TOTP Two-Factor Authentication
+
+	This script handle the time based one time password (RFC 6238)
+	second factor for user logins. The 2FA settings are stored as
+
+	auth/totp/{username} => TOTPConfig
+
+	Recovery codes are generated on enrollment and each of them
+	can only be used once in place of a TOTP code. TOTP codes can only
+	be used once as well, codes of the last accepted time step or
+	earlier are rejected.
+*/
+
+const (
+	totpPeriod            = 30 //Time step in seconds
+	totpDigits            = 6  //Number of digits of the generated code
+	totpRecoveryCodeCount = 10 //Number of recovery codes generated on enrollment
+	totpIssuer            = "ArozOS"
+)
+
+type TOTPConfig struct {
+	Secret        string   //Base32 encoded shared secret
+	Enabled       bool     //Set to true after the user verified the first code
+	RecoveryCodes []string //Hashed recovery codes that are not used yet
+	LastTimeStep  int64    //Time step of the last accepted TOTP code, reject codes of this step or earlier
+}
+
+// Check if the given user has 2FA enabled
+func (a *AuthAgent) UserHasTOTPEnabled(username string) bool {
+	config, err := a.getTOTPConfig(username)
+	if err != nil {
+		return false
+	}
+	return config.Enabled
+}
+
+// Validate the TOTP code or recovery code given by the user. The TOTP code or recovery code will be consumed if matched
+func (a *AuthAgent) ValidateTOTPCode(username string, code string) bool {
+	//Consuming the codes is a read-modify-write, concurrent requests must not use the same code
+	a.totpMutex.Lock()
+	defer a.totpMutex.Unlock()
+
+	config, err := a.getTOTPConfig(username)
+	if err != nil || !config.Enabled {
+		return false
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if timeStep, ok := matchTOTP(config.Secret, code, time.Now(), a.TOTPWindow); ok {
+		if timeStep <= config.LastTimeStep {
+			log.Println("[System Auth] Reused 2FA code of " + username + " rejected")
+			return false
+		}
+		config.LastTimeStep = timeStep
+		err = a.Database.Write("auth", "totp/"+username, config)
+		return err == nil
+	}
+
+	//Not a valid TOTP code. Check if it is a recovery code
+	hashedCode := Hash(strings.ToLower(code))
+	for i, recoveryCode := range config.RecoveryCodes {
+		if recoveryCode == hashedCode {
+			//Consume this recovery code
+			config.RecoveryCodes = append(config.RecoveryCodes[:i], config.RecoveryCodes[i+1:]...)
+			a.Database.Write("auth", "totp/"+username, config)
+			log.Println("[System Auth] " + username + " logged in with a 2FA recovery code")
+			return true
+		}
+	}
+
+	return false
+}
+
+// Remove the 2FA settings of a user
+func (a *AuthAgent) ResetUserTOTP(username string) error {
+	if !a.Database.KeyExists("auth", "totp/"+username) {
+		return errors.New("2FA is not enabled for this user")
+	}
+	//Devices trusted to skip the second factor are no longer valid
+	a.RevokeAllUserTrustedDevices(username)
+	return a.Database.Delete("auth", "totp/"+username)
+}
+
+// Handle 2FA enrollment, return the secret, provisioning URI and recovery codes
+func (a *AuthAgent) HandleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	if a.UserHasTOTPEnabled(username) {
+		sendErrorResponse(w, "2FA already enabled for this account")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		sendErrorResponse(w, "Unable to generate 2FA secret")
+		return
+	}
+
+	//Generate the recovery codes, only the hashed values are stored
+	recoveryCodes := []string{}
+	hashedRecoveryCodes := []string{}
+	for i := 0; i < totpRecoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			sendErrorResponse(w, "Unable to generate recovery codes")
+			return
+		}
+		recoveryCodes = append(recoveryCodes, code)
+		hashedRecoveryCodes = append(hashedRecoveryCodes, Hash(code))
+	}
+
+	//Store it as pending until the user verify the first code
+	err = a.Database.Write("auth", "totp/"+username, TOTPConfig{
+		Secret:        secret,
+		Enabled:       false,
+		RecoveryCodes: hashedRecoveryCodes,
+	})
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	type EnrollResponse struct {
+		Secret        string
+		ProvisionURI  string
+		RecoveryCodes []string
+	}
+
+	js, _ := json.Marshal(EnrollResponse{
+		Secret:        secret,
+		ProvisionURI:  getTOTPProvisionURI(username, secret),
+		RecoveryCodes: recoveryCodes,
+	})
+	sendJSONResponse(w, string(js))
+}
+
+// Handle verification of the first TOTP code, which enable 2FA for the account
+func (a *AuthAgent) HandleTOTPVerify(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	code, err := utils.PostPara(r, "code")
+	if err != nil {
+		sendErrorResponse(w, "Invalid code given")
+		return
+	}
+
+	config, err := a.getTOTPConfig(username)
+	if err != nil {
+		sendErrorResponse(w, "2FA enrollment not started")
+		return
+	}
+
+	if config.Enabled {
+		sendErrorResponse(w, "2FA already enabled for this account")
+		return
+	}
+
+	timeStep, ok := matchTOTP(config.Secret, strings.TrimSpace(code), time.Now(), a.TOTPWindow)
+	if !ok {
+		sendErrorResponse(w, "Invalid 2FA code")
+		return
+	}
+
+	//The code used for enabling 2FA cannot be used for login
+	config.Enabled = true
+	config.LastTimeStep = timeStep
+	err = a.Database.Write("auth", "totp/"+username, config)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] " + username + " enabled 2FA")
+	sendOK(w)
+}
+
+// Handle disabling 2FA, require a valid TOTP code or recovery code
+func (a *AuthAgent) HandleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	code, err := utils.PostPara(r, "code")
+	if err != nil {
+		sendErrorResponse(w, "Invalid code given")
+		return
+	}
+
+	if !a.ValidateTOTPCode(username, code) {
+		sendErrorResponse(w, "Invalid 2FA code")
+		return
+	}
+
+	err = a.ResetUserTOTP(username)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] " + username + " disabled 2FA")
+	sendOK(w)
+}
+
+// Handle admin reset of a user 2FA settings. Require POST username
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleTOTPAdminReset(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		sendErrorResponse(w, "Missing 'username' paramter")
+		return
+	}
+
+	adminUsername, _ := a.GetUserName(w, r)
+	err = a.ResetUserTOTP(username)
+	if err != nil {
+		a.LogAuditEvent(r, AuditActionTOTPReset, adminUsername, username, false, err.Error())
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] 2FA for " + username + " has been reset by admin")
+	a.LogAuditEvent(r, AuditActionTOTPReset, adminUsername, username, true, "")
+	sendOK(w)
+}
+
+func (a *AuthAgent) getTOTPConfig(username string) (*TOTPConfig, error) {
+	config := TOTPConfig{}
+	err := a.Database.Read("auth", "totp/"+username, &config)
+	if err != nil || config.Secret == "" {
+		return nil, errors.New("2FA not configured")
+	}
+	return &config, nil
+}
+
+/*
+	TOTP Helper functions
+*/
+
+// Generate a new base32 encoded 160 bits secret
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// Generate a recovery code in the format of xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(b)
+	return code[:5] + "-" + code[5:], nil
+}
+
+func getTOTPProvisionURI(username string, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Generate the TOTP code of the given secret at the given time
+func generateTOTP(secret string, t time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	return generateHOTP(key, uint64(t.Unix()/totpPeriod)), nil
+}
+
+func generateHOTP(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	//Dynamic truncation as defined in RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// Validate the code with the given window, window is the number of time steps allowed before and after now
+func validateTOTP(secret string, code string, t time.Time, window int) bool {
+	_, ok := matchTOTP(secret, code, t, window)
+	return ok
+}
+
+// Find the time step of the code within the window, return false if the code does not match any of them
+func matchTOTP(secret string, code string, t time.Time, window int) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	for i := -window; i <= window; i++ {
+		stepTime := t.Add(time.Duration(i*totpPeriod) * time.Second)
+		expected, err := generateTOTP(secret, stepTime)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return stepTime.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
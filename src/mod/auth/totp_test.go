@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestGenerateTOTP(t *testing.T) {
+	//Test vectors from RFC 6238 Appendix B (SHA1, last 6 digits)
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for ts, expected := range vectors {
+		code, err := generateTOTP(secret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("Expected %s at %d, got %s", expected, ts, code)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	previousCode, _ := generateTOTP(secret, now.Add(-30*time.Second))
+
+	// Test case 1: Previous step accepted with window = 1
+	if !validateTOTP(secret, previousCode, now, 1) {
+		t.Error("Expected previous step code to be accepted with window 1")
+	}
+
+	// Test case 2: Previous step rejected with window = 0
+	if validateTOTP(secret, previousCode, now, 0) {
+		t.Error("Expected previous step code to be rejected with window 0")
+	}
+
+	// Test case 3: Malformed code
+	if validateTOTP(secret, "12345", now, 1) {
+		t.Error("Expected malformed code to be rejected")
+	}
+}
+
+func TestValidateTOTPCodeReuse(t *testing.T) {
+	a := newTestAuthAgent(t, "auth")
+	a.TOTPWindow = 1
+	secret, _ := generateTOTPSecret()
+	a.Database.Write("auth", "totp/alice", TOTPConfig{
+		Secret:        secret,
+		Enabled:       true,
+		RecoveryCodes: []string{Hash("abcde-12345")},
+	})
+
+	//Each code can only be used once
+	code, _ := generateTOTP(secret, time.Now())
+	if !a.ValidateTOTPCode("alice", code) {
+		t.Fatal("Expected valid code accepted")
+	}
+	if a.ValidateTOTPCode("alice", code) {
+		t.Error("Expected reused code rejected")
+	}
+
+	//Codes of earlier time steps are rejected once a later one is used
+	previousCode, _ := generateTOTP(secret, time.Now().Add(-30*time.Second))
+	if previousCode != code && a.ValidateTOTPCode("alice", previousCode) {
+		t.Error("Expected code of earlier time step rejected")
+	}
+
+	//Recovery codes are consumed once even with concurrent requests
+	results := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- a.ValidateTOTPCode("alice", "ABCDE-12345")
+		}()
+	}
+	if accepted := <-results; accepted == <-results {
+		t.Error("Expected recovery code accepted exactly once")
+	}
+}
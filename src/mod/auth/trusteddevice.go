@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Trusted Devices
+
+	When a user login with 2FA and check "remember this device", a device
+	token is stored in a cookie of the browser. Login from the same browser
+	can skip the second factor until the token expires or is revoked.
+
+	The token is bound to the fingerprint (User-Agent) of the device,
+	so a copied cookie cannot be used from a different browser. Only the
+	hash of the token is stored as
+
+	auth_trusteddevice/{hashed token} => TrustedDevice
+*/
+
+const trustedDeviceCookieName = "ao_trusted_device"
+
+type TrustedDevice struct {
+	ID           string //ID of this device for listing and revoking
+	Owner        string //Username of the device owner
+	Name         string //Name of the device, the User-Agent when trusted
+	Fingerprint  string //Hash of the device fingerprint
+	IpAddr       string //IP address when the device is trusted
+	CreationTime int64  //Creation time of this record
+	ExpireTime   int64  //Expire time of this record
+	LastUsed     int64  //Last time this device skipped 2FA
+	tokenHash    string
+}
+
+// Get the fingerprint of the device sending the request
+func getDeviceFingerprint(r *http.Request) string {
+	return Hash(r.UserAgent())
+}
+
+// Trust the device sending this request for the user and store the device token in cookie
+func (a *AuthAgent) TrustDevice(w http.ResponseWriter, r *http.Request, username string) (*TrustedDevice, error) {
+	if !a.UserExists(username) {
+		return nil, errors.New("user not exists")
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+
+	clientIP, _ := network.GetIpFromRequest(r)
+	now := time.Now()
+	thisDevice := TrustedDevice{
+		ID:           uuid.NewV4().String(),
+		Owner:        username,
+		Name:         r.UserAgent(),
+		Fingerprint:  getDeviceFingerprint(r),
+		IpAddr:       clientIP,
+		CreationTime: now.Unix(),
+		ExpireTime:   now.Unix() + a.TrustedDeviceTTL,
+	}
+
+	err = a.Database.Write("auth_trusteddevice", Hash(token), thisDevice)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, a.newCookie(r, trustedDeviceCookieName, token, int(a.TrustedDeviceTTL)))
+	return &thisDevice, nil
+}
+
+// Check if the request is sent from a device trusted by the user
+func (a *AuthAgent) IsTrustedDevice(username string, r *http.Request) bool {
+	cookie, err := r.Cookie(trustedDeviceCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	tokenHash := Hash(cookie.Value)
+	thisDevice := TrustedDevice{}
+	err = a.Database.Read("auth_trusteddevice", tokenHash, &thisDevice)
+	if err != nil || thisDevice.Owner != username {
+		return false
+	}
+
+	now := time.Now().Unix()
+	if thisDevice.ExpireTime < now {
+		a.Database.Delete("auth_trusteddevice", tokenHash)
+		return false
+	}
+
+	if thisDevice.Fingerprint != getDeviceFingerprint(r) {
+		log.Println("[System Auth] Trusted device token of " + username + " used from a different device")
+		return false
+	}
+
+	thisDevice.LastUsed = now
+	a.Database.Write("auth_trusteddevice", tokenHash, thisDevice)
+	return true
+}
+
+// List the trusted devices of the user, set username to empty string to list all
+func (a *AuthAgent) ListTrustedDevices(username string) []*TrustedDevice {
+	results := []*TrustedDevice{}
+	if !a.Database.TableExists("auth_trusteddevice") {
+		return results
+	}
+	entries, err := a.Database.ListTable("auth_trusteddevice")
+	if err != nil {
+		return results
+	}
+	for _, keypairs := range entries {
+		thisDevice := TrustedDevice{}
+		err = json.Unmarshal(keypairs[1], &thisDevice)
+		if err != nil {
+			continue
+		}
+		if username == "" || thisDevice.Owner == username {
+			thisDevice.tokenHash = string(keypairs[0])
+			results = append(results, &thisDevice)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreationTime < results[j].CreationTime
+	})
+	return results
+}
+
+// Revoke a trusted device of the user by its ID
+func (a *AuthAgent) RevokeTrustedDevice(username string, deviceID string) error {
+	for _, thisDevice := range a.ListTrustedDevices(username) {
+		if thisDevice.ID == deviceID {
+			return a.Database.Delete("auth_trusteddevice", thisDevice.tokenHash)
+		}
+	}
+	return errors.New("trusted device not found")
+}
+
+// Revoke all trusted devices of the user
+func (a *AuthAgent) RevokeAllUserTrustedDevices(username string) {
+	for _, thisDevice := range a.ListTrustedDevices(username) {
+		a.Database.Delete("auth_trusteddevice", thisDevice.tokenHash)
+	}
+}
+
+// Remove the trusted devices that are expired
+func (a *AuthAgent) RemoveExpiredTrustedDevices() {
+	now := time.Now().Unix()
+	for _, thisDevice := range a.ListTrustedDevices("") {
+		if thisDevice.ExpireTime < now {
+			a.Database.Delete("auth_trusteddevice", thisDevice.tokenHash)
+		}
+	}
+}
+
+/*
+	Trusted Device Handlers
+*/
+
+// Handle listing of the current user's trusted devices
+func (a *AuthAgent) HandleListTrustedDevices(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	js, _ := json.Marshal(a.ListTrustedDevices(username))
+	sendJSONResponse(w, string(js))
+}
+
+// Handle revoking of the current user's trusted device. Require POST id, or all=true to revoke all devices
+func (a *AuthAgent) HandleRevokeTrustedDevice(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	revokeAll, _ := utils.PostPara(r, "all")
+	if revokeAll == "true" {
+		a.RevokeAllUserTrustedDevices(username)
+		log.Println("[System Auth] " + username + " revoked all trusted devices")
+		sendOK(w)
+		return
+	}
+
+	deviceID, err := utils.PostPara(r, "id")
+	if err != nil {
+		sendErrorResponse(w, "Invalid device id given")
+		return
+	}
+
+	err = a.RevokeTrustedDevice(username, deviceID)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] " + username + " revoked trusted device " + deviceID)
+	sendOK(w)
+}
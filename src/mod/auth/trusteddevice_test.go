@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedDevice(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_trusteddevice")
+	a.TrustedDeviceTTL = 3600
+	a.CreateUserAccount("alice", "password", []string{"user"})
+	a.CreateUserAccount("bob", "password", []string{"user"})
+
+	loginRequest := func(cookies []*http.Cookie, userAgent string) *http.Request {
+		r := httptest.NewRequest("POST", "/system/auth/login", nil)
+		r.Header.Set("User-Agent", userAgent)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	device, err := a.TrustDevice(w, loginRequest(nil, "browser-a"), "alice")
+	if err != nil {
+		t.Fatalf("Failed to trust device: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected a HttpOnly device cookie, got %v", cookies)
+	}
+
+	if !a.IsTrustedDevice("alice", loginRequest(cookies, "browser-a")) {
+		t.Error("Expected device to be trusted")
+	}
+	if a.IsTrustedDevice("bob", loginRequest(cookies, "browser-a")) {
+		t.Error("Expected device not to be trusted by other users")
+	}
+	if a.IsTrustedDevice("alice", loginRequest(cookies, "browser-b")) {
+		t.Error("Expected copied cookie to be rejected on a different device")
+	}
+	if a.IsTrustedDevice("alice", loginRequest(nil, "browser-a")) {
+		t.Error("Expected request without device cookie not to be trusted")
+	}
+
+	//Revoke take effect immediately
+	if err := a.RevokeTrustedDevice("bob", device.ID); err == nil {
+		t.Error("Expected other users cannot revoke the device")
+	}
+	if err := a.RevokeTrustedDevice("alice", device.ID); err != nil {
+		t.Fatalf("Failed to revoke device: %v", err)
+	}
+	if a.IsTrustedDevice("alice", loginRequest(cookies, "browser-a")) {
+		t.Error("Expected revoked device not to be trusted")
+	}
+
+	//Expired devices are not trusted
+	a.TrustedDeviceTTL = -1
+	w = httptest.NewRecorder()
+	a.TrustDevice(w, loginRequest(nil, "browser-a"), "alice")
+	if a.IsTrustedDevice("alice", loginRequest(w.Result().Cookies(), "browser-a")) {
+		t.Error("Expected expired device not to be trusted")
+	}
+	if len(a.ListTrustedDevices("alice")) != 0 {
+		t.Error("Expected expired device to be removed")
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Username Policy
+
+	This script enforce the username rules on new accounts, so names with
+	spaces, unicode look-alikes or reserved words cannot be registered.
+	Existing accounts are not affected. An empty policy means no restrictions,
+	DefaultUsernamePolicy is used until an admin change it. The policy is stored as
+
+	auth_policy/username => UsernamePolicy
+
+	The first account of the system is exempted from the reserved names,
+	as it is the administrator setting up the system
+*/
+
+type UsernamePolicy struct {
+	AllowedPattern string   //Regular expression the whole username must match, empty for no restriction
+	MinLength      int      //Min number of characters, 0 = no restriction
+	MaxLength      int      //Max number of characters, 0 = no restriction
+	ReservedNames  []string //Names that cannot be registered, case insensitive
+	NormalizeCase  bool     //Store new usernames in lower case and reject names differ from existing users by case only
+}
+
+// Alphanumeric plus dot, underscore and dash, starting with an alphanumeric character
+var DefaultUsernamePolicy = UsernamePolicy{
+	AllowedPattern: "^[a-zA-Z0-9][a-zA-Z0-9._-]*$",
+	MinLength:      2,
+	MaxLength:      32,
+	ReservedNames: []string{
+		"admin", "administrator", "root", "system", "sysadmin", "superuser", "arozos",
+		"guest", "anonymous", "nobody", "everyone", "public", "user", "users",
+	},
+}
+
+// Load the username policy from database
+func (a *AuthAgent) loadUsernamePolicy() {
+	policy := DefaultUsernamePolicy
+	if a.Database.KeyExists("auth_policy", "username") {
+		policy = UsernamePolicy{}
+		a.Database.Read("auth_policy", "username", &policy)
+	}
+	a.usernamePolicy = policy
+}
+
+// Get the current username policy
+func (a *AuthAgent) GetUsernamePolicy() UsernamePolicy {
+	return a.usernamePolicy
+}
+
+// Set and save the username policy
+func (a *AuthAgent) SetUsernamePolicy(policy UsernamePolicy) error {
+	if policy.AllowedPattern != "" {
+		if _, err := regexp.Compile(policy.AllowedPattern); err != nil {
+			return errors.New("invalid allowed pattern: " + err.Error())
+		}
+	}
+	if policy.MinLength < 0 {
+		policy.MinLength = 0
+	}
+	if policy.MaxLength < 0 {
+		policy.MaxLength = 0
+	}
+	if policy.MaxLength > 0 && policy.MaxLength < policy.MinLength {
+		return errors.New("max length cannot be shorter than min length")
+	}
+
+	cleanNames := []string{}
+	for _, name := range policy.ReservedNames {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			cleanNames = append(cleanNames, name)
+		}
+	}
+	policy.ReservedNames = cleanNames
+
+	a.usernamePolicy = policy
+	return a.Database.Write("auth_policy", "username", policy)
+}
+
+// Get the username to be stored for a new account, in lower case if NormalizeCase is set
+func (a *AuthAgent) NormalizeUsername(username string) string {
+	username = strings.TrimSpace(username)
+	if a.usernamePolicy.NormalizeCase {
+		return strings.ToLower(username)
+	}
+	return username
+}
+
+// Validate the new username against the username policy, return the rejection reason if not accepted
+func (a *AuthAgent) ValidateUsernameWithPolicy(username string) (bool, string) {
+	policy := a.usernamePolicy
+	if a.GetUserCounts() == 0 {
+		//The first account is the administrator setting up the system
+		policy.ReservedNames = []string{}
+	}
+
+	if ok, reason := policy.Validate(username); !ok {
+		return false, reason
+	}
+
+	if policy.NormalizeCase {
+		for _, existingUser := range a.ListUsers() {
+			if strings.EqualFold(existingUser, username) {
+				return false, "This username has already been used"
+			}
+		}
+	}
+	return true, ""
+}
+
+// Validate the username against this policy, return the rejection reason if not accepted
+func (p UsernamePolicy) Validate(username string) (bool, string) {
+	length := len([]rune(username))
+	if p.MinLength > 0 && length < p.MinLength {
+		return false, "Username too short. Must be at least " + strconv.Itoa(p.MinLength) + " characters."
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return false, "Username too long. Must be at most " + strconv.Itoa(p.MaxLength) + " characters."
+	}
+
+	if p.AllowedPattern != "" {
+		pattern, err := regexp.Compile(p.AllowedPattern)
+		if err != nil {
+			return false, "Invalid username policy. Please contact the system administrator."
+		}
+		if !pattern.MatchString(username) {
+			return false, "Username contains characters that are not allowed."
+		}
+	}
+
+	for _, reservedName := range p.ReservedNames {
+		if strings.EqualFold(username, reservedName) {
+			return false, "Username " + username + " is reserved. Please choose another one."
+		}
+	}
+
+	return true, ""
+}
+
+// Handle the username policy settings. Leave policy empty for reading the current settings
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (a *AuthAgent) HandleUsernamePolicySettings(w http.ResponseWriter, r *http.Request) {
+	policyJSON, err := utils.PostPara(r, "policy")
+	if err != nil {
+		//Read mode
+		js, _ := json.Marshal(a.GetUsernamePolicy())
+		sendJSONResponse(w, string(js))
+		return
+	}
+
+	newPolicy := UsernamePolicy{}
+	err = json.Unmarshal([]byte(policyJSON), &newPolicy)
+	if err != nil {
+		sendErrorResponse(w, "Invalid policy given")
+		return
+	}
+
+	err = a.SetUsernamePolicy(newPolicy)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] Username policy updated")
+	sendOK(w)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestUsernamePolicyValidate(t *testing.T) {
+	//Empty policy should not restrict anything
+	if ok, reason := (UsernamePolicy{}).Validate("a b"); !ok {
+		t.Errorf("Empty policy rejected username: %s", reason)
+	}
+
+	tests := []struct {
+		username string
+		accepted bool
+	}{
+		{"a", false}, //Too short
+		{"abcdefghijklmnopqrstuvwxyz0123456", false}, //Too long
+		{"john doe", false},                          //Space
+		{"аlice", false},                             //Cyrillic look-alike
+		{".alice", false},                            //Leading symbol
+		{"Admin", false},                             //Reserved
+		{"john.doe_2-x", true},
+	}
+	for _, test := range tests {
+		ok, reason := DefaultUsernamePolicy.Validate(test.username)
+		if ok != test.accepted {
+			t.Errorf("Username %q: expected accepted=%v, got %v (%s)", test.username, test.accepted, ok, reason)
+		}
+		if !ok && reason == "" {
+			t.Errorf("Username %q rejected without reason", test.username)
+		}
+	}
+}
+
+func TestUsernamePolicyAgent(t *testing.T) {
+	a := newTestAuthAgent(t, "auth", "auth_policy")
+	a.loadUsernamePolicy()
+	if a.GetUsernamePolicy().MaxLength != DefaultUsernamePolicy.MaxLength {
+		t.Fatalf("Expected default username policy, got %+v", a.GetUsernamePolicy())
+	}
+
+	//The first account can use reserved names
+	if ok, reason := a.ValidateUsernameWithPolicy("admin"); !ok {
+		t.Errorf("Expected first account to be exempted from reserved names: %s", reason)
+	}
+	a.CreateUserAccount("Alice", "password", []string{"administrator"})
+	if ok, _ := a.ValidateUsernameWithPolicy("admin"); ok {
+		t.Error("Expected reserved name to be rejected")
+	}
+
+	if err := a.SetUsernamePolicy(UsernamePolicy{AllowedPattern: "(["}); err == nil {
+		t.Error("Expected invalid pattern to be rejected")
+	}
+	if err := a.SetUsernamePolicy(UsernamePolicy{NormalizeCase: true}); err != nil {
+		t.Fatalf("Failed to set username policy: %v", err)
+	}
+	if a.NormalizeUsername(" Bob ") != "bob" {
+		t.Errorf("Expected username to be normalized, got %q", a.NormalizeUsername(" Bob "))
+	}
+	if ok, _ := a.ValidateUsernameWithPolicy("alice"); ok {
+		t.Error("Expected username differ by case only to be rejected")
+	}
+
+	//Policy survive reload
+	a.loadUsernamePolicy()
+	if !a.GetUsernamePolicy().NormalizeCase || len(a.GetUsernamePolicy().ReservedNames) != 0 {
+		t.Errorf("Unexpected username policy after reload: %+v", a.GetUsernamePolicy())
+	}
+}
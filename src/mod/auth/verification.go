@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Email Verification
+
+	This script handle the pending state of accounts created by
+	public registration with email verification enabled.
+	Pending accounts cannot login until the verification link is visited.
+
+	auth/pending/{username} => PendingAccount
+	auth/verifytoken/{hashed token} => username
+*/
+
+type PendingAccount struct {
+	Username     string //Username of the pending account
+	Email        string //Email address the verification is sent to
+	TokenHash    string //Hash of the verification token
+	CreationTime int64  //Registration time of this account
+}
+
+// Create a user account that cannot login until verified, return the one-time verification token
+func (a *AuthAgent) CreatePendingUserAccount(newusername string, password string, group []string, email string) (string, error) {
+	token, err := generateVerificationToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.CreateUserAccount(newusername, password, group)
+	if err != nil {
+		return "", err
+	}
+
+	pendingAccount := PendingAccount{
+		Username:     newusername,
+		Email:        email,
+		TokenHash:    Hash(token),
+		CreationTime: time.Now().Unix(),
+	}
+
+	err = a.Database.Write("auth", "pending/"+newusername, pendingAccount)
+	if err != nil {
+		a.UnregisterUser(newusername)
+		return "", err
+	}
+	a.Database.Write("auth", "verifytoken/"+pendingAccount.TokenHash, newusername)
+	return token, nil
+}
+
+// Check if the given user has not verified their email yet
+func (a *AuthAgent) UserIsPendingVerification(username string) bool {
+	return a.Database.KeyExists("auth", "pending/"+username)
+}
+
+// Verify the pending account with the given token, return the username of the verified account
+func (a *AuthAgent) VerifyPendingAccount(token string) (string, error) {
+	tokenHash := Hash(strings.TrimSpace(token))
+	username := ""
+	err := a.Database.Read("auth", "verifytoken/"+tokenHash, &username)
+	if err != nil || username == "" {
+		return "", errors.New("invalid or expired verification token")
+	}
+
+	//Token is one-time use
+	a.removePendingAccountRecord(username)
+	return username, nil
+}
+
+// Remove pending accounts that are not verified within the given TTL (in seconds)
+func (a *AuthAgent) RemoveExpiredPendingAccounts(ttl int64) {
+	entries, err := a.Database.ListTable("auth")
+	if err != nil {
+		return
+	}
+	for _, keypairs := range entries {
+		if !strings.HasPrefix(string(keypairs[0]), "pending/") {
+			continue
+		}
+
+		thisPendingAccount := PendingAccount{}
+		err = json.Unmarshal(keypairs[1], &thisPendingAccount)
+		if err != nil {
+			continue
+		}
+
+		if time.Now().Unix()-thisPendingAccount.CreationTime > ttl {
+			a.UnregisterUser(thisPendingAccount.Username)
+			log.Println("[System Auth] Pending account " + thisPendingAccount.Username + " removed as it is not verified in time")
+		}
+	}
+}
+
+// Handle the verification link in the email. Require GET token
+func (a *AuthAgent) HandlePendingAccountVerify(w http.ResponseWriter, r *http.Request) {
+	token, err := utils.GetPara(r, "token")
+	if err != nil {
+		sendTextResponse(w, "Invalid verification token")
+		return
+	}
+
+	username, err := a.VerifyPendingAccount(token)
+	if err != nil {
+		sendTextResponse(w, "Invalid or expired verification link")
+		return
+	}
+
+	log.Println("[System Auth] " + username + " verified registration email")
+	sendTextResponse(w, "Email verified. You can now login with your account.")
+}
+
+func (a *AuthAgent) removePendingAccountRecord(username string) {
+	pendingAccount := PendingAccount{}
+	err := a.Database.Read("auth", "pending/"+username, &pendingAccount)
+	if err == nil {
+		a.Database.Delete("auth", "verifytoken/"+pendingAccount.TokenHash)
+	}
+	a.Database.Delete("auth", "pending/"+username)
+}
+
+// Generate a random 256 bits verification token
+func generateVerificationToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,532 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"imuslab.com/arozos/mod/network"
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	WebAuthn / Passkey Login
+
+	This script handle the registration and login with WebAuthn
+	authenticators (hardware keys and platform authenticators).
+	Each user can register multiple authenticators, stored as
+
+	auth_webauthn/{username} => []*WebAuthnCredential
+
+	The ceremony session data (the challenge) is kept server side for
+	webauthnCeremonyTTL, keyed by a random ceremony id stored in the
+	user session cookie. Each ceremony can only be finished once.
+
+	The relying party is taken from the configured external URL (see
+	SetWebAuthnRelyingParty), WebAuthn is disabled if it is not set.
+*/
+
+const (
+	webauthnRegisterSessionKey = "webauthn_register"
+	webauthnLoginSessionKey    = "webauthn_login"
+	webauthnCeremonyTTL        = 5 * time.Minute
+)
+
+// Pending WebAuthn ceremony between the begin and finish requests
+type webauthnCeremony struct {
+	kind        string //Session key of the ceremony, register or login
+	sessionData webauthn.SessionData
+	expireTime  int64
+}
+
+type WebAuthnCredential struct {
+	Name         string              //Name of the authenticator given by the user
+	CreationTime int64               //Registration time of this authenticator
+	LastUsed     int64               //Last login time with this authenticator
+	Credential   webauthn.Credential //The credential public key and metadata
+}
+
+// webauthn.User implementation of an arozos user
+type webauthnUser struct {
+	username    string
+	credentials []*WebAuthnCredential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return []byte(u.username)
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.username
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	return u.username
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	results := []webauthn.Credential{}
+	for _, c := range u.credentials {
+		results = append(results, c.Credential)
+	}
+	return results
+}
+
+// List the WebAuthn credentials registered by the user
+func (a *AuthAgent) GetUserWebAuthnCredentials(username string) []*WebAuthnCredential {
+	credentials := []*WebAuthnCredential{}
+	a.Database.Read("auth_webauthn", username, &credentials)
+	return credentials
+}
+
+// Check if the given user has at least one WebAuthn credential registered
+func (a *AuthAgent) UserHasWebAuthnCredentials(username string) bool {
+	return len(a.GetUserWebAuthnCredentials(username)) > 0
+}
+
+// Remove all WebAuthn credentials of the user
+func (a *AuthAgent) RemoveUserWebAuthnCredentials(username string) error {
+	return a.Database.Delete("auth_webauthn", username)
+}
+
+func (a *AuthAgent) saveUserWebAuthnCredentials(username string, credentials []*WebAuthnCredential) error {
+	if len(credentials) == 0 {
+		return a.RemoveUserWebAuthnCredentials(username)
+	}
+	return a.Database.Write("auth_webauthn", username, credentials)
+}
+
+// Set the relying party of WebAuthn from the external URL of this host, e.g. https://cloud.example.com.
+// Passkeys are bound to the host name of the URL, and the ceremonies are only accepted from its origin
+func (a *AuthAgent) SetWebAuthnRelyingParty(externalURL string) error {
+	u, err := url.Parse(strings.TrimSpace(externalURL))
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("invalid external URL " + externalURL)
+	}
+	a.WebAuthnRPID = u.Hostname()
+	a.WebAuthnOrigins = []string{u.Scheme + "://" + u.Host}
+	return nil
+}
+
+// Create the WebAuthn relying party from the configured RP ID and origins
+func (a *AuthAgent) getWebAuthnRelyingParty() (*webauthn.WebAuthn, error) {
+	if a.WebAuthnRPID == "" {
+		return nil, errors.New("WebAuthn is not configured on this host")
+	}
+
+	origins := a.WebAuthnOrigins
+	if len(origins) == 0 {
+		origins = []string{"https://" + a.WebAuthnRPID}
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPDisplayName: "ArozOS",
+		RPID:          a.WebAuthnRPID,
+		RPOrigins:     origins,
+	})
+}
+
+// Store the ceremony session data server side and its ceremony id in the user session cookie
+func (a *AuthAgent) saveWebAuthnSessionData(w http.ResponseWriter, r *http.Request, key string, sessionData *webauthn.SessionData) error {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	ceremonyID := hex.EncodeToString(b)
+
+	a.webauthnCeremonies.Store(ceremonyID, &webauthnCeremony{
+		kind:        key,
+		sessionData: *sessionData,
+		expireTime:  time.Now().Add(webauthnCeremonyTTL).Unix(),
+	})
+
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
+	session.Values[key] = ceremonyID
+	return session.Save(r, w)
+}
+
+// Load and remove the ceremony session data of the ceremony id in the user session cookie.
+// The ceremony is removed even if the finish request fails, so a ceremony cannot be replayed
+func (a *AuthAgent) popWebAuthnSessionData(r *http.Request, key string) (*webauthn.SessionData, error) {
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
+	ceremonyID, ok := session.Values[key].(string)
+	if !ok {
+		return nil, errors.New("WebAuthn session not found")
+	}
+	delete(session.Values, key)
+
+	val, ok := a.webauthnCeremonies.LoadAndDelete(ceremonyID)
+	if !ok {
+		return nil, errors.New("WebAuthn session not found")
+	}
+	ceremony := val.(*webauthnCeremony)
+	if ceremony.kind != key || ceremony.expireTime < time.Now().Unix() {
+		return nil, errors.New("WebAuthn session expired")
+	}
+	return &ceremony.sessionData, nil
+}
+
+// Remove the ceremonies that are not finished in time
+func (a *AuthAgent) RemoveExpiredWebAuthnCeremonies() {
+	now := time.Now().Unix()
+	a.webauthnCeremonies.Range(func(key, value interface{}) bool {
+		if value.(*webauthnCeremony).expireTime < now {
+			a.webauthnCeremonies.Delete(key)
+		}
+		return true
+	})
+}
+
+// Generate a stable fake credential for users without passkeys, so the login begin response
+// does not tell which accounts have passkeys registered
+func (a *AuthAgent) getWebAuthnDummyCredentials(username string) []*WebAuthnCredential {
+	a.webauthnDummyOnce.Do(func() {
+		a.webauthnDummyKey = make([]byte, 32)
+		rand.Read(a.webauthnDummyKey)
+	})
+	mac := hmac.New(sha256.New, a.webauthnDummyKey)
+	mac.Write([]byte(username))
+	return []*WebAuthnCredential{
+		{Credential: webauthn.Credential{ID: mac.Sum(nil)[:16]}},
+	}
+}
+
+/*
+	Registration
+*/
+
+// Handle the start of authenticator registration for the current user
+func (a *AuthAgent) HandleWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	rp, err := a.getWebAuthnRelyingParty()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	//Prevent registering the same authenticator twice
+	user := &webauthnUser{username: username, credentials: a.GetUserWebAuthnCredentials(username)}
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, c := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	creation, sessionData, err := rp.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	err = a.saveWebAuthnSessionData(w, r, webauthnRegisterSessionKey, sessionData)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(creation)
+	sendJSONResponse(w, string(js))
+}
+
+// Handle the authenticator response of registration. Require the credential in request body and GET name
+func (a *AuthAgent) HandleWebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	name, _ := utils.GetPara(r, "name")
+	if strings.TrimSpace(name) == "" {
+		name = "Authenticator"
+	}
+
+	sessionData, err := a.popWebAuthnSessionData(r, webauthnRegisterSessionKey)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	rp, err := a.getWebAuthnRelyingParty()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	credentials := a.GetUserWebAuthnCredentials(username)
+	user := &webauthnUser{username: username, credentials: credentials}
+	credential, err := rp.FinishRegistration(user, *sessionData, r)
+	if err != nil {
+		sendErrorResponse(w, "Authenticator registration failed")
+		return
+	}
+
+	credentials = append(credentials, &WebAuthnCredential{
+		Name:         name,
+		CreationTime: time.Now().Unix(),
+		Credential:   *credential,
+	})
+	err = a.saveUserWebAuthnCredentials(username, credentials)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	//Save the session to clear the ceremony id
+	session, _ := a.getSessionStore().Get(r, a.SessionName)
+	session.Save(r, w)
+
+	log.Println("[System Auth] " + username + " registered a new WebAuthn authenticator")
+	sendOK(w)
+}
+
+// Handle listing of the current user's authenticators
+func (a *AuthAgent) HandleWebAuthnList(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	type AuthenticatorInfo struct {
+		ID           string
+		Name         string
+		CreationTime int64
+		LastUsed     int64
+	}
+
+	results := []*AuthenticatorInfo{}
+	for _, c := range a.GetUserWebAuthnCredentials(username) {
+		results = append(results, &AuthenticatorInfo{
+			ID:           base64.RawURLEncoding.EncodeToString(c.Credential.ID),
+			Name:         c.Name,
+			CreationTime: c.CreationTime,
+			LastUsed:     c.LastUsed,
+		})
+	}
+
+	js, _ := json.Marshal(results)
+	sendJSONResponse(w, string(js))
+}
+
+// Handle removal of one of the current user's authenticators. Require POST id
+func (a *AuthAgent) HandleWebAuthnRemove(w http.ResponseWriter, r *http.Request) {
+	username, err := a.GetUserName(w, r)
+	if err != nil {
+		sendErrorResponse(w, "User not logged in")
+		return
+	}
+
+	id, err := utils.PostPara(r, "id")
+	if err != nil {
+		sendErrorResponse(w, "Invalid authenticator id given")
+		return
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		sendErrorResponse(w, "Invalid authenticator id given")
+		return
+	}
+
+	credentials := a.GetUserWebAuthnCredentials(username)
+	remainingCredentials := []*WebAuthnCredential{}
+	for _, c := range credentials {
+		if !bytes.Equal(c.Credential.ID, credentialID) {
+			remainingCredentials = append(remainingCredentials, c)
+		}
+	}
+
+	if len(remainingCredentials) == len(credentials) {
+		sendErrorResponse(w, "Authenticator not found")
+		return
+	}
+
+	err = a.saveUserWebAuthnCredentials(username, remainingCredentials)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	log.Println("[System Auth] " + username + " removed a WebAuthn authenticator")
+	sendOK(w)
+}
+
+/*
+	Login
+*/
+
+// Handle the start of passwordless login. Require POST username
+func (a *AuthAgent) HandleWebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	username, err := utils.PostPara(r, "username")
+	if err != nil {
+		sendErrorResponse(w, "Username not defined or empty.")
+		return
+	}
+
+	//Check if this request origin is allowed to access
+	if ok, reason := a.ValidateLoginRequest(w, r); !ok {
+		if reason == nil {
+			reason = errors.New("Unable to resolve request origin")
+		}
+		sendErrorResponse(w, reason.Error())
+		return
+	}
+
+	rp, err := a.getWebAuthnRelyingParty()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	//Reply with a fake assertion if passkey login is not available, the finish request will fail as usual
+	credentials := a.GetUserWebAuthnCredentials(username)
+	if len(credentials) == 0 || !a.UserExists(username) || a.UserIsPendingVerification(username) {
+		credentials = a.getWebAuthnDummyCredentials(username)
+	}
+
+	assertion, sessionData, err := rp.BeginLogin(&webauthnUser{username: username, credentials: credentials})
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	err = a.saveWebAuthnSessionData(w, r, webauthnLoginSessionKey, sessionData)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(assertion)
+	sendJSONResponse(w, string(js))
+}
+
+// Handle the authenticator response of login. Require the assertion in request body and optional GET rmbme
+func (a *AuthAgent) HandleWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	//Check if this request origin is allowed to access
+	if ok, reason := a.ValidateLoginRequest(w, r); !ok {
+		if reason == nil {
+			reason = errors.New("Unable to resolve request origin")
+		}
+		sendErrorResponse(w, reason.Error())
+		return
+	}
+
+	sessionData, err := a.popWebAuthnSessionData(r, webauthnLoginSessionKey)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	username := string(sessionData.UserID)
+
+	//Check Exponential Login Handler
+	ok, _ := a.ExpDelayHandler.AllowImmediateAccess(username, r)
+	if !ok {
+		a.ExpDelayHandler.AddUserRetrycount(username, r)
+		sendErrorResponse(w, "Too many request! Please try again later")
+		return
+	}
+
+	if a.UserIsLocked(username) {
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "Account locked")
+		sendErrorResponse(w, accountLockedReason)
+		return
+	}
+
+	if a.UserIsDisabled(username) {
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "Account disabled")
+		sendErrorResponse(w, accountDisabledReason)
+		return
+	}
+
+	//Only administrators can login during maintenance
+	if a.MaintenanceBlocksUser(username) {
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "System under maintenance")
+		a.publishEvent(r, EventLoginFailure, username, LoginFailureMaintenance)
+		sendAuthErrorResponse(w, AuthErrMaintenance, a.GetMaintenanceMessage())
+		return
+	}
+
+	rp, err := a.getWebAuthnRelyingParty()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	credentials := a.GetUserWebAuthnCredentials(username)
+	credential, err := rp.FinishLogin(&webauthnUser{username: username, credentials: credentials}, *sessionData, r)
+	if err != nil {
+		log.Println("[System Auth] " + username + " WebAuthn login rejected: " + err.Error())
+		a.ExpDelayHandler.AddUserRetrycount(username, r)
+		a.recordLoginFailure(r)
+		a.recordUserLoginFailure(r, username)
+		a.Logger.LogAuth(r, false)
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "Authenticator verification failed")
+		sendErrorResponse(w, "Authenticator verification failed")
+		return
+	}
+
+	//Sign counter went backward, the authenticator might be cloned
+	if credential.Authenticator.CloneWarning {
+		log.Println("[System Auth] WARNING! Possible cloned authenticator used by " + username + ". Login rejected")
+		a.ExpDelayHandler.AddUserRetrycount(username, r)
+		a.recordLoginFailure(r)
+		a.recordUserLoginFailure(r, username)
+		a.Logger.LogAuth(r, false)
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, "Possible cloned authenticator")
+		sendErrorResponse(w, "Authenticator verification failed")
+		return
+	}
+
+	//Update the sign counter and last used time of the authenticator
+	for _, c := range credentials {
+		if bytes.Equal(c.Credential.ID, credential.ID) {
+			c.Credential.Authenticator = credential.Authenticator
+			c.LastUsed = time.Now().Unix()
+		}
+	}
+	a.saveUserWebAuthnCredentials(username, credentials)
+
+	rmbme, _ := utils.GetPara(r, "rmbme")
+	err = a.LoginUserByRequest(w, r, username, rmbme == "true")
+	if err != nil {
+		code := getAuthErrorCode(err)
+		a.Logger.LogAuth(r, false)
+		a.LogAuditEvent(r, AuditActionLogin, "", username, false, err.Error())
+		a.publishEvent(r, EventLoginFailure, username, getLoginFailureType(code))
+		sendAuthErrorResponse(w, code, err.Error())
+		return
+	}
+	a.ExpDelayHandler.ResetUserRetryCount(username, r)
+	a.resetUserLoginFailure(username)
+	a.SwitchableAccountManager.MatchPoolCreatorOrResetPoolID(username, w, r)
+
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		clientIP = "unknown"
+	}
+	log.Println(username + " logged in with WebAuthn.")
+	a.Logger.LogAuthEvent(username, clientIP, true, "webauthn")
+	a.LogAuditEvent(r, AuditActionLogin, username, username, true, "webauthn")
+	sendOK(w)
+}
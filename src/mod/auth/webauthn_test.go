@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/sessions"
+)
+
+func TestWebAuthnRelyingParty(t *testing.T) {
+	a := &AuthAgent{}
+	if _, err := a.getWebAuthnRelyingParty(); err == nil {
+		t.Error("Expected WebAuthn disabled without external URL")
+	}
+
+	if err := a.SetWebAuthnRelyingParty("cloud.example.com"); err == nil {
+		t.Error("Expected external URL without scheme rejected")
+	}
+	if err := a.SetWebAuthnRelyingParty("https://cloud.example.com:8443/"); err != nil {
+		t.Fatal(err)
+	}
+	if a.WebAuthnRPID != "cloud.example.com" || a.WebAuthnOrigins[0] != "https://cloud.example.com:8443" {
+		t.Errorf("Unexpected relying party %s %v", a.WebAuthnRPID, a.WebAuthnOrigins)
+	}
+	if _, err := a.getWebAuthnRelyingParty(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebAuthnCeremony(t *testing.T) {
+	a := &AuthAgent{
+		SessionStore: sessions.NewCookieStore([]byte("0123456789abcdef")),
+		SessionName:  "ao_auth",
+	}
+
+	begin := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		err := a.saveWebAuthnSessionData(w, httptest.NewRequest("POST", "/system/auth/webauthn/login/begin", nil), webauthnLoginSessionKey, &webauthn.SessionData{Challenge: "challenge", UserID: []byte("alice")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	finish := func(w *httptest.ResponseRecorder, key string) error {
+		r := httptest.NewRequest("POST", "/system/auth/webauthn/login/finish", nil)
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		_, err := a.popWebAuthnSessionData(r, key)
+		return err
+	}
+
+	//Each ceremony can only be finished once, even if the cookie is replayed
+	w := begin()
+	if err := finish(w, webauthnRegisterSessionKey); err == nil {
+		t.Error("Expected ceremony of another kind rejected")
+	}
+	w = begin()
+	if err := finish(w, webauthnLoginSessionKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := finish(w, webauthnLoginSessionKey); err == nil {
+		t.Error("Expected replayed ceremony rejected")
+	}
+
+	//Expired ceremonies are rejected and removed
+	w = begin()
+	a.webauthnCeremonies.Range(func(key, value interface{}) bool {
+		value.(*webauthnCeremony).expireTime = time.Now().Add(-time.Second).Unix()
+		return true
+	})
+	a.RemoveExpiredWebAuthnCeremonies()
+	if err := finish(w, webauthnLoginSessionKey); err == nil {
+		t.Error("Expected expired ceremony rejected")
+	}
+
+	//Fake credentials are stable per user
+	alice := a.getWebAuthnDummyCredentials("alice")
+	if !bytes.Equal(alice[0].Credential.ID, a.getWebAuthnDummyCredentials("alice")[0].Credential.ID) ||
+		bytes.Equal(alice[0].Credential.ID, a.getWebAuthnDummyCredentials("bob")[0].Credential.ID) {
+		t.Error("Expected stable and distinct fake credentials")
+	}
+}
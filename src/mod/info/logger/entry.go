@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+	Log Entry
+
+	Parsed representation of a log line, shared by the log query,
+	ring buffer and hooks. Use ParseLine to read the log files
+	written by the logger in either text or JSON format
+*/
+
+type LogEntry struct {
+	Timestamp time.Time
+	Title     string
+	Level     string
+	Message   string
+	Error     string //Only available for JSON format logs, text format logs append the error to the message
+
+	Fields map[string]string `json:",omitempty"` //Only available for JSON format logs, text format logs append the fields to the message
+}
+
+// Matching 2006-01-02 15:04:05.000000|{title} [LEVEL]message
+var textLogLineRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{6})\|(.*?) \[(DEBUG|INFO|WARN|ERROR)\](.*)$`)
+
+// Convert the log parameters into a LogEntry
+func newLogEntry(t time.Time, level LogLevel, title string, message string, originalError error) LogEntry {
+	thisEntry := LogEntry{
+		Timestamp: t,
+		Title:     title,
+		Level:     level.String(),
+		Message:   message,
+	}
+	if originalError != nil {
+		thisEntry.Error = originalError.Error()
+	}
+	return thisEntry
+}
+
+// ParseLine parse a log line written by the logger in either text or JSON format.
+// For text format, the original error (if any) is kept at the end of the message
+// as there is no separator between them in the written line
+func ParseLine(line string) (LogEntry, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "{") {
+		thisLine := jsonLogLine{}
+		err := json.Unmarshal([]byte(line), &thisLine)
+		if err != nil {
+			return LogEntry{}, err
+		}
+		ts, err := time.Parse(jsonTimestampLayout, thisLine.Timestamp)
+		if err != nil {
+			return LogEntry{}, err
+		}
+		return LogEntry{
+			Timestamp: ts,
+			Title:     thisLine.Title,
+			Level:     thisLine.Level,
+			Message:   thisLine.Message,
+			Error:     thisLine.Error,
+			Fields:    thisLine.Fields,
+		}, nil
+	}
+
+	matches := textLogLineRegex.FindStringSubmatch(line)
+	if matches == nil {
+		return LogEntry{}, errors.New("malformed log line")
+	}
+	ts, err := time.ParseInLocation("2006-01-02 15:04:05.000000", matches[1], time.Local)
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	return LogEntry{
+		Timestamp: ts,
+		Title:     strings.TrimRight(matches[2], " "),
+		Level:     matches[3],
+		Message:   matches[4],
+	}, nil
+}
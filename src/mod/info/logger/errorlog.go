@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+	Error Log
+
+	If SeparateErrorLog is set, ERROR entries are also written to a dedicated
+	file in parallel with the main log file, in the format of
+
+	{prefix}_error_{year}-{month}.log
+
+	The error log is rotated by the RotationPeriod together with the main log file.
+	Size rotation and compression only apply to the main log file.
+*/
+
+func (l *Logger) getErrorLogFilepath() string {
+	return filepath.Join(l.LogFolder, l.Prefix+"_error_"+l.RotationPeriod.periodName(time.Now())+".log")
+}
+
+// Write the log line to the error log file. Caller must hold the logger mutex
+func (l *Logger) writeToErrorFile(logLine string) {
+	l.validateAndUpdateErrorLogFilepath()
+	if l.errorFile == nil {
+		return
+	}
+	l.errorFile.WriteString(logLine)
+}
+
+// Switch to the error log file of the current period if changed. Caller must hold the logger mutex
+func (l *Logger) validateAndUpdateErrorLogFilepath() {
+	expectedErrorLogFilepath := l.getErrorLogFilepath()
+	if l.errorFile != nil && l.CurrentErrorLog == expectedErrorLogFilepath {
+		return
+	}
+
+	f, err := os.OpenFile(expectedErrorLogFilepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
+	if err != nil {
+		//Keep writing to the main log file only
+		return
+	}
+	if l.errorFile != nil {
+		l.errorFile.Close()
+	}
+	l.errorFile = f
+	l.CurrentErrorLog = expectedErrorLogFilepath
+}
@@ -0,0 +1,165 @@
+package logger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Log Fields
+
+	Structured key / value context attached to the log entries, e.g. the
+	request_id and user of the request being handled, so the lines of
+	concurrent requests can be correlated. Fields are written as
+
+	Text format, appended to the end of the line sorted by key
+	2006-01-02 15:04:05.000000|Title            [INFO]message request_id=1a2b3c user=alice
+
+	JSON format, in the fields object
+	{"ts":"...","title":"Title","level":"INFO","message":"message","fields":{"request_id":"1a2b3c","user":"alice"}}
+
+	Sinks receive the fields by implementing FieldLogSink, the others (e.g.
+	STDOUT and syslog) get the fields appended to the message as in text format.
+	Use WithFields or WithRequest to get a FieldLogger bound to a set of fields.
+*/
+
+// Header carrying the ID of a request, reused if given by the client or proxy
+const RequestIDHeader = "X-Request-ID"
+
+// Sink that receive the fields of the log entries separately
+type FieldLogSink interface {
+	LogSink
+	WriteLogWithFields(t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string)
+}
+
+// Logger bound to a fixed set of fields, create with Logger.WithFields
+type FieldLogger struct {
+	parent *Logger
+	fields map[string]string
+}
+
+// LogWithFields will log the message with the fields to all sinks and print the log to STDOUT if the level is above the threshold
+func (l *Logger) LogWithFields(fields map[string]string, level LogLevel, title string, message string, originalError error) {
+	if level < l.LogLevel {
+		return
+	}
+	now := time.Now()
+	if !l.allowSample(now, level, title) {
+		return
+	}
+	l.logWithLevel(now, level, title, message, originalError, copyFields(fields))
+}
+
+// Get a child logger that attach the given fields to all of its log entries
+func (l *Logger) WithFields(fields map[string]string) *FieldLogger {
+	return &FieldLogger{
+		parent: l,
+		fields: copyFields(fields),
+	}
+}
+
+// Get a child logger with the request_id field of the request. The ID is taken from the X-Request-ID header if valid,
+// otherwise a new one is generated and set to the request header, so later calls with the same request get the same ID
+func (l *Logger) WithRequest(r *http.Request) *FieldLogger {
+	requestID := r.Header.Get(RequestIDHeader)
+	if !isValidRequestID(requestID) {
+		requestID = NewRequestID()
+		r.Header.Set(RequestIDHeader, requestID)
+	}
+	return l.WithFields(map[string]string{"request_id": requestID})
+}
+
+// Generate a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Accept client given request IDs that are short and printable only, so they cannot forge log lines
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 64 {
+		return false
+	}
+	for _, c := range requestID {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Get a child logger with the fields of this logger and the given fields, the given ones take precedence
+func (f *FieldLogger) WithFields(fields map[string]string) *FieldLogger {
+	merged := copyFields(f.fields)
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &FieldLogger{
+		parent: f.parent,
+		fields: merged,
+	}
+}
+
+// Get a copy of the fields bound to this logger
+func (f *FieldLogger) Fields() map[string]string {
+	return copyFields(f.fields)
+}
+
+// PrintAndLog will log the message with the fields to file and print the log to STDOUT
+// Logged as INFO if originalError is nil, otherwise ERROR
+func (f *FieldLogger) PrintAndLog(title string, message string, originalError error) {
+	f.parent.LogWithFields(f.fields, getDefaultLevel(originalError), title, message, originalError)
+}
+
+func (f *FieldLogger) LogWithLevel(level LogLevel, title string, message string, originalError error) {
+	f.parent.LogWithFields(f.fields, level, title, message, originalError)
+}
+
+func (f *FieldLogger) Debug(title string, message string, originalError error) {
+	f.parent.LogWithFields(f.fields, LevelDebug, title, message, originalError)
+}
+
+func (f *FieldLogger) Info(title string, message string, originalError error) {
+	f.parent.LogWithFields(f.fields, LevelInfo, title, message, originalError)
+}
+
+func (f *FieldLogger) Warn(title string, message string, originalError error) {
+	f.parent.LogWithFields(f.fields, LevelWarn, title, message, originalError)
+}
+
+func (f *FieldLogger) Error(title string, message string, originalError error) {
+	f.parent.LogWithFields(f.fields, LevelError, title, message, originalError)
+}
+
+func copyFields(fields map[string]string) map[string]string {
+	results := make(map[string]string, len(fields))
+	for key, value := range fields {
+		results[key] = value
+	}
+	return results
+}
+
+// Format the fields as space separated key=value sorted by key, values with spaces or quotes are quoted
+func formatFieldsText(fields map[string]string) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := fields[key]
+		if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
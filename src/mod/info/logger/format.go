@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	Log Format
+
+	Text format (default)
+	2006-01-02 15:04:05.000000|{title padded to 16} [LEVEL]message {original error}
+
+	JSON format (one object per line)
+	{"ts":"2006-01-02T15:04:05.000000+08:00","title":"...","level":"INFO","message":"...","error":"...","fields":{...}}
+
+	Fields are only written for entries logged with fields, see fields.go
+*/
+
+type LogFormat int
+
+const (
+	FormatText LogFormat = iota
+	FormatJSON
+)
+
+// Timestamp layout used by the JSON format, RFC3339 with microseconds
+const jsonTimestampLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+type jsonLogLine struct {
+	Timestamp string            `json:"ts"`
+	Title     string            `json:"title"`
+	Level     string            `json:"level"`
+	Message   string            `json:"message"`
+	Error     string            `json:"error,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// Parse the log format from its text representation, e.g. "json"
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatText, errors.New("invalid log format given")
+}
+
+// Format a log line (with trailing newline) in the given format
+func formatLogLine(format LogFormat, t time.Time, level LogLevel, title string, message string, originalError error) string {
+	return formatLogLineWithFields(format, t, level, title, message, originalError, nil)
+}
+
+// Format a log line (with trailing newline) with the fields in the given format. fields can be nil
+func formatLogLineWithFields(format LogFormat, t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) string {
+	if format == FormatJSON {
+		thisLine := jsonLogLine{
+			Timestamp: t.Format(jsonTimestampLayout),
+			Title:     title,
+			Level:     level.String(),
+			Message:   message,
+		}
+		if originalError != nil {
+			thisLine.Error = originalError.Error()
+		}
+		if len(fields) > 0 {
+			thisLine.Fields = fields
+		}
+		js, _ := json.Marshal(thisLine)
+		return string(js) + "\n"
+	}
+
+	logLine := t.Format("2006-01-02 15:04:05.000000") + "|" + fmt.Sprintf("%-16s", title) + " [" + level.String() + "]" + message
+	if originalError != nil {
+		logLine += " " + originalError.Error()
+	}
+	if len(fields) > 0 {
+		logLine += " " + formatFieldsText(fields)
+	}
+	return logLine + "\n"
+}
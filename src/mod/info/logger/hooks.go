@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+	Log Hooks
+
+	Hooks are callbacks invoked for every log entry after it is
+	written, e.g. for forwarding ERROR logs to a webhook.
+
+	Entries are queued in a buffered channel and passed to the hooks
+	in a background goroutine, so a slow hook never block the logging
+	path. If the queue is full, the entry is dropped for the hooks.
+*/
+
+const hookQueueSize = 256
+
+type LogHook func(entry LogEntry)
+
+type hookSink struct {
+	hooks     map[int]LogHook
+	nextID    int
+	queue     chan LogEntry
+	startOnce sync.Once
+	mutex     sync.RWMutex
+}
+
+func newHookSink() *hookSink {
+	return &hookSink{
+		hooks: map[int]LogHook{},
+		queue: make(chan LogEntry, hookQueueSize),
+	}
+}
+
+// Add a hook that is invoked for every log entry, return the hook ID for removal
+func (l *Logger) AddHook(hook LogHook) int {
+	return l.hooks.add(hook)
+}
+
+// Remove a hook by its ID
+func (l *Logger) RemoveHook(hookID int) {
+	l.hooks.remove(hookID)
+}
+
+func (s *hookSink) add(hook LogHook) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextID++
+	s.hooks[s.nextID] = hook
+
+	//Start the dispatcher when the first hook is added
+	s.startOnce.Do(func() {
+		go s.dispatch()
+	})
+	return s.nextID
+}
+
+func (s *hookSink) remove(hookID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.hooks, hookID)
+}
+
+func (s *hookSink) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+	s.WriteLogWithFields(t, level, title, message, originalError, nil)
+}
+
+func (s *hookSink) WriteLogWithFields(t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) {
+	s.mutex.RLock()
+	hookCount := len(s.hooks)
+	s.mutex.RUnlock()
+	if hookCount == 0 {
+		return
+	}
+
+	thisEntry := newLogEntry(t, level, title, message, originalError)
+	thisEntry.Fields = fields
+	select {
+	case s.queue <- thisEntry:
+	default:
+		//Queue full, drop the entry instead of blocking the logging path
+	}
+}
+
+func (s *hookSink) dispatch() {
+	for entry := range s.queue {
+		s.mutex.RLock()
+		hooks := make([]LogHook, 0, len(s.hooks))
+		for _, hook := range s.hooks {
+			hooks = append(hooks, hook)
+		}
+		s.mutex.RUnlock()
+
+		for _, hook := range hooks {
+			runHook(hook, entry)
+		}
+	}
+}
+
+// Run the hook and recover from its panic, so one faulty hook do not stop the others
+func runHook(hook LogHook, entry LogEntry) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("[Logger] Log hook panic recovered: ", r)
+		}
+	}()
+	hook(entry)
+}
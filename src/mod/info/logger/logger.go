@@ -1,11 +1,11 @@
 package logger
 
 import (
-	"fmt"
-	"log"
+	"errors"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,12 +16,78 @@ import (
 	and replace the ton of log.Println in the system core
 */
 
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Get the text representation of the log level, e.g. INFO
+func (lv LogLevel) String() string {
+	switch lv {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "UNKNOWN"
+}
+
+// Parse the log level from its text representation, e.g. "warn"
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToUpper(strings.TrimSpace(level)) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO":
+		return LevelInfo, nil
+	case "WARN", "WARNING":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	}
+	return LevelInfo, errors.New("invalid log level given")
+}
+
 type Logger struct {
-	LogToFile      bool     //Set enable write to file
-	Prefix         string   //Prefix for log files
-	LogFolder      string   //Folder to store the log  file
-	CurrentLogFile string   //Current writing filename
-	file           *os.File //File, empty if LogToFile is false
+	LogToFile        bool      //Set enable write to file
+	LogLevel         LogLevel  //Minimum level to be logged, default INFO
+	Format           LogFormat //Format of the log lines written to file, default Text. See format.go
+	Prefix           string    //Prefix for log files
+	LogFolder        string    //Folder to store the log  file
+	CurrentLogFile   string    //Current writing filename
+	CurrentErrorLog  string    //Current writing error log filename, empty if SeparateErrorLog is not enabled
+	MaxFileSizeBytes int64     //Rotate to a new file when the current one exceed this size, 0 to disable. See rotate.go
+	RetentionMonths  int       //Number of months of log files to keep including the current one, 0 to keep forever. See retention.go
+	CompressRotated  bool      //Gzip compress the previous log file after rotation. See rotate.go
+	Synchronous      bool      //Write to the sinks inline instead of the background writer, slower but no message lost on crash
+	QueueSize        int       //Number of entries buffered for the background writer, set before logging. See writer.go
+	DropWhenFull     bool      //Drop the entries instead of blocking when the background writer queue is full
+	SeparateErrorLog bool      //Also write ERROR entries to a dedicated error log file. See errorlog.go
+	file             *os.File  //File, empty if LogToFile is false
+	errorFile        *os.File  //Error log file, empty if SeparateErrorLog is false
+	currentPeriodLog string    //Log filepath of the current period without rotation suffix
+	currentSuffix    int       //Rotation suffix of the current log file
+	currentFileSize  int64     //Size of the current log file
+	openRetry        openRetry //Backoff of opening the log file after failure. See rotate.go
+	mutex            sync.Mutex
+	compressing      sync.WaitGroup //Background compression of rotated log files
+	pendingWrites    sync.WaitGroup //Async writes from LogWithLevel that are not yet written to the sinks
+	writer           asyncWriter    //Background writer of the async writes. See writer.go
+	ringBuffer       *RingBuffer    //In-memory buffer of the latest entries. See sink.go
+	stdout           LogSink        //Sink for PrintAndLog and leveled log functions
+	sinks            []LogSink      //All sinks that receive the log entries
+	hooks            *hookSink      //Callbacks for every log entry. See hooks.go
+	sampler          logSampler     //Per title rate limit of the log entries. See sampling.go
+	sinkMutex        sync.RWMutex
+
+	RotationPeriod RotationPeriod //Period of the log files, default Monthly. See period.go
 }
 
 // Create a default logger
@@ -34,19 +100,23 @@ func NewLogger(logFilePrefix string, logFolder string, logToFile bool) (*Logger,
 	}
 
 	thisLogger := Logger{
-		LogToFile: logToFile,
-		Prefix:    logFilePrefix,
-		LogFolder: logFolder,
+		LogToFile:  logToFile,
+		LogLevel:   LevelInfo,
+		Format:     FormatText,
+		Prefix:     logFilePrefix,
+		LogFolder:  logFolder,
+		QueueSize:  DefaultQueueSize,
+		ringBuffer: NewRingBuffer(DefaultRingBufferSize),
+		stdout:     &StdoutSink{},
 	}
+	thisLogger.hooks = newHookSink()
+	thisLogger.sinks = []LogSink{&fileSink{logger: &thisLogger}, thisLogger.ringBuffer, thisLogger.hooks}
 
 	if logToFile {
-		logFilePath := thisLogger.getLogFilepath()
-		f, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
+		err := thisLogger.switchLogFile(thisLogger.getLogFilepath(), 0)
 		if err != nil {
 			return nil, err
 		}
-		thisLogger.CurrentLogFile = logFilePath
-		thisLogger.file = f
 	}
 
 	return &thisLogger, nil
@@ -58,47 +128,162 @@ func NewTmpLogger() (*Logger, error) {
 }
 
 func (l *Logger) getLogFilepath() string {
-	year, month, _ := time.Now().Date()
-	return filepath.Join(l.LogFolder, l.Prefix+"_"+strconv.Itoa(year)+"-"+strconv.Itoa(int(month))+".log")
+	return filepath.Join(l.LogFolder, l.Prefix+"_"+l.RotationPeriod.periodName(time.Now())+".log")
 }
 
 // PrintAndLog will log the message to file and print the log to STDOUT
+// Logged as INFO if originalError is nil, otherwise ERROR
 func (l *Logger) PrintAndLog(title string, message string, originalError error) {
-	go func() {
-		l.Log(title, message, originalError)
-	}()
-	log.Println("[" + title + "] " + message)
+	l.LogWithLevel(getDefaultLevel(originalError), title, message, originalError)
 }
 
+// Log will log the message to file and the ring buffer only
+// Logged as INFO if originalError is nil, otherwise ERROR
 func (l *Logger) Log(title string, errorMessage string, originalError error) {
-	if l.LogToFile {
-		l.ValidateAndUpdateLogFilepath()
-		if originalError == nil {
-			l.file.WriteString(time.Now().Format("2006-01-02 15:04:05.000000") + "|" + fmt.Sprintf("%-16s", title) + " [INFO]" + errorMessage + "\n")
-		} else {
-			l.file.WriteString(time.Now().Format("2006-01-02 15:04:05.000000") + "|" + fmt.Sprintf("%-16s", title) + " [ERROR]" + errorMessage + " " + originalError.Error() + "\n")
-		}
+	level := getDefaultLevel(originalError)
+	if level < l.LogLevel {
+		return
+	}
+	now := time.Now()
+	if !l.allowSample(now, level, title) {
+		return
+	}
+	l.writeToSinks(now, level, title, errorMessage, originalError, nil)
+}
+
+// LogWithLevel will log the message to all sinks and print the log to STDOUT if the level is above the threshold
+func (l *Logger) LogWithLevel(level LogLevel, title string, message string, originalError error) {
+	if level < l.LogLevel {
+		return
+	}
+	now := time.Now()
+	if !l.allowSample(now, level, title) {
+		return
+	}
+	l.logWithLevel(now, level, title, message, originalError, nil)
+}
+
+// Write the entry to all sinks and STDOUT without the level and sampling checks. fields can be nil
+func (l *Logger) logWithLevel(now time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) {
+	if l.Synchronous || !l.enqueue(queuedEntry{now, level, title, message, originalError, fields}) {
+		//Synchronous mode or the logger is closed
+		l.writeToSinks(now, level, title, message, originalError, fields)
+	}
+	writeToSink(l.stdout, now, level, title, message, originalError, fields)
+}
+
+func (l *Logger) Debug(title string, message string, originalError error) {
+	l.LogWithLevel(LevelDebug, title, message, originalError)
+}
+
+func (l *Logger) Info(title string, message string, originalError error) {
+	l.LogWithLevel(LevelInfo, title, message, originalError)
+}
+
+func (l *Logger) Warn(title string, message string, originalError error) {
+	l.LogWithLevel(LevelWarn, title, message, originalError)
+}
+
+func (l *Logger) Error(title string, message string, originalError error) {
+	l.LogWithLevel(LevelError, title, message, originalError)
+}
+
+func (l *Logger) writeToFile(t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.LogToFile {
+		return
+	}
+
+	logLine := formatLogLineWithFields(l.Format, t, level, title, message, originalError, fields)
+
+	l.validateAndUpdateLogFilepath(int64(len(logLine)))
+	if l.file == nil {
+		//No log file opened yet, retry on next write
+		return
+	}
+	n, _ := l.file.WriteString(logLine)
+	l.currentFileSize += int64(n)
+
+	if l.SeparateErrorLog && level >= LevelError {
+		l.writeToErrorFile(logLine)
 	}
+}
 
+// Map the legacy logging functions into INFO / ERROR levels
+func getDefaultLevel(originalError error) LogLevel {
+	if originalError == nil {
+		return LevelInfo
+	}
+	return LevelError
 }
 
-// Validate if the logging target is still valid (detect any months change)
+// Validate if the logging target is still valid (detect any period change or file size exceeded)
 func (l *Logger) ValidateAndUpdateLogFilepath() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.validateAndUpdateLogFilepath(0)
+	if l.SeparateErrorLog && l.LogToFile {
+		l.validateAndUpdateErrorLogFilepath()
+	}
+}
+
+// Caller must hold the logger mutex. nextWriteSize is the size of the pending log line.
+// If the new log file cannot be opened, keep writing to the current one and retry later
+func (l *Logger) validateAndUpdateLogFilepath(nextWriteSize int64) {
 	expectedCurrentLogFilepath := l.getLogFilepath()
-	if l.CurrentLogFile != expectedCurrentLogFilepath {
-		//Change of month. Update to a new log file
-		l.file.Close()
-		f, err := os.OpenFile(expectedCurrentLogFilepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
-		if err != nil {
-			log.Println("[Logger] Unable to create new log. Logging to file disabled.")
-			l.LogToFile = false
-			return
-		}
-		l.CurrentLogFile = expectedCurrentLogFilepath
-		l.file = f
+	periodChanged := l.currentPeriodLog != expectedCurrentLogFilepath
+	if !periodChanged && !l.requireSizeRotation(nextWriteSize) {
+		return
+	}
+
+	if !l.openRetry.due(time.Now()) {
+		//Still backing off from the previous failure
+		return
+	}
+
+	var err error
+	if periodChanged {
+		//Change of period (or rotation period). Update to a new log file
+		err = l.switchLogFile(expectedCurrentLogFilepath, 0)
+	} else {
+		//File size exceeded. Rotate to the next file of the period
+		err = l.switchLogFile(expectedCurrentLogFilepath, l.currentSuffix+1)
+	}
+	l.recordOpenResult(err)
+}
+
+// Flush wait for all pending async writes and commit the log file to disk
+// Call this before os.Exit to make sure the final messages are not lost
+func (l *Logger) Flush() error {
+	l.pendingWrites.Wait()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.errorFile != nil {
+		l.errorFile.Sync()
 	}
+	if l.file != nil && l.LogToFile {
+		return l.file.Sync()
+	}
+	return nil
 }
 
+// Close drain the queued entries and close the log files
 func (l *Logger) Close() {
-	l.file.Close()
+	l.flushSampleSummaries()
+	l.stopWriter()
+	l.pendingWrites.Wait()
+	l.closeSinks()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	if l.errorFile != nil {
+		l.errorFile.Close()
+	}
+
+	//Wait for the rotated log files to finish compressing
+	l.compressing.Wait()
 }
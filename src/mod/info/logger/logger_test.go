@@ -0,0 +1,804 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentPrintAndLog(t *testing.T) {
+	//Suppress the STDOUT output during test
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	// Fire thousands of concurrent log requests
+	totalLines := 5000
+	var wg sync.WaitGroup
+	for i := 0; i < totalLines; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			logger.PrintAndLog("Test", "concurrent log line "+strconv.Itoa(id), nil)
+		}(i)
+	}
+	wg.Wait()
+
+	// PrintAndLog writes asynchronously, wait until all lines are written
+	lines := []string{}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		lines = readLines(t, logger.CurrentLogFile)
+		if len(lines) >= totalLines {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if len(lines) != totalLines {
+		t.Fatalf("Expected %d lines, got %d", totalLines, len(lines))
+	}
+
+	// Every line must be well-formed
+	lineFormat := regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{6}\|Test {12} \[INFO\]concurrent log line \d+$`)
+	for _, line := range lines {
+		if !lineFormat.MatchString(line) {
+			t.Fatalf("Malformed log line: %q", line)
+		}
+	}
+}
+
+func TestSizeBasedRotation(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+	logger.MaxFileSizeBytes = 512
+
+	monthLog := logger.getLogFilepath()
+	for i := 0; i < 50; i++ {
+		logger.Log("Test", "rotation log line "+strconv.Itoa(i), nil)
+	}
+
+	if logger.CurrentLogFile == monthLog {
+		t.Fatalf("Expected log to be rotated, still writing to %s", monthLog)
+	}
+
+	//Every file of the month must be within the size limit and no line is lost
+	totalLines := 0
+	for suffix := 0; suffix <= logger.currentSuffix; suffix++ {
+		filename := getRotatedLogFilepath(monthLog, suffix)
+		st, err := os.Stat(filename)
+		if err != nil {
+			t.Fatalf("Missing rotated log file %s: %v", filename, err)
+		}
+		if st.Size() > logger.MaxFileSizeBytes {
+			t.Fatalf("Log file %s exceed size limit: %d bytes", filename, st.Size())
+		}
+		totalLines += len(readLines(t, filename))
+	}
+
+	if totalLines != 50 {
+		t.Fatalf("Expected 50 lines, got %d", totalLines)
+	}
+}
+
+func TestGetRotatedLogFilepath(t *testing.T) {
+	if got := getRotatedLogFilepath("system_2024-1.log", 0); got != "system_2024-1.log" {
+		t.Errorf("Unexpected filename for suffix 0: %s", got)
+	}
+	if got := getRotatedLogFilepath("system_2024-1.log", 2); got != "system_2024-1.2.log" {
+		t.Errorf("Unexpected filename for suffix 2: %s", got)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	line := formatLogLine(FormatJSON, ts, LevelError, "Test", "something failed", errors.New("disk full"))
+	expected := `{"ts":"2024-01-02T03:04:05.123456Z","title":"Test","level":"ERROR","message":"something failed","error":"disk full"}` + "\n"
+	if line != expected {
+		t.Errorf("Unexpected JSON log line: %s", line)
+	}
+
+	//Error field should be omitted when nil
+	line = formatLogLine(FormatJSON, ts, LevelInfo, "Test", "hello", nil)
+	expected = `{"ts":"2024-01-02T03:04:05.123456Z","title":"Test","level":"INFO","message":"hello"}` + "\n"
+	if line != expected {
+		t.Errorf("Unexpected JSON log line: %s", line)
+	}
+}
+
+func TestParseLineRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.Local)
+	for _, format := range []LogFormat{FormatText, FormatJSON} {
+		//Info line without error
+		entry, err := ParseLine(formatLogLine(format, ts, LevelInfo, "System", "hello [world]", nil))
+		if err != nil {
+			t.Fatalf("Failed to parse info line in format %d: %v", format, err)
+		}
+		if !entry.Timestamp.Equal(ts) || entry.Title != "System" || entry.Level != "INFO" || entry.Message != "hello [world]" || entry.Error != "" {
+			t.Errorf("Unexpected info entry in format %d: %+v", format, entry)
+		}
+
+		//Error line with the original error appended
+		entry, err = ParseLine(formatLogLine(format, ts, LevelError, "A very long module title", "write failed", errors.New("disk full")))
+		if err != nil {
+			t.Fatalf("Failed to parse error line in format %d: %v", format, err)
+		}
+		if !entry.Timestamp.Equal(ts) || entry.Title != "A very long module title" || entry.Level != "ERROR" {
+			t.Errorf("Unexpected error entry in format %d: %+v", format, entry)
+		}
+		if format == FormatJSON && (entry.Message != "write failed" || entry.Error != "disk full") {
+			t.Errorf("Unexpected JSON error entry: %+v", entry)
+		}
+		if format == FormatText && entry.Message != "write failed disk full" {
+			t.Errorf("Unexpected text error entry: %+v", entry)
+		}
+	}
+
+	for _, line := range []string{"", "not a log line", "2024-01-02 03:04:05|System [INFO]missing microseconds", "{\"ts\":\"yesterday\"}"} {
+		if _, err := ParseLine(line); err == nil {
+			t.Errorf("Expected malformed line to be rejected: %q", line)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	logger.Log("Storage", "mounted disk", nil)
+	logger.Log("Storage", "unable to mount disk", errors.New("device busy"))
+	logger.Format = FormatJSON
+	logger.Log("Network", "interface up", nil)
+
+	//Title filter, with error line appended at the end
+	results, err := logger.Query(LogQuery{Title: "storage"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(results))
+	}
+	if results[1].Level != "ERROR" || results[1].Message != "unable to mount disk device busy" {
+		t.Errorf("Unexpected error entry: %+v", results[1])
+	}
+
+	//Level filter across text and JSON lines
+	results, _ = logger.Query(LogQuery{Levels: []LogLevel{LevelInfo}})
+	if len(results) != 2 || results[1].Title != "Network" {
+		t.Errorf("Unexpected level filtered results: %+v", results)
+	}
+
+	//Time range filter
+	results, _ = logger.Query(LogQuery{EndTime: time.Now().Add(-time.Hour)})
+	if len(results) != 0 {
+		t.Errorf("Expected no entries before an hour ago, got %d", len(results))
+	}
+}
+
+func readLines(t *testing.T, filename string) []string {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestCleanupOldLogs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logFolder := t.TempDir()
+	logger, err := NewLogger("test", logFolder, true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	now := time.Now()
+	monthFilename := func(prefix string, monthsAgo int, suffix string) string {
+		t := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local).AddDate(0, -monthsAgo, 0)
+		return prefix + "_" + strconv.Itoa(t.Year()) + "-" + strconv.Itoa(int(t.Month())) + suffix + ".log"
+	}
+
+	kept := []string{monthFilename("test", 1, ""), monthFilename("other", 5, ""), "notes.txt"}
+	removed := []string{monthFilename("test", 2, ""), monthFilename("test", 14, ".1")}
+	for _, filename := range append(append([]string{}, kept...), removed...) {
+		os.WriteFile(filepath.Join(logFolder, filename), []byte("log\n"), 0775)
+	}
+
+	//Disabled retention should not remove anything
+	logger.cleanupLogsBefore(now)
+	for _, filename := range removed {
+		if _, err := os.Stat(filepath.Join(logFolder, filename)); err != nil {
+			t.Errorf("Expected %s to be kept when retention is disabled", filename)
+		}
+	}
+
+	logger.RetentionMonths = 2
+	err = logger.cleanupLogsBefore(now)
+	if err != nil {
+		t.Fatalf("Cleanup failed: %v", err)
+	}
+
+	for _, filename := range append(kept, filepath.Base(logger.CurrentLogFile)) {
+		if _, err := os.Stat(filepath.Join(logFolder, filename)); err != nil {
+			t.Errorf("Expected %s to be kept", filename)
+		}
+	}
+	for _, filename := range removed {
+		if _, err := os.Stat(filepath.Join(logFolder, filename)); err == nil {
+			t.Errorf("Expected %s to be removed", filename)
+		}
+	}
+}
+
+func TestCompressRotated(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	logger.MaxFileSizeBytes = 512
+	logger.CompressRotated = true
+
+	monthLog := logger.getLogFilepath()
+	for i := 0; i < 50; i++ {
+		logger.Log("Test", "compressed log line "+strconv.Itoa(i), nil)
+	}
+
+	//Wait for the background compression to finish
+	logger.mutex.Lock()
+	lastSuffix := logger.currentSuffix
+	logger.mutex.Unlock()
+	logger.compressing.Wait()
+
+	for suffix := 0; suffix < lastSuffix; suffix++ {
+		filename := getRotatedLogFilepath(monthLog, suffix)
+		if _, err := os.Stat(filename + ".gz"); err != nil {
+			t.Errorf("Expected %s to be compressed", filename)
+		}
+		if _, err := os.Stat(filename); err == nil {
+			t.Errorf("Expected plain file %s to be removed", filename)
+		}
+	}
+
+	//Query should read both compressed and plain files
+	entries, err := logger.Query(LogQuery{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(entries) != 50 {
+		t.Fatalf("Expected 50 entries, got %d", len(entries))
+	}
+	if entries[0].Message != "compressed log line 0" || entries[49].Message != "compressed log line 49" {
+		t.Errorf("Unexpected entry order: %q ... %q", entries[0].Message, entries[49].Message)
+	}
+	logger.Close()
+}
+
+func TestRingBufferTail(t *testing.T) {
+	buffer := NewRingBuffer(3)
+	if len(buffer.Tail(10)) != 0 {
+		t.Fatal("Expected empty buffer")
+	}
+
+	for i := 0; i < 5; i++ {
+		buffer.WriteLog(time.Now(), LevelInfo, "Test", strconv.Itoa(i), nil)
+	}
+
+	entries := buffer.Tail(0)
+	if len(entries) != 3 || entries[0].Message != "2" || entries[2].Message != "4" {
+		t.Fatalf("Expected the latest 3 entries in order, got %v", entries)
+	}
+	entries = buffer.Tail(2)
+	if len(entries) != 2 || entries[0].Message != "3" || entries[1].Message != "4" {
+		t.Fatalf("Expected the latest 2 entries in order, got %v", entries)
+	}
+
+	//Ring buffer works without logging to file
+	logger, _ := NewTmpLogger()
+	logger.Log("Test", "hello", errors.New("oops"))
+	entries = logger.Tail(1)
+	if len(entries) != 1 || entries[0].Message != "hello" || entries[0].Error != "oops" || entries[0].Level != "ERROR" {
+		t.Fatalf("Expected entry in logger ring buffer, got %v", entries)
+	}
+}
+
+func TestLogHooks(t *testing.T) {
+	logger, _ := NewTmpLogger()
+	received := make(chan LogEntry, 10)
+	hookID := logger.AddHook(func(entry LogEntry) {
+		if entry.Level == "ERROR" {
+			received <- entry
+		}
+	})
+
+	//A panicking hook should not affect other hooks
+	logger.AddHook(func(entry LogEntry) {
+		panic("faulty hook")
+	})
+
+	logger.Log("Test", "ok", nil)
+	logger.Log("Disk", "write failed", errors.New("disk full"))
+	select {
+	case entry := <-received:
+		if entry.Title != "Disk" || entry.Error != "disk full" {
+			t.Errorf("Unexpected entry passed to hook: %v", entry)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected hook to be invoked")
+	}
+
+	logger.RemoveHook(hookID)
+	logger.Log("Disk", "write failed again", errors.New("disk full"))
+	select {
+	case entry := <-received:
+		t.Errorf("Expected removed hook not to be invoked, got %v", entry)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestFlushAndSynchronous(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	//Async writes must all be on disk after Flush
+	for i := 0; i < 100; i++ {
+		logger.PrintAndLog("Test", "async line "+strconv.Itoa(i), nil)
+	}
+	if err := logger.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if lines := readLines(t, logger.CurrentLogFile); len(lines) != 100 {
+		t.Fatalf("Expected 100 lines after flush, got %d", len(lines))
+	}
+
+	//Synchronous writes must be on disk right after the call
+	logger.Synchronous = true
+	logger.PrintAndLog("Test", "sync line", nil)
+	lines := readLines(t, logger.CurrentLogFile)
+	if len(lines) != 101 || !regexp.MustCompile(`sync line$`).MatchString(lines[100]) {
+		t.Fatalf("Synchronous line not written in order, got %d lines", len(lines))
+	}
+}
+
+func TestSeparateErrorLog(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logFolder := t.TempDir()
+	logger, err := NewLogger("test", logFolder, true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+	logger.Synchronous = true
+	logger.SeparateErrorLog = true
+
+	logger.PrintAndLog("Test", "info line", nil)
+	logger.PrintAndLog("Test", "error line", errors.New("test error"))
+	logger.Warn("Test", "warn line", nil)
+
+	if lines := readLines(t, logger.CurrentLogFile); len(lines) != 3 {
+		t.Fatalf("Expected 3 lines in main log, got %d", len(lines))
+	}
+	if logger.CurrentErrorLog != logger.getErrorLogFilepath() {
+		t.Fatalf("Unexpected error log path: %s", logger.CurrentErrorLog)
+	}
+	lines := readLines(t, logger.CurrentErrorLog)
+	if len(lines) != 1 || !regexp.MustCompile(`error line`).MatchString(lines[0]) {
+		t.Fatalf("Expected only the error line in error log, got %v", lines)
+	}
+
+	//Error logs are not listed as the main log files
+	logFiles, _ := logger.listLogFiles()
+	if len(logFiles) != 1 {
+		t.Errorf("Expected 1 main log file, got %d", len(logFiles))
+	}
+}
+
+// Sink that block until released, for filling up the writer queue
+type blockingSink struct {
+	release chan bool
+}
+
+func (s *blockingSink) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+	<-s.release
+}
+
+func TestAsyncWriterOrderAndBackpressure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	//Entries are written in order and drained on Close
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	logger.QueueSize = 8
+	for i := 0; i < 500; i++ {
+		logger.PrintAndLog("Test", "line "+strconv.Itoa(i), nil)
+	}
+	logger.Close()
+	lines := readLines(t, logger.CurrentLogFile)
+	if len(lines) != 500 {
+		t.Fatalf("Expected 500 lines after close, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if !regexp.MustCompile(`line ` + strconv.Itoa(i) + `$`).MatchString(line) {
+			t.Fatalf("Line %d out of order: %s", i, line)
+		}
+	}
+	if logger.DroppedEntries() != 0 {
+		t.Errorf("Expected no dropped entries in blocking mode, got %d", logger.DroppedEntries())
+	}
+
+	//Entries are dropped and counted when the queue is full
+	logger, err = NewLogger("test", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	sink := &blockingSink{release: make(chan bool)}
+	logger.AddSink(sink)
+	logger.QueueSize = 4
+	logger.DropWhenFull = true
+	for i := 0; i < 20; i++ {
+		logger.PrintAndLog("Test", "line "+strconv.Itoa(i), nil)
+	}
+	if logger.DroppedEntries() == 0 {
+		t.Error("Expected entries to be dropped when the queue is full")
+	}
+	close(sink.release)
+	logger.Close()
+}
+
+func TestSyslogSink(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Unable to listen on UDP: %v", err)
+	}
+	defer conn.Close()
+
+	sink, err := NewSyslogSink("udp", conn.LocalAddr().String(), "arozos")
+	if err != nil {
+		t.Skipf("Syslog not supported: %v", err)
+	}
+
+	logger, err := NewLogger("test", "", false)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	logger.Synchronous = true
+	logger.AddSink(sink)
+	logger.LogWithLevel(LevelError, "Test", "disk failure", errors.New("io error"))
+	logger.Close()
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to receive syslog message: %v", err)
+	}
+
+	//Priority of LOG_DAEMON | LOG_ERR is 3*8+3
+	message := string(buf[:n])
+	if !strings.HasPrefix(message, "<27>") || !strings.Contains(message, "arozos") || !strings.HasSuffix(strings.TrimSpace(message), "[Test] disk failure io error") {
+		t.Errorf("Unexpected syslog message: %s", message)
+	}
+}
+
+func BenchmarkPrintAndLog(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("bench", b.TempDir(), true)
+	if err != nil {
+		b.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	peakGoroutines := runtime.NumGoroutine()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.PrintAndLog("Bench", "benchmark line", nil)
+		if i%100 == 0 {
+			if n := runtime.NumGoroutine(); n > peakGoroutines {
+				peakGoroutines = n
+			}
+		}
+	}
+	logger.Flush()
+	b.ReportMetric(float64(peakGoroutines), "peak-goroutines")
+}
+
+func TestLogFileOpenRetry(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+	logger.Synchronous = true
+	previousLogFile := logger.CurrentLogFile
+
+	//Simulate a transient disk full when switching to the log file of the next month
+	openAttempts := 0
+	openLogFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		openAttempts++
+		return nil, errors.New("no space left on device")
+	}
+	defer func() { openLogFile = os.OpenFile }()
+	logger.currentPeriodLog = "previous_month.log"
+
+	logger.PrintAndLog("Test", "line during failure", nil)
+	logger.PrintAndLog("Test", "line during backoff", nil)
+	if !logger.LogToFile || openAttempts != 1 {
+		t.Fatalf("Expected logging to file kept enabled with one open attempt, got %v and %d", logger.LogToFile, openAttempts)
+	}
+	if lines := readLines(t, previousLogFile); len(lines) != 2 {
+		t.Fatalf("Expected lines kept writing to the current log file, got %d lines", len(lines))
+	}
+
+	//Recover after the backoff
+	openLogFile = os.OpenFile
+	logger.openRetry.nextRetry = time.Now()
+	logger.PrintAndLog("Test", "line after recovery", nil)
+	if logger.CurrentLogFile != logger.getLogFilepath() || logger.currentPeriodLog != logger.getLogFilepath() {
+		t.Fatalf("Expected log file switched after recovery, got %s", logger.CurrentLogFile)
+	}
+	if logger.openRetry.failures != 0 {
+		t.Errorf("Expected retry state reset after recovery, got %d failures", logger.openRetry.failures)
+	}
+
+	//Backoff grows with consecutive failures
+	logger.openRetry = openRetry{}
+	logger.recordOpenResult(errors.New("failed"))
+	logger.recordOpenResult(errors.New("failed"))
+	if delay := time.Until(logger.openRetry.nextRetry); delay <= openRetryMinDelay || delay > 2*openRetryMinDelay {
+		t.Errorf("Expected backoff to double after the second failure, got %v", delay)
+	}
+}
+
+func TestLogSampling(t *testing.T) {
+	if _, err := ParseSamplingRules("WebDAV=100"); err == nil {
+		t.Error("Expected error for sampling rule without window")
+	}
+	rules, err := ParseSamplingRules("Disk=2/200ms, Disk/IO=5/1m")
+	if err != nil || len(rules) != 2 || rules[1].TitlePrefix != "Disk/IO" || rules[1].Window != time.Minute {
+		t.Fatalf("Unexpected sampling rules parsed: %v (%v)", rules, err)
+	}
+
+	logger, _ := NewTmpLogger()
+	logger.SetSamplingRules(rules[:1])
+	for i := 0; i < 10; i++ {
+		logger.Log("Disk", "write failed "+strconv.Itoa(i), errors.New("disk full"))
+		logger.Log("Network", "ok "+strconv.Itoa(i), nil)
+	}
+
+	countTitle := func(title string) int {
+		count := 0
+		for _, entry := range logger.Tail(0) {
+			if entry.Title == title {
+				count++
+			}
+		}
+		return count
+	}
+	if countTitle("Disk") != 2 {
+		t.Fatalf("Expected 2 Disk entries within the window, got %d", countTitle("Disk"))
+	}
+	if countTitle("Network") != 10 {
+		t.Fatalf("Expected titles without rule not to be sampled, got %d", countTitle("Network"))
+	}
+
+	//Summary is written at the end of the window
+	time.Sleep(400 * time.Millisecond)
+	entries := logger.Tail(1)
+	if len(entries) != 1 || entries[0].Message != "8 messages suppressed in the last 200ms" || entries[0].Level != "ERROR" {
+		t.Fatalf("Expected suppressed summary, got %v", entries)
+	}
+
+	//New window after the summary
+	logger.Log("Disk", "write failed again", errors.New("disk full"))
+	if entries := logger.Tail(1); len(entries) != 1 || entries[0].Message != "write failed again" {
+		t.Fatalf("Expected entry logged in the new window, got %v", entries)
+	}
+}
+
+type panicSink struct{}
+
+func (s *panicSink) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+	panic("sink failed")
+}
+
+func TestLogSinkPanicRecovery(t *testing.T) {
+	var output strings.Builder
+	var outputMutex sync.Mutex
+	log.SetOutput(writerFunc(func(p []byte) (int, error) {
+		outputMutex.Lock()
+		defer outputMutex.Unlock()
+		return output.Write(p)
+	}))
+	defer log.SetOutput(os.Stderr)
+
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	//Close the log file behind the logger and drop it, as if a race closed it
+	logger.mutex.Lock()
+	logger.file.Close()
+	logger.mutex.Unlock()
+	logger.PrintAndLog("Test", "after close", nil)
+	logger.mutex.Lock()
+	logger.file = nil
+	logger.mutex.Unlock()
+	logger.PrintAndLog("Test", "after nil", nil)
+
+	//A panicking sink must not take down the background writer
+	logger.AddSink(&panicSink{})
+	logger.PrintAndLog("Test", "sink panic", nil)
+	logger.PrintAndLog("Test", "still alive", nil)
+	logger.Flush()
+	logger.Log("Test", "sync path", nil)
+
+	entries := logger.Tail(0)
+	if len(entries) != 5 || entries[4].Message != "sync path" {
+		t.Errorf("Expected all entries to reach the ring buffer, got %v", entries)
+	}
+
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	if !strings.Contains(output.String(), "Log sink panic recovered") || !strings.Contains(output.String(), "[Test] still alive") {
+		t.Errorf("Expected recovered panic and fallback output, got %q", output.String())
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestRotationPeriod(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("Timezone database not available")
+	}
+
+	//The day before the DST change is only 23 hours long
+	beforeMidnight := time.Date(2024, 3, 10, 23, 30, 0, 0, newYork)
+	afterMidnight := time.Date(2024, 3, 11, 0, 10, 0, 0, newYork)
+	dayStart := time.Date(2024, 3, 10, 0, 10, 0, 0, newYork)
+	if RotateDaily.periodName(beforeMidnight) != "2024-03-10" || RotateDaily.periodName(dayStart) != "2024-03-10" {
+		t.Errorf("Expected the same day across the DST change, got %s", RotateDaily.periodName(beforeMidnight))
+	}
+	if RotateDaily.periodName(afterMidnight) != "2024-03-11" {
+		t.Errorf("Expected day change at local midnight, got %s", RotateDaily.periodName(afterMidnight))
+	}
+
+	//The same instant belongs to different days in different timezones
+	if RotateDaily.periodName(afterMidnight.In(time.FixedZone("UTC-8", -8*3600))) != "2024-03-10" {
+		t.Error("Expected the day of the local timezone")
+	}
+	if RotateWeekly.periodName(time.Date(2024, 12, 30, 12, 0, 0, 0, time.Local)) != "2025-W01" || RotateMonthly.periodName(dayStart) != "2024-3" {
+		t.Error("Unexpected weekly or monthly period name")
+	}
+
+	start, end := periodRange(2025, 0, 1, 0)
+	if !start.Equal(time.Date(2024, 12, 30, 0, 0, 0, 0, time.Local)) || !end.Equal(time.Date(2025, 1, 6, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("Unexpected range of ISO week, got %v to %v", start, end)
+	}
+
+	//Switching to daily rotation changes the log file on the next write
+	logger, err := NewLogger("test", t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+	logger.Synchronous = true
+	logger.RotationPeriod = RotateDaily
+	logger.Log("Test", "daily entry", nil)
+	if filepath.Base(logger.CurrentLogFile) != "test_"+time.Now().Format("2006-01-02")+".log" {
+		t.Fatalf("Unexpected daily log file %s", logger.CurrentLogFile)
+	}
+
+	//Daily files are listed together with the monthly ones
+	results, _ := logger.Query(LogQuery{StartTime: time.Now().Add(-time.Hour)})
+	if len(results) != 1 || results[0].Message != "daily entry" {
+		t.Errorf("Expected the daily entry to be queried, got %+v", results)
+	}
+	logFiles, _ := logger.listLogFiles()
+	if len(logFiles) != 1 || logFiles[0].Filepath != logger.CurrentLogFile {
+		t.Errorf("Expected the empty monthly file removed and the daily file listed, got %d files", len(logFiles))
+	}
+	if start, end := logFiles[0].Start, logFiles[0].End; time.Now().Before(start) || !time.Now().Before(end) {
+		t.Errorf("Unexpected range of the daily file, got %v to %v", start, end)
+	}
+}
+
+func TestLogWithFields(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	fields := map[string]string{"user": "alice", "request_id": "1a2b"}
+	line := formatLogLineWithFields(FormatText, ts, LevelInfo, "Test", "hello", nil, fields)
+	if !strings.HasSuffix(line, "[INFO]hello request_id=1a2b user=alice\n") {
+		t.Errorf("Unexpected text log line with fields: %s", line)
+	}
+	line = formatLogLineWithFields(FormatJSON, ts, LevelInfo, "Test", "hello", nil, fields)
+	entry, err := ParseLine(line)
+	if err != nil || entry.Fields["request_id"] != "1a2b" || entry.Fields["user"] != "alice" {
+		t.Errorf("Unexpected JSON log line with fields: %s", line)
+	}
+
+	//Child loggers keep the parent fields
+	logger, _ := NewTmpLogger()
+	logger.Synchronous = true
+	requestLogger := logger.WithFields(map[string]string{"request_id": "1a2b"}).WithFields(map[string]string{"user": "bob"})
+	requestLogger.Info("Test", "child", nil)
+	entries := logger.Tail(1)
+	if len(entries) != 1 || entries[0].Message != "child" || entries[0].Fields["request_id"] != "1a2b" || entries[0].Fields["user"] != "bob" {
+		t.Fatalf("Expected fields of child logger in ring buffer, got %v", entries)
+	}
+
+	//Request ID is reused for the same request, forged IDs are replaced
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(RequestIDHeader, "abc\ninjected")
+	requestID := logger.WithRequest(r).Fields()["request_id"]
+	if requestID == "abc\ninjected" || logger.WithRequest(r).Fields()["request_id"] != requestID {
+		t.Errorf("Expected generated request ID reused for the request, got %q", requestID)
+	}
+}
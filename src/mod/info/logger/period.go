@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Rotation Period
+
+	Log files are rotated by the calendar period of the local time, with the
+	period written in the filename as
+
+	Monthly (default)  {prefix}_{year}-{month}.log           e.g. system_2024-1.log
+	Weekly (ISO week)  {prefix}_{iso year}-W{week}.log       e.g. system_2024-W03.log
+	Daily              {prefix}_{year}-{month}-{day}.log     e.g. system_2024-01-15.log
+
+	The period change is detected by comparing the period name of the current
+	time with the one of the opened file, instead of counting elapsed hours,
+	so days shorter or longer than 24 hours (DST changes) and changes of the
+	host timezone switch the file exactly at the local midnight.
+*/
+
+type RotationPeriod int
+
+const (
+	RotateMonthly RotationPeriod = iota
+	RotateWeekly
+	RotateDaily
+)
+
+func (p RotationPeriod) String() string {
+	switch p {
+	case RotateWeekly:
+		return "weekly"
+	case RotateDaily:
+		return "daily"
+	}
+	return "monthly"
+}
+
+// Parse the rotation period from its text representation, e.g. "daily"
+func ParseRotationPeriod(period string) (RotationPeriod, error) {
+	switch strings.ToLower(strings.TrimSpace(period)) {
+	case "monthly":
+		return RotateMonthly, nil
+	case "weekly":
+		return RotateWeekly, nil
+	case "daily":
+		return RotateDaily, nil
+	}
+	return RotateMonthly, errors.New("invalid rotation period given")
+}
+
+// Get the name of the period containing the given time, used in the log filename
+func (p RotationPeriod) periodName(t time.Time) string {
+	switch p {
+	case RotateWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case RotateDaily:
+		year, month, day := t.Date()
+		return fmt.Sprintf("%d-%02d-%02d", year, int(month), day)
+	}
+	year, month, _ := t.Date()
+	return strconv.Itoa(year) + "-" + strconv.Itoa(int(month))
+}
+
+// Get the local time range [start, end) of the period written in a log filename
+func periodRange(year int, month int, week int, day int) (time.Time, time.Time) {
+	if week > 0 {
+		//4th of January is always in the first ISO week
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.Local)
+		mondayOffset := (int(jan4.Weekday()) + 6) % 7
+		start := time.Date(year, time.January, 4-mondayOffset+(week-1)*7, 0, 0, 0, 0, time.Local)
+		return start, start.AddDate(0, 0, 7)
+	}
+	if day > 0 {
+		start := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+	return start, start.AddDate(0, 1, 0)
+}
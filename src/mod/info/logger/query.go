@@ -0,0 +1,250 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Log Query
+
+	This script parse the log files written by the logger
+	and allow searching them by title, level and time range
+*/
+
+type LogQuery struct {
+	Title     string     //Substring of the title to match, case insensitive. Empty for all
+	Levels    []LogLevel //Levels to include, empty for all
+	StartTime time.Time  //Entries before this time are excluded, zero for no lower bound
+	EndTime   time.Time  //Entries after this time are excluded, zero for no upper bound
+	Limit     int        //Max number of entries returned (latest ones), 0 for no limit
+}
+
+// Matching {prefix}_{year}-{month}(-{day})(.{suffix}).log(.gz) or {prefix}_{year}-W{week}(.{suffix}).log(.gz), see period.go
+var logFilenameRegex = regexp.MustCompile(`^(.*)_(\d{4})-(?:(\d{1,2})(?:-(\d{2}))?|W(\d{2}))(?:\.(\d+))?\.log(\.gz)?$`)
+
+type logFileInfo struct {
+	Filepath string
+	Start    time.Time //Start of the period covered by this file
+	End      time.Time //End of the period covered by this file, exclusive
+	Suffix   int
+}
+
+// Query the log files of this logger, entries are returned in chronological order
+func (l *Logger) Query(filter LogQuery) ([]LogEntry, error) {
+	logFiles, err := l.listLogFiles()
+	if err != nil {
+		return []LogEntry{}, err
+	}
+
+	results := []LogEntry{}
+	for _, logFile := range logFiles {
+		if !periodInRange(logFile.Start, logFile.End, filter.StartTime, filter.EndTime) {
+			continue
+		}
+
+		entries, err := parseLogFile(logFile.Filepath)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if filter.match(entry) {
+				results = append(results, entry)
+			}
+		}
+	}
+
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		results = results[len(results)-filter.Limit:]
+	}
+	return results, nil
+}
+
+// Handle query of the logs. Accept GET title, level (comma seperated), start, end (unix timestamp) and limit
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (l *Logger) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	filter := LogQuery{
+		Limit: 1000,
+	}
+
+	filter.Title, _ = utils.GetPara(r, "title")
+
+	levels, err := utils.GetPara(r, "level")
+	if err == nil && levels != "" {
+		for _, level := range strings.Split(levels, ",") {
+			thisLevel, err := ParseLogLevel(level)
+			if err != nil {
+				utils.SendErrorResponse(w, "invalid level given")
+				return
+			}
+			filter.Levels = append(filter.Levels, thisLevel)
+		}
+	}
+
+	for _, key := range []string{"start", "end", "limit"} {
+		value, err := utils.GetPara(r, key)
+		if err != nil || value == "" {
+			continue
+		}
+		valueInt, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			utils.SendErrorResponse(w, "invalid "+key+" given")
+			return
+		}
+		switch key {
+		case "start":
+			filter.StartTime = time.Unix(valueInt, 0)
+		case "end":
+			filter.EndTime = time.Unix(valueInt, 0)
+		case "limit":
+			filter.Limit = int(valueInt)
+		}
+	}
+
+	results, err := l.Query(filter)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(results)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Check if the entry match the query filter
+func (q *LogQuery) match(entry LogEntry) bool {
+	if q.Title != "" && !strings.Contains(strings.ToLower(entry.Title), strings.ToLower(q.Title)) {
+		return false
+	}
+
+	if len(q.Levels) > 0 {
+		levelMatched := false
+		for _, level := range q.Levels {
+			if level.String() == entry.Level {
+				levelMatched = true
+				break
+			}
+		}
+		if !levelMatched {
+			return false
+		}
+	}
+
+	if !q.StartTime.IsZero() && entry.Timestamp.Before(q.StartTime) {
+		return false
+	}
+
+	if !q.EndTime.IsZero() && entry.Timestamp.After(q.EndTime) {
+		return false
+	}
+	return true
+}
+
+// List the log files of this logger, sorted by time
+func (l *Logger) listLogFiles() ([]*logFileInfo, error) {
+	return l.listLogFilesWithPrefix(l.Prefix)
+}
+
+func (l *Logger) listLogFilesWithPrefix(prefix string) ([]*logFileInfo, error) {
+	files, err := os.ReadDir(l.LogFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*logFileInfo{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		matches := logFilenameRegex.FindStringSubmatch(file.Name())
+		if matches == nil || matches[1] != prefix {
+			continue
+		}
+		if matches[7] != "" && utils.FileExists(filepath.Join(l.LogFolder, strings.TrimSuffix(file.Name(), ".gz"))) {
+			//Compression of this file is not finished yet. Use the plain text one
+			continue
+		}
+		year, _ := strconv.Atoi(matches[2])
+		month, _ := strconv.Atoi(matches[3])
+		day, _ := strconv.Atoi(matches[4])
+		week, _ := strconv.Atoi(matches[5])
+		suffix, _ := strconv.Atoi(matches[6])
+		start, end := periodRange(year, month, week, day)
+		results = append(results, &logFileInfo{
+			Filepath: filepath.Join(l.LogFolder, file.Name()),
+			Start:    start,
+			End:      end,
+			Suffix:   suffix,
+		})
+	}
+
+	sortLogFiles(results)
+	return results, nil
+}
+
+// Sort the log files by time. Files of different rotation periods (e.g. after changing the period) are ordered by their start
+func sortLogFiles(logFiles []*logFileInfo) {
+	sort.SliceStable(logFiles, func(i, j int) bool {
+		if !logFiles[i].Start.Equal(logFiles[j].Start) {
+			return logFiles[i].Start.Before(logFiles[j].Start)
+		}
+		if !logFiles[i].End.Equal(logFiles[j].End) {
+			return logFiles[i].End.Before(logFiles[j].End)
+		}
+		return logFiles[i].Suffix < logFiles[j].Suffix
+	})
+}
+
+// Check if the given period overlap with the time range
+func periodInRange(periodStart time.Time, periodEnd time.Time, startTime time.Time, endTime time.Time) bool {
+	if !startTime.IsZero() && !periodEnd.After(startTime) {
+		return false
+	}
+	if !endTime.IsZero() && periodStart.After(endTime) {
+		return false
+	}
+	return true
+}
+
+// Parse all the valid log lines in the given file
+func parseLogFile(filename string) ([]LogEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(filename, ".gz") {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		reader = gr
+	}
+
+	results := []LogEntry{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := ParseLine(scanner.Text())
+		if err == nil {
+			results = append(results, entry)
+		}
+	}
+	return results, scanner.Err()
+}
@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+	Log Retention
+
+	This script remove the log files that are older than
+	RetentionMonths. Only the files matching the logger's own
+	{Prefix}_{YYYY}-{M}.log pattern (or the weekly / daily ones, and
+	their rotated parts) and the {Prefix}_error_ error logs are removed.
+	A weekly file is kept until the month of its last day is expired.
+*/
+
+// Remove the log files older than the retention window. Do nothing if RetentionMonths is 0
+func (l *Logger) CleanupOldLogs() error {
+	return l.cleanupLogsBefore(time.Now())
+}
+
+func (l *Logger) cleanupLogsBefore(now time.Time) error {
+	if l.RetentionMonths <= 0 {
+		return nil
+	}
+
+	logFiles, err := l.listLogFiles()
+	if err != nil {
+		return err
+	}
+
+	//Error log files share the same retention window. See errorlog.go
+	errorLogFiles, err := l.listLogFilesWithPrefix(l.Prefix + "_error")
+	if err == nil {
+		logFiles = append(logFiles, errorLogFiles...)
+		sortLogFiles(logFiles)
+	}
+
+	l.mutex.Lock()
+	currentLogFile := filepath.Clean(l.CurrentLogFile)
+	currentErrorLog := filepath.Clean(l.CurrentErrorLog)
+	l.mutex.Unlock()
+
+	currentMonthIndex := now.Year()*12 + int(now.Month()) - 1
+	for _, logFile := range logFiles {
+		lastDay := logFile.End.AddDate(0, 0, -1)
+		fileMonthIndex := lastDay.Year()*12 + int(lastDay.Month()) - 1
+		if currentMonthIndex-fileMonthIndex < l.RetentionMonths {
+			//Still within the retention window
+			continue
+		}
+
+		if filepath.Clean(logFile.Filepath) == currentLogFile || filepath.Clean(logFile.Filepath) == currentErrorLog {
+			//Never remove the file that is being written
+			continue
+		}
+
+		err = os.Remove(logFile.Filepath)
+		if err != nil {
+			l.PrintAndLog("Logger", "Unable to remove expired log file "+filepath.Base(logFile.Filepath), err)
+			continue
+		}
+		l.PrintAndLog("Logger", "Removed expired log file "+filepath.Base(logFile.Filepath), nil)
+	}
+	return nil
+}
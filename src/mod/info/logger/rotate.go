@@ -0,0 +1,189 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Log Rotation
+
+	Log files are rotated monthly in the format of {prefix}_{year}-{month}.log,
+	or weekly / daily if RotationPeriod is set (see period.go).
+	If MaxFileSizeBytes is set, the log file of the period will further be
+	rotated when it exceed the given size, with a numeric suffix appended
+	before the extension, e.g.
+
+	system_2024-1.log   (first file of the month)
+	system_2024-1.1.log (second file of the month)
+	system_2024-1.2.log (third file of the month)
+
+	If CompressRotated is set, the previous file is gzip compressed in the
+	background after rotation, e.g. system_2024-1.log => system_2024-1.log.gz
+
+	If the new log file cannot be opened (e.g. disk full), the logger keep
+	writing to the current file and retry on the following writes with an
+	exponential backoff, starting from openRetryMinDelay up to openRetryMaxDelay
+*/
+
+// Log file opener, replaceable for testing
+var openLogFile = os.OpenFile
+
+// Backoff of retrying to open the log file after failure
+var (
+	openRetryMinDelay = time.Second
+	openRetryMaxDelay = 5 * time.Minute
+)
+
+type openRetry struct {
+	failures  int       //Number of consecutive failures
+	nextRetry time.Time //Time of the next open attempt
+}
+
+// Check if opening the log file can be attempted at the given time
+func (r *openRetry) due(now time.Time) bool {
+	return r.failures == 0 || !now.Before(r.nextRetry)
+}
+
+// Update the backoff with the result of opening the log file. Caller must hold the logger mutex
+func (l *Logger) recordOpenResult(err error) {
+	if err == nil {
+		if l.openRetry.failures > 0 {
+			log.Println("[Logger] Log file " + l.CurrentLogFile + " opened after " + strconv.Itoa(l.openRetry.failures) + " failed attempts")
+		}
+		l.openRetry = openRetry{}
+		return
+	}
+
+	delay := openRetryMinDelay
+	for i := 0; i < l.openRetry.failures && delay < openRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > openRetryMaxDelay {
+		delay = openRetryMaxDelay
+	}
+	l.openRetry.failures++
+	l.openRetry.nextRetry = time.Now().Add(delay)
+	log.Println("[Logger] Unable to open new log file: " + err.Error() + ". Retry in " + delay.String())
+}
+
+// Get the log filepath of the period with the given rotation suffix. Suffix 0 is the first file of the period
+func getRotatedLogFilepath(periodLogFilepath string, suffix int) string {
+	if suffix == 0 {
+		return periodLogFilepath
+	}
+	return strings.TrimSuffix(periodLogFilepath, ".log") + "." + strconv.Itoa(suffix) + ".log"
+}
+
+// Check if writing the next log line will exceed the max file size. Caller must hold the logger mutex
+func (l *Logger) requireSizeRotation(nextWriteSize int64) bool {
+	if l.MaxFileSizeBytes <= 0 || l.currentFileSize == 0 {
+		//Size rotation disabled or the file is empty (a single line larger than the limit)
+		return false
+	}
+	return l.currentFileSize+nextWriteSize > l.MaxFileSizeBytes
+}
+
+// Switch the current log file to the given period log with the first suffix that is not full. Caller must hold the logger mutex
+func (l *Logger) switchLogFile(periodLogFilepath string, suffix int) error {
+	if l.MaxFileSizeBytes > 0 {
+		//Skip the rotated files that are already full (e.g. after restart)
+		for {
+			rotatedLogFilepath := getRotatedLogFilepath(periodLogFilepath, suffix)
+			if _, err := os.Stat(rotatedLogFilepath + ".gz"); err == nil {
+				//This file is already rotated and compressed
+				suffix++
+				continue
+			}
+			st, err := os.Stat(rotatedLogFilepath)
+			if err != nil || st.Size() < l.MaxFileSizeBytes {
+				break
+			}
+			suffix++
+		}
+	}
+
+	logFilepath := getRotatedLogFilepath(periodLogFilepath, suffix)
+	f, err := openLogFile(logFilepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+
+	fileSize := int64(0)
+	if st, err := f.Stat(); err == nil {
+		fileSize = st.Size()
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+
+	if l.CurrentLogFile != "" && l.CurrentLogFile != logFilepath && l.currentFileSize == 0 {
+		//Nothing written to the previous file, e.g. opened by NewLogger before the RotationPeriod is set
+		os.Remove(l.CurrentLogFile)
+	} else if l.CurrentLogFile != "" && l.CurrentLogFile != logFilepath {
+		log.Println("[Logger] Log rotated to " + logFilepath)
+		if l.CompressRotated {
+			//Compress in background to keep the logging latency low
+			previousLogFilepath := l.CurrentLogFile
+			l.compressing.Add(1)
+			go func() {
+				defer l.compressing.Done()
+				err := compressLogFile(previousLogFilepath)
+				if err != nil {
+					log.Println("[Logger] Unable to compress rotated log " + previousLogFilepath + ": " + err.Error())
+				}
+			}()
+		}
+	}
+
+	l.file = f
+	l.CurrentLogFile = logFilepath
+	l.currentPeriodLog = periodLogFilepath
+	l.currentSuffix = suffix
+	l.currentFileSize = fileSize
+	return nil
+}
+
+// Gzip compress the given log file to {filename}.gz and remove the original file
+func compressLogFile(logFilepath string) error {
+	src, err := os.Open(logFilepath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	//Write to a temporary file first so a partially compressed file is never listed
+	tmpFilepath := logFilepath + ".gz.tmp"
+	dst, err := os.OpenFile(tmpFilepath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(dst)
+	_, err = io.Copy(gw, src)
+	if err == nil {
+		err = gw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFilepath)
+		return err
+	}
+
+	err = os.Rename(tmpFilepath, logFilepath+".gz")
+	if err != nil {
+		os.Remove(tmpFilepath)
+		return err
+	}
+
+	src.Close()
+	return os.Remove(logFilepath)
+}
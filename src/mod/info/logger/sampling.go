@@ -0,0 +1,181 @@
+package logger
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Log Sampling
+
+	Limit the number of entries logged per title during error storms.
+	Each sampling rule applies to the titles starting with its prefix.
+	Every title is counted separately: once a title logged MaxPerWindow
+	entries within the window, the rest of the entries in the window are
+	suppressed and a summary is logged with the same title at the end of
+	the window instead, e.g.
+
+	1234 messages suppressed in the last 1s
+
+	Sampling is disabled if no rules are set (default). Rules are given
+	in text as comma separated {prefix}={count}/{window}, e.g.
+
+	WebDAV=100/1s,Network=20/10s
+*/
+
+type SamplingRule struct {
+	TitlePrefix  string        //Apply to the titles starting with this prefix, empty for all titles
+	MaxPerWindow int           //Max number of entries logged per title within the window
+	Window       time.Duration //Length of the sampling window
+}
+
+type sampleState struct {
+	windowStart time.Time
+	count       int      //Number of entries seen in the current window
+	suppressed  int      //Number of entries suppressed in the current window
+	maxLevel    LogLevel //Highest level of the suppressed entries, used for the summary
+	summary     *time.Timer
+}
+
+type logSampler struct {
+	rules  []SamplingRule
+	states map[string]*sampleState
+	mutex  sync.Mutex
+}
+
+// Parse the sampling rules from text, e.g. "WebDAV=100/1s,Network=20/10s"
+func ParseSamplingRules(rules string) ([]SamplingRule, error) {
+	results := []SamplingRule{}
+	for _, thisRule := range strings.Split(rules, ",") {
+		thisRule = strings.TrimSpace(thisRule)
+		if thisRule == "" {
+			continue
+		}
+		prefix, limit, ok := strings.Cut(thisRule, "=")
+		if !ok {
+			return nil, errors.New("invalid sampling rule given: " + thisRule)
+		}
+		count, window, ok := strings.Cut(limit, "/")
+		if !ok {
+			return nil, errors.New("invalid sampling rule given: " + thisRule)
+		}
+		maxPerWindow, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || maxPerWindow < 0 {
+			return nil, errors.New("invalid sampling count given: " + thisRule)
+		}
+		windowDuration, err := time.ParseDuration(strings.TrimSpace(window))
+		if err != nil || windowDuration <= 0 {
+			return nil, errors.New("invalid sampling window given: " + thisRule)
+		}
+		results = append(results, SamplingRule{
+			TitlePrefix:  strings.TrimSpace(prefix),
+			MaxPerWindow: maxPerWindow,
+			Window:       windowDuration,
+		})
+	}
+	return results, nil
+}
+
+// Set the sampling rules of the logger, replacing the previous ones. Set nil to disable sampling
+func (l *Logger) SetSamplingRules(rules []SamplingRule) {
+	l.sampler.mutex.Lock()
+	defer l.sampler.mutex.Unlock()
+	l.sampler.rules = append([]SamplingRule{}, rules...)
+
+	//Counting restart with the new rules, pending summaries are still written
+	for title, state := range l.sampler.states {
+		if state.suppressed == 0 {
+			delete(l.sampler.states, title)
+		}
+	}
+}
+
+// Get the rule applied to the title, the longest matching prefix wins
+func (s *logSampler) matchRule(title string) (SamplingRule, bool) {
+	matched := false
+	result := SamplingRule{}
+	for _, rule := range s.rules {
+		if !strings.HasPrefix(title, rule.TitlePrefix) {
+			continue
+		}
+		if !matched || len(rule.TitlePrefix) > len(result.TitlePrefix) {
+			result = rule
+			matched = true
+		}
+	}
+	return result, matched
+}
+
+// Check if the entry should be logged. Schedule a summary at the end of the window
+// when the first entry of the window is suppressed
+func (l *Logger) allowSample(t time.Time, level LogLevel, title string) bool {
+	l.sampler.mutex.Lock()
+	defer l.sampler.mutex.Unlock()
+	rule, ok := l.sampler.matchRule(title)
+	if !ok {
+		return true
+	}
+	if l.sampler.states == nil {
+		l.sampler.states = map[string]*sampleState{}
+	}
+
+	state, ok := l.sampler.states[title]
+	if !ok || (state.suppressed == 0 && t.Sub(state.windowStart) >= rule.Window) {
+		state = &sampleState{windowStart: t}
+		l.sampler.states[title] = state
+	}
+
+	state.count++
+	if state.count <= rule.MaxPerWindow {
+		return true
+	}
+
+	if state.suppressed == 0 || level > state.maxLevel {
+		state.maxLevel = level
+	}
+	state.suppressed++
+	if state.summary == nil {
+		state.summary = time.AfterFunc(state.windowStart.Add(rule.Window).Sub(t), func() {
+			l.writeSampleSummary(title, rule.Window)
+		})
+	}
+	return false
+}
+
+// Log the number of suppressed entries of the title and start a new window
+func (l *Logger) writeSampleSummary(title string, window time.Duration) {
+	l.sampler.mutex.Lock()
+	state, ok := l.sampler.states[title]
+	if !ok || state.suppressed == 0 {
+		l.sampler.mutex.Unlock()
+		return
+	}
+	suppressed, level := state.suppressed, state.maxLevel
+	delete(l.sampler.states, title)
+	l.sampler.mutex.Unlock()
+
+	message := strconv.Itoa(suppressed) + " messages suppressed"
+	if window > 0 {
+		message += " in the last " + window.String()
+	}
+	l.logWithLevel(time.Now(), level, title, message, nil, nil)
+}
+
+// Write the summaries of all suppressed entries now, used when the logger is closing
+func (l *Logger) flushSampleSummaries() {
+	l.sampler.mutex.Lock()
+	titles := []string{}
+	for title, state := range l.sampler.states {
+		if state.summary != nil && state.summary.Stop() {
+			titles = append(titles, title)
+		}
+	}
+	l.sampler.mutex.Unlock()
+
+	for _, title := range titles {
+		l.writeSampleSummary(title, 0)
+	}
+}
@@ -0,0 +1,212 @@
+package logger
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
+
+/*
+	Log Sinks
+
+	A log entry accepted by the logger is dispatched to all of its sinks.
+	Each logger comes with the following sinks
+
+	1. File sink, write to the log file if LogToFile is enabled
+	2. Ring buffer sink, keep the latest entries in memory for the web UI
+	3. Hook sink, pass the entries to the hooks added by AddHook. See hooks.go
+	4. STDOUT sink, only used by PrintAndLog and the leveled log functions
+
+	Additional sinks can be attached with AddSink, e.g. the syslog sink in
+	syslog.go. Sinks implementing io.Closer are closed with the logger.
+*/
+
+type LogSink interface {
+	WriteLog(t time.Time, level LogLevel, title string, message string, originalError error)
+}
+
+// Default number of entries kept in the ring buffer of a logger
+const DefaultRingBufferSize = 1000
+
+/*
+	File Sink
+*/
+
+type fileSink struct {
+	logger *Logger
+}
+
+func (s *fileSink) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+	s.logger.writeToFile(t, level, title, message, originalError, nil)
+}
+
+func (s *fileSink) WriteLogWithFields(t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) {
+	s.logger.writeToFile(t, level, title, message, originalError, fields)
+}
+
+/*
+	STDOUT Sink
+*/
+
+type StdoutSink struct{}
+
+func (s *StdoutSink) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+	log.Println("[" + title + "] " + message)
+}
+
+/*
+	Ring Buffer Sink
+*/
+
+type RingBuffer struct {
+	entries []LogEntry
+	next    int  //Index of the next write
+	full    bool //If the buffer has wrapped around
+	mutex   sync.RWMutex
+}
+
+// Create a ring buffer that keep the latest size entries
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = DefaultRingBufferSize
+	}
+	return &RingBuffer{
+		entries: make([]LogEntry, size),
+	}
+}
+
+func (b *RingBuffer) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+	b.WriteLogWithFields(t, level, title, message, originalError, nil)
+}
+
+func (b *RingBuffer) WriteLogWithFields(t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) {
+	thisEntry := newLogEntry(t, level, title, message, originalError)
+	thisEntry.Fields = fields
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.entries[b.next] = thisEntry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Get the latest n entries in chronological order. n <= 0 for all entries in the buffer
+func (b *RingBuffer) Tail(n int) []LogEntry {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	count := b.next
+	if b.full {
+		count = len(b.entries)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	results := make([]LogEntry, 0, n)
+	start := b.next - n
+	if start < 0 {
+		start += len(b.entries)
+	}
+	for i := 0; i < n; i++ {
+		results = append(results, b.entries[(start+i)%len(b.entries)])
+	}
+	return results
+}
+
+/*
+	Logger Sink Management
+*/
+
+// Attach an additional sink to the logger
+func (l *Logger) AddSink(sink LogSink) {
+	l.sinkMutex.Lock()
+	defer l.sinkMutex.Unlock()
+	l.sinks = append(l.sinks, sink)
+}
+
+// Close the sinks that hold resources, called after the pending writes are done
+func (l *Logger) closeSinks() {
+	l.sinkMutex.RLock()
+	defer l.sinkMutex.RUnlock()
+	for _, sink := range l.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// Get the in-memory ring buffer of this logger
+func (l *Logger) GetRingBuffer() *RingBuffer {
+	return l.ringBuffer
+}
+
+// Get the latest n log entries from the ring buffer in chronological order
+func (l *Logger) Tail(n int) []LogEntry {
+	return l.ringBuffer.Tail(n)
+}
+
+func (l *Logger) writeToSinks(t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) {
+	l.sinkMutex.RLock()
+	defer l.sinkMutex.RUnlock()
+	for _, sink := range l.sinks {
+		writeToSink(sink, t, level, title, message, originalError, fields)
+	}
+}
+
+// Write the entries queued by the background writer in order. See writer.go
+func (l *Logger) writeBatchToSinks(batch []queuedEntry) {
+	l.sinkMutex.RLock()
+	defer l.sinkMutex.RUnlock()
+	for _, entry := range batch {
+		for _, sink := range l.sinks {
+			writeToSink(sink, entry.t, entry.level, entry.title, entry.message, entry.originalError, entry.fields)
+		}
+	}
+}
+
+// Write the entry to the sink and recover from its panic, so a failing sink (e.g. file closed
+// by a race) do not crash the system from the background writer. The entry is printed to STDOUT instead.
+// Sinks not implementing FieldLogSink get the fields appended to the message
+func writeToSink(sink LogSink, t time.Time, level LogLevel, title string, message string, originalError error, fields map[string]string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("[Logger] Log sink panic recovered: ", r)
+			log.Println("[" + title + "] " + message)
+		}
+	}()
+	if len(fields) == 0 {
+		sink.WriteLog(t, level, title, message, originalError)
+		return
+	}
+	if fieldSink, ok := sink.(FieldLogSink); ok {
+		fieldSink.WriteLogWithFields(t, level, title, message, originalError, fields)
+		return
+	}
+	sink.WriteLog(t, level, title, message+" "+formatFieldsText(fields), originalError)
+}
+
+// Handle listing of the recent logs from the ring buffer. Accept GET n, default 100
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (l *Logger) HandleTail(w http.ResponseWriter, r *http.Request) {
+	n := 100
+	nString, err := utils.GetPara(r, "n")
+	if err == nil && nString != "" {
+		n, err = strconv.Atoi(nString)
+		if err != nil {
+			utils.SendErrorResponse(w, "invalid n given")
+			return
+		}
+	}
+
+	js, _ := json.Marshal(l.Tail(n))
+	utils.SendJSONResponse(w, string(js))
+}
@@ -0,0 +1,59 @@
+//go:build !windows && !plan9 && !js
+
+package logger
+
+import (
+	"log/syslog"
+	"time"
+)
+
+/*
+	Syslog Sink
+
+	Forward the log entries to the local syslog / journald, or to a
+	remote syslog server. Attach it to a logger with AddSink.
+	Syslog is not available on Windows, see syslog_unsupported.go
+*/
+
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// Create a syslog sink. Leave network and raddr empty for the local syslog daemon
+func NewSyslogSink(network string, raddr string, tag string) (*SyslogSink, error) {
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+func (s *SyslogSink) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+	line := formatSyslogLine(title, message, originalError)
+
+	//Write errors are ignored, the writer reconnects on the next write
+	switch level {
+	case LevelDebug:
+		s.writer.Debug(line)
+	case LevelWarn:
+		s.writer.Warning(line)
+	case LevelError:
+		s.writer.Err(line)
+	default:
+		s.writer.Info(line)
+	}
+}
+
+// Close the connection to the syslog daemon
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
+
+// Format the entry as syslog message, the timestamp and level are handled by syslog
+func formatSyslogLine(title string, message string, originalError error) string {
+	line := "[" + title + "] " + message
+	if originalError != nil {
+		line += " " + originalError.Error()
+	}
+	return line
+}
@@ -0,0 +1,23 @@
+//go:build windows || plan9 || js
+
+package logger
+
+import (
+	"errors"
+	"time"
+)
+
+// No-op syslog sink for platforms without syslog
+type SyslogSink struct{}
+
+// Syslog is not supported on this platform. Return a no-op sink with error
+func NewSyslogSink(network string, raddr string, tag string) (*SyslogSink, error) {
+	return &SyslogSink{}, errors.New("syslog is not supported on this platform")
+}
+
+func (s *SyslogSink) WriteLog(t time.Time, level LogLevel, title string, message string, originalError error) {
+}
+
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	Async Writer
+
+	Entries logged with LogWithLevel are written to the sinks by a single
+	background writer goroutine fed by a buffered channel, so the log lines
+	keep their order and a log storm does not spawn unbounded goroutines.
+
+	The writer is started on the first async log entry with QueueSize.
+	When the queue is full, the caller is blocked until there is space,
+	or the entry is dropped and counted if DropWhenFull is set.
+*/
+
+// Default number of entries buffered for the background writer
+const DefaultQueueSize = 1024
+
+// Max number of queued entries written to the sinks at once
+const maxWriteBatchSize = 64
+
+type queuedEntry struct {
+	t             time.Time
+	level         LogLevel
+	title         string
+	message       string
+	originalError error
+	fields        map[string]string
+}
+
+type asyncWriter struct {
+	queue   chan queuedEntry
+	start   sync.Once
+	done    chan bool
+	closed  bool
+	mutex   sync.RWMutex //Prevent sending to the queue after it is closed
+	dropped uint64       //Number of entries dropped due to full queue
+}
+
+// Queue the entry for the background writer, return false if the logger is closed
+func (l *Logger) enqueue(entry queuedEntry) bool {
+	l.writer.start.Do(func() {
+		queueSize := l.QueueSize
+		if queueSize <= 0 {
+			queueSize = DefaultQueueSize
+		}
+		l.writer.queue = make(chan queuedEntry, queueSize)
+		l.writer.done = make(chan bool)
+		go l.runWriter()
+	})
+
+	l.writer.mutex.RLock()
+	defer l.writer.mutex.RUnlock()
+	if l.writer.closed {
+		return false
+	}
+
+	l.pendingWrites.Add(1)
+	if !l.DropWhenFull {
+		l.writer.queue <- entry
+		return true
+	}
+
+	select {
+	case l.writer.queue <- entry:
+	default:
+		//Queue full. Drop the entry
+		atomic.AddUint64(&l.writer.dropped, 1)
+		l.pendingWrites.Done()
+	}
+	return true
+}
+
+// Write the queued entries in batches until the queue is closed
+func (l *Logger) runWriter() {
+	batch := make([]queuedEntry, 0, maxWriteBatchSize)
+	for entry := range l.writer.queue {
+		batch = append(batch[:0], entry)
+
+		//Collect the entries already in the queue without blocking
+	collect:
+		for len(batch) < maxWriteBatchSize {
+			select {
+			case next, ok := <-l.writer.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+
+		l.writeBatchToSinks(batch)
+		l.pendingWrites.Add(-len(batch))
+	}
+	close(l.writer.done)
+}
+
+// Stop accepting new entries and wait for the queued entries to be written
+func (l *Logger) stopWriter() {
+	l.writer.mutex.Lock()
+	if l.writer.closed || l.writer.queue == nil {
+		l.writer.closed = true
+		l.writer.mutex.Unlock()
+		return
+	}
+	l.writer.closed = true
+	close(l.writer.queue)
+	l.writer.mutex.Unlock()
+
+	<-l.writer.done
+}
+
+// Get the number of entries dropped because the queue of the background writer is full
+func (l *Logger) DroppedEntries() uint64 {
+	return atomic.LoadUint64(&l.writer.dropped)
+}
@@ -44,7 +44,10 @@ func (h *Handler) Start() error {
 // Scan the devices within the LAN
 func (h *Handler) Scan() ([]*iot.Device, error) {
 	foundDevices := []*iot.Device{}
-	hosts := h.scanner.Scan(3, "hds.arozos.com")
+	hosts, err := h.scanner.Scan(3, "hds.arozos.com")
+	if err != nil {
+		return foundDevices, err
+	}
 	for _, host := range hosts {
 		//Decode the URL and escape characters
 		decodedURL, err := url.QueryUnescape(host.HostName)
@@ -41,7 +41,10 @@ func (h *Handler) Start() error {
 
 func (h *Handler) Scan() ([]*iot.Device, error) {
 	results := []*iot.Device{}
-	scannedDevices := h.scanner.Scan(30, "")
+	scannedDevices, err := h.scanner.Scan(30, "")
+	if err != nil {
+		return results, err
+	}
 	for _, dev := range scannedDevices {
 		if dev.Port == 80 {
 			if len(dev.IPv4) == 0 {
@@ -0,0 +1,173 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/*
+	Client IP Resolution
+
+	Resolve the real client IP of a request sent via reverse proxies
+	(e.g. nginx or Cloudflare). The proxy headers can be forged by any
+	client, so they are only honored when the direct peer is one of the
+	trusted proxies. Otherwise the peer address is the client IP.
+
+	For X-Forwarded-For, the addresses are checked from right to left and
+	the first one that is not a trusted proxy is the client IP
+*/
+
+// Header names commonly set by reverse proxies
+const (
+	HeaderXForwardedFor  = "X-Forwarded-For"
+	HeaderXRealIP        = "X-Real-IP"
+	HeaderCFConnectingIP = "CF-Connecting-IP"
+)
+
+// Proxies on the same host are trusted by default
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+type trustedProxyConfig struct {
+	networks []*net.IPNet
+	header   string //Header to read the client IP from, empty for X-Real-IP then X-Forwarded-For
+}
+
+var (
+	proxyConfig      = newTrustedProxyConfig(DefaultTrustedProxies, "")
+	proxyConfigMutex sync.RWMutex
+)
+
+// Set the trusted proxies (IP or CIDR) and the header carrying the client IP.
+// Set header to empty string for using X-Real-IP, then X-Forwarded-For
+func SetTrustedProxies(proxies []string, header string) error {
+	for _, proxy := range proxies {
+		if strings.TrimSpace(proxy) != "" && parseProxyNetwork(proxy) == nil {
+			return errors.New("invalid trusted proxy given: " + proxy)
+		}
+	}
+
+	newConfig := newTrustedProxyConfig(proxies, header)
+	proxyConfigMutex.Lock()
+	proxyConfig = newConfig
+	proxyConfigMutex.Unlock()
+	return nil
+}
+
+func newTrustedProxyConfig(proxies []string, header string) *trustedProxyConfig {
+	config := trustedProxyConfig{
+		networks: []*net.IPNet{},
+		header:   http.CanonicalHeaderKey(strings.TrimSpace(header)),
+	}
+	for _, proxy := range proxies {
+		if ipnet := parseProxyNetwork(proxy); ipnet != nil {
+			config.networks = append(config.networks, ipnet)
+		}
+	}
+	return &config
+}
+
+// Parse a trusted proxy given as IP or CIDR, return nil if invalid
+func parseProxyNetwork(proxy string) *net.IPNet {
+	proxy = strings.TrimSpace(proxy)
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+	_, ipnet, err := net.ParseCIDR(proxy)
+	if err != nil {
+		return nil
+	}
+	return ipnet
+}
+
+// Check if the given IP is a trusted proxy
+func (c *trustedProxyConfig) isTrusted(ip net.IP) bool {
+	for _, ipnet := range c.networks {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the client IP from the header value, return empty string if not found
+func (c *trustedProxyConfig) clientIPFromHeader(header string, value string) string {
+	if header != HeaderXForwardedFor {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			return ""
+		}
+		return ip.String()
+	}
+
+	hops := strings.Split(value, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			//Malformed entry, the addresses on its left cannot be trusted
+			return ""
+		}
+		if i == 0 || !c.isTrusted(ip) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// Get the IP address of the client sending the request. The proxy headers
+// are only honored if the request is sent by a trusted proxy
+func GetIpFromRequest(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peerIP := net.ParseIP(host)
+	if peerIP == nil {
+		return "", errors.New("No IP information found")
+	}
+
+	proxyConfigMutex.RLock()
+	config := proxyConfig
+	proxyConfigMutex.RUnlock()
+	if !config.isTrusted(peerIP) {
+		return peerIP.String(), nil
+	}
+
+	headers := []string{HeaderXRealIP, HeaderXForwardedFor}
+	if config.header != "" {
+		headers = []string{config.header}
+	}
+	for _, header := range headers {
+		value := r.Header.Get(header)
+		if value == "" {
+			continue
+		}
+		if clientIP := config.clientIPFromHeader(header, value); clientIP != "" {
+			return clientIP, nil
+		}
+	}
+	return peerIP.String(), nil
+}
+
+// Get the client address in host:port format for logging. The port is the
+// one of the direct peer, which is the proxy if the request is proxied
+func GetRemoteAddrFromRequest(r *http.Request) string {
+	clientIP, err := GetIpFromRequest(r)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	_, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		port = "0"
+	}
+	return net.JoinHostPort(clientIP, port)
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetIpFromRequest(t *testing.T) {
+	defer SetTrustedProxies(DefaultTrustedProxies, "")
+
+	newRequest := func(remoteAddr string, headers map[string]string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		ip, err := GetIpFromRequest(r)
+		if err != nil {
+			return "error"
+		}
+		return ip
+	}
+
+	//Headers from untrusted peers are ignored
+	SetTrustedProxies(DefaultTrustedProxies, "")
+	if ip := newRequest("203.0.113.5:1234", map[string]string{"X-Forwarded-For": "10.0.0.1", "X-Real-IP": "10.0.0.1"}); ip != "203.0.113.5" {
+		t.Errorf("Expected spoofed headers to be ignored, got %s", ip)
+	}
+
+	//Local proxy is trusted by default, X-Real-IP take priority
+	if ip := newRequest("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.2", "X-Real-IP": "198.51.100.1"}); ip != "198.51.100.1" {
+		t.Errorf("Expected X-Real-IP from local proxy, got %s", ip)
+	}
+	if ip := newRequest("[::1]:1234", map[string]string{"X-Forwarded-For": "2001:db8::1"}); ip != "2001:db8::1" {
+		t.Errorf("Expected X-Forwarded-For from local IPv6 proxy, got %s", ip)
+	}
+	if ip := newRequest("127.0.0.1:1234", nil); ip != "127.0.0.1" {
+		t.Errorf("Expected peer IP without proxy headers, got %s", ip)
+	}
+
+	//Only the configured header is used, X-Forwarded-For is read from right to left
+	err := SetTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"}, "x-forwarded-for")
+	if err != nil {
+		t.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	if ip := newRequest("10.1.1.1:80", map[string]string{"X-Real-IP": "198.51.100.1", "X-Forwarded-For": "1.1.1.1, 198.51.100.9, 10.2.2.2"}); ip != "198.51.100.9" {
+		t.Errorf("Expected the last untrusted hop, got %s", ip)
+	}
+	if ip := newRequest("192.0.2.1:80", map[string]string{"X-Forwarded-For": "10.3.3.3, 10.2.2.2"}); ip != "10.3.3.3" {
+		t.Errorf("Expected the first hop when all are trusted, got %s", ip)
+	}
+	if ip := newRequest("192.0.2.1:80", map[string]string{"X-Forwarded-For": "198.51.100.1, garbage"}); ip != "192.0.2.1" {
+		t.Errorf("Expected peer IP for malformed header, got %s", ip)
+	}
+	if ip := newRequest("192.0.2.2:80", map[string]string{"X-Forwarded-For": "198.51.100.1"}); ip != "192.0.2.2" {
+		t.Errorf("Expected untrusted peer IP, got %s", ip)
+	}
+
+	SetTrustedProxies([]string{"173.245.48.0/20"}, HeaderCFConnectingIP)
+	if ip := newRequest("173.245.48.10:443", map[string]string{"CF-Connecting-IP": "198.51.100.7", "X-Forwarded-For": "1.1.1.1"}); ip != "198.51.100.7" {
+		t.Errorf("Expected CF-Connecting-IP from Cloudflare, got %s", ip)
+	}
+
+	if SetTrustedProxies([]string{"not-an-ip"}, "") == nil {
+		t.Error("Expected invalid trusted proxy to be rejected")
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "173.245.48.10:443"
+	r.Header.Set("CF-Connecting-IP", "2001:db8::7")
+	if addr := GetRemoteAddrFromRequest(r); addr != "[2001:db8::7]:443" {
+		t.Errorf("Unexpected remote address for logging: %s", addr)
+	}
+}
@@ -0,0 +1,64 @@
+package mdns
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+	Scan Cache
+
+	Keep the last scan result of each domain filter, so rapidly polling
+	callers (e.g. dashboards) do not flood the network with multicast
+	queries. Concurrent callers of CachedScan wait for the ongoing scan
+	and share its result instead of starting their own.
+*/
+
+type scanCache struct {
+	entries map[string]*scanCacheEntry //Domain filter as key
+	mutex   sync.Mutex
+}
+
+type scanCacheEntry struct {
+	Hosts    []*NetworkHost
+	ScanTime time.Time
+}
+
+// Return the cached scan result if it is fresher than maxAge, otherwise perform a scan with the given timeout and domain filter
+func (m *MDNSHost) CachedScan(maxAge time.Duration, timeout int, domainFilter string) ([]*NetworkHost, error) {
+	m.cache.mutex.Lock()
+	defer m.cache.mutex.Unlock()
+
+	if cached, ok := m.cache.entries[domainFilter]; ok && time.Since(cached.ScanTime) < maxAge {
+		return copyHostList(cached.Hosts), nil
+	}
+
+	hosts, err := m.Scan(timeout, domainFilter)
+	if err != nil {
+		//Do not cache failed scans
+		return hosts, err
+	}
+
+	if m.cache.entries == nil {
+		m.cache.entries = map[string]*scanCacheEntry{}
+	}
+	m.cache.entries[domainFilter] = &scanCacheEntry{
+		Hosts:    hosts,
+		ScanTime: time.Now(),
+	}
+	return copyHostList(hosts), nil
+}
+
+// Remove all cached scan results, the next CachedScan will perform a real scan
+func (m *MDNSHost) InvalidateScanCache() {
+	m.cache.mutex.Lock()
+	m.cache.entries = map[string]*scanCacheEntry{}
+	m.cache.mutex.Unlock()
+}
+
+// Copy the host list so callers can modify the returned slice without changing the cache
+func copyHostList(hosts []*NetworkHost) []*NetworkHost {
+	results := make([]*NetworkHost, len(hosts))
+	copy(results, hosts)
+	return results
+}
@@ -1,6 +1,6 @@
 package mdns
 
-import "net"
+import "strings"
 
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
@@ -11,8 +11,24 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
+// Lower case the MAC address for comparing
+func normalizeMacAddr(mac string) string {
+	return strings.ToLower(strings.TrimSpace(mac))
+}
+
+// Normalize the MAC addresses and remove the empty ones
+func normalizeMacAddrs(macs []string) []string {
+	results := []string{}
+	for _, mac := range macs {
+		if mac = normalizeMacAddr(mac); mac != "" {
+			results = append(results, mac)
+		}
+	}
+	return results
+}
+
 func getMacAddr() ([]string, error) {
-	ifas, err := net.Interfaces()
+	ifas, err := listInterfaces()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,104 @@
+package mdns
+
+import (
+	"context"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+/*
+	Continuous Scan
+
+	This script keep browsing the network in the background and
+	notify the caller when a host appears or disappears, so the
+	caller do not need to poll Scan on a timer.
+
+	Hosts are deduplicated by their UUID (or MAC address if the
+	host do not broadcast its UUID). A host that is not seen
+	for HostLostTTL is considered lost. A known host found with
+	new addresses is reported via OnHostAddressChanged at the
+	end of the round. See tracker.go
+*/
+
+const defaultHostLostTTL = 60 * time.Second
+
+type continuousScanRecord struct {
+	Host     *NetworkHost
+	LastSeen time.Time
+}
+
+// Keep scanning until the context is cancelled. onHostDiscovered is called when a new host is found
+// and onHostLost is called when a known host is not seen for HostLostTTL. Callbacks can be nil
+func (m *MDNSHost) ScanContinuous(ctx context.Context, onHostDiscovered func(*NetworkHost), onHostLost func(*NetworkHost)) error {
+	ttl := m.HostLostTTL
+	if ttl <= 0 {
+		ttl = defaultHostLostTTL
+	}
+
+	//zeroconf only report each entry once per browse, so restart the browse in rounds
+	//to refresh the last seen time of hosts that are still online
+	roundDuration := ttl / 3
+	if roundDuration < 5*time.Second {
+		roundDuration = 5 * time.Second
+	}
+
+	zcoption := m.getClientOption()
+
+	knownHosts := map[string]*continuousScanRecord{}
+	for {
+		resolver, err := newResolver(zcoption)
+		if err != nil {
+			return err
+		}
+
+		roundCtx, cancel := context.WithTimeout(ctx, roundDuration)
+		entries := make(chan *zeroconf.ServiceEntry)
+		err = resolver.Browse(roundCtx, m.getServiceType(), "local.", entries)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		seenHosts := []*NetworkHost{}
+		for entry := range entries {
+			thisHost := newNetworkHostFromEntry(entry)
+			hostKey := getHostKey(thisHost)
+			seenHosts = append(seenHosts, thisHost)
+
+			if record, ok := knownHosts[hostKey]; ok {
+				record.Host = thisHost
+				record.LastSeen = time.Now()
+				continue
+			}
+
+			knownHosts[hostKey] = &continuousScanRecord{
+				Host:     thisHost,
+				LastSeen: time.Now(),
+			}
+			if onHostDiscovered != nil {
+				onHostDiscovered(thisHost)
+			}
+		}
+		cancel()
+
+		//Compare the addresses after merging the announcements from different interfaces
+		m.trackHosts(mergeNetworkHosts(seenHosts))
+
+		if ctx.Err() != nil {
+			//Scan cancelled by caller
+			return nil
+		}
+
+		//Remove the hosts that are not seen for longer than TTL
+		for hostKey, record := range knownHosts {
+			if time.Since(record.LastSeen) > ttl {
+				delete(knownHosts, hostKey)
+				record.Host.Online = false
+				if onHostLost != nil {
+					onHostLost(record.Host)
+				}
+			}
+		}
+	}
+}
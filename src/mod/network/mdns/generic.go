@@ -0,0 +1,166 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grandcat/zeroconf"
+)
+
+/*
+	Generic Service Scan
+
+	Besides arozos nodes, devices like printers, NAS and Chromecasts
+	announce their services over mDNS. ScanServices browse the given
+	service types (DefaultGenericServiceTypes if none) and return every
+	discovered service without domain filtering, for using arozos as a
+	general network browser.
+
+	All hosts carry the raw TXT records in TXT. Arozos nodes, the hosts
+	advertising a uuid or domain, have IsArozos set, other devices are
+	described by their TXT records only (e.g. "ty" for printer model,
+	"fn" for Chromecast name).
+
+	Generic devices are not tracked (see tracker.go), and the same host
+	is listed once for each service type it offers.
+*/
+
+// Service types browsed by ScanServices if none is given
+var DefaultGenericServiceTypes = []string{
+	"_http._tcp",
+	"_https._tcp",
+	"_ipp._tcp",
+	"_ipps._tcp",
+	"_printer._tcp",
+	"_pdl-datastream._tcp",
+	"_googlecast._tcp",
+	"_airplay._tcp",
+	"_smb._tcp",
+	"_afpovertcp._tcp",
+	"_nfs._tcp",
+	"_ssh._tcp",
+}
+
+// Parse the TXT records into a map. Keys without value (boolean attributes) are kept with empty value
+func parseRawTXT(records []string) map[string]string {
+	results := map[string]string{}
+	for _, record := range records {
+		key, value, _ := strings.Cut(record, "=")
+		if key == "" {
+			continue
+		}
+		if _, exists := results[key]; exists {
+			//Only the first occurrence of a key is used (RFC 6763 section 6.4)
+			continue
+		}
+		results[key] = value
+	}
+	return results
+}
+
+// Check if the TXT records are advertised by an arozos node
+func isArozosTXT(txt map[string]string) bool {
+	return txt["uuid"] != "" || txt["domain"] != ""
+}
+
+// Browse the service types until the context is done or MaxScanDuration is reached and return all discovered services.
+// Set serviceTypes to nil for DefaultGenericServiceTypes. If the context is cancelled, the services discovered so far
+// are returned with the context error
+func (m *MDNSHost) ScanServices(ctx context.Context, serviceTypes []string) ([]*NetworkHost, error) {
+	if m == nil {
+		return []*NetworkHost{}, errors.New("mDNS host not initialized")
+	}
+	if len(serviceTypes) == 0 {
+		serviceTypes = DefaultGenericServiceTypes
+	}
+	maxDuration := m.MaxScanDuration
+	if maxDuration <= 0 {
+		maxDuration = DefaultMaxScanDuration
+	}
+	scanCtx, cancel := context.WithTimeout(ctx, maxDuration)
+	defer cancel()
+
+	//Each resolver browse one service type, the responses of a shared one would be split between the browses
+	resolvers := []*zeroconf.Resolver{}
+	for range serviceTypes {
+		resolver, err := newResolver(m.getClientOption())
+		if err != nil {
+			log.Println("[mDNS] Failed to initialize resolver:", err.Error())
+			return []*NetworkHost{}, err
+		}
+		resolvers = append(resolvers, resolver)
+	}
+
+	discoveredHosts := [][]*NetworkHost{}
+	var resultMutex sync.Mutex
+	var wg sync.WaitGroup
+	for i, serviceType := range serviceTypes {
+		entries := make(chan *zeroconf.ServiceEntry)
+		err := resolvers[i].Browse(scanCtx, serviceType, "local.", entries)
+		if err != nil {
+			log.Println("[mDNS] Failed to browse " + serviceType + ": " + err.Error())
+			continue
+		}
+
+		wg.Add(1)
+		go func(results <-chan *zeroconf.ServiceEntry) {
+			defer wg.Done()
+			hosts := []*NetworkHost{}
+			for entry := range results {
+				hosts = append(hosts, newNetworkHostFromEntry(entry))
+			}
+			resultMutex.Lock()
+			discoveredHosts = append(discoveredHosts, hosts)
+			resultMutex.Unlock()
+		}(entries)
+	}
+
+	//Wait until the resolvers close the result channels after timeout
+	wg.Wait()
+	results := mergeServiceHosts(discoveredHosts)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return results, ctx.Err()
+	}
+	return results, nil
+}
+
+// Merge the duplicated announcements within each service type, result is sorted by service type then HostName.
+// Arozos nodes are merged by their host key, other devices by host and instance name
+func mergeServiceHosts(hostsByService [][]*NetworkHost) []*NetworkHost {
+	results := []*NetworkHost{}
+	for _, hosts := range hostsByService {
+		arozosHosts := []*NetworkHost{}
+		genericHosts := map[string]*NetworkHost{}
+		for _, thisHost := range hosts {
+			if thisHost.IsArozos {
+				arozosHosts = append(arozosHosts, thisHost)
+				continue
+			}
+			hostKey := thisHost.HostName + "/" + thisHost.Instance
+			existingHost, ok := genericHosts[hostKey]
+			if !ok {
+				genericHosts[hostKey] = thisHost
+				results = append(results, thisHost)
+				continue
+			}
+			existingHost.IPv4 = mergeIPs(existingHost.IPv4, thisHost.IPv4)
+			existingHost.IPv6 = mergeIPs(existingHost.IPv6, thisHost.IPv6)
+		}
+		results = append(results, mergeNetworkHosts(arozosHosts)...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].ServiceType != results[j].ServiceType {
+			return results[i].ServiceType < results[j].ServiceType
+		}
+		if results[i].HostName != results[j].HostName {
+			return results[i].HostName < results[j].HostName
+		}
+		return results[i].Instance < results[j].Instance
+	})
+	return results
+}
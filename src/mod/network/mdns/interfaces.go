@@ -0,0 +1,175 @@
+package mdns
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+)
+
+/*
+	Scan Interfaces
+
+	The interfaces to browse on are selected in the following order
+
+	1. ScanIfaces, if not empty (multiple MAC addresses or interface names
+	   given to NewMDNS or set by SetScanInterfaces)
+	2. IfaceOverride, if set (single MAC address or interface name given
+	   to NewMDNS, the same behavior as older versions)
+	3. All multicast capable interfaces of the host (zeroconf default)
+
+	Results from different interfaces are merged and deduplicated by Scan
+
+	Advertise Interfaces
+
+	The service is advertised on all multicast capable interfaces by
+	default, including VPN tunnels and public facing interfaces. This leak
+	the host name, UUID, MAC addresses and version of this host to every
+	network it is connected to. Set AdvertiseIfaces (MAC addresses or
+	interface names) to only answer and announce on the selected interfaces.
+	If none of the selected interfaces is found, the service is not advertised
+	at all instead of falling back to all interfaces.
+*/
+
+// Interface lister, replaceable for testing
+var listInterfaces = net.Interfaces
+
+// Get the zeroconf client option selecting the interfaces to browse on, nil for all interfaces
+func (m *MDNSHost) getClientOption() zeroconf.ClientOption {
+	if len(m.ScanIfaces) > 0 {
+		return zeroconf.SelectIfaces(m.ScanIfaces)
+	}
+	if m.IfaceOverride != nil {
+		return zeroconf.SelectIfaces([]net.Interface{*m.IfaceOverride})
+	}
+	return nil
+}
+
+// Set the interfaces to browse on by their MAC addresses or names. Set to empty slice for using IfaceOverride or all interfaces
+func (m *MDNSHost) SetScanInterfaces(selectors []string) error {
+	if len(selectors) == 0 {
+		m.ScanIfaces = []net.Interface{}
+		return nil
+	}
+
+	ifaces := findIfaces(selectors)
+	if len(ifaces) == 0 {
+		return errors.New("no interface matching the given MAC addresses or names")
+	}
+	m.ScanIfaces = ifaces
+	return nil
+}
+
+// Set the interfaces to browse on by their MAC addresses, kept for backward compatibility. See SetScanInterfaces
+func (m *MDNSHost) SetScanInterfacesByMAC(macAddrs []string) error {
+	return m.SetScanInterfaces(macAddrs)
+}
+
+// Find the interfaces with the given MAC addresses or interface names (e.g. eth0),
+// selectors that do not match any interface are ignored
+func findIfaces(selectors []string) []net.Interface {
+	results := []net.Interface{}
+	ifaces, err := listInterfaces()
+	if err != nil {
+		log.Println("[mDNS] Unable to override iface: " + err.Error() + ". Resuming with default iface")
+		return results
+	}
+
+	for _, selector := range selectors {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+
+		_, macErr := net.ParseMAC(selector)
+		isMac := macErr == nil
+		macAddr := strings.ReplaceAll(selector, ":", "-")
+
+		foundMatching := false
+		for _, iface := range ifaces {
+			if !ifaceMatches(iface, selector) {
+				continue
+			}
+
+			//This is the correct iface to use
+			results = append(results, iface)
+			log.Println("[mDNS] Entering force interface mode, listening on: " + iface.Name + " (MAC address: " + iface.HardwareAddr.String() + ", IP address: " + getIfaceIPv4(&iface) + ")")
+			foundMatching = true
+			break
+		}
+
+		if !foundMatching {
+			if isMac {
+				log.Println("[mDNS] Unable to find the target iface with MAC address: " + macAddr + ". Resuming with default iface")
+			} else {
+				log.Println("[mDNS] Unable to find the target iface with name: " + selector + ". Resuming with default iface")
+			}
+		}
+	}
+	return results
+}
+
+// Check if the interface has the MAC address if the selector is a MAC address, otherwise the interface name
+func ifaceMatches(iface net.Interface, selector string) bool {
+	if _, err := net.ParseMAC(selector); err == nil {
+		thisIfaceMac := strings.ReplaceAll(iface.HardwareAddr.String(), ":", "-")
+		return strings.EqualFold(thisIfaceMac, strings.ReplaceAll(selector, ":", "-"))
+	}
+	return iface.Name == selector
+}
+
+// Get the interfaces to advertise on by their MAC addresses or names, nil for all interfaces.
+// Interfaces are resolved on every registration as their index may change (e.g. interface recreated)
+func getAdvertiseIfaces(selectors []string) ([]net.Interface, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+	ifaces, err := listInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []net.Interface{}
+	for _, iface := range ifaces {
+		for _, selector := range selectors {
+			if ifaceMatches(iface, strings.TrimSpace(selector)) {
+				results = append(results, iface)
+				break
+			}
+		}
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no interface matching " + strings.Join(selectors, ",") + " to advertise on")
+	}
+	return results, nil
+}
+
+// Get the IPv4 address of the interface, or its first address if it has no IPv4 address
+func getIfaceIPv4(iface *net.Interface) string {
+	ifaceIp := ""
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ifaceIp
+	}
+	if len(addrs) > 0 {
+		ifaceIp = addrs[0].String()
+	}
+
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+
+		if ip.To4() != nil {
+			//This NIC have Ipv4 addr
+			ifaceIp = ip.String()
+		}
+	}
+	return ifaceIp
+}
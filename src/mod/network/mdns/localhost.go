@@ -0,0 +1,73 @@
+package mdns
+
+import (
+	"net"
+)
+
+/*
+	Local Host Info
+
+	Build the NetworkHost describing this host from the running system,
+	so the callers of NewMDNS and other modules get a consistent
+	self-description without collecting the addresses themselves.
+
+	The caller provides the identity of the host (host name, port, UUID,
+	version, vendor, model etc), and the IP and MAC addresses of the up
+	interfaces are filled in. Loopback and link-local addresses are
+	skipped as they are not reachable by other hosts.
+*/
+
+// Create the NetworkHost of this host from the given identity, with the local IP and MAC addresses filled in
+func NewLocalNetworkHost(config NetworkHost) (*NetworkHost, error) {
+	macAddress, err := getMacAddr()
+	if err != nil {
+		return nil, err
+	}
+	ipv4, ipv6, err := getLocalIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ServiceType == "" {
+		config.ServiceType = DefaultServiceType
+	}
+	config.Scheme = normalizeScheme(config.Scheme)
+	config.BasePath = normalizeBasePath(config.BasePath)
+	config.IPv4 = ipv4
+	config.IPv6 = ipv6
+	config.MacAddr = macAddress
+	config.Online = true
+	return &config, nil
+}
+
+// Get the IPv4 and IPv6 addresses of the up interfaces, excluding loopback and link-local addresses
+func getLocalIPs() ([]net.IP, []net.IP, error) {
+	ifaces, err := listInterfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ipv4 := []net.IP{}
+	ipv6 := []net.IP{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := interfaceAddrs(iface)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				ipv4 = append(ipv4, ip4)
+			} else {
+				ipv6 = append(ipv6, ipnet.IP)
+			}
+		}
+	}
+	return ipv4, ipv6, nil
+}
@@ -2,24 +2,51 @@ package mdns
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grandcat/zeroconf"
 )
 
+// Resolver constructor, replaceable for testing
+var newResolver = zeroconf.NewResolver
+
+// Service registration, replaceable for testing
+var registerZeroconf = zeroconf.Register
+
 type MDNSHost struct {
 	MDNS          *zeroconf.Server
 	Host          *NetworkHost
 	IfaceOverride *net.Interface
+	ScanIfaces    []net.Interface //Interfaces to browse on, take priority over IfaceOverride. See interfaces.go
+	HostLostTTL   time.Duration   //Time before a host not seen in continuous scan is considered lost, default 60 seconds
+	ProbeTimeout  time.Duration   //Timeout of each connection attempt in VerifyReachability, default 2 seconds
+	ProbeWorkers  int             //Max number of hosts probed at the same time in VerifyReachability, default 16
+	UnicastSeeds  []string        //Hosts or DNS-SD servers to query by unicast in addition to multicast scan. See unicast.go
+	serverMutex   sync.Mutex      //Protect MDNS during re-registration. See reregister.go
+	cache         scanCache       //Last scan results for CachedScan. See cache.go
+
+	//Called when a known host is found with different addresses, can be nil. See tracker.go
+	OnHostAddressChanged func(host *NetworkHost, previousIPv4 []net.IP, previousIPv6 []net.IP)
+	tracker              hostTracker //Hosts seen in previous scans
+
+	//MAC addresses or names of the interfaces to advertise on, empty for all interfaces. See interfaces.go
+	AdvertiseIfaces []string
+
+	//Max duration of ScanContext if the context has no earlier deadline, default 30 seconds
+	MaxScanDuration time.Duration
 }
 
 type NetworkHost struct {
 	HostName     string
 	Port         int
 	IPv4         []net.IP
+	IPv6         []net.IP
 	Domain       string
 	Model        string
 	UUID         string
@@ -28,202 +55,359 @@ type NetworkHost struct {
 	MinorVersion string
 	MacAddr      []string
 	Online       bool
+	Scheme       string            //Scheme of the web interface, http or https. See scheme.go
+	BasePath     string            //Base path of the web interface, empty for root
+	ServiceType  string            //Service type to advertise and browse, default _http._tcp
+	ExtraTXT     map[string]string //Extra TXT records to advertise, or non-standard TXT records of a discovered host
+	Status       *NodeStatus       //Load of the host, nil if not advertised. See status.go
+
+	//Raw TXT records of a discovered host, including the arozos ones. See generic.go
+	TXT      map[string]string
+	IsArozos bool   //Discovered host is an arozos node, i.e. advertise uuid or domain
+	Instance string //Service instance name of a discovered host, e.g. the name of a printer
 }
 
-// Create a new MDNS discoverer, set MacOverride to empty string for using the first NIC discovered
-func NewMDNS(config NetworkHost, MacOverride string) (*MDNSHost, error) {
-	//Get host MAC Address
-	macAddress, err := getMacAddr()
-	if err != nil {
-		return nil, err
-	}
+// Default service type, for backward compatibility with older nodes
+const DefaultServiceType = "_http._tcp"
 
-	macAddressBoardcast := ""
-	if err == nil {
-		macAddressBoardcast = strings.Join(macAddress, ",")
-	} else {
-		log.Println("[mDNS] Unable to get MAC Address: ", err.Error())
-	}
+// Default max duration of ScanContext
+const DefaultMaxScanDuration = 30 * time.Second
 
-	//Register the mds services
-	server, err := zeroconf.Register(config.HostName, "_http._tcp", "local.", config.Port, []string{"version_build=" + config.BuildVersion, "version_minor=" + config.MinorVersion, "vendor=" + config.Vendor, "model=" + config.Model, "uuid=" + config.UUID, "domain=" + config.Domain, "mac_addr=" + macAddressBoardcast}, nil)
-	if err != nil {
-		log.Println("[mDNS] Error when registering zeroconf broadcast message", err.Error())
-		return &MDNSHost{}, err
+// TXT record keys used by arozos, cannot be overwritten by ExtraTXT
+var reservedTXTKeys = []string{"version_build", "version_minor", "vendor", "model", "uuid", "domain", "mac_addr", "scheme", "path"}
+
+// Create a new MDNS discoverer, set MacOverride to empty string for browsing on all multicast capable NICs.
+// MacOverride can be a comma seperated list of MAC addresses or interface names (e.g. eth0) for browsing on multiple NICs.
+// If the service registration failed, the error is returned with a host that can still scan but is not advertising.
+// Use IsAdvertising to check the broadcast status and Reregister to retry
+func NewMDNS(config NetworkHost, MacOverride string) (*MDNSHost, error) {
+	return NewMDNSWithAdvertiseIfaces(config, MacOverride, []string{})
+}
+
+// Create a new MDNS discoverer that only advertise on the interfaces with the given MAC addresses or names (e.g. eth0),
+// see NewMDNS for MacOverride. Set advertiseIfaces to empty slice for advertising on all interfaces
+func NewMDNSWithAdvertiseIfaces(config NetworkHost, MacOverride string, advertiseIfaces []string) (*MDNSHost, error) {
+	if config.ServiceType == "" {
+		config.ServiceType = DefaultServiceType
 	}
+	config.Scheme = normalizeScheme(config.Scheme)
+	config.BasePath = normalizeBasePath(config.BasePath)
 
-	//Discover the iface to override if exists
+	//Discover the ifaces to override if exists. See interfaces.go
 	var overrideIface *net.Interface = nil
+	scanIfaces := []net.Interface{}
 	if MacOverride != "" {
-		ifaceIp := ""
-		ifaces, err := net.Interfaces()
-		if err != nil {
-			log.Println("[mDNS] Unable to override iface MAC: " + err.Error() + ". Resuming with default iface")
+		scanIfaces = findIfaces(strings.Split(MacOverride, ","))
+		if len(scanIfaces) > 0 {
+			overrideIface = &scanIfaces[0]
+		}
+		if len(scanIfaces) < 2 {
+			//Single override mode, only browse on IfaceOverride
+			scanIfaces = []net.Interface{}
 		}
+	}
 
-		foundMatching := false
-		for _, iface := range ifaces {
-			thisIfaceMac := iface.HardwareAddr.String()
-			thisIfaceMac = strings.ReplaceAll(thisIfaceMac, ":", "-")
-			MacOverride = strings.ReplaceAll(MacOverride, ":", "-")
-			if strings.EqualFold(thisIfaceMac, strings.TrimSpace(MacOverride)) {
-				//This is the correct iface to use
-				overrideIface = &iface
-				addrs, err := iface.Addrs()
-
-				if err == nil && len(addrs) > 0 {
-					ifaceIp = addrs[0].String()
-				}
+	//Registration failure should not break discovery, the server is left nil
+	server, err := registerServer(&config, advertiseIfaces)
+	return &MDNSHost{
+		MDNS:            server,
+		Host:            &config,
+		IfaceOverride:   overrideIface,
+		ScanIfaces:      scanIfaces,
+		AdvertiseIfaces: advertiseIfaces,
+	}, err
+}
 
-				for _, addr := range addrs {
-					var ip net.IP
-					switch v := addr.(type) {
-					case *net.IPNet:
-						ip = v.IP
-					case *net.IPAddr:
-						ip = v.IP
-					}
-
-					if ip.To4() != nil {
-						//This NIC have Ipv4 addr
-						ifaceIp = ip.String()
-					}
-				}
-				foundMatching = true
-				break
-			}
-		}
+// Register the mds services on the selected interfaces, empty for all interfaces. Both IPv4 and IPv6 addresses of the host are announced by zeroconf
+func registerServer(config *NetworkHost, advertiseIfaces []string) (*zeroconf.Server, error) {
+	ifaces, err := getAdvertiseIfaces(advertiseIfaces)
+	if err != nil {
+		//Never fall back to all interfaces, the host should not be discoverable on the other networks
+		log.Println("[mDNS] Service not advertised: " + err.Error())
+		return nil, err
+	}
 
-		if !foundMatching {
-			log.Println("[mDNS] Unable to find the target iface with MAC address: " + MacOverride + ". Resuming with default iface")
-		} else {
-			log.Println("[mDNS] Entering force MAC address mode, listening on: " + MacOverride + "(IP address: " + ifaceIp + ")")
-		}
+	//Get host MAC Address
+	macAddress, err := getMacAddr()
+	if err != nil {
+		return nil, err
 	}
+	macAddressBoardcast := strings.Join(macAddress, ",")
 
-	return &MDNSHost{
-		MDNS:          server,
-		Host:          &config,
-		IfaceOverride: overrideIface,
-	}, nil
+	server, err := registerZeroconf(config.HostName, config.ServiceType, "local.", config.Port, buildTXTRecords(config, macAddressBoardcast), ifaces)
+	if err != nil {
+		log.Println("[mDNS] Error when registering zeroconf broadcast message", err.Error())
+		return nil, err
+	}
+	return server, nil
 }
 
+// Shutdown the mDNS broadcast. Safe to call multiple times or after re-registration
 func (m *MDNSHost) Close() {
-	if m != nil {
+	if m == nil {
+		return
+	}
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+	if m.MDNS != nil {
 		m.MDNS.Shutdown()
+		m.MDNS = nil
 	}
+}
 
+// Check if the mDNS service is currently advertised on the network
+func (m *MDNSHost) IsAdvertising() bool {
+	if m == nil {
+		return false
+	}
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+	return m.MDNS != nil
 }
 
 // Scan with given timeout and domain filter. Use m.Host.Domain for scanning similar typed devices
-func (m *MDNSHost) Scan(timeout int, domainFilter string) []*NetworkHost {
-	// Discover all services on the network (e.g. _workstation._tcp)
+func (m *MDNSHost) Scan(timeout int, domainFilter string) ([]*NetworkHost, error) {
+	return m.ScanUntil(timeout, domainFilter, 0)
+}
+
+// Scan until the context is done or MaxScanDuration is reached, whichever comes first. e.g. use the
+// request context in web handlers to stop scanning when the client disconnects.
+// If the context is cancelled, the hosts discovered so far are returned with the context error
+func (m *MDNSHost) ScanContext(ctx context.Context, domainFilter string) ([]*NetworkHost, error) {
+	if m == nil {
+		return []*NetworkHost{}, errors.New("mDNS host not initialized")
+	}
+	maxDuration := m.MaxScanDuration
+	if maxDuration <= 0 {
+		maxDuration = DefaultMaxScanDuration
+	}
+	scanCtx, cancel := context.WithTimeout(ctx, maxDuration)
+	defer cancel()
+	return m.scanContext(scanCtx, domainFilter, 0)
+}
 
-	var zcoption zeroconf.ClientOption = nil
-	if m.IfaceOverride != nil {
-		zcoption = zeroconf.SelectIfaces([]net.Interface{*m.IfaceOverride})
+// Scan until expectedCount unique hosts are discovered or the timeout is reached, whichever comes first.
+// Set expectedCount to 0 for always waiting the full timeout
+func (m *MDNSHost) ScanUntil(timeout int, domainFilter string, expectedCount int) ([]*NetworkHost, error) {
+	if m == nil {
+		return []*NetworkHost{}, errors.New("mDNS host not initialized")
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeout))
+	defer cancel()
+	return m.scanContext(ctx, domainFilter, expectedCount)
+}
 
-	resolver, err := zeroconf.NewResolver(zcoption)
+// Scan until the context is done or expectedCount unique hosts are discovered (0 for waiting until the context is done)
+func (m *MDNSHost) scanContext(parentCtx context.Context, domainFilter string, expectedCount int) ([]*NetworkHost, error) {
+	// Discover all services on the network (e.g. _workstation._tcp)
+	resolver, err := newResolver(m.getClientOption())
 	if err != nil {
-		log.Fatalln("Failed to initialize resolver:", err.Error())
+		log.Println("[mDNS] Failed to initialize resolver:", err.Error())
+		return []*NetworkHost{}, err
 	}
 
+	//Resolve each of the mDNS and pipe it back to the log functions
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	//Query the unicast seeds for hosts in other subnets alongside the multicast browse
+	unicastHosts := make(chan []*NetworkHost, 1)
+	go func() {
+		unicastHosts <- m.queryUnicastSeeds(ctx, domainFilter)
+	}()
+
 	entries := make(chan *zeroconf.ServiceEntry)
 	//Create go routine  to wait for the resolver
 
 	discoveredHost := []*NetworkHost{}
+	collectDone := make(chan bool)
 
 	go func(results <-chan *zeroconf.ServiceEntry) {
+		uniqueHosts := map[string]bool{}
 		for entry := range results {
-			if domainFilter == "" {
-				//This is a ArOZ Online Host
-				//Split the required information out of the text element
-				TEXT := entry.Text
-				properties := map[string]string{}
-				for _, v := range TEXT {
-					kv := strings.Split(v, "=")
-					if len(kv) == 2 {
-						properties[kv[0]] = kv[1]
-					}
-				}
+			if matchDomainFilter(entry, domainFilter) {
+				//This is a ArOZ Online Host or a generic scan request matching the domain
+				thisHost := newNetworkHostFromEntry(entry)
+				discoveredHost = append(discoveredHost, thisHost)
 
-				var macAddrs []string
-				val, ok := properties["mac_addr"]
-				if !ok || val == "" {
-					//No MacAddr found. Target node version too old
-					macAddrs = []string{}
-				} else {
-					macAddrs = strings.Split(properties["mac_addr"], ",")
+				//Stop browsing once enough hosts are found, the resolver close the channel afterward
+				uniqueHosts[getHostKey(thisHost)] = true
+				if expectedCount > 0 && len(uniqueHosts) >= expectedCount {
+					cancel()
 				}
+			}
+		}
+		close(collectDone)
+	}(entries)
 
-				//log.Println(properties)
-				discoveredHost = append(discoveredHost, &NetworkHost{
-					HostName:     entry.HostName,
-					Port:         entry.Port,
-					IPv4:         entry.AddrIPv4,
-					Domain:       properties["domain"],
-					Model:        properties["model"],
-					UUID:         properties["uuid"],
-					Vendor:       properties["vendor"],
-					BuildVersion: properties["version_build"],
-					MinorVersion: properties["version_minor"],
-					MacAddr:      macAddrs,
-					Online:       true,
-				})
-
-			} else {
-				if stringInSlice("domain="+domainFilter, entry.Text) {
-					//This is generic scan request
-					//Split the required information out of the text element
-					TEXT := entry.Text
-					properties := map[string]string{}
-					for _, v := range TEXT {
-						kv := strings.Split(v, "=")
-						if len(kv) == 2 {
-							properties[kv[0]] = kv[1]
-						}
-					}
-
-					var macAddrs []string
-					val, ok := properties["mac_addr"]
-					if !ok || val == "" {
-						//No MacAddr found. Target node version too old
-						macAddrs = []string{}
-					} else {
-						macAddrs = strings.Split(properties["mac_addr"], ",")
-					}
-
-					//log.Println(properties)
-					discoveredHost = append(discoveredHost, &NetworkHost{
-						HostName:     entry.HostName,
-						Port:         entry.Port,
-						IPv4:         entry.AddrIPv4,
-						Domain:       properties["domain"],
-						Model:        properties["model"],
-						UUID:         properties["uuid"],
-						Vendor:       properties["vendor"],
-						BuildVersion: properties["version_build"],
-						MinorVersion: properties["version_minor"],
-						MacAddr:      macAddrs,
-						Online:       true,
-					})
+	err = resolver.Browse(ctx, m.getServiceType(), "local.", entries)
+	if err != nil {
+		log.Println("[mDNS] Failed to browse:", err.Error())
+		return []*NetworkHost{}, err
+	}
 
-				}
+	//Wait until the resolver closes the result channel after timeout
+	<-collectDone
+	discoveredHost = append(discoveredHost, <-unicastHosts...)
+
+	//The same host might be announced multiple times via different interfaces
+	results := mergeNetworkHosts(discoveredHost)
+	if errors.Is(parentCtx.Err(), context.Canceled) {
+		//Cancelled by the caller, the partial results are not tracked
+		return results, parentCtx.Err()
+	}
+	m.trackHosts(results)
+	return results, nil
+}
+
+// Get the key to identify a host, use UUID, or MAC address if UUID is not broadcasted,
+// or HostName for hosts too old to broadcast either of them
+func getHostKey(host *NetworkHost) string {
+	if host.UUID != "" {
+		return host.UUID
+	}
+	if macAddrs := normalizeMacAddrs(host.MacAddr); len(macAddrs) > 0 {
+		//Use the smallest one so the key does not depend on the order of the list
+		sort.Strings(macAddrs)
+		return "mac:" + macAddrs[0]
+	}
+	return host.HostName
+}
+
+// Merge the duplicated hosts and their IP addresses, result is sorted by HostName
+func mergeNetworkHosts(hosts []*NetworkHost) []*NetworkHost {
+	mergedHosts := map[string]*NetworkHost{}
+	results := []*NetworkHost{}
+	for _, thisHost := range hosts {
+		hostKey := getHostKey(thisHost)
+		existingHost, ok := mergedHosts[hostKey]
+		if !ok {
+			mergedHosts[hostKey] = thisHost
+			results = append(results, thisHost)
+			continue
+		}
+
+		existingHost.IPv4 = mergeIPs(existingHost.IPv4, thisHost.IPv4)
+		existingHost.IPv6 = mergeIPs(existingHost.IPv6, thisHost.IPv6)
+		for _, mac := range thisHost.MacAddr {
+			if !stringInSlice(mac, existingHost.MacAddr) {
+				existingHost.MacAddr = append(existingHost.MacAddr, mac)
 			}
+		}
+	}
 
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].HostName != results[j].HostName {
+			return results[i].HostName < results[j].HostName
 		}
-	}(entries)
+		return getHostKey(results[i]) < getHostKey(results[j])
+	})
+	return results
+}
 
-	//Resolve each of the mDNS and pipe it back to the log functions
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeout))
-	defer cancel()
-	err = resolver.Browse(ctx, "_http._tcp", "local.", entries)
-	if err != nil {
-		log.Fatalln("Failed to browse:", err.Error())
+// Append the IPs that are not already in the list
+func mergeIPs(ips []net.IP, newIPs []net.IP) []net.IP {
+	for _, newIP := range newIPs {
+		exists := false
+		for _, ip := range ips {
+			if ip.Equal(newIP) {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			ips = append(ips, newIP)
+		}
+	}
+	return ips
+}
+
+// Get the service type to browse, default _http._tcp
+func (m *MDNSHost) getServiceType() string {
+	if m.Host == nil || m.Host.ServiceType == "" {
+		return DefaultServiceType
+	}
+	return m.Host.ServiceType
+}
+
+// Build the TXT records to advertise. Extra TXT records are appended in sorted order
+func buildTXTRecords(config *NetworkHost, macAddressBoardcast string) []string {
+	txtRecords := []string{"version_build=" + config.BuildVersion, "version_minor=" + config.MinorVersion, "vendor=" + config.Vendor, "model=" + config.Model, "uuid=" + config.UUID, "domain=" + config.Domain, "mac_addr=" + macAddressBoardcast, "scheme=" + normalizeScheme(config.Scheme)}
+	if basePath := normalizeBasePath(config.BasePath); basePath != "" {
+		txtRecords = append(txtRecords, "path="+basePath)
+	}
+	if config.Status != nil {
+		txtRecords = append(txtRecords, config.Status.txtRecords()...)
+	}
+
+	extraKeys := []string{}
+	for key := range config.ExtraTXT {
+		if stringInSlice(key, reservedTXTKeys) || stringInSlice(key, statusTXTKeys) {
+			log.Println("[mDNS] Extra TXT record " + key + " conflict with reserved key. Ignoring")
+			continue
+		}
+		extraKeys = append(extraKeys, key)
+	}
+	sort.Strings(extraKeys)
+
+	for _, key := range extraKeys {
+		txtRecords = append(txtRecords, key+"="+config.ExtraTXT[key])
+	}
+	return txtRecords
+}
+
+// Check if the entry match the domain filter, empty filter match all entries
+func matchDomainFilter(entry *zeroconf.ServiceEntry, domainFilter string) bool {
+	return domainFilter == "" || stringInSlice("domain="+domainFilter, entry.Text)
+}
+
+// Convert a zeroconf service entry into NetworkHost by splitting the required information out of the text element
+func newNetworkHostFromEntry(entry *zeroconf.ServiceEntry) *NetworkHost {
+	properties := map[string]string{}
+	for _, v := range entry.Text {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 {
+			properties[kv[0]] = kv[1]
+		}
 	}
+	rawTXT := parseRawTXT(entry.Text)
 
-	//Update the master scan record
-	<-ctx.Done()
-	return discoveredHost
+	extraTXT := map[string]string{}
+	for key, value := range properties {
+		if !stringInSlice(key, reservedTXTKeys) && !stringInSlice(key, statusTXTKeys) {
+			extraTXT[key] = value
+		}
+	}
+
+	var macAddrs []string
+	val, ok := properties["mac_addr"]
+	if !ok || val == "" {
+		//No MacAddr found. Target node version too old
+		macAddrs = []string{}
+	} else {
+		macAddrs = strings.Split(properties["mac_addr"], ",")
+	}
+
+	return &NetworkHost{
+		HostName:     entry.HostName,
+		Port:         entry.Port,
+		IPv4:         entry.AddrIPv4,
+		IPv6:         entry.AddrIPv6,
+		Domain:       properties["domain"],
+		Model:        properties["model"],
+		UUID:         properties["uuid"],
+		Vendor:       properties["vendor"],
+		BuildVersion: properties["version_build"],
+		MinorVersion: properties["version_minor"],
+		MacAddr:      macAddrs,
+		Online:       true,
+		Scheme:       normalizeScheme(properties["scheme"]),
+		BasePath:     normalizeBasePath(properties["path"]),
+		ServiceType:  entry.Service,
+		ExtraTXT:     extraTXT,
+		Status:       parseNodeStatus(properties),
+		TXT:          rawTXT,
+		IsArozos:     isArozosTXT(rawTXT),
+		Instance:     entry.Instance,
+	}
 }
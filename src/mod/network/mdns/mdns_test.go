@@ -0,0 +1,803 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+)
+
+func TestScanResolverInitFailure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	//Inject a resolver init failure
+	expectedErr := errors.New("resolver init failed")
+	newResolver = func(options ...zeroconf.ClientOption) (*zeroconf.Resolver, error) {
+		return nil, expectedErr
+	}
+	defer func() { newResolver = zeroconf.NewResolver }()
+
+	m := &MDNSHost{Host: &NetworkHost{}}
+	hosts, err := m.Scan(1, "")
+	if !errors.Is(err, expectedErr) {
+		t.Fatalf("Expected resolver error to be surfaced, got %v", err)
+	}
+	if len(hosts) != 0 {
+		t.Fatalf("Expected no hosts on failure, got %d", len(hosts))
+	}
+
+	err = m.ScanContinuous(context.Background(), nil, nil)
+	if !errors.Is(err, expectedErr) {
+		t.Fatalf("Expected resolver error to be surfaced in continuous scan, got %v", err)
+	}
+}
+
+func TestNetworkHostFromEntryDualStack(t *testing.T) {
+	entry := zeroconf.NewServiceEntry("test", "_http._tcp", "local.")
+	entry.HostName = "test.local."
+	entry.Port = 8080
+	entry.AddrIPv4 = []net.IP{net.ParseIP("192.168.1.10")}
+	entry.AddrIPv6 = []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::10")}
+	entry.Text = []string{"uuid=1234", "domain=arozos.com", "mac_addr=aa:bb:cc:dd:ee:ff"}
+
+	//Both the domain filtered and non-filtered path should populate the addresses
+	for _, domainFilter := range []string{"", "arozos.com"} {
+		if !matchDomainFilter(entry, domainFilter) {
+			t.Fatalf("Entry should match domain filter %q", domainFilter)
+		}
+		host := newNetworkHostFromEntry(entry)
+		if len(host.IPv4) != 1 || !host.IPv4[0].Equal(net.ParseIP("192.168.1.10")) {
+			t.Errorf("Unexpected IPv4 addresses: %v", host.IPv4)
+		}
+		if len(host.IPv6) != 2 || !host.IPv6[1].Equal(net.ParseIP("2001:db8::10")) {
+			t.Errorf("Unexpected IPv6 addresses: %v", host.IPv6)
+		}
+		if host.UUID != "1234" || host.Domain != "arozos.com" {
+			t.Errorf("Unexpected TXT properties: %+v", host)
+		}
+	}
+
+	if matchDomainFilter(entry, "hds.arozos.com") {
+		t.Errorf("Entry should not match a different domain filter")
+	}
+}
+
+func TestCustomServiceTypeAndTXT(t *testing.T) {
+	config := &NetworkHost{
+		UUID:     "1234",
+		Domain:   "arozos.com",
+		ExtraTXT: map[string]string{"region": "eu-west", "cluster": "a=b", "uuid": "override"},
+	}
+
+	log.SetOutput(io.Discard)
+	records := buildTXTRecords(config, "")
+	log.SetOutput(os.Stderr)
+
+	//Reserved keys cannot be overwritten and extra keys are sorted
+	expectedTail := []string{"cluster=a=b", "region=eu-west"}
+	if len(records) != 10 || records[8] != expectedTail[0] || records[9] != expectedTail[1] {
+		t.Fatalf("Unexpected TXT records: %v", records)
+	}
+
+	entry := zeroconf.NewServiceEntry("test", "_arozos._tcp", "local.")
+	entry.Text = records
+	host := newNetworkHostFromEntry(entry)
+	if host.UUID != "1234" || host.ServiceType != "_arozos._tcp" {
+		t.Errorf("Unexpected discovered host: %+v", host)
+	}
+	if host.ExtraTXT["cluster"] != "a=b" || host.ExtraTXT["region"] != "eu-west" || len(host.ExtraTXT) != 2 {
+		t.Errorf("Unexpected extra TXT records: %v", host.ExtraTXT)
+	}
+
+	m := &MDNSHost{Host: &NetworkHost{}}
+	if m.getServiceType() != DefaultServiceType {
+		t.Errorf("Expected default service type, got %s", m.getServiceType())
+	}
+}
+
+func TestVerifyReachability(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+
+	//Get a port with nothing listening on it
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	closedPort := closedListener.Addr().(*net.TCPAddr).Port
+	closedListener.Close()
+
+	reachable := &NetworkHost{HostName: "alive", Port: openPort, IPv4: []net.IP{net.ParseIP("127.0.0.1")}, Online: false}
+	unreachable := &NetworkHost{HostName: "crashed", Port: closedPort, IPv4: []net.IP{net.ParseIP("127.0.0.1")}, Online: true}
+	noAddr := &NetworkHost{HostName: "noaddr", Port: openPort, Online: true}
+
+	m := &MDNSHost{ProbeTimeout: time.Second, ProbeWorkers: 2}
+	m.VerifyReachability([]*NetworkHost{reachable, unreachable, noAddr})
+
+	if !reachable.Online {
+		t.Error("Expected reachable host to be online")
+	}
+	if unreachable.Online {
+		t.Error("Expected unreachable host to be offline")
+	}
+	if noAddr.Online {
+		t.Error("Expected host without address to be offline")
+	}
+}
+
+func TestMergeNetworkHosts(t *testing.T) {
+	newEntry := func(hostname string, uuid string, ipv4 string, ipv6 string) *zeroconf.ServiceEntry {
+		entry := zeroconf.NewServiceEntry(hostname, "_http._tcp", "local.")
+		entry.HostName = hostname + ".local."
+		entry.Port = 8080
+		entry.Text = []string{"uuid=" + uuid, "domain=arozos.com", "mac_addr=aa:bb:cc:dd:ee:ff"}
+		if ipv4 != "" {
+			entry.AddrIPv4 = []net.IP{net.ParseIP(ipv4)}
+		}
+		if ipv6 != "" {
+			entry.AddrIPv6 = []net.IP{net.ParseIP(ipv6)}
+		}
+		return entry
+	}
+
+	discovered := []*NetworkHost{
+		newNetworkHostFromEntry(newEntry("zeta", "uuid-1", "192.168.0.10", "")),
+		newNetworkHostFromEntry(newEntry("alpha", "uuid-2", "192.168.0.20", "")),
+		newNetworkHostFromEntry(newEntry("zeta", "uuid-1", "10.0.0.10", "fe80::1")),
+		newNetworkHostFromEntry(newEntry("zeta", "uuid-1", "192.168.0.10", "")), //Retransmission
+		newNetworkHostFromEntry(newEntry("legacy", "", "192.168.0.30", "")),
+		newNetworkHostFromEntry(newEntry("legacy", "", "192.168.0.31", "")),
+	}
+
+	hosts := mergeNetworkHosts(discovered)
+	if len(hosts) != 3 {
+		t.Fatalf("Expected 3 hosts after merge, got %d", len(hosts))
+	}
+
+	//Sorted by hostname
+	expectedOrder := []string{"alpha.local.", "legacy.local.", "zeta.local."}
+	for i, host := range hosts {
+		if host.HostName != expectedOrder[i] {
+			t.Errorf("Expected host %d to be %s, got %s", i, expectedOrder[i], host.HostName)
+		}
+	}
+
+	zeta := hosts[2]
+	if len(zeta.IPv4) != 2 || !zeta.IPv4[0].Equal(net.ParseIP("192.168.0.10")) || !zeta.IPv4[1].Equal(net.ParseIP("10.0.0.10")) {
+		t.Errorf("Unexpected merged IPv4 addresses: %v", zeta.IPv4)
+	}
+	if len(zeta.IPv6) != 1 || !zeta.IPv6[0].Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("Unexpected merged IPv6 addresses: %v", zeta.IPv6)
+	}
+	if len(zeta.MacAddr) != 1 {
+		t.Errorf("Expected MAC addresses to be deduplicated, got %v", zeta.MacAddr)
+	}
+
+	//Hosts without UUID are merged by MAC address
+	if len(hosts[1].IPv4) != 2 {
+		t.Errorf("Expected legacy host to have 2 IPv4 addresses, got %v", hosts[1].IPv4)
+	}
+}
+
+func TestInterfaceFingerprint(t *testing.T) {
+	original := listInterfaceAddrs
+	defer func() { listInterfaceAddrs = original }()
+
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("192.168.0.20"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(8, 32)},
+	}
+	listInterfaceAddrs = func(iface *net.Interface) ([]net.Addr, error) {
+		return addrs, nil
+	}
+
+	m := &MDNSHost{}
+	before, _ := m.getInterfaceFingerprint()
+
+	//Order of the addresses should not matter
+	addrs[0], addrs[1] = addrs[1], addrs[0]
+	reordered, _ := m.getInterfaceFingerprint()
+	if before != reordered {
+		t.Errorf("Expected same fingerprint for reordered addresses, got %q and %q", before, reordered)
+	}
+
+	addrs[0] = &net.IPNet{IP: net.ParseIP("192.168.0.21"), Mask: net.CIDRMask(24, 32)}
+	after, _ := m.getInterfaceFingerprint()
+	if before == after {
+		t.Error("Expected fingerprint to change after address change")
+	}
+
+	//Close should be safe without a running server and when called twice
+	m.Close()
+	m.Close()
+}
+
+func TestFindIfacesByMAC(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	original := listInterfaces
+	defer func() { listInterfaces = original }()
+
+	mac1, _ := net.ParseMAC("00:11:22:33:44:55")
+	mac2, _ := net.ParseMAC("66:77:88:99:aa:bb")
+	listInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Index: 1, Name: "eth0", HardwareAddr: mac1},
+			{Index: 2, Name: "eth1", HardwareAddr: mac2},
+		}, nil
+	}
+
+	//Both : and - seperated MAC addresses are accepted, unknown MAC are ignored
+	ifaces := findIfaces([]string{"66-77-88-99-AA-BB", " 00:11:22:33:44:55", "de:ad:be:ef:00:00"})
+	if len(ifaces) != 2 || ifaces[0].Name != "eth1" || ifaces[1].Name != "eth0" {
+		t.Fatalf("Expected eth1 and eth0, got %v", ifaces)
+	}
+
+	//Interface names can be mixed with MAC addresses
+	ifaces = findIfaces([]string{"eth1", "00:11:22:33:44:55", "en0"})
+	if len(ifaces) != 2 || ifaces[0].Name != "eth1" || ifaces[1].Name != "eth0" {
+		t.Fatalf("Expected eth1 and eth0 by name and MAC, got %v", ifaces)
+	}
+
+	m := &MDNSHost{}
+	if m.getClientOption() != nil {
+		t.Error("Expected browsing on all interfaces without override")
+	}
+	if err := m.SetScanInterfacesByMAC([]string{"de:ad:be:ef:00:00"}); err == nil {
+		t.Error("Expected error when no interface matches")
+	}
+	if err := m.SetScanInterfacesByMAC([]string{"00:11:22:33:44:55", "66:77:88:99:aa:bb"}); err != nil || len(m.ScanIfaces) != 2 {
+		t.Errorf("Expected 2 scan interfaces, got %v: %v", m.ScanIfaces, err)
+	}
+	if m.getClientOption() == nil {
+		t.Error("Expected client option selecting the scan interfaces")
+	}
+}
+
+func TestFilterByMinVersion(t *testing.T) {
+	if CompareVersion("0.2.10", "0.2.9") != 1 || CompareVersion("0.2.021", "0.2.21") != 0 || CompareVersion("1.2", "1.2.1") != -1 {
+		t.Error("Numeric version segments compared incorrectly")
+	}
+
+	hosts := []*NetworkHost{
+		{HostName: "old", BuildVersion: "1", MinorVersion: "0.2.9"},
+		{HostName: "new", BuildVersion: "1", MinorVersion: "0.2.10"},
+		{HostName: "newer-build", BuildVersion: "2", MinorVersion: "0.0.1"},
+		{HostName: "unversioned"},
+	}
+
+	filtered := FilterByMinVersion(hosts, VersionFilter{MinBuildVersion: "1", MinMinorVersion: "0.2.10"})
+	if len(filtered) != 3 || filtered[0].HostName != "new" || filtered[1].HostName != "newer-build" || filtered[2].HostName != "unversioned" {
+		t.Errorf("Unexpected filter result: %d hosts", len(filtered))
+	}
+
+	filtered = FilterByMinVersion(hosts, VersionFilter{MinBuildVersion: "1", MinMinorVersion: "0.2.10", ExcludeUnversioned: true})
+	if len(filtered) != 2 {
+		t.Errorf("Expected unversioned host to be excluded, got %d hosts", len(filtered))
+	}
+}
+
+func TestCachedScan(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	//Count the real scans by the resolver init calls
+	scanCount := 0
+	newResolver = func(options ...zeroconf.ClientOption) (*zeroconf.Resolver, error) {
+		scanCount++
+		return nil, errors.New("no network in test")
+	}
+	defer func() { newResolver = zeroconf.NewResolver }()
+
+	m := &MDNSHost{Host: &NetworkHost{}}
+
+	//Failed scans are not cached
+	if _, err := m.CachedScan(time.Minute, 1, ""); err == nil || scanCount != 1 {
+		t.Fatalf("Expected a real scan with error, got %v after %d scans", err, scanCount)
+	}
+
+	//Fresh cache entries are returned without scanning
+	m.cache.entries = map[string]*scanCacheEntry{
+		"": {Hosts: []*NetworkHost{{HostName: "cached"}}, ScanTime: time.Now()},
+	}
+	hosts, err := m.CachedScan(time.Minute, 1, "")
+	if err != nil || len(hosts) != 1 || hosts[0].HostName != "cached" || scanCount != 1 {
+		t.Fatalf("Expected cached result, got %v %v after %d scans", hosts, err, scanCount)
+	}
+
+	//Modifying the returned slice must not change the cache
+	hosts[0] = nil
+	if hosts, _ := m.CachedScan(time.Minute, 1, ""); hosts[0] == nil {
+		t.Fatal("Expected cache to be isolated from the returned slice")
+	}
+
+	//Expired or invalidated entries trigger a real scan
+	m.CachedScan(0, 1, "")
+	m.InvalidateScanCache()
+	m.CachedScan(time.Minute, 1, "")
+	if scanCount != 3 {
+		t.Errorf("Expected 3 real scans, got %d", scanCount)
+	}
+}
+
+func TestSchemeTXT(t *testing.T) {
+	config := &NetworkHost{Port: 8443, Scheme: "HTTPS", BasePath: "arozos/"}
+	entry := zeroconf.NewServiceEntry("secure", DefaultServiceType, "local.")
+	entry.HostName = "secure.local."
+	entry.Port = config.Port
+	entry.Text = buildTXTRecords(config, "")
+
+	host := newNetworkHostFromEntry(entry)
+	if host.Scheme != SchemeHTTPS || host.BasePath != "/arozos" {
+		t.Errorf("Unexpected scheme or base path: %s %s", host.Scheme, host.BasePath)
+	}
+	if host.URL() != "https://secure.local:8443/arozos" {
+		t.Errorf("Unexpected URL: %s", host.URL())
+	}
+
+	//Older nodes without the scheme record default to http
+	legacyEntry := zeroconf.NewServiceEntry("legacy", DefaultServiceType, "local.")
+	legacyEntry.Port = 8080
+	legacyEntry.AddrIPv4 = []net.IP{net.ParseIP("192.168.0.30")}
+	legacyEntry.Text = []string{"uuid=legacy"}
+	legacy := newNetworkHostFromEntry(legacyEntry)
+	if legacy.Scheme != SchemeHTTP || legacy.BasePath != "" || legacy.URL() != "http://192.168.0.30:8080" {
+		t.Errorf("Unexpected legacy host: %s %s %s", legacy.Scheme, legacy.BasePath, legacy.URL())
+	}
+}
+
+func TestDiagnoseSelfDiscovery(t *testing.T) {
+	originalIfaces, originalAddrs := listInterfaces, interfaceAddrs
+	defer func() { listInterfaces, interfaceAddrs = originalIfaces, originalAddrs }()
+	listInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{{Name: "eth0"}, {Name: "wlan0"}}, nil
+	}
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+		if iface.Name == "eth0" {
+			return []net.Addr{&net.IPNet{IP: net.ParseIP("192.168.0.10"), Mask: net.CIDRMask(24, 32)}}, nil
+		}
+		return []net.Addr{&net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(8, 32)}}, nil
+	}
+
+	m := &MDNSHost{Host: &NetworkHost{UUID: "self", HostName: "self"}}
+	other := &NetworkHost{UUID: "other", IPv4: []net.IP{net.ParseIP("192.168.0.20")}}
+	self := &NetworkHost{UUID: "self", IPv4: []net.IP{net.ParseIP("192.168.0.10")}}
+
+	result := m.diagnoseSelfDiscovery([]*NetworkHost{other, self})
+	if !result.Found || result.OtherHosts != 1 {
+		t.Fatalf("Expected self to be found with 1 other host, got %+v", result)
+	}
+	if len(result.SeenOn) != 1 || result.SeenOn[0] != "eth0" {
+		t.Errorf("Expected self to be seen on eth0, got %v", result.SeenOn)
+	}
+
+	result = m.diagnoseSelfDiscovery([]*NetworkHost{other})
+	if result.Found || result.OtherHosts != 1 || result.Diagnostic == "" {
+		t.Errorf("Expected self not found with diagnostic, got %+v", result)
+	}
+
+	result = m.diagnoseSelfDiscovery(nil)
+	if result.Found || result.OtherHosts != 0 || !strings.Contains(result.Diagnostic, "Multicast") {
+		t.Errorf("Expected multicast diagnostic, got %+v", result)
+	}
+}
+
+func TestUnicastSeeds(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Unable to listen on UDP: %v", err)
+	}
+
+	//Fake responder answering the PTR query without address records
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(req)
+		service := DefaultServiceType + ".local."
+		instance := "remote." + service
+		reply.Answer = append(reply.Answer, &dns.PTR{Hdr: dns.RR_Header{Name: service, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120}, Ptr: instance})
+		reply.Extra = append(reply.Extra,
+			&dns.SRV{Hdr: dns.RR_Header{Name: instance, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 120}, Target: "remote.local.", Port: 8080},
+			&dns.TXT{Hdr: dns.RR_Header{Name: instance, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 120}, Txt: []string{"uuid=remote", "domain=arozos.com"}},
+		)
+		w.WriteMsg(reply)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	m := &MDNSHost{Host: &NetworkHost{}}
+	if err := m.SetUnicastSeeds([]string{"dns://"}); err == nil {
+		t.Error("Expected invalid seed to be rejected")
+	}
+	if err := m.SetUnicastSeeds([]string{conn.LocalAddr().String(), " "}); err != nil || len(m.UnicastSeeds) != 1 {
+		t.Fatalf("Failed to set unicast seeds: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	hosts := m.queryUnicastSeeds(ctx, "arozos.com")
+	if len(hosts) != 1 {
+		t.Fatalf("Expected 1 host from unicast seed, got %d", len(hosts))
+	}
+	if hosts[0].UUID != "remote" || hosts[0].Port != 8080 || len(hosts[0].IPv4) != 1 || !hosts[0].IPv4[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Unexpected host from unicast seed: %+v", hosts[0])
+	}
+	if hosts := m.queryUnicastSeeds(ctx, "example.com"); len(hosts) != 0 {
+		t.Errorf("Expected domain filter to apply on unicast results, got %d hosts", len(hosts))
+	}
+
+	seed, err := parseUnicastSeed("dns://10.0.0.1/example.com")
+	if err != nil || seed.Addr != "10.0.0.1:53" || seed.Domain != "example.com." {
+		t.Errorf("Unexpected DNS-SD seed: %+v %v", seed, err)
+	}
+}
+
+func TestRegistrationFailure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	//Inject a registration failure
+	expectedErr := errors.New("multicast not supported")
+	registerZeroconf = func(instance, service, domain string, port int, text []string, ifaces []net.Interface) (*zeroconf.Server, error) {
+		return nil, expectedErr
+	}
+	defer func() { registerZeroconf = zeroconf.Register }()
+	scanCount := 0
+	newResolver = func(options ...zeroconf.ClientOption) (*zeroconf.Resolver, error) {
+		scanCount++
+		return nil, errors.New("no network in test")
+	}
+	defer func() { newResolver = zeroconf.NewResolver }()
+
+	m, err := NewMDNS(NetworkHost{HostName: "test", Port: 8080, Domain: "arozos.com"}, "")
+	if err != expectedErr {
+		t.Fatalf("Expected registration error, got %v", err)
+	}
+	if m == nil || m.Host == nil || m.Host.ServiceType != DefaultServiceType || m.Host.Domain != "arozos.com" {
+		t.Fatalf("Expected an initialized host on registration failure, got %+v", m)
+	}
+	if m.IsAdvertising() {
+		t.Error("Expected host not to be advertising")
+	}
+
+	//Discovery still goes through the resolver
+	if _, err := m.Scan(1, m.Host.Domain); err == nil || scanCount != 1 {
+		t.Errorf("Expected scan to reach the resolver, got %v after %d scans", err, scanCount)
+	}
+	if _, err := m.SelfTest(1); err == nil {
+		t.Error("Expected self test to fail without broadcast")
+	}
+
+	//Failed re-registration keep the host usable, close is safe to call repeatedly
+	if err := m.Reregister(*m.Host); err != expectedErr || m.Host.HostName != "test" || m.IsAdvertising() {
+		t.Errorf("Unexpected re-registration result: %v", err)
+	}
+	m.Close()
+	m.Close()
+
+	//Nil host should not panic
+	var nilHost *MDNSHost
+	nilHost.Close()
+	if nilHost.IsAdvertising() {
+		t.Error("Expected nil host not to be advertising")
+	}
+	if _, err := nilHost.Scan(1, ""); err == nil {
+		t.Error("Expected scan on nil host to fail")
+	}
+}
+
+func TestAdvertiseIfaces(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	original := listInterfaces
+	defer func() { listInterfaces = original }()
+	mac1, _ := net.ParseMAC("00:11:22:33:44:55")
+	mac2, _ := net.ParseMAC("66:77:88:99:aa:bb")
+	listInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Index: 1, Name: "eth0", HardwareAddr: mac1},
+			{Index: 2, Name: "tun0", HardwareAddr: mac2},
+		}, nil
+	}
+
+	var registeredIfaces []net.Interface
+	registerCount := 0
+	registerZeroconf = func(instance, service, domain string, port int, text []string, ifaces []net.Interface) (*zeroconf.Server, error) {
+		registerCount++
+		registeredIfaces = ifaces
+		return nil, errors.New("no network in test")
+	}
+	defer func() { registerZeroconf = zeroconf.Register }()
+
+	//All interfaces by default
+	NewMDNS(NetworkHost{HostName: "test", Port: 8080}, "")
+	if registerCount != 1 || registeredIfaces != nil {
+		t.Errorf("Expected registration on all interfaces by default, got %v", registeredIfaces)
+	}
+
+	//Bind to the selected interface only
+	m, _ := NewMDNSWithAdvertiseIfaces(NetworkHost{HostName: "test", Port: 8080}, "", []string{"00:11:22:33:44:55"})
+	if registerCount != 2 || len(registeredIfaces) != 1 || registeredIfaces[0].Name != "eth0" {
+		t.Errorf("Expected registration on eth0 only, got %v", registeredIfaces)
+	}
+	m.Reregister(*m.Host)
+	if len(registeredIfaces) != 1 || registeredIfaces[0].Name != "eth0" {
+		t.Errorf("Expected re-registration on eth0 only, got %v", registeredIfaces)
+	}
+
+	//Never fall back to all interfaces if the selected interface is gone
+	registerCount = 0
+	_, err := NewMDNSWithAdvertiseIfaces(NetworkHost{HostName: "test", Port: 8080}, "", []string{"wlan0"})
+	if err == nil || registerCount != 0 {
+		t.Errorf("Expected no registration without matching interface, got %v after %d registrations", err, registerCount)
+	}
+}
+
+func TestScanContextCancel(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	m := &MDNSHost{Host: &NetworkHost{Domain: "arozos.com"}, MaxScanDuration: 10 * time.Second}
+	if _, err := newResolver(m.getClientOption()); err != nil {
+		t.Skip("Multicast not available: " + err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	startTime := time.Now()
+	_, err := m.ScanContext(ctx, "arozos.com")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancelled scan error, got %v", err)
+	}
+	if time.Since(startTime) > 5*time.Second {
+		t.Errorf("Expected scan stopped on cancel, took %v", time.Since(startTime))
+	}
+}
+
+func TestBuildTopology(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	onlineHost := &NetworkHost{
+		HostName: "online",
+		Port:     listener.Addr().(*net.TCPAddr).Port,
+		IPv4:     []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")},
+	}
+	offlineHost := &NetworkHost{
+		HostName: "offline",
+		Port:     1,
+		IPv4:     []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	m := &MDNSHost{Host: &NetworkHost{HostName: "local"}, ProbeTimeout: 500 * time.Millisecond}
+	topology := m.BuildTopology([]*NetworkHost{onlineHost, nil, offlineHost})
+	if len(topology.Nodes) != 2 || topology.LocalHost.HostName != "local" {
+		t.Fatalf("Unexpected topology: %+v", topology)
+	}
+
+	node := topology.Nodes[0]
+	if !node.Host.Online || node.RTT < 0 || node.LocalIP != "127.0.0.1" || node.Interface == "" {
+		t.Errorf("Expected online host reached via loopback, got %+v", node)
+	}
+	if len(node.Links) != 2 || node.Links[0].Reachable || !node.Links[1].Reachable || node.Links[1].Interface != node.Interface {
+		t.Errorf("Expected only the second address to be reachable, got %+v %+v", node.Links[0], node.Links[1])
+	}
+	if onlineHost.Online {
+		t.Error("Expected the given host not to be modified")
+	}
+
+	node = topology.Nodes[1]
+	if node.Host.Online || node.RTT != -1 || node.Interface == "" {
+		t.Errorf("Expected offline host linked to an interface without RTT, got %+v", node)
+	}
+}
+
+func TestNewLocalNetworkHost(t *testing.T) {
+	originalIfaces, originalAddrs := listInterfaces, interfaceAddrs
+	defer func() { listInterfaces, interfaceAddrs = originalIfaces, originalAddrs }()
+	listInterfaces = func() ([]net.Interface, error) {
+		return []net.Interface{
+			{Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+			{Name: "eth0", Flags: net.FlagUp, HardwareAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}},
+			{Name: "eth1", HardwareAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x66}},
+		}, nil
+	}
+	interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+		switch iface.Name {
+		case "lo":
+			return []net.Addr{&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}}, nil
+		case "eth0":
+			return []net.Addr{
+				&net.IPNet{IP: net.ParseIP("192.168.0.10"), Mask: net.CIDRMask(24, 32)},
+				&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+				&net.IPNet{IP: net.ParseIP("2001:db8::10"), Mask: net.CIDRMask(64, 128)},
+			}, nil
+		}
+		//Interface down
+		return []net.Addr{&net.IPNet{IP: net.ParseIP("10.0.0.5"), Mask: net.CIDRMask(8, 32)}}, nil
+	}
+
+	host, err := NewLocalNetworkHost(NetworkHost{HostName: "self", UUID: "self-uuid", Vendor: "imuslab", BuildVersion: "2.0"})
+	if err != nil {
+		t.Fatalf("Failed to build local host: %v", err)
+	}
+	if host.HostName != "self" || host.UUID != "self-uuid" || host.Vendor != "imuslab" || host.BuildVersion != "2.0" {
+		t.Errorf("Expected identity from caller to be kept, got %+v", host)
+	}
+	if len(host.IPv4) != 1 || !host.IPv4[0].Equal(net.ParseIP("192.168.0.10")) {
+		t.Errorf("Unexpected IPv4 addresses %v", host.IPv4)
+	}
+	if len(host.IPv6) != 1 || !host.IPv6[0].Equal(net.ParseIP("2001:db8::10")) {
+		t.Errorf("Unexpected IPv6 addresses %v", host.IPv6)
+	}
+	if len(host.MacAddr) != 2 || host.MacAddr[0] != "00:11:22:33:44:55" {
+		t.Errorf("Unexpected MAC addresses %v", host.MacAddr)
+	}
+	if host.ServiceType != DefaultServiceType || host.Scheme != SchemeHTTP || !host.Online {
+		t.Errorf("Expected defaults to be filled, got %+v", host)
+	}
+}
+
+func TestTrackHostAddressChange(t *testing.T) {
+	changes := []string{}
+	m := &MDNSHost{Host: &NetworkHost{}}
+	m.OnHostAddressChanged = func(host *NetworkHost, previousIPv4 []net.IP, previousIPv6 []net.IP) {
+		changes = append(changes, getHostKey(host)+" "+previousIPv4[0].String()+" => "+host.IPv4[0].String())
+	}
+
+	newHost := func(uuid string, mac string, ipv4 ...string) *NetworkHost {
+		host := &NetworkHost{HostName: "node.local.", UUID: uuid, MacAddr: []string{mac}}
+		for _, ip := range ipv4 {
+			host.IPv4 = append(host.IPv4, net.ParseIP(ip))
+		}
+		return host
+	}
+
+	m.trackHosts([]*NetworkHost{newHost("uuid-1", "aa:bb:cc:dd:ee:01", "192.168.0.10"), newHost("", "AA:BB:CC:DD:EE:02", "192.168.0.20")})
+	if len(changes) != 0 {
+		t.Fatalf("Expected no change on first scan, got %v", changes)
+	}
+
+	//Same addresses in different order is not a change
+	m.trackHosts([]*NetworkHost{newHost("uuid-1", "aa:bb:cc:dd:ee:01", "192.168.0.10")})
+	m.trackHosts([]*NetworkHost{newHost("uuid-1", "aa:bb:cc:dd:ee:01", "10.0.0.1", "192.168.0.10")})
+	m.trackHosts([]*NetworkHost{newHost("uuid-1", "aa:bb:cc:dd:ee:01", "192.168.0.10", "10.0.0.1")})
+	if len(changes) != 1 || changes[0] != "uuid-1 192.168.0.10 => 10.0.0.1" {
+		t.Fatalf("Expected a single address change, got %v", changes)
+	}
+
+	//Host without UUID is matched by MAC address, regardless of the case and other MACs
+	changes = []string{}
+	noUUID := newHost("", "aa:bb:cc:dd:ee:02", "192.168.0.21")
+	noUUID.MacAddr = append([]string{"aa:bb:cc:dd:ee:00"}, noUUID.MacAddr...)
+	m.trackHosts([]*NetworkHost{noUUID})
+	if len(changes) != 1 || !strings.HasSuffix(changes[0], "192.168.0.20 => 192.168.0.21") {
+		t.Errorf("Expected address change of host without UUID, got %v", changes)
+	}
+	if getHostKey(newHost("", "AA:BB:CC:DD:EE:02")) != "mac:aa:bb:cc:dd:ee:02" {
+		t.Errorf("Unexpected host key %s", getHostKey(newHost("", "AA:BB:CC:DD:EE:02")))
+	}
+}
+
+func TestNodeStatusTXTRecords(t *testing.T) {
+	config := &NetworkHost{UUID: "uuid-1", Domain: "arozos.com", ExtraTXT: map[string]string{"load": "0", "role": "storage"}}
+	m := &MDNSHost{Host: config}
+	if err := m.SetStatus(NodeStatus{CPULoad: 150, FreeStorage: 2048, UserCount: 3}); err != nil {
+		t.Fatalf("Failed to set status: %v", err)
+	}
+	if config.Status != nil || m.Host.Status == nil || m.Host.Status.CPULoad != 100 {
+		t.Fatalf("Expected status set on a new host config with load capped, got %+v", m.Host.Status)
+	}
+
+	entry := zeroconf.NewServiceEntry("node", "_http._tcp", "local.")
+	entry.Text = buildTXTRecords(m.Host, "aa:bb:cc:dd:ee:ff")
+	if !stringInSlice("load=100", entry.Text) || stringInSlice("load=0", entry.Text) {
+		t.Errorf("Expected status records not overwritten by extra records, got %v", entry.Text)
+	}
+
+	host := newNetworkHostFromEntry(entry)
+	if host.Status == nil || *host.Status != (NodeStatus{CPULoad: 100, FreeStorage: 2048, UserCount: 3}) {
+		t.Errorf("Unexpected parsed status %+v", host.Status)
+	}
+	if _, ok := host.ExtraTXT["load"]; ok || host.ExtraTXT["role"] != "storage" {
+		t.Errorf("Expected status records excluded from extra records, got %v", host.ExtraTXT)
+	}
+
+	//Older nodes do not advertise their status
+	entry.Text = []string{"uuid=uuid-2", "domain=arozos.com"}
+	if newNetworkHostFromEntry(entry).Status != nil {
+		t.Error("Expected nil status for hosts not advertising it")
+	}
+}
+
+func TestGenericServiceHosts(t *testing.T) {
+	printer := zeroconf.NewServiceEntry("Office Printer", "_ipp._tcp", "local.")
+	printer.HostName = "printer.local."
+	printer.AddrIPv4 = []net.IP{net.ParseIP("192.168.1.20")}
+	printer.Text = []string{"ty=LaserJet 400", "color=T", "duplex", "ty=ignored"}
+
+	node := zeroconf.NewServiceEntry("arozos", "_http._tcp", "local.")
+	node.HostName = "node.local."
+	node.Text = []string{"uuid=1234", "domain=arozos.com", "model=Generic"}
+
+	printerHost := newNetworkHostFromEntry(printer)
+	if printerHost.IsArozos || printerHost.Instance != "Office Printer" || printerHost.ServiceType != "_ipp._tcp" {
+		t.Errorf("Unexpected generic host: %+v", printerHost)
+	}
+	if printerHost.TXT["ty"] != "LaserJet 400" || printerHost.TXT["color"] != "T" {
+		t.Errorf("Unexpected raw TXT: %v", printerHost.TXT)
+	}
+	if value, ok := printerHost.TXT["duplex"]; !ok || value != "" {
+		t.Errorf("Expected boolean TXT attribute to be kept, got %v", printerHost.TXT)
+	}
+
+	nodeHost := newNetworkHostFromEntry(node)
+	if !nodeHost.IsArozos || nodeHost.TXT["model"] != "Generic" || nodeHost.UUID != "1234" {
+		t.Errorf("Unexpected arozos host: %+v", nodeHost)
+	}
+
+	//Announcements via different interfaces are merged within a service type only
+	printerAgain := newNetworkHostFromEntry(printer)
+	printerAgain.IPv4 = []net.IP{net.ParseIP("10.0.0.20")}
+	printerWeb := newNetworkHostFromEntry(printer)
+	printerWeb.ServiceType = "_http._tcp"
+	results := mergeServiceHosts([][]*NetworkHost{
+		{printerHost, printerAgain},
+		{nodeHost, newNetworkHostFromEntry(node), printerWeb},
+	})
+	if len(results) != 3 || results[0].HostName != "node.local." || results[2].ServiceType != "_ipp._tcp" || len(results[2].IPv4) != 2 {
+		t.Fatalf("Unexpected merged services: %+v", results)
+	}
+}
+
+func TestScanServicesResolverInitFailure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	expectedErr := errors.New("resolver init failed")
+	newResolver = func(options ...zeroconf.ClientOption) (*zeroconf.Resolver, error) {
+		return nil, expectedErr
+	}
+	defer func() { newResolver = zeroconf.NewResolver }()
+
+	m := &MDNSHost{Host: &NetworkHost{}}
+	hosts, err := m.ScanServices(context.Background(), []string{"_ipp._tcp"})
+	if !errors.Is(err, expectedErr) || len(hosts) != 0 {
+		t.Fatalf("Expected resolver error to be surfaced, got %v %v", hosts, err)
+	}
+}
@@ -0,0 +1,71 @@
+package mdns
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	Reachability Check
+
+	A host might have announced itself and crashed afterward while
+	its mDNS record is still cached by other nodes. This script
+	verify the discovered hosts by opening a TCP connection to
+	the advertised port on each of their addresses.
+*/
+
+const (
+	defaultProbeTimeout = 2 * time.Second
+	defaultProbeWorkers = 16
+)
+
+// Check if the hosts are reachable on their advertised port and update their Online state in place
+func (m *MDNSHost) VerifyReachability(hosts []*NetworkHost) {
+	timeout := m.ProbeTimeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	workers := m.ProbeWorkers
+	if workers <= 0 {
+		workers = defaultProbeWorkers
+	}
+	if workers > len(hosts) {
+		workers = len(hosts)
+	}
+
+	jobs := make(chan *NetworkHost)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range jobs {
+				host.Online = probeHost(host, timeout)
+			}
+		}()
+	}
+
+	for _, host := range hosts {
+		if host != nil {
+			jobs <- host
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Try to connect to the host port on any of its addresses, return true if one of them accept the connection
+func probeHost(host *NetworkHost, timeout time.Duration) bool {
+	addrs := append(append([]net.IP{}, host.IPv4...), host.IPv6...)
+	for _, ip := range addrs {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(host.Port)), timeout)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
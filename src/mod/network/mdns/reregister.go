@@ -0,0 +1,120 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	mDNS Re-registration
+
+	zeroconf resolve the host addresses only once when the service
+	is registered. When the IP of the host changed (DHCP renewal,
+	interface up / down), the service has to be registered again
+	so the new addresses are advertised.
+
+	The interface watcher poll the interface addresses and trigger
+	re-registration automatically when they change.
+*/
+
+// Interface address lister, replaceable for testing
+var listInterfaceAddrs = func(iface *net.Interface) ([]net.Addr, error) {
+	if iface != nil {
+		return iface.Addrs()
+	}
+	return net.InterfaceAddrs()
+}
+
+const defaultWatchInterval = 10 * time.Second
+
+// Shutdown the current broadcast and register again with the given config and fresh interface / IP info.
+// zeroconf send a goodbye packet on shutdown using the same instance name, so the new server is only
+// registered after the old one is gone to prevent the goodbye from removing it from the peers' cache.
+// If the registration failed, the previous config is restored
+func (m *MDNSHost) Reregister(config NetworkHost) error {
+	if m == nil {
+		return errors.New("mDNS host not initialized")
+	}
+	if config.ServiceType == "" {
+		config.ServiceType = DefaultServiceType
+	}
+
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+
+	if m.MDNS != nil {
+		m.MDNS.Shutdown()
+		m.MDNS = nil
+	}
+
+	server, err := registerServer(&config, m.AdvertiseIfaces)
+	if err != nil {
+		if m.Host != nil {
+			//Try to bring back the previous broadcast
+			previousServer, restoreErr := registerServer(m.Host, m.AdvertiseIfaces)
+			if restoreErr == nil {
+				m.MDNS = previousServer
+			}
+		}
+		return err
+	}
+
+	m.MDNS = server
+	m.Host = &config
+	log.Println("[mDNS] Service re-registered as " + config.HostName)
+	return nil
+}
+
+// Watch the interface addresses and re-register the service when they change. Block until the context is cancelled.
+// Set interval to 0 for using the default interval of 10 seconds
+func (m *MDNSHost) WatchInterfaceChanges(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	lastFingerprint, _ := m.getInterfaceFingerprint()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fingerprint, err := m.getInterfaceFingerprint()
+			if err != nil || fingerprint == lastFingerprint {
+				continue
+			}
+
+			log.Println("[mDNS] Interface address changed. Re-registering mDNS service")
+			if m.Host == nil {
+				continue
+			}
+			err = m.Reregister(*m.Host)
+			if err != nil {
+				log.Println("[mDNS] Unable to re-register mDNS service: " + err.Error())
+				continue
+			}
+			lastFingerprint = fingerprint
+		}
+	}
+}
+
+// Get a sorted list of addresses on the watched interfaces for change detection
+func (m *MDNSHost) getInterfaceFingerprint() (string, error) {
+	addrs, err := listInterfaceAddrs(m.IfaceOverride)
+	if err != nil {
+		return "", err
+	}
+
+	addrStrings := []string{}
+	for _, addr := range addrs {
+		addrStrings = append(addrStrings, addr.String())
+	}
+	sort.Strings(addrStrings)
+	return strings.Join(addrStrings, ","), nil
+}
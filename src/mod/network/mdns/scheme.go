@@ -0,0 +1,48 @@
+package mdns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+	Connection Scheme
+
+	The scheme (http / https) and base path of the web interface are advertised
+	in the TXT records, so clients can build the URL of a discovered host directly.
+	Older nodes do not advertise them and are assumed to serve http on the root path.
+*/
+
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// Return the scheme in lower case, default to http if empty or unknown
+func normalizeScheme(scheme string) string {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	if scheme != SchemeHTTPS {
+		return SchemeHTTP
+	}
+	return scheme
+}
+
+// Return the base path with a leading slash and without trailing slash, root path is empty
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// Get the URL to connect to the host, using the first IPv4 address or the hostname if no IPv4 address is known
+func (h *NetworkHost) URL() string {
+	host := strings.TrimSuffix(h.HostName, ".")
+	if len(h.IPv4) > 0 {
+		host = h.IPv4[0].String()
+	}
+
+	return normalizeScheme(h.Scheme) + "://" + net.JoinHostPort(host, strconv.Itoa(h.Port)) + normalizeBasePath(h.BasePath)
+}
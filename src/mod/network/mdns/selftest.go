@@ -0,0 +1,155 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Self Discovery Test
+
+	Scan the network and check if the announcement of this host can be
+	discovered, for debugging why other nodes cannot see this host.
+
+	zeroconf answer the queries with the addresses of the interface the
+	query is received on, so the local interfaces seeing this host are
+	resolved by matching the discovered addresses with the interface addresses.
+*/
+
+type SelfTestResult struct {
+	Found          bool     //If this host is discovered in the scan
+	UUID           string   //UUID of this host
+	HostName       string   //Host name of this host
+	ScanInterfaces []string //Interfaces browsed, empty for all multicast capable interfaces
+	SeenOn         []string //Local interfaces this host is discovered on
+	Addresses      []string //Addresses announced in the discovered record of this host
+	OtherHosts     int      //Number of other hosts discovered in the same domain
+	Diagnostic     string   //Human readable result of the test
+}
+
+// Interface address lister, replaceable for testing
+var interfaceAddrs = func(iface net.Interface) ([]net.Addr, error) {
+	return iface.Addrs()
+}
+
+// Scan with the given timeout in seconds and check if this host is discoverable
+func (m *MDNSHost) SelfTest(timeout int) (*SelfTestResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	return m.SelfTestContext(ctx)
+}
+
+// Scan until the context is done and check if this host is discoverable, see ScanContext
+func (m *MDNSHost) SelfTestContext(ctx context.Context) (*SelfTestResult, error) {
+	if m == nil || m.Host == nil {
+		return nil, errors.New("mDNS host not initialized")
+	}
+	if !m.IsAdvertising() {
+		return nil, errors.New("mDNS broadcast is not running")
+	}
+
+	hosts, err := m.ScanContext(ctx, m.Host.Domain)
+	if err != nil {
+		return nil, err
+	}
+	return m.diagnoseSelfDiscovery(hosts), nil
+}
+
+// Build the self test result from the scan results
+func (m *MDNSHost) diagnoseSelfDiscovery(hosts []*NetworkHost) *SelfTestResult {
+	result := SelfTestResult{
+		UUID:           m.Host.UUID,
+		HostName:       m.Host.HostName,
+		ScanInterfaces: []string{},
+		SeenOn:         []string{},
+		Addresses:      []string{},
+	}
+
+	if len(m.ScanIfaces) > 0 {
+		for _, iface := range m.ScanIfaces {
+			result.ScanInterfaces = append(result.ScanInterfaces, iface.Name)
+		}
+	} else if m.IfaceOverride != nil {
+		result.ScanInterfaces = append(result.ScanInterfaces, m.IfaceOverride.Name)
+	}
+
+	var selfHost *NetworkHost
+	for _, host := range hosts {
+		if host == nil {
+			continue
+		}
+		if selfHost == nil && m.isSelf(host) {
+			selfHost = host
+			continue
+		}
+		result.OtherHosts++
+	}
+
+	if selfHost == nil {
+		if result.OtherHosts > 0 {
+			result.Diagnostic = "Other hosts are discovered but not this host. The broadcast of this host might be blocked by its firewall or sent on another interface"
+		} else {
+			result.Diagnostic = "No mDNS response received. Multicast traffic might be blocked by the firewall or not forwarded by the network"
+		}
+		return &result
+	}
+
+	result.Found = true
+	announcedIPs := append(append([]net.IP{}, selfHost.IPv4...), selfHost.IPv6...)
+	for _, ip := range announcedIPs {
+		result.Addresses = append(result.Addresses, ip.String())
+	}
+	result.SeenOn = findIfacesWithIPs(announcedIPs)
+	if len(result.SeenOn) > 0 {
+		result.Diagnostic = "This host is discoverable via mDNS on " + strings.Join(result.SeenOn, ", ")
+	} else {
+		result.Diagnostic = "This host is discoverable via mDNS, but none of the announced addresses belong to a local interface"
+	}
+	return &result
+}
+
+// Check if the discovered host is this host, by UUID or by host name if UUID is not set
+func (m *MDNSHost) isSelf(host *NetworkHost) bool {
+	if m.Host.UUID != "" {
+		return host.UUID == m.Host.UUID
+	}
+	return strings.TrimSuffix(host.HostName, ".") == strings.TrimSuffix(m.Host.HostName, ".")
+}
+
+// Get the names of the local interfaces owning any of the given IPs, sorted by name
+func findIfacesWithIPs(ips []net.IP) []string {
+	results := []string{}
+	ifaces, err := listInterfaces()
+	if err != nil {
+		return results
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := interfaceAddrs(iface)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if ok && ipInList(ipnet.IP, ips) {
+				results = append(results, iface.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(results)
+	return results
+}
+
+func ipInList(ip net.IP, list []net.IP) bool {
+	for _, thisIP := range list {
+		if thisIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
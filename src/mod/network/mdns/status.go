@@ -0,0 +1,113 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	Node Status
+
+	Advertise the current load of this node in its TXT records so clients
+	can route to the least loaded node. The TXT records are updated on the
+	live server (zeroconf SetText announce the new records right away),
+	the service is not re-registered. The records are kept short as
+
+	load=<CPU usage in percent>
+	free=<free storage in MB>
+	users=<number of users>
+
+	Hosts not advertising their status (e.g. older nodes) have nil Status
+*/
+
+type NodeStatus struct {
+	CPULoad     int   //CPU usage in percent, 0 - 100
+	FreeStorage int64 //Free storage in MB
+	UserCount   int   //Number of user accounts
+}
+
+// TXT record keys of the node status, cannot be overwritten by ExtraTXT
+var statusTXTKeys = []string{"load", "free", "users"}
+
+const defaultStatusInterval = 60 * time.Second
+
+// Get the TXT records of the status
+func (s *NodeStatus) txtRecords() []string {
+	return []string{
+		"load=" + strconv.Itoa(s.CPULoad),
+		"free=" + strconv.FormatInt(s.FreeStorage, 10),
+		"users=" + strconv.Itoa(s.UserCount),
+	}
+}
+
+// Parse the node status from the TXT record properties, return nil if the host does not advertise its status
+func parseNodeStatus(properties map[string]string) *NodeStatus {
+	if _, ok := properties["load"]; !ok {
+		return nil
+	}
+	status := NodeStatus{}
+	status.CPULoad, _ = strconv.Atoi(properties["load"])
+	status.FreeStorage, _ = strconv.ParseInt(properties["free"], 10, 64)
+	status.UserCount, _ = strconv.Atoi(properties["users"])
+	return &status
+}
+
+// Update the advertised status of this host without re-registering the service
+func (m *MDNSHost) SetStatus(status NodeStatus) error {
+	if m == nil || m.Host == nil {
+		return errors.New("mDNS host not initialized")
+	}
+	if status.CPULoad < 0 {
+		status.CPULoad = 0
+	} else if status.CPULoad > 100 {
+		status.CPULoad = 100
+	}
+
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+
+	//Replace the host config so readers of the previous one are not affected
+	updatedHost := *m.Host
+	updatedHost.Status = &status
+	m.Host = &updatedHost
+
+	if m.MDNS == nil {
+		//Not advertising, the status is announced on next registration
+		return nil
+	}
+	macAddress, err := getMacAddr()
+	if err != nil {
+		return err
+	}
+	m.MDNS.SetText(buildTXTRecords(&updatedHost, strings.Join(macAddress, ",")))
+	return nil
+}
+
+// Update the advertised status with the one returned by getStatus periodically. Block until the context is cancelled.
+// Set interval to 0 for using the default interval of 60 seconds. The TXT records are only announced if the status changed
+func (m *MDNSHost) AdvertiseStatus(ctx context.Context, interval time.Duration, getStatus func() NodeStatus) {
+	if interval <= 0 {
+		interval = defaultStatusInterval
+	}
+
+	var lastStatus *NodeStatus
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status := getStatus()
+		if lastStatus == nil || *lastStatus != status {
+			if m.SetStatus(status) == nil {
+				lastStatus = &status
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package mdns
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	Network Topology
+
+	Package the discovered hosts for drawing a network map. Each host is
+	linked to the local interface it is reached from, i.e. the interface
+	the host routes through for each of its addresses, and the round trip
+	time of a TCP connection to its advertised port.
+
+	Multicast discovery only reach the hosts on the same link, so the
+	interface of a host found by multicast scan is the one that saw it.
+	Hosts found via unicast seeds are linked to the interface of the route
+	toward them.
+*/
+
+type LocalInterface struct {
+	Name    string   //Name of the interface, e.g. eth0
+	MacAddr string   //MAC address of the interface
+	IPs     []string //IP addresses of the interface in CIDR notation
+}
+
+type HostLink struct {
+	RemoteIP  string  //Address of the host
+	Interface string  //Name of the local interface reaching this address, empty if no route
+	LocalIP   string  //Address of the local interface used to reach this address
+	Reachable bool    //The advertised port accept connection via this address
+	RTT       float64 //TCP connect time in milliseconds, -1 if not reachable or not probed
+}
+
+type TopologyNode struct {
+	Host      *NetworkHost
+	Interface string      //Name of the local interface the host is reached from, empty if unknown
+	LocalIP   string      //Address of the local interface the host is reached from
+	RTT       float64     //TCP connect time in milliseconds via the reachable address, -1 if not reachable
+	Links     []*HostLink //Link of each address of the host
+}
+
+type Topology struct {
+	LocalHost  *NetworkHost      //This host
+	Interfaces []*LocalInterface //Local interfaces browsed on
+	Nodes      []*TopologyNode   //Discovered hosts, in the order given
+	ProbeTime  int64             //Time of the reachability probe
+}
+
+// Route lookup for getting the local address toward a remote address, replaceable for testing.
+// Dialing UDP does not send any packet, it only select the route
+var dialRoute = func(remoteIP net.IP) (net.IP, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(remoteIP.String(), "9"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Build the topology of the given hosts by probing each of them. The given hosts are not modified
+func (m *MDNSHost) BuildTopology(hosts []*NetworkHost) *Topology {
+	timeout := m.ProbeTimeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	workers := m.ProbeWorkers
+	if workers <= 0 {
+		workers = defaultProbeWorkers
+	}
+	if workers > len(hosts) {
+		workers = len(hosts)
+	}
+
+	localIfaces, _ := listInterfaces()
+	topology := Topology{
+		LocalHost:  m.Host,
+		Interfaces: m.getTopologyInterfaces(localIfaces),
+		Nodes:      make([]*TopologyNode, len(hosts)),
+		ProbeTime:  time.Now().Unix(),
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				topology.Nodes[index] = newTopologyNode(hosts[index], localIfaces, timeout)
+			}
+		}()
+	}
+
+	for i, host := range hosts {
+		if host != nil {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	//Skip the nil hosts
+	nodes := []*TopologyNode{}
+	for _, node := range topology.Nodes {
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	topology.Nodes = nodes
+	return &topology
+}
+
+// Probe the host and link each of its addresses to the local interface reaching it
+func newTopologyNode(host *NetworkHost, localIfaces []net.Interface, timeout time.Duration) *TopologyNode {
+	hostCopy := *host
+	node := TopologyNode{
+		Host:  &hostCopy,
+		RTT:   -1,
+		Links: []*HostLink{},
+	}
+
+	for _, ip := range append(append([]net.IP{}, host.IPv4...), host.IPv6...) {
+		thisLink := HostLink{
+			RemoteIP: ip.String(),
+			RTT:      -1,
+		}
+		if localIP, err := dialRoute(ip); err == nil {
+			thisLink.LocalIP = localIP.String()
+			thisLink.Interface = findIfaceNameByIP(localIfaces, localIP)
+		}
+
+		//Stop probing once the host is confirmed reachable, like VerifyReachability
+		if !hostCopy.Online {
+			start := time.Now()
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(host.Port)), timeout)
+			if err == nil {
+				conn.Close()
+				thisLink.Reachable = true
+				thisLink.RTT = float64(time.Since(start).Microseconds()) / 1000
+				hostCopy.Online = true
+				node.RTT = thisLink.RTT
+				node.Interface = thisLink.Interface
+				node.LocalIP = thisLink.LocalIP
+			}
+		}
+		node.Links = append(node.Links, &thisLink)
+	}
+
+	//Unreachable host, use the first address with a route to draw the link
+	if !hostCopy.Online {
+		for _, thisLink := range node.Links {
+			if thisLink.Interface != "" {
+				node.Interface = thisLink.Interface
+				node.LocalIP = thisLink.LocalIP
+				break
+			}
+		}
+	}
+	return &node
+}
+
+// Get the name of the interface with the given IP address, empty string if not found
+func findIfaceNameByIP(ifaces []net.Interface, ip net.IP) string {
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+// Get the interfaces browsed on, all up and multicast capable interfaces if not selected
+func (m *MDNSHost) getTopologyInterfaces(localIfaces []net.Interface) []*LocalInterface {
+	ifaces := m.ScanIfaces
+	if len(ifaces) == 0 && m.IfaceOverride != nil {
+		ifaces = []net.Interface{*m.IfaceOverride}
+	}
+	if len(ifaces) == 0 {
+		for _, iface := range localIfaces {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+
+	results := []*LocalInterface{}
+	for _, iface := range ifaces {
+		thisIface := LocalInterface{
+			Name:    iface.Name,
+			MacAddr: iface.HardwareAddr.String(),
+			IPs:     []string{},
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			thisIface.IPs = append(thisIface.IPs, addr.String())
+		}
+		results = append(results, &thisIface)
+	}
+	return results
+}
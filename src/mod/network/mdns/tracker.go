@@ -0,0 +1,98 @@
+package mdns
+
+import (
+	"net"
+	"sync"
+)
+
+/*
+	Host Tracking
+
+	Keep the identity of the discovered hosts across successive Scan and
+	ScanContinuous calls, so a host that got a new IP (e.g. renewed DHCP
+	lease) is reported via OnHostAddressChanged as the same host with
+	changed addresses, instead of a lost host plus a new one.
+
+	Hosts are identified by their UUID, or their MAC addresses if the UUID
+	is not broadcasted. The tracked record of the host is updated in place
+	with the new addresses. Hosts with neither UUID nor MAC address are
+	identified by hostname, see getHostKey.
+*/
+
+type hostTracker struct {
+	hosts map[string]*NetworkHost //Tracked hosts by host key
+	mutex sync.Mutex
+}
+
+// Update the tracked addresses of the scanned hosts and notify OnHostAddressChanged for the changed ones
+func (m *MDNSHost) trackHosts(hosts []*NetworkHost) {
+	type addressChange struct {
+		host         *NetworkHost
+		previousIPv4 []net.IP
+		previousIPv6 []net.IP
+	}
+	changes := []addressChange{}
+
+	m.tracker.mutex.Lock()
+	if m.tracker.hosts == nil {
+		m.tracker.hosts = map[string]*NetworkHost{}
+	}
+	for _, thisHost := range hosts {
+		trackedHost := m.tracker.find(thisHost)
+		if trackedHost == nil {
+			hostCopy := *thisHost
+			hostCopy.IPv4 = append([]net.IP{}, thisHost.IPv4...)
+			hostCopy.IPv6 = append([]net.IP{}, thisHost.IPv6...)
+			m.tracker.hosts[getHostKey(thisHost)] = &hostCopy
+			continue
+		}
+
+		if sameIPs(trackedHost.IPv4, thisHost.IPv4) && sameIPs(trackedHost.IPv6, thisHost.IPv6) {
+			continue
+		}
+		changes = append(changes, addressChange{
+			host:         thisHost,
+			previousIPv4: trackedHost.IPv4,
+			previousIPv6: trackedHost.IPv6,
+		})
+		trackedHost.IPv4 = append([]net.IP{}, thisHost.IPv4...)
+		trackedHost.IPv6 = append([]net.IP{}, thisHost.IPv6...)
+		trackedHost.HostName = thisHost.HostName
+		trackedHost.MacAddr = thisHost.MacAddr
+	}
+	m.tracker.mutex.Unlock()
+
+	//Notify outside the lock so the callback can scan again
+	if m.OnHostAddressChanged == nil {
+		return
+	}
+	for _, change := range changes {
+		m.OnHostAddressChanged(change.host, change.previousIPv4, change.previousIPv6)
+	}
+}
+
+// Find the tracked record of the host by its key, or any of its MAC addresses if the UUID is not broadcasted. Caller must hold the mutex
+func (t *hostTracker) find(host *NetworkHost) *NetworkHost {
+	if trackedHost, ok := t.hosts[getHostKey(host)]; ok {
+		return trackedHost
+	}
+	if host.UUID != "" {
+		return nil
+	}
+	for _, trackedHost := range t.hosts {
+		if trackedHost.UUID != "" {
+			continue
+		}
+		for _, mac := range host.MacAddr {
+			if mac != "" && stringInSlice(normalizeMacAddr(mac), normalizeMacAddrs(trackedHost.MacAddr)) {
+				return trackedHost
+			}
+		}
+	}
+	return nil
+}
+
+// Check if the two lists contain the same addresses, in any order
+func sameIPs(ips []net.IP, otherIPs []net.IP) bool {
+	return len(mergeIPs(append([]net.IP{}, ips...), otherIPs)) == len(ips) && len(mergeIPs(append([]net.IP{}, otherIPs...), ips)) == len(otherIPs)
+}
@@ -0,0 +1,190 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+)
+
+/*
+	Unicast Discovery
+
+	Multicast mDNS does not cross routed networks (e.g. VLANs). Hosts in other
+	subnets can be discovered by sending unicast queries to a list of seeds,
+	the results are merged with the multicast scan results. Accepted seeds
+
+	10.0.1.5 or 10.0.1.5:5353 => unicast mDNS query to a host, default port 5353
+	dns://10.0.0.1/example.com => DNS-SD query to a DNS server for the given domain, default port 53
+
+	If a seed reply without address records, the seed address is used.
+*/
+
+const (
+	defaultUnicastMDNSPort = "5353"
+	defaultDNSSDPort       = "53"
+)
+
+type unicastSeed struct {
+	Addr   string //Address of the seed with port
+	Domain string //Domain to query, e.g. local.
+}
+
+// Set the seeds for unicast discovery, set to empty for multicast only
+func (m *MDNSHost) SetUnicastSeeds(seeds []string) error {
+	validSeeds := []string{}
+	for _, seed := range seeds {
+		seed = strings.TrimSpace(seed)
+		if seed == "" {
+			continue
+		}
+		if _, err := parseUnicastSeed(seed); err != nil {
+			return err
+		}
+		validSeeds = append(validSeeds, seed)
+	}
+	m.UnicastSeeds = validSeeds
+	return nil
+}
+
+// Parse the seed into its address and domain to query
+func parseUnicastSeed(seed string) (*unicastSeed, error) {
+	if strings.HasPrefix(seed, "dns://") {
+		u, err := url.Parse(seed)
+		if err != nil || u.Hostname() == "" {
+			return nil, errors.New("invalid DNS-SD seed: " + seed)
+		}
+		domain := strings.Trim(u.Path, "/")
+		if domain == "" {
+			return nil, errors.New("missing domain in DNS-SD seed: " + seed)
+		}
+		port := u.Port()
+		if port == "" {
+			port = defaultDNSSDPort
+		}
+		return &unicastSeed{Addr: net.JoinHostPort(u.Hostname(), port), Domain: dns.Fqdn(domain)}, nil
+	}
+
+	host, port, err := net.SplitHostPort(seed)
+	if err != nil {
+		//No port given
+		host, port = strings.Trim(seed, "[]"), defaultUnicastMDNSPort
+	}
+	if host == "" {
+		return nil, errors.New("invalid seed: " + seed)
+	}
+	return &unicastSeed{Addr: net.JoinHostPort(host, port), Domain: "local."}, nil
+}
+
+// Query all the unicast seeds in parallel, seeds that failed or timeout are ignored
+func (m *MDNSHost) queryUnicastSeeds(ctx context.Context, domainFilter string) []*NetworkHost {
+	results := []*NetworkHost{}
+	var resultsMutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, seed := range m.UnicastSeeds {
+		thisSeed, err := parseUnicastSeed(seed)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(seed *unicastSeed) {
+			defer wg.Done()
+			entries, err := queryUnicastSeed(ctx, seed, m.getServiceType())
+			if err != nil {
+				return
+			}
+			resultsMutex.Lock()
+			defer resultsMutex.Unlock()
+			for _, entry := range entries {
+				if matchDomainFilter(entry, domainFilter) {
+					results = append(results, newNetworkHostFromEntry(entry))
+				}
+			}
+		}(thisSeed)
+	}
+	wg.Wait()
+	return results
+}
+
+// Send a PTR query for the service to the seed and convert the reply into service entries
+func queryUnicastSeed(ctx context.Context, seed *unicastSeed, serviceType string) ([]*zeroconf.ServiceEntry, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(serviceType+"."+seed.Domain, dns.TypePTR)
+	query.RecursionDesired = seed.Domain != "local."
+	if seed.Domain == "local." {
+		//Ask the mDNS responder to reply by unicast
+		query.Question[0].Qclass |= 1 << 15
+	}
+
+	client := dns.Client{Net: "udp"}
+	reply, _, err := client.ExchangeContext(ctx, query, seed.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	//mDNS responders might only answer with their service records, the address is the seed itself
+	var seedIP net.IP
+	if seed.Domain == "local." {
+		host, _, _ := net.SplitHostPort(seed.Addr)
+		seedIP = net.ParseIP(host)
+	}
+	return parseServiceReply(reply, serviceType, seed.Domain, seedIP), nil
+}
+
+// Convert the PTR, SRV, TXT and address records in the reply into service entries
+func parseServiceReply(reply *dns.Msg, serviceType string, domain string, fallbackIP net.IP) []*zeroconf.ServiceEntry {
+	records := append(append([]dns.RR{}, reply.Answer...), reply.Extra...)
+	serviceName := serviceType + "." + domain
+
+	addrIPv4 := map[string][]net.IP{}
+	addrIPv6 := map[string][]net.IP{}
+	srvRecords := map[string]*dns.SRV{}
+	txtRecords := map[string][]string{}
+	instances := []string{}
+	for _, record := range records {
+		switch rr := record.(type) {
+		case *dns.PTR:
+			if strings.EqualFold(rr.Hdr.Name, serviceName) && !stringInSlice(rr.Ptr, instances) {
+				instances = append(instances, rr.Ptr)
+			}
+		case *dns.SRV:
+			srvRecords[rr.Hdr.Name] = rr
+		case *dns.TXT:
+			txtRecords[rr.Hdr.Name] = rr.Txt
+		case *dns.A:
+			addrIPv4[rr.Hdr.Name] = mergeIPs(addrIPv4[rr.Hdr.Name], []net.IP{rr.A})
+		case *dns.AAAA:
+			addrIPv6[rr.Hdr.Name] = mergeIPs(addrIPv6[rr.Hdr.Name], []net.IP{rr.AAAA})
+		}
+	}
+
+	entries := []*zeroconf.ServiceEntry{}
+	for _, instance := range instances {
+		srv, ok := srvRecords[instance]
+		if !ok {
+			//Cannot connect to a service without its port
+			continue
+		}
+		instanceName := strings.TrimSuffix(instance, "."+serviceName)
+		entry := zeroconf.NewServiceEntry(instanceName, serviceType, domain)
+		entry.HostName = srv.Target
+		entry.Port = int(srv.Port)
+		entry.Text = txtRecords[instance]
+		entry.AddrIPv4 = addrIPv4[srv.Target]
+		entry.AddrIPv6 = addrIPv6[srv.Target]
+		if len(entry.AddrIPv4) == 0 && len(entry.AddrIPv6) == 0 && fallbackIP != nil {
+			if fallbackIP.To4() != nil {
+				entry.AddrIPv4 = []net.IP{fallbackIP}
+			} else {
+				entry.AddrIPv6 = []net.IP{fallbackIP}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,98 @@
+package mdns
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+	Version Filter
+
+	Filter the scanned hosts by the build and minor version advertised
+	in their TXT records, e.g. only keep hosts at or above 0.2.021
+
+	Versions are compared segment by segment (seperated by "." or "-").
+	Numeric segments are compared by value, so 10 is newer than 9.
+*/
+
+type VersionFilter struct {
+	MinBuildVersion    string //Minimum build version, empty to accept any
+	MinMinorVersion    string //Minimum minor version when the build version is equal, empty to accept any
+	ExcludeUnversioned bool   //Exclude hosts that do not advertise a version (older nodes)
+}
+
+// Return the hosts that match the version filter, the order of hosts is kept
+func FilterByMinVersion(hosts []*NetworkHost, filter VersionFilter) []*NetworkHost {
+	results := []*NetworkHost{}
+	for _, host := range hosts {
+		if host.MeetsMinVersion(filter) {
+			results = append(results, host)
+		}
+	}
+	return results
+}
+
+// Check if the host is at or above the minimum version of the filter
+func (n *NetworkHost) MeetsMinVersion(filter VersionFilter) bool {
+	if n.BuildVersion == "" && n.MinorVersion == "" {
+		return !filter.ExcludeUnversioned
+	}
+
+	if filter.MinBuildVersion != "" {
+		result := CompareVersion(n.BuildVersion, filter.MinBuildVersion)
+		if result > 0 {
+			return true
+		} else if result < 0 {
+			return false
+		}
+	}
+
+	if filter.MinMinorVersion != "" {
+		return CompareVersion(n.MinorVersion, filter.MinMinorVersion) >= 0
+	}
+	return true
+}
+
+// Compare two version strings. Return 1 if a is newer than b, -1 if a is older than b or 0 if equal
+func CompareVersion(a string, b string) int {
+	segmentsA := splitVersion(a)
+	segmentsB := splitVersion(b)
+	for i := 0; i < len(segmentsA) || i < len(segmentsB); i++ {
+		//Missing segments are treated as 0, e.g. 1.2 == 1.2.0
+		segA, segB := "0", "0"
+		if i < len(segmentsA) {
+			segA = segmentsA[i]
+		}
+		if i < len(segmentsB) {
+			segB = segmentsB[i]
+		}
+
+		numA, errA := strconv.Atoi(segA)
+		numB, errB := strconv.Atoi(segB)
+		if errA == nil && errB == nil {
+			if numA != numB {
+				if numA > numB {
+					return 1
+				}
+				return -1
+			}
+			continue
+		}
+
+		//Non numeric segment, compare as text
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func splitVersion(version string) []string {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" {
+		return []string{}
+	}
+	return strings.FieldsFunc(version, func(r rune) bool {
+		return r == '.' || r == '-'
+	})
+}
@@ -99,7 +99,11 @@ func (d *Discoverer) StartScanning(interval int, scanDuration int) {
 
 func (d *Discoverer) UpdateScan(scanDuration int) {
 	d.LastScanningTime = time.Now().Unix()
-	results := d.Host.Scan(scanDuration, d.Host.Host.Domain)
+	results, err := d.Host.Scan(scanDuration, d.Host.Host.Domain)
+	if err != nil {
+		log.Println("[Neighbour] Unable to scan nearby hosts: " + err.Error())
+		return
+	}
 	d.NearbyHosts = results
 
 	//Record all scanned host into database
@@ -108,6 +112,9 @@ func (d *Discoverer) UpdateScan(scanDuration int) {
 		for _, ipaddr := range thisHost.IPv4 {
 			thisHostIpString = append(thisHostIpString, ipaddr.String())
 		}
+		for _, ipaddr := range thisHost.IPv6 {
+			thisHostIpString = append(thisHostIpString, ipaddr.String())
+		}
 		thisHostRecord := HostRecord{
 			Name:       thisHost.HostName,
 			Model:      thisHost.Model,
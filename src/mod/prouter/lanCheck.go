@@ -4,7 +4,8 @@ import (
 	"bytes"
 	"net"
 	"net/http"
-	"strings"
+
+	"imuslab.com/arozos/mod/network"
 )
 
 type ipRange struct {
@@ -44,47 +45,19 @@ var privateRanges = []ipRange{
 }
 
 func checkIfLAN(r *http.Request) bool {
-	PredictedClientIP := []net.IP{}
-	forwarded := r.Header.Get("X-FORWARDED-FOR")
-	rip := r.Header.Get("X-Real-Ip") //Not that kind of RIP
-	if forwarded != "" {
-		ips := strings.Split(forwarded, ", ")
-		for _, ip := range ips {
-			PredictedClientIP = append(PredictedClientIP, net.ParseIP(strings.TrimSpace(ip)))
-		}
-	} else if rip != "" {
-		PredictedClientIP = append(PredictedClientIP, net.ParseIP(strings.TrimSpace(rip)))
-	} else {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-
-		} else {
-			userIP := net.ParseIP(ip)
-			PredictedClientIP = append(PredictedClientIP, userIP)
-		}
+	//Proxy headers are only honored from trusted proxies, see network/clientip.go
+	clientIP, err := network.GetIpFromRequest(r)
+	if err != nil {
+		return false
 	}
+	userIP := net.ParseIP(clientIP)
 
 	//Check if localhost loopback
-	if len(PredictedClientIP) == 1 {
-		onlyAddr := PredictedClientIP[0].String()
-		if onlyAddr == "127.0.0.1" {
-			return true
-		} else if onlyAddr == "::1" {
-			return true
-		} else if onlyAddr == "localhost" {
-			return true
-		}
-	}
-
-	IsLocal := true
-	for _, thisIP := range PredictedClientIP {
-		thisIpIsPrivate := isPrivateSubnet(thisIP)
-		if thisIpIsPrivate == false {
-			IsLocal = false
-		}
+	if userIP.IsLoopback() {
+		return true
 	}
 
-	return IsLocal
+	return isPrivateSubnet(userIP)
 }
 
 func isPrivateSubnet(ipAddress net.IP) bool {
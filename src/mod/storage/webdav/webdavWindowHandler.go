@@ -8,15 +8,13 @@ package webdav
 */
 
 import (
-	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"path/filepath"
-	"strings"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
+	"imuslab.com/arozos/mod/network"
 )
 
 //Handle request from Windows File Explorer
@@ -137,31 +135,6 @@ func (s *Server) HandleWindowClientAccess(w http.ResponseWriter, r *http.Request
 }
 
 func getIP(r *http.Request) (string, error) {
-	//Get IP from the X-REAL-IP header
-	ip := r.Header.Get("X-REAL-IP")
-	netIP := net.ParseIP(ip)
-	if netIP != nil {
-		return ip, nil
-	}
-
-	//Get IP from X-FORWARDED-FOR header
-	ips := r.Header.Get("X-FORWARDED-FOR")
-	splitIps := strings.Split(ips, ",")
-	for _, ip := range splitIps {
-		netIP := net.ParseIP(ip)
-		if netIP != nil {
-			return ip, nil
-		}
-	}
-
-	//Get IP from RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "", err
-	}
-	netIP = net.ParseIP(ip)
-	if netIP != nil {
-		return ip, nil
-	}
-	return "", fmt.Errorf("No valid ip found")
+	//Proxy headers are only honored from trusted proxies, see network/clientip.go
+	return network.GetIpFromRequest(r)
 }
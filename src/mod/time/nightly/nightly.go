@@ -1,6 +1,17 @@
 package nightly
 
-import "time"
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"imuslab.com/arozos/mod/utils"
+)
 
 /*
 	Nightly.go
@@ -9,42 +20,259 @@ import "time"
 	This module handles tasks that have to be done every night
 	like updating all user storage capacity and clean trash etc
 
+	Tasks run at the nightly task run time plus their offset. Tasks
+	registered without offset run at the run time in registration order,
+	or are spread evenly across the spread window if it is set, so the heavy
+	tasks on busy servers do not all fire at once.
 */
 
+type NightlyTask struct {
+	Name      string        //Name of the task for listing, default to the function name
+	Offset    time.Duration //Delay after the nightly task run time
+	NextRun   time.Time     //Next scheduled run of this task
+	hasOffset bool          //Offset is given on registration and not spread by the manager
+	task      func()
+}
+
 type TaskManager struct {
-	NightlTasks []func()
+	runTime      int           //Hour of the day to start the nightly tasks
+	spreadWindow time.Duration //Spread the tasks without offset across this duration after the run time, 0 to run them together
+	tasks        []*NightlyTask
+	reschedule   chan bool
+	mutex        sync.Mutex
 }
 
 func NewNightlyTaskManager(nightlyTaskRunTime int) *TaskManager {
 	//Create a new return structure
 	thisManager := TaskManager{
-		NightlTasks: []func(){},
+		runTime:    nightlyTaskRunTime,
+		tasks:      []*NightlyTask{},
+		reschedule: make(chan bool, 1),
 	}
+
 	//Start the nightly scheduler
-	go func(tm *TaskManager) {
-		t := time.Now()
-		n := time.Date(t.Year(), t.Month(), t.Day(), nightlyTaskRunTime, 0, 0, 0, t.Location())
-		d := n.Sub(t)
-		if d < 0 {
-			n = n.Add(24 * time.Hour)
-			d = n.Sub(t)
+	go thisManager.run()
+
+	return &thisManager
+}
+
+// Sleep until the earliest scheduled task and run all the tasks that are due
+func (tm *TaskManager) run() {
+	for {
+		wait := 24 * time.Hour
+		if nextRun := tm.NextRun(); !nextRun.IsZero() {
+			wait = time.Until(nextRun)
 		}
-		for {
-			time.Sleep(d)
-			d = 24 * time.Hour
-			tm.NightlyTaskRun()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			tm.runDueTasks(time.Now())
+		case <-tm.reschedule:
+			//Tasks or schedule changed, recalculate the next run
+			timer.Stop()
 		}
-	}(&thisManager)
+	}
+}
 
-	return &thisManager
+// Run the tasks that are due in order of their schedule and move them to the next night
+func (tm *TaskManager) runDueTasks(now time.Time) {
+	tm.mutex.Lock()
+	dueTasks := []*NightlyTask{}
+	for _, thisTask := range tm.tasks {
+		if !thisTask.NextRun.After(now) {
+			dueTasks = append(dueTasks, thisTask)
+		}
+	}
+	for _, thisTask := range dueTasks {
+		thisTask.NextRun = tm.getNextRunTime(thisTask.Offset, now)
+	}
+	tm.mutex.Unlock()
+
+	for _, thisTask := range dueTasks {
+		thisTask.task()
+	}
 }
 
+// Run all the nightly tasks now regardless of their schedule
 func (tm *TaskManager) NightlyTaskRun() {
-	for _, nightlyTask := range tm.NightlTasks {
-		nightlyTask()
+	tm.mutex.Lock()
+	tasks := append([]*NightlyTask{}, tm.tasks...)
+	tm.mutex.Unlock()
+
+	for _, nightlyTask := range tasks {
+		nightlyTask.task()
 	}
 }
 
+// Register a task to run at the nightly task run time, or within the spread window if set
 func (tm *TaskManager) RegisterNightlyTask(task func()) {
-	tm.NightlTasks = append(tm.NightlTasks, task)
+	tm.registerTask(&NightlyTask{
+		Name: getTaskName(task),
+		task: task,
+	})
+}
+
+// Register a task to run at the given offset after the nightly task run time, e.g. 30 * time.Minute
+func (tm *TaskManager) RegisterNightlyTaskAt(name string, offset time.Duration, task func()) {
+	if name == "" {
+		name = getTaskName(task)
+	}
+	tm.registerTask(&NightlyTask{
+		Name:      name,
+		Offset:    normalizeOffset(offset),
+		hasOffset: true,
+		task:      task,
+	})
+}
+
+// Set the window for spreading the tasks registered without offset and reschedule them
+func (tm *TaskManager) SetSpreadWindow(window time.Duration) {
+	tm.mutex.Lock()
+	tm.spreadWindow = normalizeOffset(window)
+	tm.updateSchedule()
+	tm.mutex.Unlock()
+	tm.wakeUp()
+}
+
+func (tm *TaskManager) registerTask(task *NightlyTask) {
+	tm.mutex.Lock()
+	tm.tasks = append(tm.tasks, task)
+	tm.updateSchedule()
+	tm.mutex.Unlock()
+	tm.wakeUp()
+}
+
+// Notify the scheduler to recalculate the next run
+func (tm *TaskManager) wakeUp() {
+	select {
+	case tm.reschedule <- true:
+	default:
+	}
+}
+
+// Update the offset of the tasks without offset and the next run of all tasks. Caller must hold the mutex
+func (tm *TaskManager) updateSchedule() {
+	spreadTasks := []*NightlyTask{}
+	for _, thisTask := range tm.tasks {
+		if !thisTask.hasOffset {
+			spreadTasks = append(spreadTasks, thisTask)
+		}
+	}
+	for i, thisTask := range spreadTasks {
+		thisTask.Offset = 0
+		if tm.spreadWindow > 0 {
+			thisTask.Offset = tm.spreadWindow * time.Duration(i) / time.Duration(len(spreadTasks))
+		}
+	}
+
+	now := time.Now()
+	for _, thisTask := range tm.tasks {
+		thisTask.NextRun = tm.getNextRunTime(thisTask.Offset, now)
+	}
+}
+
+// Get the next run time after now of a task with the given offset
+func (tm *TaskManager) getNextRunTime(offset time.Duration, now time.Time) time.Time {
+	//The run of yesterday with offset might still be ahead
+	for day := -1; ; day++ {
+		n := time.Date(now.Year(), now.Month(), now.Day()+day, tm.runTime, 0, 0, 0, now.Location()).Add(offset)
+		if n.After(now) {
+			return n
+		}
+	}
+}
+
+// Wrap the offset into a day
+func normalizeOffset(offset time.Duration) time.Duration {
+	offset = offset % (24 * time.Hour)
+	if offset < 0 {
+		offset += 24 * time.Hour
+	}
+	return offset
+}
+
+// Get the earliest scheduled run among all tasks, zero time if there are no tasks. Caller must hold the mutex
+func (tm *TaskManager) nextRun() time.Time {
+	earliest := time.Time{}
+	for _, thisTask := range tm.tasks {
+		if earliest.IsZero() || thisTask.NextRun.Before(earliest) {
+			earliest = thisTask.NextRun
+		}
+	}
+	return earliest
+}
+
+// Get the earliest scheduled run of the nightly tasks
+func (tm *TaskManager) NextRun() time.Time {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	return tm.nextRun()
+}
+
+// List the nightly tasks in order of their next run
+func (tm *TaskManager) ListTasks() []NightlyTask {
+	tm.mutex.Lock()
+	results := []NightlyTask{}
+	for _, thisTask := range tm.tasks {
+		results = append(results, *thisTask)
+	}
+	tm.mutex.Unlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].NextRun.Before(results[j].NextRun)
+	})
+	return results
+}
+
+// Handle listing of the nightly task schedule
+// THIS FUNCTION WILL NOT CHECK FOR PERMISSION. PLEASE USE WITH PERMISSION HANDLER
+func (tm *TaskManager) HandleListSchedule(w http.ResponseWriter, r *http.Request) {
+	type taskInfo struct {
+		Name    string
+		Offset  int64 //Offset in seconds
+		NextRun int64 //Unix timestamp
+	}
+
+	tasks := []taskInfo{}
+	for _, thisTask := range tm.ListTasks() {
+		tasks = append(tasks, taskInfo{
+			Name:    thisTask.Name,
+			Offset:  int64(thisTask.Offset.Seconds()),
+			NextRun: thisTask.NextRun.Unix(),
+		})
+	}
+
+	nextRun := int64(0)
+	if len(tasks) > 0 {
+		nextRun = tasks[0].NextRun
+	}
+
+	tm.mutex.Lock()
+	spreadWindow := int64(tm.spreadWindow.Seconds())
+	tm.mutex.Unlock()
+
+	js, _ := json.Marshal(struct {
+		RunTime      int
+		SpreadWindow int64 //Spread window in seconds
+		NextRun      int64
+		Tasks        []taskInfo
+	}{
+		RunTime:      tm.runTime,
+		SpreadWindow: spreadWindow,
+		NextRun:      nextRun,
+		Tasks:        tasks,
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Get the name of the task function without the package path, e.g. auth.(*AuthAgent).RemoveExpiredTrustedDevices
+func getTaskName(task func()) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(task).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimSuffix(name, "-fm")
 }
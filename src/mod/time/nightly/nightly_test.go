@@ -0,0 +1,50 @@
+package nightly
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNightlySchedule(t *testing.T) {
+	tm := &TaskManager{runTime: 3, reschedule: make(chan bool, 1)}
+
+	now := time.Date(2024, 5, 10, 2, 0, 0, 0, time.Local)
+	if n := tm.getNextRunTime(0, now); !n.Equal(time.Date(2024, 5, 10, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected run at 3am today, got %v", n)
+	}
+	if n := tm.getNextRunTime(0, now.Add(2*time.Hour)); !n.Equal(time.Date(2024, 5, 11, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected run at 3am tomorrow, got %v", n)
+	}
+	//Offset crossing midnight, yesterday's run is still ahead
+	if n := tm.getNextRunTime(22*time.Hour, now); !n.Equal(time.Date(2024, 5, 11, 1, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected run at 1am tomorrow, got %v", n)
+	}
+
+	ran := []string{}
+	tm.RegisterNightlyTask(func() { ran = append(ran, "a") })
+	tm.RegisterNightlyTask(func() { ran = append(ran, "b") })
+	tm.RegisterNightlyTaskAt("late", 90*time.Minute, func() { ran = append(ran, "late") })
+	tm.SetSpreadWindow(time.Hour)
+
+	if len(tm.tasks) != 3 || tm.tasks[0].Offset != 0 || tm.tasks[1].Offset != 30*time.Minute || tm.tasks[2].Name != "late" || tm.tasks[2].Offset != 90*time.Minute {
+		t.Fatalf("Unexpected task schedule: %+v", tm.ListTasks())
+	}
+
+	//Schedule from a fixed time
+	for _, thisTask := range tm.tasks {
+		thisTask.NextRun = tm.getNextRunTime(thisTask.Offset, now)
+	}
+	if !tm.NextRun().Equal(time.Date(2024, 5, 10, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected next run at 3am today, got %v", tm.NextRun())
+	}
+
+	//Only the tasks that are due run, then move to the next night
+	tm.runDueTasks(time.Date(2024, 5, 10, 3, 30, 0, 0, time.Local))
+	if len(ran) != 2 || ran[0] != "a" || ran[1] != "b" {
+		t.Fatalf("Expected the first two tasks to run in order, got %v", ran)
+	}
+	tasks := tm.ListTasks()
+	if tasks[0].Name != "late" || !tasks[1].NextRun.Equal(time.Date(2024, 5, 11, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected the late task to run next, got %+v", tasks)
+	}
+}
@@ -147,6 +147,13 @@ func (u *UserHandler) GetUserInfoFromUsername(username string) (*User, error) {
 
 //Get user obejct from session
 func (u *UserHandler) GetUserInfoFromRequest(w http.ResponseWriter, r *http.Request) (*User, error) {
+	//Fast path, use the cached user object of this session
+	if cachedUser, ok := u.authAgent.GetCachedSessionValue(r); ok {
+		if userObject, ok := cachedUser.(*User); ok {
+			return userObject, nil
+		}
+	}
+
 	username, err := u.authAgent.GetUserName(w, r)
 	if err != nil {
 		return &User{}, err
@@ -156,6 +163,7 @@ func (u *UserHandler) GetUserInfoFromRequest(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return &User{}, err
 	}
+	u.authAgent.CacheSessionValue(r, username, userObject)
 	return userObject, nil
 }
 
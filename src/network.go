@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"imuslab.com/arozos/mod/fileservers"
 	"imuslab.com/arozos/mod/fileservers/servers/dirserv"
@@ -14,6 +18,7 @@ import (
 	"imuslab.com/arozos/mod/fileservers/servers/samba"
 	"imuslab.com/arozos/mod/fileservers/servers/sftpserv"
 	"imuslab.com/arozos/mod/fileservers/servers/webdavserv"
+	usage "imuslab.com/arozos/mod/info/usageinfo"
 	network "imuslab.com/arozos/mod/network"
 	mdns "imuslab.com/arozos/mod/network/mdns"
 	"imuslab.com/arozos/mod/network/netstat"
@@ -21,6 +26,7 @@ import (
 	upnp "imuslab.com/arozos/mod/network/upnp"
 	"imuslab.com/arozos/mod/network/websocket"
 	prout "imuslab.com/arozos/mod/prouter"
+	storage "imuslab.com/arozos/mod/storage"
 	"imuslab.com/arozos/mod/utils"
 	"imuslab.com/arozos/mod/www"
 )
@@ -28,6 +34,7 @@ import (
 var (
 	//Network Services Managers
 	MDNS            *mdns.MDNSHost
+	MDNSWatcherStop context.CancelFunc
 	UPNP            *upnp.UPnPClient
 	SSDP            *ssdp.SSDPHost
 	WebSocketRouter *websocket.Router
@@ -78,6 +85,19 @@ func NetworkServiceInit() {
 	//Start the services that depends on network interface
 	StartNetworkServices()
 
+	//Network diagnostic tools that require admin access
+	adminRouter := prout.NewModuleRouter(prout.RouterOption{
+		ModuleName:  "System Setting",
+		AdminOnly:   true,
+		UserHandler: userHandler,
+		DeniedHandler: func(w http.ResponseWriter, r *http.Request) {
+			errorHandlePermissionDenied(w, r)
+		},
+	})
+	adminRouter.HandleFunc("/system/network/mdns/selftest", NetworkHandleMDNSSelfTest)
+	adminRouter.HandleFunc("/system/network/mdns/topology", NetworkHandleMDNSTopology)
+	adminRouter.HandleFunc("/system/network/mdns/services", NetworkHandleMDNSServices)
+
 	//Start the port forward configuration interface
 	portForwardInit()
 
@@ -123,21 +143,66 @@ func StartNetworkServices() {
 		MDNS Services
 	*/
 	if *allow_mdns {
-		m, err := mdns.NewMDNS(mdns.NetworkHost{
+		//Advertise the HTTPS port if the HTTP server is disabled
+		mdnsScheme := mdns.SchemeHTTP
+		mdnsPort := *listen_port
+		if *use_tls && *disable_http {
+			mdnsScheme = mdns.SchemeHTTPS
+			mdnsPort = *tls_listen_port
+		}
+
+		hostConfig := mdns.NetworkHost{
 			HostName:     *host_name + "_" + deviceUUID, //To handle more than one identical model within the same network, this must be unique
-			Port:         *listen_port,
+			Port:         mdnsPort,
 			Domain:       "arozos.com",
 			Model:        deviceModel,
 			UUID:         deviceUUID,
 			Vendor:       deviceVendor,
 			BuildVersion: build_version,
 			MinorVersion: internal_version,
-		}, *force_mac)
+			Scheme:       mdnsScheme,
+		}
 
+		//Fill in the local addresses for self-description
+		localHost, err := mdns.NewLocalNetworkHost(hostConfig)
 		if err != nil {
-			systemWideLogger.PrintAndLog("Network", "MDNS Startup Failed. Running in Offline Mode.", err)
+			systemWideLogger.PrintAndLog("Network", "Unable to read local network addresses for MDNS", err)
 		} else {
-			MDNS = m
+			hostConfig = *localHost
+		}
+
+		//Only advertise on the selected NICs so the host is not discoverable on VPN or public networks
+		advertiseIfaces := []string{}
+		if *mdns_advertise_iface != "" {
+			advertiseIfaces = strings.Split(*mdns_advertise_iface, ",")
+		}
+		m, err := mdns.NewMDNSWithAdvertiseIfaces(hostConfig, *force_mac, advertiseIfaces)
+
+		if err != nil {
+			//Other hosts can still be discovered without advertising this one
+			systemWideLogger.PrintAndLog("Network", "MDNS broadcast registration failed. Running in discovery only mode.", err)
+		}
+		MDNS = m
+		MDNS.OnHostAddressChanged = func(host *mdns.NetworkHost, previousIPv4 []net.IP, previousIPv6 []net.IP) {
+			//Same host with a new DHCP lease, not a new neighbour
+			systemWideLogger.PrintAndLog("Network", "Network host "+host.HostName+" address changed from "+fmt.Sprint(previousIPv4)+" to "+fmt.Sprint(host.IPv4), nil)
+		}
+		if *mdns_seeds != "" {
+			//Discover hosts across routed networks via the seeds
+			err = MDNS.SetUnicastSeeds(strings.Split(*mdns_seeds, ","))
+			if err != nil {
+				systemWideLogger.PrintAndLog("Network", "Invalid MDNS unicast seeds given", err)
+			}
+		}
+		var watcherCtx context.Context
+		watcherCtx, MDNSWatcherStop = context.WithCancel(context.Background())
+		if *mdns_watch_iface {
+			//Re-register the mDNS service when the host IP changed
+			go MDNS.WatchInterfaceChanges(watcherCtx, 0)
+		}
+		if *mdns_status_interval > 0 {
+			//Advertise the load of this node for load-aware clients
+			go MDNS.AdvertiseStatus(watcherCtx, time.Duration(*mdns_status_interval)*time.Second, getMDNSNodeStatus)
 		}
 
 	}
@@ -220,6 +285,16 @@ func StartNetworkServices() {
 	}
 }
 
+// Get the current load of this node for advertising in the MDNS TXT records
+func getMDNSNodeStatus() mdns.NodeStatus {
+	_, _, available := storage.GetDriveCapacity(*root_directory)
+	return mdns.NodeStatus{
+		CPULoad:     int(usage.GetCPUUsage()),
+		FreeStorage: int64(available / 1024 / 1024),
+		UserCount:   authAgent.GetUserCounts(),
+	}
+}
+
 func StopNetworkServices() {
 	//systemWideLogger.PrintAndLog("Shutting Down Network Services...",nil)
 	//Shutdown uPNP service if enabled
@@ -237,6 +312,9 @@ func StopNetworkServices() {
 	//Shutdown MDNS if enabled
 	if *allow_mdns {
 		systemWideLogger.PrintAndLog("System", "<!> Shutting down MDNS service", nil)
+		if MDNSWatcherStop != nil {
+			MDNSWatcherStop()
+		}
 		MDNS.Close()
 	}
 }
@@ -484,6 +562,121 @@ func FileServerInit() {
 	adminRouter.HandleFunc("/system/network/server/toggle", NetworkHandleFileServerToggle)
 }
 
+// Check if this host can discover its own mDNS broadcast. Accept an optional timeout in seconds (default 5)
+func NetworkHandleMDNSSelfTest(w http.ResponseWriter, r *http.Request) {
+	if MDNS == nil {
+		utils.SendErrorResponse(w, "mDNS service is not enabled")
+		return
+	}
+
+	timeout := 5
+	timeoutString, _ := utils.GetPara(r, "timeout")
+	if timeoutString != "" {
+		t, err := strconv.Atoi(timeoutString)
+		if err != nil || t < 1 || t > 30 {
+			utils.SendErrorResponse(w, "invalid timeout given")
+			return
+		}
+		timeout = t
+	}
+
+	//Stop scanning if the client disconnected
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	result, err := MDNS.SelfTestContext(ctx)
+	if err != nil {
+		utils.SendErrorResponse(w, "mDNS self test failed: "+err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(result)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Browse all mDNS services on the network including non-arozos devices like printers and NAS.
+// Accept an optional timeout in seconds (default 5) and a comma separated list of service types
+// (e.g. _ipp._tcp,_googlecast._tcp), all common service types are browsed if not given
+func NetworkHandleMDNSServices(w http.ResponseWriter, r *http.Request) {
+	if MDNS == nil {
+		utils.SendErrorResponse(w, "mDNS service is not enabled")
+		return
+	}
+
+	timeout := 5
+	timeoutString, _ := utils.GetPara(r, "timeout")
+	if timeoutString != "" {
+		t, err := strconv.Atoi(timeoutString)
+		if err != nil || t < 1 || t > 30 {
+			utils.SendErrorResponse(w, "invalid timeout given")
+			return
+		}
+		timeout = t
+	}
+
+	serviceTypes := []string{}
+	typesString, _ := utils.GetPara(r, "types")
+	if typesString != "" {
+		for _, serviceType := range strings.Split(typesString, ",") {
+			serviceType = strings.TrimSpace(serviceType)
+			if !strings.HasPrefix(serviceType, "_") || !(strings.HasSuffix(serviceType, "._tcp") || strings.HasSuffix(serviceType, "._udp")) {
+				utils.SendErrorResponse(w, "invalid service type given: "+serviceType)
+				return
+			}
+			serviceTypes = append(serviceTypes, serviceType)
+		}
+		if len(serviceTypes) > 32 {
+			utils.SendErrorResponse(w, "too many service types given")
+			return
+		}
+	}
+
+	//Stop scanning if the client disconnected
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	hosts, err := MDNS.ScanServices(ctx, serviceTypes)
+	if err != nil {
+		utils.SendErrorResponse(w, "mDNS scan failed: "+err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(hosts)
+	utils.SendJSONResponse(w, string(js))
+}
+
+// Get the discovered hosts with the local interface reaching them and their round trip time for drawing a network map.
+// Accept an optional timeout in seconds (default 5) and refresh=true to skip the scan cache
+func NetworkHandleMDNSTopology(w http.ResponseWriter, r *http.Request) {
+	if MDNS == nil {
+		utils.SendErrorResponse(w, "mDNS service is not enabled")
+		return
+	}
+
+	timeout := 5
+	timeoutString, _ := utils.GetPara(r, "timeout")
+	if timeoutString != "" {
+		t, err := strconv.Atoi(timeoutString)
+		if err != nil || t < 1 || t > 30 {
+			utils.SendErrorResponse(w, "invalid timeout given")
+			return
+		}
+		timeout = t
+	}
+
+	refresh, _ := utils.GetPara(r, "refresh")
+	if refresh == "true" {
+		MDNS.InvalidateScanCache()
+	}
+
+	hosts, err := MDNS.CachedScan(30*time.Second, timeout, MDNS.Host.Domain)
+	if err != nil {
+		utils.SendErrorResponse(w, "mDNS scan failed: "+err.Error())
+		return
+	}
+
+	js, _ := json.Marshal(MDNS.BuildTopology(hosts))
+	utils.SendJSONResponse(w, string(js))
+}
+
 // Toggle the target File Server Services
 func NetworkHandleFileServerToggle(w http.ResponseWriter, r *http.Request) {
 	servid, err := utils.PostPara(r, "id")
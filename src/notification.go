@@ -1,9 +1,11 @@
 package main
 
 import (
+	"html"
 	"strconv"
 	"time"
 
+	"imuslab.com/arozos/mod/auth"
 	fs "imuslab.com/arozos/mod/filesystem"
 	notification "imuslab.com/arozos/mod/notification"
 	"imuslab.com/arozos/mod/notification/agents/smtpn"
@@ -47,6 +49,68 @@ func notificationInit() {
 		systemWideLogger.PrintAndLog("Notification", "Unable to start smtpn agent: "+err.Error(), nil)
 	} else {
 		notificationQueue.RegisterNotificationAgent(smtpAgent)
+
+		//Send registration verification email via smtpn
+		registerHandler.SendVerificationEmail = func(username string, email string, verifyURL string) error {
+			return notificationQueue.BroadcastNotification(&notification.NotificationPayload{
+				ID:            strconv.Itoa(int(time.Now().Unix())),
+				Title:         "Verify your email",
+				Message:       "Please verify your email address by visiting <a href='" + verifyURL + "'>" + verifyURL + "</a> to activate your account.",
+				Receiver:      []string{username},
+				Sender:        "Account Registration",
+				ReciverAgents: []string{"smtpn"},
+			})
+		}
+
+		//Send password reset token via smtpn
+		authAgent.SendPasswordReset = func(username string, token string) error {
+			return notificationQueue.BroadcastNotification(&notification.NotificationPayload{
+				ID:            strconv.Itoa(int(time.Now().Unix())),
+				Title:         "Password reset",
+				Message:       "A password reset is requested for your account. Your one-time reset token is <b>" + token + "</b>, it expires in " + strconv.Itoa(int(authAgent.PasswordResetTTL/60)) + " minutes.<br>If you did not request a password reset, please ignore this email.",
+				Receiver:      []string{username},
+				Sender:        "Account Security",
+				ReciverAgents: []string{"smtpn"},
+			})
+		}
+
+		//Notify the user when the account is logged in from a new device
+		authAgent.SendNewDeviceNotice = func(username string, device auth.KnownDevice) error {
+			return notificationQueue.BroadcastNotification(&notification.NotificationPayload{
+				ID:            strconv.Itoa(int(time.Now().Unix())),
+				Title:         "New device login",
+				Message:       "Your account is logged in from a new device at " + time.Unix(device.FirstSeen, 0).Format("2006-01-02 15:04:05") + ".<br>IP address: " + html.EscapeString(device.IpAddr) + "<br>Device: " + html.EscapeString(device.UserAgent) + "<br>If this is not you, please change your password immediately.",
+				Receiver:      []string{username},
+				Sender:        "Account Security",
+				ReciverAgents: []string{"smtpn"},
+			})
+		}
+
+		//Notify the administrators when an account is locked
+		authAgent.AccountLockedHandler = func(username string, lock auth.AccountLock) {
+			admins := []string{}
+			for _, thisUser := range authAgent.ListUsers() {
+				userinfo, err := userHandler.GetUserInfoFromUsername(thisUser)
+				if err == nil && userinfo.IsAdmin() {
+					admins = append(admins, thisUser)
+				}
+			}
+			if len(admins) == 0 {
+				return
+			}
+
+			err := notificationQueue.BroadcastNotification(&notification.NotificationPayload{
+				ID:            strconv.Itoa(int(time.Now().Unix())),
+				Title:         "Account locked",
+				Message:       "The account <b>" + username + "</b> is locked due to " + lock.Reason + ".<br>Please review the login attempts and unlock the account in System Settings if needed.",
+				Receiver:      admins,
+				Sender:        "Account Security",
+				ReciverAgents: []string{"smtpn"},
+			})
+			if err != nil {
+				systemWideLogger.PrintAndLog("Notification", "Unable to notify administrators about account lockout", err)
+			}
+		}
 	}
 
 	//Create and register other notification agents
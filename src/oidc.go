@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"imuslab.com/arozos/mod/auth/oidc"
+	prout "imuslab.com/arozos/mod/prouter"
+)
+
+var oidcHandler *oidc.Handler
+
+func OIDCInit() {
+	oidcHandler = oidc.NewOIDCHandler(authAgent, sysdb)
+
+	adminRouter := prout.NewModuleRouter(prout.RouterOption{
+		ModuleName:  "System Setting",
+		AdminOnly:   true,
+		UserHandler: userHandler,
+		DeniedHandler: func(w http.ResponseWriter, r *http.Request) {
+			errorHandlePermissionDenied(w, r)
+		},
+	})
+
+	//Public endpoints for the login page and the identity provider redirect
+	http.HandleFunc("/system/auth/oidc/login", oidcHandler.HandleLogin)
+	http.HandleFunc("/system/auth/oidc/callback", oidcHandler.HandleCallback)
+	http.HandleFunc("/system/auth/oidc/check", oidcHandler.HandleCheck)
+	adminRouter.HandleFunc("/system/auth/oidc/config", oidcHandler.HandleConfig)
+}
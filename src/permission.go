@@ -18,6 +18,18 @@ func permissionNewHandler() {
 	permissionHandler = ph
 	permissionHandler.LoadPermissionGroupsFromDatabase()
 
+	//Let the auth agent validate the groups of imported accounts
+	authAgent.GroupExists = permissionHandler.GroupExists
+
+	//Restrict the administrator account creation of fresh install, see mod/auth/bootstrap.go
+	authAgent.IsAdminGroup = func(group string) bool {
+		pg := permissionHandler.GetPermissionGroupByName(group)
+		return pg != nil && pg.IsAdmin
+	}
+	err = authAgent.InitBootstrap(*bootstrap_setup_token)
+	if err != nil {
+		systemWideLogger.PrintAndLog("Auth", "Unable to generate setup token, administrator account can only be created from localhost", err)
+	}
 }
 
 func permissionInit() {
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	fs "imuslab.com/arozos/mod/filesystem"
+	"imuslab.com/arozos/mod/permission"
 	"imuslab.com/arozos/mod/utils"
 	//user "imuslab.com/arozos/mod/user"
 )
@@ -31,6 +32,26 @@ func DiskQuotaInit() {
 
 	//Register the timer for running the global user quota recalculation
 	nightlyManager.RegisterNightlyTask(system_disk_quota_updateAllUserQuotaEstimation)
+
+	//Enforce the group storage quota on file writes, see authAgent.CheckStorageQuota
+	authAgent.StorageQuota = &groupStorageQuota{}
+}
+
+//Resolve the user storage quota from the largest quota of the user's permission groups
+type groupStorageQuota struct{}
+
+func (q *groupStorageQuota) GetStorageQuota(username string) (int64, int64, error) {
+	userinfo, err := userHandler.GetUserInfoFromUsername(username)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	groupQuota := permission.GetLargestStorageQuotaFromGroups(userinfo.PermissionGroup)
+	if userinfo.StorageQuota.TotalStorageQuota != groupQuota {
+		//Group quota changed after the user quota is initialized
+		userinfo.StorageQuota.SetUserStorageQuota(groupQuota)
+	}
+	return userinfo.StorageQuota.UsedStorageQuota, groupQuota, nil
 }
 
 //Register the handler for automatically updating all user storage quota
@@ -70,14 +91,28 @@ func system_disk_quota_setQuota(w http.ResponseWriter, r *http.Request) {
 	}
 
 	quotaSize, err := utils.StringToInt64(quotaSizeString)
-	if err != nil || quotaSize < 0 {
+	if err != nil || quotaSize < -1 {
 		utils.SendErrorResponse(w, "Invalid quota size given")
 		return
 	}
-	//Qutasize unit is in MB
-	quotaSize = quotaSize << 20
+	//Qutasize unit is in MB, -1 for unlimited
+	if quotaSize > 0 {
+		quotaSize = quotaSize << 20
+	}
+
+	targetGroup := permissionHandler.GetPermissionGroupByName(groupname)
+	if targetGroup == nil {
+		utils.SendErrorResponse(w, "Permission group not exists")
+		return
+	}
+
+	err = permissionHandler.UpdatePermissionGroup(targetGroup.Name, targetGroup.IsAdmin, quotaSize, targetGroup.AccessibleModules, targetGroup.DefaultInterfaceModule)
+	if err != nil {
+		utils.SendErrorResponse(w, err.Error())
+		return
+	}
 
-	systemWideLogger.PrintAndLog("Quota", "Updating "+groupname+" to "+strconv.FormatInt(quotaSize, 10)+"WIP", nil)
+	systemWideLogger.PrintAndLog("Quota", "Updating "+groupname+" to "+strconv.FormatInt(quotaSize, 10), nil)
 	utils.SendOK(w)
 
 }
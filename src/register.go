@@ -24,8 +24,9 @@ func RegisterSystemInit() {
 	}
 
 	rh := reg.NewRegisterHandler(sysdb, authAgent, permissionHandler, reg.RegisterOptions{
-		Hostname:   *host_name,
-		VendorIcon: imgsrc,
+		Hostname:    *host_name,
+		VendorIcon:  imgsrc,
+		ExternalURL: *external_url,
 	})
 
 	registerHandler = rh
@@ -36,6 +37,17 @@ func RegisterSystemInit() {
 	} else {
 		registerHandler.AllowRegistry = false
 	}
+	registerHandler.RequireEmailVerification = *public_registry_verify
+	if *public_registry_verify && *external_url == "" {
+		systemWideLogger.PrintAndLog("Register", "Email verification requires the external_url flag. Public registration will be rejected until it is set", nil)
+	}
+
+	//Allow password reset requests by email
+	authAgent.LookupUsernameByEmail = registerHandler.GetUsernameByEmail
+
+	//Store the emails of accounts created by admin, CSV import and single sign-on
+	authAgent.CheckUserEmail = registerHandler.ValidateUserEmail
+	authAgent.StoreUserEmail = registerHandler.SetUserEmail
 
 	http.HandleFunc("/public/register/register.system", registerHandler.HandleRegisterInterface)
 	http.HandleFunc("/public/register/handleRegister.system", registerHandler.HandleRegisterRequest)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	module "imuslab.com/arozos/mod/modules"
 	prout "imuslab.com/arozos/mod/prouter"
@@ -31,7 +32,17 @@ func NightlyTasksInit() {
 		The tasks that should be done once per night. Internal function only.
 	*/
 	nightlyManager = nightly.NewNightlyTaskManager(*nightlyTaskRunTime)
+	if *nightlyTaskSpread > 0 {
+		nightlyManager.SetSpreadWindow(time.Duration(*nightlyTaskSpread) * time.Minute)
+	}
 
+	//Remove the system log files that exceed the retention period
+	nightlyManager.RegisterNightlyTask(func() {
+		err := systemWideLogger.CleanupOldLogs()
+		if err != nil {
+			systemWideLogger.PrintAndLog("Logger", "Unable to cleanup old log files", err)
+		}
+	})
 }
 
 func SchedulerInit() {
@@ -90,6 +101,17 @@ func SchedulerInit() {
 	})
 	router.HandleFunc("/system/arsm/aecron/add", systemScheduler.HandleAddJob)
 	router.HandleFunc("/system/arsm/aecron/remove", systemScheduler.HandleJobRemoval)
+
+	//Show the schedule of the nightly tasks to admins
+	adminRouter := prout.NewModuleRouter(prout.RouterOption{
+		ModuleName:  "System Setting",
+		AdminOnly:   true,
+		UserHandler: userHandler,
+		DeniedHandler: func(w http.ResponseWriter, r *http.Request) {
+			errorHandlePermissionDenied(w, r)
+		},
+	})
+	adminRouter.HandleFunc("/system/arsm/nightly/list", nightlyManager.HandleListSchedule)
 	//router.HandleFunc("/system/arsm/aecron/listlog", systemScheduler.HandleShowLog)
 
 	//Register settings
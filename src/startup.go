@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	db "imuslab.com/arozos/mod/database"
 	"imuslab.com/arozos/mod/filesystem"
@@ -17,7 +18,46 @@ import (
 )
 
 func RunStartup() {
-	systemWideLogger, _ = logger.NewLogger("system", "system/logs/system/", true)
+	systemWideLogger, _ = logger.NewLogger("system", "system/logs/system/", *log_to_file)
+	if logLevel, err := logger.ParseLogLevel(*log_level); err == nil {
+		systemWideLogger.LogLevel = logLevel
+	} else {
+		log.Println("[Logger] Invalid log level given: " + *log_level + ". Using default.")
+	}
+	if logFormat, err := logger.ParseLogFormat(*log_format); err == nil {
+		systemWideLogger.Format = logFormat
+	} else {
+		log.Println("[Logger] Invalid log format given: " + *log_format + ". Using default.")
+	}
+	if rotationPeriod, err := logger.ParseRotationPeriod(*log_rotation); err == nil {
+		systemWideLogger.RotationPeriod = rotationPeriod
+	} else {
+		log.Println("[Logger] Invalid log rotation period given: " + *log_rotation + ". Using default.")
+	}
+	systemWideLogger.RetentionMonths = *log_retention
+	systemWideLogger.CompressRotated = *log_compress
+	systemWideLogger.Synchronous = *log_sync
+	systemWideLogger.SeparateErrorLog = *log_error_file
+	systemWideLogger.QueueSize = *log_queue_size
+	systemWideLogger.DropWhenFull = *log_drop_when_full
+	if samplingRules, err := logger.ParseSamplingRules(*log_sampling); err == nil {
+		systemWideLogger.SetSamplingRules(samplingRules)
+	} else {
+		log.Println("[Logger] Invalid log sampling rules given: " + err.Error() + ". Sampling disabled.")
+	}
+	if *log_syslog != "" {
+		//Forward the system log to syslog / journald, e.g. "local" or "udp://10.0.0.1:514"
+		network, raddr := "", ""
+		if *log_syslog != "local" {
+			network, raddr, _ = strings.Cut(*log_syslog, "://")
+		}
+		syslogSink, err := logger.NewSyslogSink(network, raddr, "arozos")
+		if err != nil {
+			log.Println("[Logger] Unable to connect to syslog: " + err.Error())
+		} else {
+			systemWideLogger.AddSink(syslogSink)
+		}
+	}
 	//1. Initiate the main system database
 
 	//Check if system or web both not exists and web.tar.gz exists. Unzip it for the user
@@ -114,6 +154,7 @@ func RunStartup() {
 	security_init()
 	storageHeartbeatTickerInit()
 	OAuthInit()        //Oauth system init
+	OIDCInit()         //OpenID Connect single sign-on init
 	ldapInit()         //LDAP system init
 	notificationInit() //Notification system init
 
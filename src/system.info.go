@@ -192,6 +192,8 @@ func SystemInfoInit() {
 
 	adminRouter.HandleFunc("/system/log/list", logViewer.HandleListLog)
 	adminRouter.HandleFunc("/system/log/read", logViewer.HandleReadLog)
+	adminRouter.HandleFunc("/system/log/query", systemWideLogger.HandleQuery)
+	adminRouter.HandleFunc("/system/log/tail", systemWideLogger.HandleTail)
 
 	registerSetting(settingModule{
 		Name:         "System Log",
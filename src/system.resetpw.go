@@ -73,9 +73,14 @@ func system_resetpw_confirmReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//Check if the new password fulfill the password policy
+	if ok, reason := authAgent.ValidatePasswordWithPolicy(newpw); !ok {
+		utils.SendErrorResponse(w, reason)
+		return
+	}
+
 	//OK to procced
-	newHashedPassword := auth.Hash(newpw)
-	err = sysdb.Write("auth", "passhash/"+username, newHashedPassword)
+	err = authAgent.SetUserPassword(username, newpw)
 	if err != nil {
 		utils.SendErrorResponse(w, err.Error())
 		return
@@ -93,9 +98,8 @@ func system_resetpw_validateResetKey(username string, key string) error {
 		return err
 	}
 
-	//Get hashed user key
-	hashedKey := auth.Hash(key)
-	if passwordInDB != hashedKey {
+	//Check the user key against the password hash
+	if !auth.VerifyPassword(key, passwordInDB) {
 		return errors.New("Invalid Password Reset Key")
 	}
 
@@ -53,6 +53,9 @@ func UserSystemInit() {
 		authAgent.HandleCheckAuth(w, r, user_handleUserInfo)
 	})
 
+	//Login status and user metadata in one call, return LoggedIn false if not logged in
+	http.HandleFunc("/system/auth/whoami", user_handleWhoami)
+
 	//Interface info should be able to view by everyone logged in
 	http.HandleFunc("/system/users/interfaceinfo", func(w http.ResponseWriter, r *http.Request) {
 		authAgent.HandleCheckAuth(w, r, user_getInterfaceInfo)
@@ -274,8 +277,7 @@ func user_handleUserEdit(w http.ResponseWriter, r *http.Request) {
 		//Reset password for this user
 		//Generate a random password for this user
 		tmppassword := uuid.NewV4().String()
-		hashedPassword := auth.Hash(tmppassword)
-		err := sysdb.Write("auth", "passhash/"+username, hashedPassword)
+		err := authAgent.SetUserPassword(username, tmppassword)
 		if err != nil {
 			utils.SendErrorResponse(w, err.Error())
 			return
@@ -366,6 +368,40 @@ func user_getProfilePic(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Return the login status and metadata of the current user. /system/auth/checkLogin is kept for older clients
+func user_handleWhoami(w http.ResponseWriter, r *http.Request) {
+	type whoamiResponse struct {
+		LoggedIn     bool
+		Username     string   `json:",omitempty"`
+		IsAdmin      bool     `json:",omitempty"`
+		Groups       []string `json:",omitempty"`
+		ProfileImage string   `json:",omitempty"`
+		Impersonator string   `json:",omitempty"` //Admin impersonating this user, for showing the impersonation banner
+	}
+
+	if !authAgent.CheckAuth(r) {
+		js, _ := json.Marshal(whoamiResponse{LoggedIn: false})
+		utils.SendJSONResponse(w, string(js))
+		return
+	}
+
+	userinfo, err := userHandler.GetUserInfoFromRequest(w, r)
+	if err != nil {
+		utils.SendErrorResponse(w, "Unable to get user info")
+		return
+	}
+
+	js, _ := json.Marshal(whoamiResponse{
+		LoggedIn:     true,
+		Username:     userinfo.Username,
+		IsAdmin:      userinfo.IsAdmin(),
+		Groups:       userinfo.GetUserPermissionGroupNames(),
+		ProfileImage: getUserIcon(userinfo.Username),
+		Impersonator: authAgent.GetImpersonator(r),
+	})
+	utils.SendJSONResponse(w, string(js))
+}
+
 func user_handleUserInfo(w http.ResponseWriter, r *http.Request) {
 	username, err := authAgent.GetUserName(w, r)
 	if err != nil {
@@ -408,21 +444,29 @@ func user_handleUserInfo(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		//valid the old password
-		hashedPassword := auth.Hash(oldpw)
 		var passwordInDB string
 		err = sysdb.Read("auth", "passhash/"+username, &passwordInDB)
-		if hashedPassword != passwordInDB {
+		if err != nil || !auth.VerifyPassword(oldpw, passwordInDB) {
 			//Old password entry invalid.
 			utils.SendErrorResponse(w, "Invalid old password.")
 			return
 		}
 
+		//Check if the new password fulfill the password policy
+		if ok, reason := authAgent.ValidatePasswordWithPolicy(newpw); !ok {
+			utils.SendErrorResponse(w, reason)
+			return
+		}
+
 		//Logout users from all switchable accounts
 		authAgent.SwitchableAccountManager.ExpireUserFromAllSwitchableAccountPool(username)
 
 		//OK! Change user password
-		newHashedPassword := auth.Hash(newpw)
-		sysdb.Write("auth", "passhash/"+username, newHashedPassword)
+		err = authAgent.SetUserPassword(username, newpw)
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error())
+			return
+		}
 		utils.SendOK(w)
 	} else if opr == "changeprofilepic" {
 		picdata, _ := utils.PostPara(r, "picdata")